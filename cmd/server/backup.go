@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// runBackup writes an online backup to args[0], or a rotated snapshot into
+// the snapshot directory when no path is given.
+func runBackup(db *store.SQliteDB, args []string) error {
+	ctx := context.Background()
+	if len(args) > 0 {
+		if err := db.Backup(ctx, args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("backup written to %s\n", args[0])
+		return nil
+	}
+
+	path, err := db.Snapshot(ctx, snapshotDir, snapshotKeep)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("snapshot written to %s\n", path)
+	return nil
+}
+
+// runCheck runs the integrity check on the database, or on the backup at
+// args[0].
+func runCheck(db *store.SQliteDB, args []string) error {
+	ctx := context.Background()
+
+	var problems []string
+	if len(args) > 0 {
+		info, err := store.CheckBackup(ctx, args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version: %d\ndirty:   %v\n", info.Version, info.Dirty)
+		problems = info.Problems
+	} else {
+		var err error
+		if problems, err = db.IntegrityCheck(ctx); err != nil {
+			return err
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("integrity check failed:\n  %s", strings.Join(problems, "\n  "))
+	}
+	fmt.Println("integrity: ok")
+	return nil
+}
+
+// runRestore swaps dbPath for the backup at args[0]. It runs before the
+// database is opened.
+func runRestore(args []string) error {
+	if len(args) == 0 {
+		return errors.New("restore needs the path of a backup")
+	}
+
+	kept, err := store.Restore(context.Background(), args[0], dbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("restored %s from %s\n", dbPath, args[0])
+	if kept != "" {
+		fmt.Printf("previous database kept as %s\n", kept)
+	}
+	return nil
+}
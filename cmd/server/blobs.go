@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// runAttach stores the file at args[1] in the blob store and records it as
+// evidence of incident args[0]. args[2] is the evidence kind.
+func runAttach(db *store.SQliteDB, args []string) error {
+	if len(args) < 2 {
+		return errors.New("attach needs an incident id and a file")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid incident id %q", args[0])
+	}
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	ctx := store.WithActor(context.Background(), cliActor)
+	if _, err := db.GetIncident(ctx, id); err != nil {
+		return fmt.Errorf("incident %d: %v", id, err)
+	}
+
+	bs, err := openBlobs()
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args[1])
+	if err != nil {
+		return fmt.Errorf("attach error: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("attach error: %v", err)
+	}
+
+	b, err := bs.Put(f, maxBlobSize)
+	if err != nil {
+		return err
+	}
+	ev := &store.Evidence{
+		IncidentID: id, Title: filepath.Base(args[1]), Source: args[1],
+		BlobSHA256: b.SHA256, BlobSize: b.Size, CollectedAt: info.ModTime(),
+	}
+	if len(args) > 2 {
+		ev.Kind = args[2]
+	}
+	if err := db.CreateEvidence(ctx, ev); err != nil {
+		return err
+	}
+
+	fmt.Printf("attached %s (%d bytes, sha256 %s) as evidence %d\n", args[1], b.Size, b.SHA256, ev.ID)
+	return nil
+}
+
+// runBlobs verifies the blob store or collects unreferenced blobs.
+func runBlobs(db *store.SQliteDB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("blobs needs a command\n\n%s", usage)
+	}
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	bs, err := openBlobs()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "verify":
+		problems, err := db.VerifyBlobs(ctx, bs)
+		if err != nil {
+			return err
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("blob verification failed:\n  %s", strings.Join(problems, "\n  "))
+		}
+		fmt.Println("blobs: ok")
+		return nil
+	case "gc":
+		res, err := db.CollectBlobs(ctx, bs, blobGCGrace)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("removed %d files, freed %d bytes\n", res.Removed, res.Freed)
+		return nil
+	default:
+		return fmt.Errorf("unknown blobs command %q\n\n%s", args[0], usage)
+	}
+}
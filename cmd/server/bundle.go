@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// cliActor is who changes made from the command line are attributed to in
+// the audit log.
+const cliActor = "cli"
+
+// runExport writes the bundle of incident args[0] to args[1], by default
+// incident-<id>.tar.gz; "-" writes to stdout.
+func runExport(db *store.SQliteDB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("export needs an incident id")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid incident id %q", args[0])
+	}
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	bs, err := openBlobs()
+	if err != nil {
+		return err
+	}
+
+	path := fmt.Sprintf("incident-%d.tar.gz", id)
+	if len(args) > 1 {
+		path = args[1]
+	}
+	if path == "-" {
+		return db.ExportIncident(context.Background(), bs, id, os.Stdout)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return fmt.Errorf("export error: %v", err)
+	}
+	if err := db.ExportIncident(context.Background(), bs, id, f); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("export error: %v", err)
+	}
+
+	fmt.Printf("incident %d exported to %s\n", id, path)
+	return nil
+}
+
+// runImport imports the bundle at args[0]. args[1] is the conflict policy.
+func runImport(db *store.SQliteDB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("import needs the path of a bundle")
+	}
+	var opts store.ImportOptions
+	if len(args) > 1 {
+		policy, err := store.ParseConflictPolicy(args[1])
+		if err != nil {
+			return err
+		}
+		opts.OnConflict = policy
+	}
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	bs, err := openBlobs()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		return fmt.Errorf("import error: %v", err)
+	}
+	defer f.Close()
+
+	res, err := db.ImportIncident(store.WithActor(context.Background(), cliActor), bs, f, opts)
+	if err != nil {
+		return err
+	}
+	switch {
+	case res.Skipped:
+		fmt.Printf("%q already exists as incident %d; skipped\n", res.Title, res.IncidentID)
+	case res.Replaced:
+		fmt.Printf("%q replaced the existing incident, now incident %d\n", res.Title, res.IncidentID)
+	default:
+		fmt.Printf("%q imported as incident %d\n", res.Title, res.IncidentID)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/dtoebe/RootTensor/internal/blobs"
+	"github.com/dtoebe/RootTensor/internal/keyring"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// Encryption at rest is turned on by a keyring, given either inline in
+// keyEnv or in the file keyFileEnv names. Both hold base64 keys, primary
+// first.
+const (
+	keyEnv     = "ROOTTENSOR_KEY"
+	keyFileEnv = "ROOTTENSOR_KEY_FILE"
+)
+
+// loadKeyring returns the configured keyring, or nil when encryption is
+// off.
+func loadKeyring() (*keyring.Keyring, error) {
+	if text := os.Getenv(keyEnv); text != "" {
+		return keyring.Parse(text)
+	}
+	path := os.Getenv(keyFileEnv)
+	if path == "" {
+		return nil, nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read key file error: %v", err)
+	}
+	return keyring.Parse(string(b))
+}
+
+// openBlobs opens the blob store with the keyring the database uses.
+func openBlobs() (*blobs.Store, error) {
+	keys, err := loadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	return blobs.NewStore(blobDir, keys)
+}
+
+// runKeygen prints a new random key.
+func runKeygen() error {
+	key, err := keyring.Generate()
+	if err != nil {
+		return err
+	}
+	fmt.Println(key)
+	return nil
+}
+
+// runReencrypt encrypts everything not yet encrypted under the primary key
+// of the keyring, in the database and in the blob store.
+func runReencrypt(db *store.SQliteDB) error {
+	keys, err := loadKeyring()
+	if err != nil {
+		return err
+	}
+	if keys == nil {
+		return fmt.Errorf("reencrypt needs a key in %s or %s", keyEnv, keyFileEnv)
+	}
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+
+	n, err := db.Reencrypt(context.Background())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("reencrypted %d values\n", n)
+
+	bs, err := blobs.NewStore(blobDir, keys)
+	if err != nil {
+		return err
+	}
+	if n, err = bs.Reencrypt(); err != nil {
+		return err
+	}
+	fmt.Printf("reencrypted %d blobs\n", n)
+	return nil
+}
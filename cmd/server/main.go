@@ -2,23 +2,235 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/dtoebe/RootTensor/internal/blobs"
 	"github.com/dtoebe/RootTensor/internal/httpserver"
+	"github.com/dtoebe/RootTensor/internal/llm"
 	"github.com/dtoebe/RootTensor/internal/store"
 )
 
+const usage = `usage: RootTensor [command]
+
+Without a command the server is started. It listens on ROOTTENSOR_ADDR
+(default :3333) and keeps its data in ROOTTENSOR_DB (default roottensor.db).
+Its cookies are Secure unless it listens on a loopback address;
+ROOTTENSOR_SECURE_COOKIES=true or false overrides that.
+
+commands:
+  migrate status          print the applied and latest schema version
+  migrate up              apply all pending migrations
+  migrate down [N|all]    revert N migrations (default 1) or all of them
+  migrate steps N         apply (N > 0) or revert (N < 0) N migrations
+  migrate force V         set the schema version to V and clear the dirty flag
+  backup [PATH]           write an online backup to PATH or a snapshot to backups/
+  check [PATH]            run the integrity check on the database or a backup
+  restore PATH            replace the database with a backup; stop the server first
+  export ID [FILE|-]      write incident ID as a bundle (default incident-ID.tar.gz)
+  import FILE [POLICY]    import a bundle; POLICY is fail (default), skip, replace
+                          or copy and applies when the incident already exists
+  retention               list the evidence retention policies
+  retention set KIND DAYS [WORKSPACE]
+                          archive KIND evidence once it is DAYS days old,
+                          instance-wide or only in WORKSPACE
+  retention unset KIND [WORKSPACE]
+                          keep KIND evidence forever, or in WORKSPACE fall
+                          back to the instance-wide policy
+  retention run           archive expired evidence now and vacuum
+  attach ID FILE [KIND]   store FILE in the blob store as evidence of incident ID
+  blobs verify            check every referenced blob against its digest
+  blobs gc                remove blobs no evidence references
+  workspaces [list]       list the workspaces with their size
+  workspaces create SLUG [NAME]
+  workspaces delete SLUG  delete a workspace without incidents
+  workspaces set SLUG name|model|personas VALUE
+                          rename, set the chat model or the comma-separated
+                          personas offered (empty for all)
+  workspaces members SLUG list the members of a workspace with their roles
+  workspaces add-member SLUG MEMBER [ROLE]
+                          add MEMBER as viewer, responder (the default),
+                          commander or admin, or change the role of a member
+  workspaces remove-member SLUG MEMBER
+  workspaces move ID SLUG move incident ID to another workspace
+  settings                list the instance settings
+  settings set KEY VALUE  change a setting: provider_url, chat_model,
+                          embedding_model or temperature
+  settings reset KEY      put a setting back to its default
+  users [list]            list the users who can sign in
+  users add NAME [admin]  add a user; the password is read from stdin
+  users passwd NAME       set a new password read from stdin
+  users admin NAME on|off grant or revoke managing users and workspaces
+  users delete NAME       delete a user with its sessions and tokens
+  users token NAME TOKEN SCOPE...
+                          print a new API token of NAME called TOKEN with
+                          the scopes incidents:read, incidents:write,
+                          evidence:write or analyses:run
+  users tokens NAME       list the API tokens of NAME
+  users revoke NAME ID    revoke API token ID of NAME
+  keygen                  print a new random encryption key
+  reencrypt               encrypt everything under the primary key; run after
+                          turning encryption on or adding a new key
+`
+
+const (
+	defaultAddr   = ":3333"
+	defaultDBPath = "roottensor.db"
+
+	// Snapshots are taken while the server runs and the newest snapshotKeep
+	// are kept.
+	snapshotDir   = "backups"
+	snapshotEvery = 6 * time.Hour
+	snapshotKeep  = 28
+
+	// retentionEvery is how often expired evidence is archived.
+	retentionEvery = 24 * time.Hour
+
+	// Large evidence files are kept in blobDir beside the database. Blobs
+	// no evidence references are removed every blobGCEvery once they are
+	// older than blobGCGrace.
+	blobDir     = "blobs"
+	maxBlobSize = 4 << 30
+	blobGCEvery = 24 * time.Hour
+	blobGCGrace = time.Hour
+)
+
+// dbPath is the database file, defaultDBPath unless ROOTTENSOR_DB names
+// another.
+var dbPath = envOr(dbEnv, defaultDBPath)
+
 func main() {
-	db, err := store.NewSQLiteDB("roottensor.db")
+	// A restore swaps the database file, so it must not be open.
+	if len(os.Args) > 1 && os.Args[1] == "restore" {
+		if err := runRestore(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	keys, err := loadKeyring()
+	if err != nil {
+		log.Fatalf("failed to load encryption keys: %v", err)
+	}
+
+	db, err := store.NewSQLiteDB(dbPath)
 	if err != nil {
 		log.Fatalf("failed to initialize db: %v", err)
 	}
-	srvr, err := httpserver.NewHTTPServer(":3333", "web/templates", db)
+	defer db.Close()
+	db.SetKeyring(keys)
+
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if err := db.Migrate(); err != nil {
+		log.Fatalf("failed to migrate db: %v", err)
+	}
+
+	settings, err := db.GetSettings(context.Background())
+	if err != nil {
+		log.Fatalf("failed to load settings: %v", err)
+	}
+	if n, err := db.CountUsers(context.Background()); err != nil {
+		log.Fatalf("failed to count users: %v", err)
+	} else if n == 0 {
+		log.Printf("no users yet: clients on this host can use the server without signing in, other clients cannot; add an admin with RootTensor users add NAME admin")
+	}
+	provider := llm.NewOllamaProvider(settings.ProviderURL, settings.ChatModel)
+	provider.Configure(settings.ProviderURL, settings.ChatModel, float32(settings.Temperature))
+	if err := db.BuildVectorIndex(context.Background(), settings.EmbeddingModel, store.DefaultHNSWConfig()); err != nil {
+		log.Fatalf("failed to build vector index: %v", err)
+	}
+	live := &liveSettings{db: db, provider: provider, embeddingModel: settings.EmbeddingModel}
+	live.reembed()
+	go db.RunSnapshots(context.Background(), snapshotDir, snapshotEvery, snapshotKeep)
+	go db.RunRetention(store.WithActor(context.Background(), retentionActor), retentionEvery)
+
+	bs, err := blobs.NewStore(blobDir, keys)
+	if err != nil {
+		log.Fatalf("failed to open blob store: %v", err)
+	}
+	go db.RunBlobGC(context.Background(), bs, blobGCEvery, blobGCGrace)
+
+	srvr, err := httpserver.NewHTTPServer(envOr(addrEnv, defaultAddr), "web/templates", db, provider)
 	if err != nil {
 		log.Fatalf("failed to initialize server: %v", err)
 	}
+	srvr.UseBlobs(bs)
+	if v := os.Getenv(secureCookiesEnv); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			log.Fatalf("invalid %s %q: use true or false", secureCookiesEnv, v)
+		}
+		srvr.SecureCookies(on)
+	}
+	srvr.OnSettingsChange(live.apply)
 
 	if err := srvr.Run(context.Background()); err != nil {
 		log.Fatalf("server error: %v", err)
 	}
 }
+
+// reembed brings the embeddings up to date with model: rows added while
+// the server was down and, after the model changed, every row. The model
+// backend being unavailable is not fatal, and neither is ctx ending.
+func reembed(ctx context.Context, db *store.SQliteDB, p *llm.OllamaProvider, model string) {
+	n, err := db.Reembed(ctx, model, func(ctx context.Context, texts []string) ([][]float32, error) {
+		return p.Embed(ctx, model, texts)
+	})
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		log.Printf("reembed error after %d rows: %v", n, err)
+		return
+	}
+	if n > 0 {
+		log.Printf("embedded %d rows with %s", n, model)
+	}
+}
+
+func runCommand(db *store.SQliteDB, args []string) error {
+	switch args[0] {
+	case "migrate":
+		return runMigrate(db, args[1:])
+	case "backup":
+		return runBackup(db, args[1:])
+	case "check":
+		return runCheck(db, args[1:])
+	case "export":
+		return runExport(db, args[1:])
+	case "import":
+		return runImport(db, args[1:])
+	case "retention":
+		return runRetention(db, args[1:])
+	case "attach":
+		return runAttach(db, args[1:])
+	case "blobs":
+		return runBlobs(db, args[1:])
+	case "workspaces":
+		return runWorkspaces(db, args[1:])
+	case "users":
+		return runUsers(db, args[1:])
+	case "settings":
+		return runSettings(db, args[1:])
+	case "keygen":
+		return runKeygen()
+	case "reencrypt":
+		return runReencrypt(db)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n\n%s", args[0], usage)
+	}
+}
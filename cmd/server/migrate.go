@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func runMigrate(db *store.SQliteDB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	switch args[0] {
+	case "status":
+		v, dirty, err := db.Version()
+		if err != nil {
+			return err
+		}
+		latest, err := store.LatestVersion()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("version: %d\nlatest:  %d\ndirty:   %v\npending: %d\n", v, latest, dirty, latest-min(v, latest))
+		return nil
+
+	case "up":
+		return db.Migrate()
+
+	case "down":
+		if len(args) > 1 && args[1] == "all" {
+			return db.Down()
+		}
+		n := 1
+		if len(args) > 1 {
+			var err error
+			if n, err = strconv.Atoi(args[1]); err != nil || n <= 0 {
+				return fmt.Errorf("invalid number of migrations %q", args[1])
+			}
+		}
+		return db.Steps(-n)
+
+	case "steps":
+		if len(args) < 2 {
+			return errors.New("migrate steps needs a number of migrations")
+		}
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n == 0 {
+			return fmt.Errorf("invalid number of migrations %q", args[1])
+		}
+		return db.Steps(n)
+
+	case "force":
+		if len(args) < 2 {
+			return errors.New("migrate force needs a version")
+		}
+		v, err := strconv.Atoi(args[1])
+		if err != nil || v < 0 {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		return db.Force(v)
+
+	default:
+		return fmt.Errorf("unknown migrate command %q\n\n%s", args[0], usage)
+	}
+}
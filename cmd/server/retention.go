@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// retentionActor is who the background retention job's changes are
+// attributed to in the audit log.
+const retentionActor = "retention"
+
+// runRetention lists the retention policies, changes one or applies them.
+func runRetention(db *store.SQliteDB, args []string) error {
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	ctx := store.WithActor(context.Background(), cliActor)
+
+	if len(args) == 0 {
+		policies, err := db.ListRetentionPolicies(ctx)
+		if err != nil {
+			return err
+		}
+		if len(policies) == 0 {
+			fmt.Println("no retention policies; all evidence is kept")
+		}
+		slugs, err := workspaceSlugs(ctx, db)
+		if err != nil {
+			return err
+		}
+		for _, p := range policies {
+			where := "instance"
+			if p.WorkspaceID != 0 {
+				where = slugs[p.WorkspaceID]
+			}
+			fmt.Printf("%-12s %-12s %d days\n", p.Kind, where, p.MaxAge)
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "set":
+		if len(args) != 3 && len(args) != 4 {
+			return errors.New("retention set needs an evidence kind and a number of days")
+		}
+		ctx, err := inWorkspace(ctx, db, args[3:])
+		if err != nil {
+			return err
+		}
+		days, err := strconv.Atoi(args[2])
+		if err != nil || days <= 0 {
+			return fmt.Errorf("invalid number of days %q", args[2])
+		}
+		if err := db.SetRetentionPolicy(ctx, &store.RetentionPolicy{Kind: args[1], MaxAge: days}); err != nil {
+			return err
+		}
+		if len(args) == 4 {
+			fmt.Printf("%s evidence in %s is archived after %d days\n", args[1], args[3], days)
+		} else {
+			fmt.Printf("%s evidence is archived after %d days\n", args[1], days)
+		}
+		return nil
+	case "unset":
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New("retention unset needs an evidence kind")
+		}
+		ctx, err := inWorkspace(ctx, db, args[2:])
+		if err != nil {
+			return err
+		}
+		if err := db.DeleteRetentionPolicy(ctx, args[1]); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return fmt.Errorf("no retention policy for %q", args[1])
+			}
+			return err
+		}
+		if len(args) == 3 {
+			fmt.Printf("%s evidence in %s follows the instance-wide policy\n", args[1], args[2])
+		} else {
+			fmt.Printf("%s evidence is kept forever\n", args[1])
+		}
+		return nil
+	case "run":
+		res, err := db.ApplyRetention(ctx, time.Now())
+		if err != nil {
+			return err
+		}
+		fmt.Printf("archived %d evidence, freed %d bytes\n", res.Archived, res.Freed)
+		return nil
+	default:
+		return fmt.Errorf("unknown retention command %q\n\n%s", args[0], usage)
+	}
+}
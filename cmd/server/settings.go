@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// The listen address and the database file cannot come from the database,
+// so they are taken from the environment, and so is whether cookies are
+// Secure, which depends on how the server is reached.
+const (
+	addrEnv          = "ROOTTENSOR_ADDR"
+	dbEnv            = "ROOTTENSOR_DB"
+	secureCookiesEnv = "ROOTTENSOR_SECURE_COOKIES"
+)
+
+// envOr returns the environment variable key, or def when it is unset.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// liveSettings applies changed instance settings to the running server.
+type liveSettings struct {
+	db       *store.SQliteDB
+	provider *llm.OllamaProvider
+
+	mu             sync.Mutex
+	embeddingModel string
+	// rebuilding is set while the worker brings the vectors up to date
+	// with embeddingModel; cancel stops its current pass.
+	rebuilding bool
+	cancel     context.CancelFunc
+}
+
+// apply reconfigures the provider. A new embedding model means new
+// vectors: the index is rebuilt from what is already stored for the model
+// and everything else is embedded again in the background.
+func (l *liveSettings) apply(s *store.Settings) {
+	l.provider.Configure(s.ProviderURL, s.ChatModel, float32(s.Temperature))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s.EmbeddingModel == l.embeddingModel {
+		return
+	}
+	l.embeddingModel = s.EmbeddingModel
+	l.rebuild(false)
+}
+
+// reembed embeds what the index of the embedding model lacks in the
+// background; the index must already be built.
+func (l *liveSettings) reembed() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rebuild(true)
+}
+
+// rebuild stops the pass the worker is making for a previous model and
+// starts the worker unless it runs, so that only one pass runs at a time
+// and the last one is for the current model. l.mu must be held.
+func (l *liveSettings) rebuild(indexed bool) {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	if !l.rebuilding {
+		l.rebuilding = true
+		go l.work(indexed)
+	}
+}
+
+// work rebuilds the vector index for the embedding model, unless indexed,
+// and embeds again what it lacks. It makes another pass as long as the
+// model changed during the last one.
+func (l *liveSettings) work(indexed bool) {
+	var done string
+	for {
+		l.mu.Lock()
+		model := l.embeddingModel
+		if model == done {
+			l.rebuilding, l.cancel = false, nil
+			l.mu.Unlock()
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		l.cancel = cancel
+		l.mu.Unlock()
+
+		if indexed {
+			reembed(ctx, l.db, l.provider, model)
+		} else if err := l.db.BuildVectorIndex(ctx, model, store.DefaultHNSWConfig()); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("rebuild vector index error: %v", err)
+			}
+		} else {
+			reembed(ctx, l.db, l.provider, model)
+		}
+		cancel()
+		done, indexed = model, false
+	}
+}
+
+// runSettings lists the instance settings or changes one. A running server
+// picks up changes made here when it is restarted; the Settings page
+// applies them at once.
+func runSettings(db *store.SQliteDB, args []string) error {
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	ctx := store.WithActor(context.Background(), cliActor)
+
+	s, err := db.GetSettings(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case len(args) == 0:
+		for _, key := range store.SettingKeys {
+			v, _ := s.Get(key)
+			fmt.Printf("%-16s %s\n", key, v)
+		}
+		return nil
+	case args[0] == "set" && len(args) == 3:
+		if err := s.Set(args[1], args[2]); err != nil {
+			return err
+		}
+	case args[0] == "reset" && len(args) == 2:
+		v, err := store.DefaultSettings().Get(args[1])
+		if err != nil {
+			return err
+		}
+		if err := s.Set(args[1], v); err != nil {
+			return err
+		}
+	default:
+		return errors.New("settings needs no arguments, set KEY VALUE or reset KEY")
+	}
+
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	if err := db.SaveSettings(ctx, s); err != nil {
+		return err
+	}
+	v, _ := s.Get(args[1])
+	fmt.Printf("%s = %s\n", args[1], v)
+	return nil
+}
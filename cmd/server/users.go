@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/auth"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// runUsers lists the users or changes one of them. Passwords are read from
+// the first line of standard input so that they stay out of the shell
+// history.
+func runUsers(db *store.SQliteDB, args []string) error {
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	ctx := store.WithActor(context.Background(), cliActor)
+
+	if len(args) == 0 || args[0] == "list" {
+		users, err := db.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			role := "user"
+			if u.Admin {
+				role = "admin"
+			}
+			fmt.Printf("%-24s %-5s created %s\n", u.Username, role, u.CreatedAt.Format("2006-01-02"))
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 2 && !(len(args) == 3 && args[2] == "admin") {
+			return errors.New("users add needs a name and optionally admin")
+		}
+		hash, err := readPassword(os.Stdin)
+		if err != nil {
+			return err
+		}
+		u := &store.User{Username: args[1], PasswordHash: hash, Admin: len(args) == 3}
+		if err := db.CreateUser(ctx, u); err != nil {
+			if errors.Is(err, store.ErrUserExists) {
+				return fmt.Errorf("user %s already exists", args[1])
+			}
+			return err
+		}
+		fmt.Printf("created user %s\n", u.Username)
+		return nil
+	case "passwd":
+		if len(args) != 2 {
+			return errors.New("users passwd needs a name")
+		}
+		u, err := userByName(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		hash, err := readPassword(os.Stdin)
+		if err != nil {
+			return err
+		}
+		if err := db.SetUserPassword(ctx, u.ID, hash); err != nil {
+			return err
+		}
+		fmt.Printf("changed the password of %s and signed it out everywhere\n", u.Username)
+		return nil
+	case "admin":
+		if len(args) != 3 || (args[2] != "on" && args[2] != "off") {
+			return errors.New("users admin needs a name and on or off")
+		}
+		u, err := userByName(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		return db.SetUserAdmin(ctx, u.ID, args[2] == "on")
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("users delete needs a name")
+		}
+		u, err := userByName(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		if err := db.DeleteUser(ctx, u.ID); err != nil {
+			return err
+		}
+		fmt.Printf("deleted user %s\n", u.Username)
+		return nil
+	case "token":
+		if len(args) < 4 {
+			return fmt.Errorf("users token needs a name, a token name and scopes (%s)", scopeList())
+		}
+		u, err := userByName(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		for _, s := range args[3:] {
+			if !auth.ValidScope(s) {
+				return fmt.Errorf("unknown scope %q: use %s", s, scopeList())
+			}
+		}
+		token, err := auth.NewToken(auth.TokenPrefix)
+		if err != nil {
+			return err
+		}
+		t := &store.APIToken{UserID: u.ID, Name: args[2], Scopes: args[3:]}
+		if err := db.CreateAPIToken(ctx, t, auth.HashToken(token)); err != nil {
+			return err
+		}
+		fmt.Println(token)
+		return nil
+	case "tokens":
+		if len(args) != 2 {
+			return errors.New("users tokens needs a name")
+		}
+		u, err := userByName(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		tokens, err := db.ListAPITokens(ctx, u.ID)
+		if err != nil {
+			return err
+		}
+		for _, t := range tokens {
+			used := "never used"
+			if t.LastUsedAt != nil {
+				used = "used " + t.LastUsedAt.Format("2006-01-02")
+			}
+			fmt.Printf("%4d %-24s %-16s %s\n", t.ID, t.Name, used, strings.Join(t.Scopes, " "))
+		}
+		return nil
+	case "revoke":
+		if len(args) != 3 {
+			return errors.New("users revoke needs a name and a token id")
+		}
+		u, err := userByName(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		id, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid token id %q", args[2])
+		}
+		if err := db.DeleteAPIToken(ctx, u.ID, id); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return fmt.Errorf("%s has no token %d", u.Username, id)
+			}
+			return err
+		}
+		fmt.Printf("revoked token %d of %s\n", id, u.Username)
+		return nil
+	default:
+		return fmt.Errorf("unknown users command %q\n\n%s", args[0], usage)
+	}
+}
+
+func userByName(ctx context.Context, db *store.SQliteDB, name string) (*store.User, error) {
+	u, err := db.GetUserByName(ctx, name)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("no user %q", name)
+	}
+
+	return u, err
+}
+
+// readPassword reads a password from the first line of r and returns its
+// hash.
+func readPassword(r io.Reader) (string, error) {
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", fmt.Errorf("read password error: %v", err)
+	}
+	pw := strings.TrimRight(line, "\r\n")
+	if err := auth.CheckPassword(pw); err != nil {
+		return "", err
+	}
+
+	return auth.HashPassword(pw)
+}
+
+func scopeList() string {
+	names := make([]string, len(auth.Scopes))
+	for i, s := range auth.Scopes {
+		names[i] = string(s)
+	}
+
+	return strings.Join(names, ", ")
+}
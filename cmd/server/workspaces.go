@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// runWorkspaces lists the workspaces or changes one of them.
+func runWorkspaces(db *store.SQliteDB, args []string) error {
+	if err := db.Migrate(); err != nil {
+		return err
+	}
+	ctx := store.WithActor(context.Background(), cliActor)
+
+	if len(args) == 0 || args[0] == "list" {
+		stats, err := db.ListWorkspaceStats(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range stats {
+			model := s.ChatModel
+			if model == "" {
+				model = "-"
+			}
+			fmt.Printf("%-16s %-24s %4d incidents %3d members  model %s\n", s.Slug, s.Name, s.Incidents, s.Members, model)
+		}
+		return nil
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) != 2 && len(args) != 3 {
+			return errors.New("workspaces create needs a slug and optionally a name")
+		}
+		if !store.ValidSlug(args[1]) {
+			return fmt.Errorf("invalid slug %q: use lower case letters, digits and dashes", args[1])
+		}
+		ws := &store.Workspace{Slug: args[1]}
+		if len(args) == 3 {
+			ws.Name = args[2]
+		}
+		if err := db.CreateWorkspace(ctx, ws); err != nil {
+			return err
+		}
+		fmt.Printf("created workspace %s\n", ws.Slug)
+		return nil
+	case "delete":
+		if len(args) != 2 {
+			return errors.New("workspaces delete needs a slug")
+		}
+		ws, err := workspaceBySlug(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		if err := db.DeleteWorkspace(ctx, ws.ID); err != nil {
+			if errors.Is(err, store.ErrWorkspaceNotEmpty) {
+				return fmt.Errorf("workspace %s still has incidents; move or delete them first", ws.Slug)
+			}
+			return err
+		}
+		fmt.Printf("deleted workspace %s\n", ws.Slug)
+		return nil
+	case "set":
+		if len(args) != 4 {
+			return errors.New("workspaces set needs a slug, a setting (name, model or personas) and a value")
+		}
+		ws, err := workspaceBySlug(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		switch args[2] {
+		case "name":
+			ws.Name = args[3]
+		case "model":
+			ws.ChatModel = args[3]
+		case "personas":
+			ws.Personas = nil
+			for _, key := range strings.Split(args[3], ",") {
+				if key = strings.TrimSpace(key); key != "" {
+					ws.Personas = append(ws.Personas, key)
+				}
+			}
+		default:
+			return fmt.Errorf("unknown workspace setting %q", args[2])
+		}
+		if err := db.UpdateWorkspace(ctx, ws); err != nil {
+			return err
+		}
+		fmt.Printf("updated workspace %s\n", ws.Slug)
+		return nil
+	case "members":
+		if len(args) != 2 {
+			return errors.New("workspaces members needs a slug")
+		}
+		ws, err := workspaceBySlug(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		members, err := db.ListWorkspaceMembers(ctx, ws.ID)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			fmt.Printf("%-24s %-10s added %s\n", m.Member, m.Role, m.AddedAt.Format("2006-01-02"))
+		}
+		return nil
+	case "add-member", "remove-member":
+		if len(args) != 3 && (args[0] == "remove-member" || len(args) != 4) {
+			return fmt.Errorf("workspaces %s needs a slug and a member", args[0])
+		}
+		role := store.DefaultRole
+		if len(args) == 4 {
+			if !store.ValidRole(args[3]) {
+				return fmt.Errorf("invalid role %q: use one of %v", args[3], store.Roles)
+			}
+			role = store.Role(args[3])
+		}
+		ws, err := workspaceBySlug(ctx, db, args[1])
+		if err != nil {
+			return err
+		}
+		if args[0] == "add-member" {
+			err = db.AddWorkspaceMember(ctx, ws.ID, args[2], role)
+		} else {
+			err = db.RemoveWorkspaceMember(ctx, ws.ID, args[2])
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("%s is not a member of %s", args[2], ws.Slug)
+		}
+		return err
+	case "move":
+		if len(args) != 3 {
+			return errors.New("workspaces move needs an incident id and a slug")
+		}
+		id, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid incident id %q", args[1])
+		}
+		ws, err := workspaceBySlug(ctx, db, args[2])
+		if err != nil {
+			return err
+		}
+		if err := db.MoveIncident(ctx, id, ws.ID); err != nil {
+			if errors.Is(err, store.ErrNotFound) {
+				return fmt.Errorf("incident %d not found", id)
+			}
+			return err
+		}
+		fmt.Printf("moved incident %d to %s\n", id, ws.Slug)
+		return nil
+	default:
+		return fmt.Errorf("unknown workspaces command %q\n\n%s", args[0], usage)
+	}
+}
+
+func workspaceBySlug(ctx context.Context, db *store.SQliteDB, slug string) (*store.Workspace, error) {
+	ws, err := db.GetWorkspaceBySlug(ctx, slug)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("no workspace %q", slug)
+	}
+
+	return ws, err
+}
+
+// inWorkspace scopes ctx to the workspace named by the optional slug in
+// args; without one ctx is returned unchanged.
+func inWorkspace(ctx context.Context, db *store.SQliteDB, args []string) (context.Context, error) {
+	if len(args) == 0 {
+		return ctx, nil
+	}
+	ws, err := workspaceBySlug(ctx, db, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	return store.WithWorkspace(ctx, ws.ID), nil
+}
+
+// workspaceSlugs maps workspace ids to slugs for listings.
+func workspaceSlugs(ctx context.Context, db *store.SQliteDB) (map[int64]string, error) {
+	all, err := db.ListWorkspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	slugs := make(map[int64]string, len(all))
+	for _, ws := range all {
+		slugs[ws.ID] = ws.Slug
+	}
+
+	return slugs, nil
+}
@@ -7,3 +7,7 @@ require github.com/mattn/go-sqlite3 v1.14.34
 require github.com/golang-migrate/migrate/v4 v4.19.1
 
 require github.com/a-h/templ v0.3.1001
+
+require golang.org/x/crypto v0.54.0
+
+require golang.org/x/sys v0.47.0 // indirect
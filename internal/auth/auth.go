@@ -0,0 +1,154 @@
+// Package auth hashes passwords and creates the random tokens that
+// identify sessions and API clients. Passwords are hashed with Argon2id;
+// tokens are only ever stored as their SHA-256, so a copy of the database
+// does not let anyone sign in.
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrMalformedHash is returned for a password hash this package did not
+// produce.
+var ErrMalformedHash = errors.New("malformed password hash")
+
+// MinPasswordLength is the shortest password accepted.
+const MinPasswordLength = 12
+
+// Argon2id parameters, following the OWASP recommendation of 64 MiB and
+// three passes. They are stored in every hash, so raising them later
+// leaves existing hashes valid.
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024
+	argonThreads = 2
+	argonKeyLen  = 32
+	saltLen      = 16
+)
+
+// tokenBytes is the entropy of session and API tokens.
+const tokenBytes = 32
+
+// TokenPrefix starts every API token, so they are easy to recognise in
+// configuration and to catch with secret scanners.
+const TokenPrefix = "rt_"
+
+// Scope is something an API token may be used for.
+type Scope string
+
+const (
+	// ScopeReadIncidents reads incidents and everything recorded for them.
+	ScopeReadIncidents Scope = "incidents:read"
+	// ScopeWriteIncidents opens and changes incidents, hypotheses and
+	// action items.
+	ScopeWriteIncidents Scope = "incidents:write"
+	// ScopeWriteEvidence adds and changes evidence and timeline events.
+	ScopeWriteEvidence Scope = "evidence:write"
+	// ScopeRunAnalyses starts and cancels analyses.
+	ScopeRunAnalyses Scope = "analyses:run"
+)
+
+// Scopes lists every scope in display order.
+var Scopes = []Scope{ScopeReadIncidents, ScopeWriteIncidents, ScopeWriteEvidence, ScopeRunAnalyses}
+
+// ValidScope reports whether s names a scope.
+func ValidScope(s string) bool {
+	return slices.Contains(Scopes, Scope(s))
+}
+
+// CheckPassword returns an error when pw is too weak to be set.
+func CheckPassword(pw string) error {
+	if len([]rune(pw)) < MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	}
+
+	return nil
+}
+
+// HashPassword returns the Argon2id hash of pw in the PHC string format,
+// with a random salt.
+func HashPassword(pw string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("password salt error: %v", err)
+	}
+	key := argon2.IDKey([]byte(pw), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, argonMemory, argonTime, argonThreads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// VerifyPassword reports whether pw matches hash. The comparison takes
+// the same time however much of the hash matches.
+func VerifyPassword(hash, pw string) (bool, error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return false, ErrMalformedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrMalformedHash
+	}
+	var memory, passes uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &passes, &threads); err != nil {
+		return false, ErrMalformedHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+	want, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(want) == 0 {
+		return false, ErrMalformedHash
+	}
+
+	got := argon2.IDKey([]byte(pw), salt, passes, memory, threads, uint32(len(want)))
+	return subtle.ConstantTimeCompare(got, want) == 1, nil
+}
+
+// dummyHash is verified against when there is no user to check, so that
+// an unknown user name takes as long to reject as a wrong password.
+var dummyHash = sync.OnceValue(func() string {
+	h, _ := HashPassword("not the password of anyone")
+	return h
+})
+
+// RejectPassword spends the time a password check takes and returns
+// false.
+func RejectPassword(pw string) bool {
+	_, _ = VerifyPassword(dummyHash(), pw)
+	return false
+}
+
+// NewToken returns a random token starting with prefix. Only HashToken of
+// it should be stored.
+func NewToken(prefix string) (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("token error: %v", err)
+	}
+
+	return prefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns what is stored for token. Tokens carry enough entropy
+// that a fast hash is as good as a slow one.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+
+	t.Run("HashPassword: PHC format", func(t *testing.T) {
+		if !strings.HasPrefix(hash, "$argon2id$v=19$m=65536,t=3,p=2$") {
+			t.Errorf("hash: got %q", hash)
+		}
+	})
+
+	t.Run("HashPassword: salted", func(t *testing.T) {
+		again, err := HashPassword("correct horse battery staple")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again == hash {
+			t.Error("two hashes of the same password are equal")
+		}
+	})
+
+	tests := []struct {
+		name string
+		hash string
+		pw   string
+		want bool
+		err  error
+	}{
+		{"VerifyPassword: match", hash, "correct horse battery staple", true, nil},
+		{"VerifyPassword: mismatch", hash, "correct horse battery stapler", false, nil},
+		{"VerifyPassword: empty", hash, "", false, nil},
+		{"VerifyPassword: bcrypt", "$2a$10$abcdefghijklmnopqrstuv", "x", false, ErrMalformedHash},
+		{"VerifyPassword: bad salt", "$argon2id$v=19$m=65536,t=3,p=2$!!$aGFzaA", "x", false, ErrMalformedHash},
+		{"VerifyPassword: no key", "$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$", "x", false, ErrMalformedHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := VerifyPassword(tt.hash, tt.pw)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error: got %v want %v", err, tt.err)
+			}
+			if got != tt.want {
+				t.Errorf("match: got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPassword(t *testing.T) {
+	if err := CheckPassword("short"); err == nil {
+		t.Error("CheckPassword: short password accepted")
+	}
+	if err := CheckPassword("long enough password"); err != nil {
+		t.Errorf("CheckPassword: got %v", err)
+	}
+}
+
+func TestNewToken(t *testing.T) {
+	a, err := NewToken(TokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewToken(TokenPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(a, TokenPrefix) || len(a) != len(TokenPrefix)+43 {
+		t.Errorf("NewToken: got %q", a)
+	}
+	if a == b {
+		t.Error("NewToken: two tokens are equal")
+	}
+	if HashToken(a) == HashToken(b) || HashToken(a) != HashToken(a) || len(HashToken(a)) != 64 {
+		t.Errorf("HashToken: got %q and %q", HashToken(a), HashToken(b))
+	}
+}
+
+func TestValidScope(t *testing.T) {
+	for _, s := range Scopes {
+		if !ValidScope(string(s)) {
+			t.Errorf("ValidScope(%q): got false", s)
+		}
+	}
+	if ValidScope("incidents:delete") {
+		t.Error("ValidScope: unknown scope accepted")
+	}
+}
@@ -0,0 +1,408 @@
+// Package blobs stores large files on disk beside the database, addressed
+// by the SHA-256 of their content. A file is written once however many
+// times it is stored. With a keyring, files are encrypted; the address is
+// still the digest of the plaintext.
+package blobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/keyring"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+	ErrTooLarge = errors.New("blob too large")
+	// ErrCorrupt is returned when the content read does not match its
+	// digest or size.
+	ErrCorrupt = errors.New("blob corrupt")
+)
+
+// tmpDir holds files while they are written. It is inside the store so
+// that finished files can be renamed into place.
+const tmpDir = "tmp"
+
+// encSuffix marks encrypted files. Plain files written before encryption
+// was enabled stay readable until Reencrypt converts them.
+const encSuffix = ".enc"
+
+// Blob identifies stored content.
+type Blob struct {
+	SHA256 string
+	Size   int64
+}
+
+// Store keeps blobs under dir as <dir>/<first two hex digits>/<sha256>,
+// with encSuffix appended when encrypted.
+type Store struct {
+	dir  string
+	keys *keyring.Keyring
+}
+
+// NewStore opens the store in dir. New blobs are encrypted with keys unless
+// it is nil.
+func NewStore(dir string, keys *keyring.Keyring) (*Store, error) {
+	if err := os.MkdirAll(filepath.Join(dir, tmpDir), 0o750); err != nil {
+		return nil, fmt.Errorf("open blob store error: %v", err)
+	}
+
+	return &Store{dir: dir, keys: keys}, nil
+}
+
+// Put streams r into the store. Content longer than limit bytes is
+// rejected with ErrTooLarge; limit <= 0 means no limit. Storing content
+// that is already there keeps the existing file.
+func (s *Store) Put(r io.Reader, limit int64) (Blob, error) {
+	f, err := os.CreateTemp(filepath.Join(s.dir, tmpDir), "put-*")
+	if err != nil {
+		return Blob{}, fmt.Errorf("put blob error: %v", err)
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	var (
+		w   io.Writer = f
+		enc io.WriteCloser
+	)
+	if s.keys != nil {
+		if enc, err = s.keys.NewWriter(f); err != nil {
+			f.Close()
+			return Blob{}, fmt.Errorf("put blob error: %v", err)
+		}
+		w = enc
+	}
+
+	h := sha256.New()
+	if limit > 0 {
+		r = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if err == nil && limit > 0 && n > limit {
+		err = ErrTooLarge
+	}
+	if err == nil && enc != nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if errors.Is(err, ErrTooLarge) {
+		return Blob{}, fmt.Errorf("put blob error: %w: more than %d bytes", err, limit)
+	}
+	if err != nil {
+		return Blob{}, fmt.Errorf("put blob error: %v", err)
+	}
+
+	b := Blob{SHA256: hex.EncodeToString(h.Sum(nil)), Size: n}
+	for _, existing := range []string{s.path(b.SHA256) + encSuffix, s.path(b.SHA256)} {
+		if _, err := os.Stat(existing); err == nil {
+			// Already stored. Touch it so that Sweep's grace period starts
+			// over and it is not collected before the caller references it.
+			now := time.Now()
+			if err := os.Chtimes(existing, now, now); err != nil {
+				return Blob{}, fmt.Errorf("put blob error: %v", err)
+			}
+			return b, nil
+		}
+	}
+
+	dest := s.path(b.SHA256)
+	if s.keys != nil {
+		dest += encSuffix
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0o750); err != nil {
+		return Blob{}, fmt.Errorf("put blob error: %v", err)
+	}
+	if err := os.Chmod(tmp, 0o440); err != nil {
+		return Blob{}, fmt.Errorf("put blob error: %v", err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		return Blob{}, fmt.Errorf("put blob error: %v", err)
+	}
+
+	return b, nil
+}
+
+// Open returns a reader of the blob that verifies its content as it goes:
+// the read that reaches the end returns ErrCorrupt instead of io.EOF when
+// the content does not match sum.
+func (s *Store) Open(sum string) (io.ReadCloser, error) {
+	if !validSum(sum) {
+		return nil, ErrNotFound
+	}
+
+	f, err := os.Open(s.path(sum) + encSuffix)
+	if err == nil {
+		if s.keys == nil {
+			f.Close()
+			return nil, fmt.Errorf("open blob error: %s is encrypted and no key is configured", sum)
+		}
+		r, err := s.keys.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("open blob error: %w", err)
+		}
+		return &verifier{r: r, c: f, h: sha256.New(), sum: sum}, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("open blob error: %v", err)
+	}
+
+	f, err = os.Open(s.path(sum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open blob error: %v", err)
+	}
+
+	return &verifier{r: f, c: f, h: sha256.New(), sum: sum}, nil
+}
+
+// Verify reads the whole blob and reports whether it matches b.
+func (s *Store) Verify(b Blob) error {
+	rc, err := s.Open(b.SHA256)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	n, err := io.Copy(io.Discard, rc)
+	if err != nil {
+		return err
+	}
+	if n != b.Size {
+		return fmt.Errorf("%w: %s is %d bytes, want %d", ErrCorrupt, b.SHA256, n, b.Size)
+	}
+
+	return nil
+}
+
+// Remove deletes the blob. Removing a missing blob is not an error.
+func (s *Store) Remove(sum string) error {
+	if !validSum(sum) {
+		return ErrNotFound
+	}
+	for _, path := range []string{s.path(sum), s.path(sum) + encSuffix} {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("remove blob error: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Sweep removes the blobs that keep does not want and the files left in
+// the temporary directory by interrupted writes. Only files last modified
+// before olderThan are considered, so that a blob just put is not removed
+// before the caller had a chance to reference it. It returns the number of
+// files removed and their total size.
+func (s *Store) Sweep(keep func(sum string) bool, olderThan time.Time) (int, int64, error) {
+	var (
+		removed int
+		freed   int64
+	)
+
+	err := filepath.WalkDir(s.dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() {
+			return nil
+		}
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if !info.ModTime().Before(olderThan) {
+			return nil
+		}
+
+		inTmp := filepath.Base(filepath.Dir(path)) == tmpDir
+		sum := strings.TrimSuffix(e.Name(), encSuffix)
+		if !inTmp && (!validSum(sum) || keep(sum)) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		freed += info.Size()
+		return nil
+	})
+	if err != nil {
+		return removed, freed, fmt.Errorf("sweep blobs error: %v", err)
+	}
+
+	return removed, freed, nil
+}
+
+// Reencrypt encrypts the plain blobs and rewraps the data keys of the
+// encrypted ones that are not under the primary key. Plain blobs are
+// verified on the way. It returns the number of files rewritten.
+func (s *Store) Reencrypt() (int, error) {
+	if s.keys == nil {
+		return 0, errors.New("reencrypt blobs error: no key is configured")
+	}
+
+	var paths []string
+	err := filepath.WalkDir(s.dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if e.IsDir() && e.Name() == tmpDir {
+			return filepath.SkipDir
+		}
+		if !e.IsDir() && validSum(strings.TrimSuffix(e.Name(), encSuffix)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("reencrypt blobs error: %v", err)
+	}
+
+	n := 0
+	for _, path := range paths {
+		changed, err := s.reencrypt(path)
+		if err != nil {
+			return n, fmt.Errorf("reencrypt blobs error: %s: %w", filepath.Base(path), err)
+		}
+		if changed {
+			n++
+		}
+	}
+
+	return n, nil
+}
+
+func (s *Store) reencrypt(path string) (bool, error) {
+	sum := strings.TrimSuffix(filepath.Base(path), encSuffix)
+	encrypted := strings.HasSuffix(path, encSuffix)
+
+	src, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+
+	var header []byte
+	if encrypted {
+		header = make([]byte, keyring.HeaderSize)
+		if _, err := io.ReadFull(src, header); err != nil {
+			return false, ErrCorrupt
+		}
+		if s.keys.Current(header) {
+			return false, nil
+		}
+		if header, err = s.keys.Rewrap(header); err != nil {
+			return false, err
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Join(s.dir, tmpDir), "reencrypt-*")
+	if err != nil {
+		return false, err
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+
+	if encrypted {
+		// Only the header changes; the chunks are copied as they are.
+		if _, err = f.Write(header); err == nil {
+			_, err = io.Copy(f, src)
+		}
+	} else {
+		err = s.encryptTo(f, &verifier{r: src, c: src, h: sha256.New(), sum: sum})
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := os.Chmod(tmp, 0o440); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp, s.path(sum)+encSuffix); err != nil {
+		return false, err
+	}
+	if !encrypted {
+		if err := os.Remove(path); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (s *Store) encryptTo(w io.Writer, r io.Reader) error {
+	enc, err := s.keys.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(enc, r); err != nil {
+		return err
+	}
+
+	return enc.Close()
+}
+
+func (s *Store) path(sum string) string {
+	return filepath.Join(s.dir, sum[:2], sum)
+}
+
+func validSum(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range sum {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+
+	return true
+}
+
+type verifier struct {
+	r   io.Reader
+	c   io.Closer
+	h   hash.Hash
+	sum string
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+	if errors.Is(err, keyring.ErrMalformed) {
+		return n, fmt.Errorf("%w: %s: %v", ErrCorrupt, v.sum, err)
+	}
+	if err == io.EOF && hex.EncodeToString(v.h.Sum(nil)) != v.sum {
+		return n, fmt.Errorf("%w: %s does not match its content", ErrCorrupt, v.sum)
+	}
+
+	return n, err
+}
+
+func (v *verifier) Close() error {
+	return v.c.Close()
+}
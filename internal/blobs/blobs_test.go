@@ -0,0 +1,226 @@
+package blobs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/keyring"
+)
+
+func testStore(t *testing.T) *Store {
+	t.Helper()
+
+	s, err := NewStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestStore_Put(t *testing.T) {
+	s := testStore(t)
+
+	t.Run("Put: stores by digest", func(t *testing.T) {
+		b, err := s.Put(strings.NewReader("hello"), 0)
+		if err != nil {
+			t.Fatalf("failed to put: %v", err)
+		}
+		want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+		if b.SHA256 != want || b.Size != 5 {
+			t.Errorf("got %+v want %s", b, want)
+		}
+		if _, err := os.Stat(filepath.Join(s.dir, "2c", want)); err != nil {
+			t.Errorf("file: %v", err)
+		}
+	})
+
+	t.Run("Put: deduplicates", func(t *testing.T) {
+		a, _ := s.Put(strings.NewReader("same"), 0)
+		b, err := s.Put(strings.NewReader("same"), 0)
+		if err != nil || a != b {
+			t.Fatalf("got %+v, %v want %+v", b, err, a)
+		}
+		entries, _ := os.ReadDir(filepath.Join(s.dir, a.SHA256[:2]))
+		if len(entries) != 1 {
+			t.Errorf("files: got %d want 1", len(entries))
+		}
+	})
+
+	t.Run("Put: limit", func(t *testing.T) {
+		if _, err := s.Put(strings.NewReader("12345"), 5); err != nil {
+			t.Errorf("content at the limit: %v", err)
+		}
+		if _, err := s.Put(strings.NewReader("123456"), 5); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("expected ErrTooLarge, got %v", err)
+		}
+		tmp, _ := os.ReadDir(filepath.Join(s.dir, tmpDir))
+		if len(tmp) != 0 {
+			t.Errorf("temporary files left: %d", len(tmp))
+		}
+	})
+}
+
+func TestStore_Open(t *testing.T) {
+	s := testStore(t)
+	b, err := s.Put(strings.NewReader("log line"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Open: reads content", func(t *testing.T) {
+		rc, err := s.Open(b.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, err := io.ReadAll(rc)
+		if err != nil || string(got) != "log line" {
+			t.Errorf("got %q, %v", got, err)
+		}
+	})
+
+	t.Run("Open: missing or invalid", func(t *testing.T) {
+		for _, sum := range []string{strings.Repeat("0", 64), "../../etc/passwd", ""} {
+			if _, err := s.Open(sum); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%q: expected ErrNotFound, got %v", sum, err)
+			}
+		}
+	})
+
+	t.Run("Open: detects corruption", func(t *testing.T) {
+		path := s.path(b.SHA256)
+		os.Chmod(path, 0o640)
+		if err := os.WriteFile(path, []byte("log lime"), 0o640); err != nil {
+			t.Fatal(err)
+		}
+		rc, err := s.Open(b.SHA256)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if _, err := io.ReadAll(rc); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected ErrCorrupt, got %v", err)
+		}
+		if err := s.Verify(b); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("verify: expected ErrCorrupt, got %v", err)
+		}
+	})
+}
+
+func TestStore_Sweep(t *testing.T) {
+	s := testStore(t)
+	keep, _ := s.Put(strings.NewReader("keep"), 0)
+	drop, _ := s.Put(bytes.NewReader(make([]byte, 100)), 0)
+	stale := filepath.Join(s.dir, tmpDir, "put-1")
+	if err := os.WriteFile(stale, []byte("partial"), 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Sweep: grace period", func(t *testing.T) {
+		n, _, err := s.Sweep(func(string) bool { return false }, time.Now().Add(-time.Hour))
+		if err != nil || n != 0 {
+			t.Errorf("removed %d, %v; want 0", n, err)
+		}
+	})
+
+	t.Run("Sweep: unreferenced and temporary files", func(t *testing.T) {
+		n, freed, err := s.Sweep(func(sum string) bool { return sum == keep.SHA256 }, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 || freed != 100+int64(len("partial")) {
+			t.Errorf("removed %d files, %d bytes", n, freed)
+		}
+		if err := s.Verify(keep); err != nil {
+			t.Errorf("kept blob: %v", err)
+		}
+		if _, err := s.Open(drop.SHA256); !errors.Is(err, ErrNotFound) {
+			t.Errorf("dropped blob: %v", err)
+		}
+	})
+}
+
+func TestStore_Encryption(t *testing.T) {
+	dir := t.TempDir()
+	keyA, keyB := make([]byte, keyring.KeySize), make([]byte, keyring.KeySize)
+	rand.Read(keyA)
+	rand.Read(keyB)
+	ringA, _ := keyring.New(keyA)
+
+	plainStore, _ := NewStore(dir, nil)
+	old, err := plainStore.Put(strings.NewReader("written before encryption"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ := NewStore(dir, ringA)
+	content := strings.Repeat("customer alice@example.com\n", 5000)
+	b, err := s.Put(strings.NewReader(content), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Encryption: stored sealed", func(t *testing.T) {
+		raw, err := os.ReadFile(s.path(b.SHA256) + encSuffix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, []byte("alice")) {
+			t.Error("plaintext found in the blob file")
+		}
+		if err := s.Verify(b); err != nil {
+			t.Errorf("verify: %v", err)
+		}
+		if _, err := plainStore.Open(b.SHA256); err == nil {
+			t.Error("expected an error opening an encrypted blob without a key")
+		}
+	})
+
+	t.Run("Encryption: corruption", func(t *testing.T) {
+		dup, _ := s.Put(strings.NewReader("small"), 0)
+		path := s.path(dup.SHA256) + encSuffix
+		raw, _ := os.ReadFile(path)
+		raw[len(raw)-1] ^= 1
+		os.Chmod(path, 0o640)
+		os.WriteFile(path, raw, 0o640)
+		if err := s.Verify(dup); !errors.Is(err, ErrCorrupt) {
+			t.Errorf("expected ErrCorrupt, got %v", err)
+		}
+		s.Remove(dup.SHA256)
+	})
+
+	t.Run("Reencrypt: plain blobs and rotation", func(t *testing.T) {
+		n, err := s.Reencrypt()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("reencrypted: got %d want 1", n)
+		}
+		if _, err := os.Stat(s.path(old.SHA256)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("plain file left: %v", err)
+		}
+
+		ringBA, _ := keyring.New(keyB, keyA)
+		rotated, _ := NewStore(dir, ringBA)
+		if n, err := rotated.Reencrypt(); err != nil || n != 2 {
+			t.Fatalf("rewrapped %d, %v; want 2", n, err)
+		}
+
+		ringB, _ := keyring.New(keyB)
+		only, _ := NewStore(dir, ringB)
+		for _, want := range []Blob{old, b} {
+			if err := only.Verify(want); err != nil {
+				t.Errorf("new key alone: %v", err)
+			}
+		}
+	})
+}
@@ -5,41 +5,174 @@ import (
 	"net/http"
 
 	"github.com/a-h/templ"
+	"github.com/dtoebe/RootTensor/internal/store"
 	"github.com/dtoebe/RootTensor/internal/templates"
 )
 
+// homeIncidents is how many of the latest incidents the home page lists.
+const homeIncidents = 50
+
 func (s *HTTPServer) routes() http.Handler {
 	mux := http.NewServeMux()
 
-	mux.HandleFunc("/", s.handlePage("Home", templates.HomePage()))
+	mux.HandleFunc("/", s.handleHome)
 	mux.HandleFunc("GET /about", s.handlePage("About", templates.AboutPage()))
-	mux.HandleFunc("GET /settings", s.handlePage("Settings", templates.SettingsPage()))
+	mux.HandleFunc("GET /settings", s.handleSettings)
+	mux.HandleFunc("POST /settings", s.handleSettingsSave)
+	mux.HandleFunc("POST /settings/workspace", s.handleWorkspaceSettings)
+	mux.HandleFunc("POST /settings/retention", s.handleRetentionSet)
+	mux.HandleFunc("POST /settings/retention/{kind}/delete", s.handleRetentionDelete)
 	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+
+	mux.HandleFunc("GET /search", s.handleSearch)
+	mux.HandleFunc("GET /api/v1/search", s.handleAPISearch)
+	mux.HandleFunc("/api/v1/", s.handleAPINotFound)
+	mux.HandleFunc("GET /api/v1/incidents", s.handleAPIIncidents)
+	mux.HandleFunc("POST /api/v1/incidents", s.handleAPIIncidentCreate)
+	mux.HandleFunc("GET /api/v1/incidents/{id}", s.handleAPIIncident)
+	mux.HandleFunc("PATCH /api/v1/incidents/{id}", s.handleAPIIncidentUpdate)
+	mux.HandleFunc("DELETE /api/v1/incidents/{id}", s.handleAPIIncidentDelete)
+	mux.HandleFunc("GET /api/v1/incidents/{id}/evidence", s.handleAPIEvidenceList)
+	mux.HandleFunc("POST /api/v1/incidents/{id}/evidence", s.handleAPIEvidenceCreate)
+	mux.HandleFunc("GET /api/v1/incidents/{id}/timeline", s.handleAPITimeline)
+	mux.HandleFunc("POST /api/v1/incidents/{id}/timeline", s.handleAPITimelineCreate)
+	mux.HandleFunc("GET /api/v1/incidents/{id}/hypotheses", s.handleAPIHypotheses)
+	mux.HandleFunc("POST /api/v1/incidents/{id}/hypotheses", s.handleAPIHypothesisCreate)
+	mux.HandleFunc("GET /api/v1/incidents/{id}/action-items", s.handleAPIActionItems)
+	mux.HandleFunc("POST /api/v1/incidents/{id}/action-items", s.handleAPIActionItemCreate)
+	mux.HandleFunc("GET /api/v1/evidence/{id}", s.handleAPIEvidence)
+	mux.HandleFunc("PATCH /api/v1/evidence/{id}", s.handleAPIEvidenceUpdate)
+	mux.HandleFunc("DELETE /api/v1/evidence/{id}", s.handleAPIEvidenceDelete)
+	mux.HandleFunc("GET /api/v1/timeline/{id}", s.handleAPITimelineEvent)
+	mux.HandleFunc("PATCH /api/v1/timeline/{id}", s.handleAPITimelineUpdate)
+	mux.HandleFunc("DELETE /api/v1/timeline/{id}", s.handleAPITimelineDelete)
+	mux.HandleFunc("GET /api/v1/hypotheses/{id}", s.handleAPIHypothesis)
+	mux.HandleFunc("PATCH /api/v1/hypotheses/{id}", s.handleAPIHypothesisUpdate)
+	mux.HandleFunc("GET /api/v1/action-items/{id}", s.handleAPIActionItem)
+	mux.HandleFunc("PATCH /api/v1/action-items/{id}", s.handleAPIActionItemUpdate)
+
+	mux.HandleFunc("GET /analyses/{id}", s.handleAnalysis)
+	mux.HandleFunc("POST /analyses/{id}/critique", s.handleAnalysisCritique)
+	mux.HandleFunc("POST /analyses/{id}/publish", s.handleAnalysisPublish)
+
+	mux.HandleFunc("GET /debates/new", s.handleDebateNew)
+	mux.HandleFunc("POST /debates", s.handleDebateCreate)
+	mux.HandleFunc("GET /debates/{id}", s.handleDebate)
+
+	mux.HandleFunc("GET /incidents/{id}", s.handleIncident)
+	mux.HandleFunc("POST /incidents/{id}/timeline", s.handleTimelineCreate)
+	mux.HandleFunc("POST /incidents/{id}/evidence", s.handleEvidenceUpload)
+	mux.HandleFunc("GET /evidence/{id}/file", s.handleEvidenceFile)
+	mux.HandleFunc("POST /incidents/{id}/hypotheses", s.handleHypothesisCreate)
+	mux.HandleFunc("POST /hypotheses/{id}/status", s.handleHypothesisStatus)
+	mux.HandleFunc("POST /incidents/{id}/action-items", s.handleActionItemCreate)
+	mux.HandleFunc("POST /action-items/{id}/status", s.handleActionItemStatus)
+	mux.HandleFunc("POST /incidents/{id}/analyses", s.handleAnalysisStart)
+	mux.HandleFunc("POST /incidents/{id}/chat", s.handleChatMessage)
+	mux.HandleFunc("GET /incidents/{id}/chat.md", s.handleChatMarkdown)
+	mux.HandleFunc("POST /chat-messages/{id}/regenerate", s.handleChatRegenerate)
+	mux.HandleFunc("GET /runs/{id}/events", s.handleRunEvents)
+	mux.HandleFunc("POST /runs/{id}/cancel", s.handleRunCancel)
+	mux.HandleFunc("GET /incidents/{id}/history", s.handleIncidentHistory)
+	mux.HandleFunc("GET /incidents/{id}/history/{audit}", s.handleIncidentVersion)
+	mux.HandleFunc("GET /incidents/{id}/export", s.handleIncidentExport)
+	mux.HandleFunc("GET /bundles/import", s.handlePage("Import Incident Bundle", templates.ImportBundlePage()))
+	mux.HandleFunc("POST /bundles/import", s.handleBundleImport)
+
+	mux.HandleFunc("GET /personas", s.handlePersonas)
+	mux.HandleFunc("POST /incidents/{id}/persona", s.handleAssignPersona(store.PersonaSubjectIncident))
+	mux.HandleFunc("POST /chats/{id}/persona", s.handleAssignPersona(store.PersonaSubjectChat))
+
+	mux.HandleFunc("GET /account", s.handleAccount)
+	mux.HandleFunc("POST /account/password", s.handlePasswordChange)
+	mux.HandleFunc("POST /account/tokens", s.handleTokenCreate)
+	mux.HandleFunc("POST /account/tokens/{id}/delete", s.handleTokenDelete)
+
+	mux.HandleFunc("POST /workspace", s.handleSwitchWorkspace)
+	mux.HandleFunc("GET /admin/workspaces", s.handleAdminWorkspaces)
+	mux.HandleFunc("POST /admin/workspaces", s.handleAdminWorkspaceCreate)
+	mux.HandleFunc("GET /admin/workspaces/{slug}", s.handleAdminWorkspace)
+	mux.HandleFunc("POST /admin/workspaces/{slug}", s.handleAdminWorkspaceRename)
+	mux.HandleFunc("POST /admin/workspaces/{slug}/delete", s.handleAdminWorkspaceDelete)
+	mux.HandleFunc("POST /admin/workspaces/{slug}/members", s.handleAdminMemberAdd)
+	mux.HandleFunc("POST /admin/workspaces/{slug}/members/{member}/delete", s.handleAdminMemberRemove)
 
 	mux.Handle("/static/",
 		http.StripPrefix("/static/",
 			http.FileServer(http.Dir("web/static"))))
 
-	return mux
+	// Signing in and out needs no workspace, so it sits in front of the
+	// others.
+	root := http.NewServeMux()
+	root.HandleFunc("GET /login", s.handleLoginPage)
+	root.HandleFunc("POST /login", s.handleLogin)
+	root.HandleFunc("POST /logout", s.handleLogout)
+	root.Handle("/", s.withAuth(mux, withActor(s.withWorkspace(withPolicy(mux, mux)))))
+
+	return chain(http.NewCrossOriginProtection().Handler(root),
+		withRequestID,
+		withAccessLog,
+		withGzip,
+		withTimeouts(mux),
+		// Inside the timeout, which runs the handler in a goroutine of its
+		// own and would lose the stack of a panic.
+		withRecovery,
+	)
+}
+
+// handleHome lists the incidents of the current workspace. It also
+// answers every path no other route matches, with a 404.
+func (s *HTTPServer) handleHome(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var incidents []store.Incident
+	if s.db != nil {
+		var err error
+		incidents, err = s.db.ListIncidents(r.Context(), store.IncidentFilter{Limit: homeIncidents})
+		if err != nil {
+			log.Printf("list incidents error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.render(w, r, "Home", templates.HomePage(incidents))
 }
 
 func (s *HTTPServer) handlePage(title string, content templ.Component) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		if err := templates.Layout(title, r.URL.Path, content).Render(r.Context(), w); err != nil {
-			http.Error(w, "render error", http.StatusInternalServerError)
-			log.Printf("layout render error: %v", err)
-		}
+		s.render(w, r, title, content)
+	}
+}
+
+func (s *HTTPServer) render(w http.ResponseWriter, r *http.Request, title string, content templ.Component) {
+	nav, err := s.workspaceNav(r.Context())
+	if err != nil {
+		log.Printf("list workspaces error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := templates.Layout(title, r.URL.Path, nav, content).Render(r.Context(), w); err != nil {
+		http.Error(w, "render error", http.StatusInternalServerError)
+		log.Printf("layout render error: %v", err)
 	}
 }
 
+// handleHealthz is the liveness probe: it answers as long as the process
+// serves requests, whatever the state of its dependencies. See handleReadyz.
 func (s *HTTPServer) handleHealthz(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	w.Header().Set("Content-Type", "plain/text; charset=utf-8")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("OK")); err != nil {
 		log.Printf("error writing response: %s; error: %v", r.URL, err)
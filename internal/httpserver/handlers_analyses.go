@@ -0,0 +1,115 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+func (s *HTTPServer) handleAnalysis(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.loadAnalysis(w, r)
+	if !ok {
+		return
+	}
+
+	var critique *llm.Critique
+	if a.Critique != "" {
+		critique = &llm.Critique{}
+		if err := json.Unmarshal([]byte(a.Critique), critique); err != nil {
+			log.Printf("analysis %d critique decode error: %v", a.ID, err)
+			critique = nil
+		}
+	}
+
+	s.render(w, r, fmt.Sprintf("RCA Draft #%d", a.ID), templates.AnalysisPage(a, critique))
+}
+
+// handleAnalysisCritique runs the verifier over a draft and stores the
+// annotations next to it.
+func (s *HTTPServer) handleAnalysisCritique(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.loadAnalysis(w, r)
+	if !ok {
+		return
+	}
+	if s.llm == nil {
+		http.Error(w, "no model backend configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	critique, err := llm.CritiqueDraft(r.Context(), s.chatter(r.Context()), a.Draft, a.Evidence)
+	if err != nil {
+		log.Printf("analysis %d critique error: %v", a.ID, err)
+		http.Error(w, "critique failed", http.StatusBadGateway)
+		return
+	}
+
+	b, err := json.Marshal(critique)
+	if err != nil {
+		log.Printf("analysis %d critique marshal error: %v", a.ID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.SetAnalysisCritique(r.Context(), a.ID, string(b)); err != nil {
+		log.Printf("analysis %d critique store error: %v", a.ID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/analyses/%d", a.ID), http.StatusSeeOther)
+}
+
+// handleAnalysisPublish makes a draft the published RCA of its incident.
+func (s *HTTPServer) handleAnalysisPublish(w http.ResponseWriter, r *http.Request) {
+	a, ok := s.loadAnalysis(w, r)
+	if !ok {
+		return
+	}
+
+	inc, err := s.db.GetIncident(r.Context(), a.IncidentID)
+	if err != nil {
+		log.Printf("get incident %d error: %v", a.IncidentID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	inc.RCA = a.Draft
+	err = s.db.UpdateIncident(r.Context(), inc)
+	if errors.Is(err, store.ErrForbidden) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		log.Printf("publish analysis %d error: %v", a.ID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("/incidents/%d#rca", inc.ID), http.StatusSeeOther)
+}
+
+func (s *HTTPServer) loadAnalysis(w http.ResponseWriter, r *http.Request) (*store.Analysis, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid analysis id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	a, err := s.db.GetAnalysis(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("get analysis %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return a, true
+}
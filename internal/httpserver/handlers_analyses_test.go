@@ -0,0 +1,79 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleAnalysisCritique(t *testing.T) {
+	svr := setupServerWithDB(t)
+	svr.llm = &fakeChatter{resp: `{"claims":[
+		{"claim":"Redis pool was exhausted","verdict":"supported","evidence":[1]},
+		{"claim":"A kernel bug caused it","verdict":"unsupported"}
+	]}`}
+
+	inc := &store.Incident{Title: "redis timeouts"}
+	if err := svr.db.CreateIncident(context.Background(), inc); err != nil {
+		t.Fatal(err)
+	}
+	a := &store.Analysis{
+		IncidentID: inc.ID,
+		Draft:      "Redis pool was exhausted. A kernel bug caused it.",
+		Evidence:   []string{"redis: pool exhausted"},
+	}
+	if err := svr.db.CreateAnalysis(context.Background(), a); err != nil {
+		t.Fatalf("failed to create analysis: %v", err)
+	}
+	h := loopback(svr.routes())
+
+	t.Run("critique: stores annotations and redirects", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/analyses/%d/critique", a.ID), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+
+		got, err := svr.db.GetAnalysis(context.Background(), a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(got.Critique, "A kernel bug caused it") {
+			t.Errorf("critique not stored: %q", got.Critique)
+		}
+	})
+
+	t.Run("page: highlights unsupported claims", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/analyses/%d", a.ID), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "<mark>A kernel bug caused it</mark>") {
+			t.Errorf("unsupported claim not highlighted:\n%s", body)
+		}
+		if strings.Contains(body, "<mark>Redis pool was exhausted</mark>") {
+			t.Errorf("supported claim highlighted:\n%s", body)
+		}
+	})
+
+	t.Run("page: not found", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/analyses/9999", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,305 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+const (
+	// maxAPIBody bounds a JSON request body; evidence content is the
+	// largest thing sent.
+	maxAPIBody = 8 << 20
+
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// errPreconditionFailed is returned through the store when If-Match names
+// another version of the row.
+var errPreconditionFailed = errors.New("precondition failed")
+
+// problem is an RFC 9457 problem details body. Every API error is one.
+type problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Errors   []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError is what is wrong with one field of a request body or query.
+type fieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// fieldErrors collects validation failures.
+type fieldErrors []fieldError
+
+func (e *fieldErrors) add(field, format string, args ...any) {
+	*e = append(*e, fieldError{Field: field, Detail: fmt.Sprintf(format, args...)})
+}
+
+// listJSON is one page of a list. NextCursor is passed back as the cursor
+// parameter for the next page and is empty on the last one.
+type listJSON[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, errs ...fieldError) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(status)
+	p := problem{
+		Type:     "about:blank",
+		Title:    http.StatusText(status),
+		Status:   status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Errors:   errs,
+	}
+	if err := json.NewEncoder(w).Encode(p); err != nil {
+		log.Printf("problem encode error: %v", err)
+	}
+}
+
+// writeInvalid reports field errors as 422 Unprocessable Content.
+func writeInvalid(w http.ResponseWriter, r *http.Request, errs fieldErrors) {
+	writeProblem(w, r, http.StatusUnprocessableEntity, "the request has invalid fields", errs...)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("json encode error: %v", err)
+	}
+}
+
+// writeStoreError maps a store error to its problem; anything unexpected
+// is logged and reported as a 500 without details.
+func writeStoreError(w http.ResponseWriter, r *http.Request, op string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		writeProblem(w, r, http.StatusNotFound, "")
+	case errors.Is(err, errPreconditionFailed):
+		writeProblem(w, r, http.StatusPreconditionFailed, "the resource was changed since it was read; fetch it again")
+	case errors.Is(err, store.ErrForbidden):
+		writeProblem(w, r, http.StatusForbidden, err.Error())
+	default:
+		log.Printf("%s error: %v", op, err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+	}
+}
+
+func (s *HTTPServer) handleAPINotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, http.StatusNotFound, "no such API route")
+}
+
+// decodeJSON reads the JSON body of r into v. Unknown fields, trailing data
+// and other content types are rejected. It writes the problem itself and
+// reports whether decoding succeeded.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+		writeProblem(w, r, http.StatusUnsupportedMediaType, "send application/json")
+		return false
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("unexpected data after the JSON value")
+	}
+	if err == nil {
+		return true
+	}
+
+	var (
+		maxErr  *http.MaxBytesError
+		typeErr *json.UnmarshalTypeError
+	)
+	switch {
+	case errors.As(err, &maxErr):
+		writeProblem(w, r, http.StatusRequestEntityTooLarge, fmt.Sprintf("the body is larger than %d bytes", maxAPIBody))
+	case errors.As(err, &typeErr):
+		writeInvalid(w, r, fieldErrors{{Field: typeErr.Field, Detail: "must be a " + typeErr.Type.String()}})
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field := strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`)
+		writeInvalid(w, r, fieldErrors{{Field: field, Detail: "unknown field"}})
+	default:
+		writeProblem(w, r, http.StatusBadRequest, "malformed JSON: "+err.Error())
+	}
+	return false
+}
+
+// etag is the strong entity tag of the JSON representation v.
+func etag(v any) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		// The views are plain structs; this cannot happen.
+		panic(fmt.Sprintf("etag marshal error: %v", err))
+	}
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// writeEntity sends the representation v with its ETag, or 304 when the
+// client already has it.
+func writeEntity(w http.ResponseWriter, r *http.Request, status int, v any) {
+	tag := etag(v)
+	w.Header().Set("ETag", tag)
+	if r.Method == http.MethodGet && matchesETag(r.Header.Get("If-None-Match"), tag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	writeJSON(w, status, v)
+}
+
+// matchesETag reports whether the If-Match or If-None-Match header value
+// names tag, or is "*". If-None-Match compares weakly, ignoring a "W/"
+// prefix; If-Match compares strongly, so a weak tag never matches.
+func matchesETag(header, tag string, weak bool) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" {
+			return true
+		}
+		if weak {
+			t, tag = strings.TrimPrefix(t, "W/"), strings.TrimPrefix(tag, "W/")
+		} else if strings.HasPrefix(t, "W/") || strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ifMatch returns the context for an update or delete of the resource of r.
+// With an If-Match header the store checks, in the transaction of the
+// write, that the current row still has a matching ETag; view turns the
+// stored row into its representation.
+func ifMatch[T any, V any](r *http.Request, view func(*T) V) context.Context {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return r.Context()
+	}
+
+	return store.WithPrecondition(r.Context(), func(current any) error {
+		row, ok := current.(*T)
+		if !ok || !matchesETag(header, etag(view(row)), false) {
+			return errPreconditionFailed
+		}
+		return nil
+	})
+}
+
+// encodeCursor turns a store cursor into an opaque page token.
+func encodeCursor(c store.Cursor) string {
+	b, err := json.Marshal(struct {
+		Key any   `json:"k"`
+		ID  int64 `json:"id"`
+	}{c.Key, c.ID})
+	if err != nil {
+		panic(fmt.Sprintf("cursor marshal error: %v", err))
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// decodeCursor reads a page token whose key is a K, or null. An empty
+// token is the first page.
+func decodeCursor[K any](token string) (*store.Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, errors.New("not a cursor from this API")
+	}
+
+	var raw struct {
+		Key json.RawMessage `json:"k"`
+		ID  int64           `json:"id"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil || raw.ID <= 0 {
+		return nil, errors.New("not a cursor from this API")
+	}
+	c := &store.Cursor{ID: raw.ID}
+	if len(raw.Key) > 0 && !bytes.Equal(raw.Key, []byte("null")) {
+		var key K
+		if err := json.Unmarshal(raw.Key, &key); err != nil {
+			return nil, errors.New("not a cursor from this API")
+		}
+		c.Key = key
+	}
+
+	return c, nil
+}
+
+// pageParams reads limit and cursor; the cursor key is a K.
+func pageParams[K any](v url.Values, errs *fieldErrors) (int, *store.Cursor) {
+	limit := defaultPageSize
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > maxPageSize {
+			errs.add("limit", "must be between 1 and %d", maxPageSize)
+		} else {
+			limit = n
+		}
+	}
+
+	c, err := decodeCursor[K](v.Get("cursor"))
+	if err != nil {
+		errs.add("cursor", "%v", err)
+	}
+
+	return limit, c
+}
+
+// page builds the response for rows fetched with limit+1: the extra row
+// only tells that there is a next page.
+func page[R any, V any](rows []R, limit int, cursor func(*R) store.Cursor, view func(*R) V) listJSON[V] {
+	out := listJSON[V]{Data: []V{}}
+	for i := range rows {
+		if i == limit {
+			out.NextCursor = encodeCursor(cursor(&rows[i-1]))
+			break
+		}
+		out.Data = append(out.Data, view(&rows[i]))
+	}
+
+	return out
+}
+
+// pathID reads the id path value, writing a 404 for anything that cannot
+// be an id.
+func pathID(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		writeProblem(w, r, http.StatusNotFound, "")
+		return 0, false
+	}
+
+	return id, true
+}
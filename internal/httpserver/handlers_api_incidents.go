@@ -0,0 +1,278 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// maxTitleLen bounds titles, which are shown in lists and page headers.
+const maxTitleLen = 200
+
+type incidentJSON struct {
+	ID         int64                `json:"id"`
+	UID        string               `json:"uid"`
+	Title      string               `json:"title"`
+	Summary    string               `json:"summary"`
+	Status     store.IncidentStatus `json:"status"`
+	Severity   store.Severity       `json:"severity"`
+	RCA        string               `json:"rca"`
+	StartedAt  time.Time            `json:"started_at"`
+	DetectedAt *time.Time           `json:"detected_at"`
+	ResolvedAt *time.Time           `json:"resolved_at"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+}
+
+// incidentInput is the body of POST and PATCH. Absent fields keep their
+// value on PATCH and their default on POST.
+type incidentInput struct {
+	Title      *string               `json:"title"`
+	Summary    *string               `json:"summary"`
+	Status     *store.IncidentStatus `json:"status"`
+	Severity   *store.Severity       `json:"severity"`
+	RCA        *string               `json:"rca"`
+	StartedAt  *time.Time            `json:"started_at"`
+	DetectedAt *time.Time            `json:"detected_at"`
+	ResolvedAt *time.Time            `json:"resolved_at"`
+}
+
+func toIncidentJSON(inc *store.Incident) incidentJSON {
+	return incidentJSON{
+		ID:         inc.ID,
+		UID:        inc.UID,
+		Title:      inc.Title,
+		Summary:    inc.Summary,
+		Status:     inc.Status,
+		Severity:   inc.Severity,
+		RCA:        inc.RCA,
+		StartedAt:  inc.StartedAt,
+		DetectedAt: inc.DetectedAt,
+		ResolvedAt: inc.ResolvedAt,
+		CreatedAt:  inc.CreatedAt,
+		UpdatedAt:  inc.UpdatedAt,
+	}
+}
+
+// apply copies the fields present in in onto inc and validates the result.
+func (in *incidentInput) apply(inc *store.Incident) fieldErrors {
+	if in.Title != nil {
+		inc.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Summary != nil {
+		inc.Summary = *in.Summary
+	}
+	if in.Status != nil {
+		inc.Status = *in.Status
+	}
+	if in.Severity != nil {
+		inc.Severity = *in.Severity
+	}
+	if in.RCA != nil {
+		inc.RCA = *in.RCA
+	}
+	if in.StartedAt != nil {
+		inc.StartedAt = *in.StartedAt
+	}
+	if in.DetectedAt != nil {
+		inc.DetectedAt = in.DetectedAt
+	}
+	if in.ResolvedAt != nil {
+		inc.ResolvedAt = in.ResolvedAt
+	}
+
+	var errs fieldErrors
+	switch {
+	case inc.Title == "":
+		errs.add("title", "is required")
+	case len(inc.Title) > maxTitleLen:
+		errs.add("title", "must be at most %d bytes", maxTitleLen)
+	}
+	if !inc.Status.Valid() {
+		errs.add("status", "must be one of open, investigating, mitigated, resolved, closed")
+	}
+	if !inc.Severity.Valid() {
+		errs.add("severity", "must be one of sev1, sev2, sev3, sev4")
+	}
+	if inc.DetectedAt != nil && !inc.StartedAt.IsZero() && inc.DetectedAt.Before(inc.StartedAt) {
+		errs.add("detected_at", "must not be before started_at")
+	}
+	if inc.ResolvedAt != nil && !inc.StartedAt.IsZero() && inc.ResolvedAt.Before(inc.StartedAt) {
+		errs.add("resolved_at", "must not be before started_at")
+	}
+
+	return errs
+}
+
+// handleAPIIncidents lists incidents, newest first, filtered by status,
+// severity, service (id or name), since and until.
+func (s *HTTPServer) handleAPIIncidents(w http.ResponseWriter, r *http.Request) {
+	v := r.URL.Query()
+	var (
+		f    store.IncidentFilter
+		errs fieldErrors
+		err  error
+	)
+
+	if st := store.IncidentStatus(v.Get("status")); st != "" {
+		if !st.Valid() {
+			errs.add("status", "must be one of open, investigating, mitigated, resolved, closed")
+		}
+		f.Status = st
+	}
+	if sev := store.Severity(v.Get("severity")); sev != "" {
+		if !sev.Valid() {
+			errs.add("severity", "must be one of sev1, sev2, sev3, sev4")
+		}
+		f.Severity = sev
+	}
+	if svc := v.Get("service"); svc != "" {
+		f.ServiceID, err = s.serviceParam(r, svc)
+		if errors.Is(err, store.ErrNotFound) {
+			errs.add("service", "unknown service %q", svc)
+		} else if err != nil {
+			writeStoreError(w, r, "api incidents service", err)
+			return
+		}
+	}
+	if f.Since, err = parseSearchTime(v.Get("since"), false); err != nil {
+		errs.add("since", "%v", err)
+	}
+	if f.Until, err = parseSearchTime(v.Get("until"), true); err != nil {
+		errs.add("until", "%v", err)
+	}
+	limit, after := pageParams[time.Time](v, &errs)
+	if len(errs) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+	f.After, f.Limit = after, limit+1
+
+	incidents, err := s.db.ListIncidents(r.Context(), f)
+	if err != nil {
+		writeStoreError(w, r, "api list incidents", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page(incidents, limit, (*store.Incident).Cursor, toIncidentJSON))
+}
+
+// serviceParam resolves a service given by id or by name.
+func (s *HTTPServer) serviceParam(r *http.Request, v string) (int64, error) {
+	if id, err := strconv.ParseInt(v, 10, 64); err == nil {
+		svc, err := s.db.GetService(r.Context(), id)
+		if err != nil {
+			return 0, err
+		}
+		return svc.ID, nil
+	}
+
+	svc, err := s.db.GetServiceByName(r.Context(), v)
+	if err != nil {
+		return 0, err
+	}
+	return svc.ID, nil
+}
+
+func (s *HTTPServer) handleAPIIncidentCreate(w http.ResponseWriter, r *http.Request) {
+	var in incidentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	inc := &store.Incident{Status: store.IncidentOpen, Severity: store.Sev3, StartedAt: time.Now().UTC()}
+	if errs := in.apply(inc); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.CreateIncident(r.Context(), inc); err != nil {
+		writeStoreError(w, r, "api create incident", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/incidents/%d", inc.ID))
+	writeEntity(w, r, http.StatusCreated, toIncidentJSON(inc))
+}
+
+func (s *HTTPServer) handleAPIIncident(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	inc, err := s.db.GetIncident(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get incident", err)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, toIncidentJSON(inc))
+}
+
+func (s *HTTPServer) handleAPIIncidentUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var in incidentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	inc, err := s.db.GetIncident(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get incident", err)
+		return
+	}
+	if errs := in.apply(inc); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.UpdateIncident(ifMatch(r, toIncidentJSON), inc); err != nil {
+		writeStoreError(w, r, "api update incident", err)
+		return
+	}
+
+	inc, err = s.db.GetIncident(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get incident", err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, toIncidentJSON(inc))
+}
+
+func (s *HTTPServer) handleAPIIncidentDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteIncident(ifMatch(r, toIncidentJSON), id); err != nil {
+		writeStoreError(w, r, "api delete incident", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// apiIncident loads the incident of the {id} path value for the routes
+// below an incident, writing the problem when there is none.
+func (s *HTTPServer) apiIncident(w http.ResponseWriter, r *http.Request) (*store.Incident, bool) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	inc, err := s.db.GetIncident(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get incident", err)
+		return nil, false
+	}
+
+	return inc, true
+}
@@ -0,0 +1,203 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestAPIIncidents(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := loopback(svr.routes())
+
+	svc := &store.Service{Name: "checkout"}
+	if err := svr.db.CreateService(ctx, svc); err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		inc := &store.Incident{Title: fmt.Sprintf("incident %d", i), Severity: store.Sev3, StartedAt: base.Add(time.Duration(i) * time.Hour)}
+		if i%2 == 0 {
+			inc.Severity = store.Sev1
+		}
+		if err := svr.db.CreateIncident(ctx, inc); err != nil {
+			t.Fatal(err)
+		}
+		if i == 4 {
+			if err := svr.db.AttachService(ctx, inc.ID, svc.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	list := func(t *testing.T, path string) listJSON[incidentJSON] {
+		t.Helper()
+		w := apiDo(t, h, http.MethodGet, path, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		var res listJSON[incidentJSON]
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	t.Run("handleAPIIncidents: pages", func(t *testing.T) {
+		var titles []string
+		path := "/api/v1/incidents?limit=2"
+		for pages := 0; ; pages++ {
+			if pages > 5 {
+				t.Fatal("too many pages")
+			}
+			res := list(t, path)
+			for _, inc := range res.Data {
+				titles = append(titles, inc.Title)
+			}
+			if res.NextCursor == "" {
+				break
+			}
+			path = "/api/v1/incidents?limit=2&cursor=" + res.NextCursor
+		}
+		want := "[incident 4 incident 3 incident 2 incident 1 incident 0]"
+		if got := fmt.Sprint(titles); got != want {
+			t.Errorf("got %s want %s", got, want)
+		}
+	})
+
+	t.Run("handleAPIIncidents: filters", func(t *testing.T) {
+		if res := list(t, "/api/v1/incidents?severity=sev1"); len(res.Data) != 3 {
+			t.Errorf("severity: got %d want 3", len(res.Data))
+		}
+		if res := list(t, "/api/v1/incidents?service=checkout"); len(res.Data) != 1 || res.Data[0].Title != "incident 4" {
+			t.Errorf("service: got %+v", res.Data)
+		}
+		res := list(t, "/api/v1/incidents?since=2025-04-10T10:00:00Z&until=2025-04-10T12:00:00Z")
+		if len(res.Data) != 3 {
+			t.Errorf("time: got %d want 3", len(res.Data))
+		}
+	})
+
+	t.Run("handleAPIIncidents: invalid query", func(t *testing.T) {
+		p := decodeProblem(t, apiDo(t, h, http.MethodGet, "/api/v1/incidents?status=lost&severity=sev9&service=nope&cursor=x", nil), http.StatusBadRequest)
+		for _, field := range []string{"status", "severity", "service", "cursor"} {
+			if !hasFieldError(p, field) {
+				t.Errorf("missing %s error: %+v", field, p.Errors)
+			}
+		}
+	})
+
+	var created incidentJSON
+	t.Run("handleAPIIncidentCreate: created", func(t *testing.T) {
+		w := apiDo(t, h, http.MethodPost, "/api/v1/incidents", map[string]any{"title": " Queue backlog ", "severity": "sev2"})
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusCreated, w.Body)
+		}
+		if err := json.NewDecoder(w.Body).Decode(&created); err != nil {
+			t.Fatal(err)
+		}
+		if created.Title != "Queue backlog" || created.Status != store.IncidentOpen || created.Severity != store.Sev2 {
+			t.Errorf("unexpected incident: %+v", created)
+		}
+		if loc := w.Header().Get("Location"); loc != fmt.Sprintf("/api/v1/incidents/%d", created.ID) {
+			t.Errorf("location: got %q", loc)
+		}
+		if w.Header().Get("ETag") == "" {
+			t.Error("missing ETag")
+		}
+	})
+
+	t.Run("handleAPIIncidentCreate: invalid", func(t *testing.T) {
+		p := decodeProblem(t, apiDo(t, h, http.MethodPost, "/api/v1/incidents", map[string]any{
+			"status":      "lost",
+			"started_at":  base,
+			"resolved_at": base.Add(-time.Hour),
+		}), http.StatusUnprocessableEntity)
+		for _, field := range []string{"title", "status", "resolved_at"} {
+			if !hasFieldError(p, field) {
+				t.Errorf("missing %s error: %+v", field, p.Errors)
+			}
+		}
+	})
+
+	path := fmt.Sprintf("/api/v1/incidents/%d", created.ID)
+
+	t.Run("handleAPIIncident: conditional get", func(t *testing.T) {
+		w := apiDo(t, h, http.MethodGet, path, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		tag := w.Header().Get("ETag")
+		if w := apiDo(t, h, http.MethodGet, path, nil, "If-None-Match", tag); w.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match: got %d want %d", w.Code, http.StatusNotModified)
+		}
+	})
+
+	t.Run("handleAPIIncidentUpdate: If-Match", func(t *testing.T) {
+		tag := apiDo(t, h, http.MethodGet, path, nil).Header().Get("ETag")
+
+		w := apiDo(t, h, http.MethodPatch, path, map[string]any{"status": "mitigated"}, "If-Match", tag)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		var inc incidentJSON
+		if err := json.NewDecoder(w.Body).Decode(&inc); err != nil {
+			t.Fatal(err)
+		}
+		if inc.Status != store.IncidentMitigated || inc.Title != "Queue backlog" {
+			t.Errorf("unexpected incident: %+v", inc)
+		}
+		if w.Header().Get("ETag") == tag {
+			t.Error("ETag did not change")
+		}
+
+		decodeProblem(t, apiDo(t, h, http.MethodPatch, path, map[string]any{"status": "resolved"}, "If-Match", tag), http.StatusPreconditionFailed)
+		got, err := svr.db.GetIncident(ctx, created.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != store.IncidentMitigated {
+			t.Errorf("stale update applied: %s", got.Status)
+		}
+	})
+
+	t.Run("handleAPIIncidentUpdate: weak If-Match", func(t *testing.T) {
+		tag := apiDo(t, h, http.MethodGet, path, nil).Header().Get("ETag")
+		decodeProblem(t, apiDo(t, h, http.MethodPatch, path, map[string]any{"status": "resolved"}, "If-Match", "W/"+tag), http.StatusPreconditionFailed)
+	})
+
+	t.Run("handleAPIIncidentUpdate: unknown", func(t *testing.T) {
+		decodeProblem(t, apiDo(t, h, http.MethodPatch, "/api/v1/incidents/9999", map[string]any{"status": "closed"}), http.StatusNotFound)
+	})
+
+	t.Run("handleAPIIncidentDelete: deleted", func(t *testing.T) {
+		decodeProblem(t, apiDo(t, h, http.MethodDelete, path, nil, "If-Match", `"stale"`), http.StatusPreconditionFailed)
+		if w := apiDo(t, h, http.MethodDelete, path, nil); w.Code != http.StatusNoContent {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusNoContent)
+		}
+		decodeProblem(t, apiDo(t, h, http.MethodGet, path, nil), http.StatusNotFound)
+	})
+
+	t.Run("handleAPIIncident: other workspace", func(t *testing.T) {
+		ops := &store.Workspace{Slug: "ops", Name: "Operations"}
+		if err := svr.db.CreateWorkspace(ctx, ops); err != nil {
+			t.Fatal(err)
+		}
+		inc := &store.Incident{Title: "Payroll batch stuck"}
+		if err := svr.db.CreateIncident(store.WithWorkspace(ctx, ops.ID), inc); err != nil {
+			t.Fatal(err)
+		}
+		path := fmt.Sprintf("/api/v1/incidents/%d", inc.ID)
+
+		decodeProblem(t, apiDo(t, h, http.MethodGet, path, nil), http.StatusNotFound)
+		if w := apiDo(t, h, http.MethodGet, path+"?workspace=ops", nil); w.Code != http.StatusOK {
+			t.Errorf("ops workspace: got %d want %d", w.Code, http.StatusOK)
+		}
+	})
+}
@@ -0,0 +1,728 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// The records of an incident are listed and created below it, e.g.
+// /api/v1/incidents/1/evidence, and read, changed and deleted by their own
+// id, e.g. /api/v1/evidence/7.
+
+type evidenceJSON struct {
+	ID          int64      `json:"id"`
+	IncidentID  int64      `json:"incident_id"`
+	ServiceID   *int64     `json:"service_id"`
+	Kind        string     `json:"kind"`
+	Title       string     `json:"title"`
+	Content     string     `json:"content"`
+	BlobSHA256  string     `json:"blob_sha256,omitempty"`
+	BlobSize    int64      `json:"blob_size,omitempty"`
+	Source      string     `json:"source"`
+	CollectedAt time.Time  `json:"collected_at"`
+	ArchivedAt  *time.Time `json:"archived_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type evidenceInput struct {
+	ServiceID   *int64     `json:"service_id"`
+	Kind        *string    `json:"kind"`
+	Title       *string    `json:"title"`
+	Content     *string    `json:"content"`
+	Source      *string    `json:"source"`
+	CollectedAt *time.Time `json:"collected_at"`
+}
+
+func toEvidenceJSON(ev *store.Evidence) evidenceJSON {
+	return evidenceJSON{
+		ID:          ev.ID,
+		IncidentID:  ev.IncidentID,
+		ServiceID:   ev.ServiceID,
+		Kind:        ev.Kind,
+		Title:       ev.Title,
+		Content:     ev.Content,
+		BlobSHA256:  ev.BlobSHA256,
+		BlobSize:    ev.BlobSize,
+		Source:      ev.Source,
+		CollectedAt: ev.CollectedAt,
+		ArchivedAt:  ev.ArchivedAt,
+		CreatedAt:   ev.CreatedAt,
+	}
+}
+
+func (s *HTTPServer) applyEvidence(r *http.Request, in *evidenceInput, ev *store.Evidence) (fieldErrors, error) {
+	var errs fieldErrors
+	if in.ServiceID != nil {
+		if _, err := s.db.GetService(r.Context(), *in.ServiceID); errors.Is(err, store.ErrNotFound) {
+			errs.add("service_id", "unknown service %d", *in.ServiceID)
+		} else if err != nil {
+			return nil, err
+		}
+		ev.ServiceID = in.ServiceID
+	}
+	if in.Kind != nil {
+		ev.Kind = strings.TrimSpace(*in.Kind)
+	}
+	if in.Title != nil {
+		ev.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Content != nil {
+		if ev.BlobSHA256 != "" {
+			errs.add("content", "is an attachment and cannot be changed")
+		}
+		ev.Content = *in.Content
+	}
+	if in.Source != nil {
+		ev.Source = *in.Source
+	}
+	if in.CollectedAt != nil {
+		ev.CollectedAt = *in.CollectedAt
+	}
+
+	if ev.Kind == "" {
+		errs.add("kind", "is required")
+	}
+	switch {
+	case ev.Title == "":
+		errs.add("title", "is required")
+	case len(ev.Title) > maxTitleLen:
+		errs.add("title", "must be at most %d bytes", maxTitleLen)
+	}
+	if ev.Content == "" && ev.BlobSHA256 == "" {
+		errs.add("content", "is required")
+	}
+
+	return errs, nil
+}
+
+func (s *HTTPServer) handleAPIEvidenceList(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	f := store.EvidenceFilter{IncidentID: inc.ID, Kind: v.Get("kind")}
+
+	var errs fieldErrors
+	if svc := v.Get("service"); svc != "" {
+		var err error
+		f.ServiceID, err = s.serviceParam(r, svc)
+		if errors.Is(err, store.ErrNotFound) {
+			errs.add("service", "unknown service %q", svc)
+		} else if err != nil {
+			writeStoreError(w, r, "api evidence service", err)
+			return
+		}
+	}
+	limit, after := pageParams[time.Time](v, &errs)
+	if len(errs) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+	f.After, f.Limit = after, limit+1
+
+	evidence, err := s.db.ListEvidence(r.Context(), f)
+	if err != nil {
+		writeStoreError(w, r, "api list evidence", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page(evidence, limit, (*store.Evidence).Cursor, toEvidenceJSON))
+}
+
+func (s *HTTPServer) handleAPIEvidenceCreate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	var in evidenceInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	ev := &store.Evidence{IncidentID: inc.ID, Kind: "log"}
+	errs, err := s.applyEvidence(r, &in, ev)
+	if err != nil {
+		writeStoreError(w, r, "api create evidence", err)
+		return
+	}
+	if len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.CreateEvidence(r.Context(), ev); err != nil {
+		writeStoreError(w, r, "api create evidence", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/evidence/%d", ev.ID))
+	writeEntity(w, r, http.StatusCreated, toEvidenceJSON(ev))
+}
+
+func (s *HTTPServer) handleAPIEvidence(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	ev, err := s.db.GetEvidence(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get evidence", err)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, toEvidenceJSON(ev))
+}
+
+func (s *HTTPServer) handleAPIEvidenceUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var in evidenceInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	ev, err := s.db.GetEvidence(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get evidence", err)
+		return
+	}
+	errs, err := s.applyEvidence(r, &in, ev)
+	if err != nil {
+		writeStoreError(w, r, "api update evidence", err)
+		return
+	}
+	if len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.UpdateEvidence(ifMatch(r, toEvidenceJSON), ev); err != nil {
+		writeStoreError(w, r, "api update evidence", err)
+		return
+	}
+
+	if ev, err = s.db.GetEvidence(r.Context(), id); err != nil {
+		writeStoreError(w, r, "api get evidence", err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, toEvidenceJSON(ev))
+}
+
+func (s *HTTPServer) handleAPIEvidenceDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteEvidence(ifMatch(r, toEvidenceJSON), id); err != nil {
+		writeStoreError(w, r, "api delete evidence", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type timelineEventJSON struct {
+	ID          int64     `json:"id"`
+	IncidentID  int64     `json:"incident_id"`
+	OccurredAt  time.Time `json:"occurred_at"`
+	Kind        string    `json:"kind"`
+	Description string    `json:"description"`
+	Source      string    `json:"source"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type timelineEventInput struct {
+	OccurredAt  *time.Time `json:"occurred_at"`
+	Kind        *string    `json:"kind"`
+	Description *string    `json:"description"`
+	Source      *string    `json:"source"`
+}
+
+func toTimelineEventJSON(ev *store.TimelineEvent) timelineEventJSON {
+	return timelineEventJSON{
+		ID:          ev.ID,
+		IncidentID:  ev.IncidentID,
+		OccurredAt:  ev.OccurredAt,
+		Kind:        ev.Kind,
+		Description: ev.Description,
+		Source:      ev.Source,
+		CreatedAt:   ev.CreatedAt,
+	}
+}
+
+func (in *timelineEventInput) apply(ev *store.TimelineEvent) fieldErrors {
+	if in.OccurredAt != nil {
+		ev.OccurredAt = *in.OccurredAt
+	}
+	if in.Kind != nil {
+		ev.Kind = strings.TrimSpace(*in.Kind)
+	}
+	if in.Description != nil {
+		ev.Description = strings.TrimSpace(*in.Description)
+	}
+	if in.Source != nil {
+		ev.Source = *in.Source
+	}
+
+	var errs fieldErrors
+	if ev.Kind == "" {
+		errs.add("kind", "is required")
+	}
+	if ev.Description == "" {
+		errs.add("description", "is required")
+	}
+
+	return errs
+}
+
+func (s *HTTPServer) handleAPITimeline(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	f := store.TimelineFilter{IncidentID: inc.ID, Kind: v.Get("kind")}
+
+	var (
+		errs fieldErrors
+		err  error
+	)
+	if f.Since, err = parseSearchTime(v.Get("since"), false); err != nil {
+		errs.add("since", "%v", err)
+	}
+	if f.Until, err = parseSearchTime(v.Get("until"), true); err != nil {
+		errs.add("until", "%v", err)
+	}
+	limit, after := pageParams[time.Time](v, &errs)
+	if len(errs) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+	f.After, f.Limit = after, limit+1
+
+	events, err := s.db.ListTimelineEvents(r.Context(), f)
+	if err != nil {
+		writeStoreError(w, r, "api list timeline events", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page(events, limit, (*store.TimelineEvent).Cursor, toTimelineEventJSON))
+}
+
+func (s *HTTPServer) handleAPITimelineCreate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	var in timelineEventInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	ev := &store.TimelineEvent{IncidentID: inc.ID, Kind: "note", OccurredAt: time.Now().UTC()}
+	if errs := in.apply(ev); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.CreateTimelineEvent(r.Context(), ev); err != nil {
+		writeStoreError(w, r, "api create timeline event", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/timeline/%d", ev.ID))
+	writeEntity(w, r, http.StatusCreated, toTimelineEventJSON(ev))
+}
+
+func (s *HTTPServer) handleAPITimelineEvent(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	ev, err := s.db.GetTimelineEvent(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get timeline event", err)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, toTimelineEventJSON(ev))
+}
+
+func (s *HTTPServer) handleAPITimelineUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var in timelineEventInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	ev, err := s.db.GetTimelineEvent(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get timeline event", err)
+		return
+	}
+	if errs := in.apply(ev); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.UpdateTimelineEvent(ifMatch(r, toTimelineEventJSON), ev); err != nil {
+		writeStoreError(w, r, "api update timeline event", err)
+		return
+	}
+
+	if ev, err = s.db.GetTimelineEvent(r.Context(), id); err != nil {
+		writeStoreError(w, r, "api get timeline event", err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, toTimelineEventJSON(ev))
+}
+
+func (s *HTTPServer) handleAPITimelineDelete(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.db.DeleteTimelineEvent(ifMatch(r, toTimelineEventJSON), id); err != nil {
+		writeStoreError(w, r, "api delete timeline event", err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type hypothesisJSON struct {
+	ID         int64                  `json:"id"`
+	IncidentID int64                  `json:"incident_id"`
+	Statement  string                 `json:"statement"`
+	Rationale  string                 `json:"rationale"`
+	Status     store.HypothesisStatus `json:"status"`
+	Confidence float64                `json:"confidence"`
+	CreatedAt  time.Time              `json:"created_at"`
+	UpdatedAt  time.Time              `json:"updated_at"`
+}
+
+type hypothesisInput struct {
+	Statement  *string                 `json:"statement"`
+	Rationale  *string                 `json:"rationale"`
+	Status     *store.HypothesisStatus `json:"status"`
+	Confidence *float64                `json:"confidence"`
+}
+
+func toHypothesisJSON(h *store.Hypothesis) hypothesisJSON {
+	return hypothesisJSON{
+		ID:         h.ID,
+		IncidentID: h.IncidentID,
+		Statement:  h.Statement,
+		Rationale:  h.Rationale,
+		Status:     h.Status,
+		Confidence: h.Confidence,
+		CreatedAt:  h.CreatedAt,
+		UpdatedAt:  h.UpdatedAt,
+	}
+}
+
+func (in *hypothesisInput) apply(h *store.Hypothesis) fieldErrors {
+	if in.Statement != nil {
+		h.Statement = strings.TrimSpace(*in.Statement)
+	}
+	if in.Rationale != nil {
+		h.Rationale = *in.Rationale
+	}
+	if in.Status != nil {
+		h.Status = *in.Status
+	}
+	if in.Confidence != nil {
+		h.Confidence = *in.Confidence
+	}
+
+	var errs fieldErrors
+	if h.Statement == "" {
+		errs.add("statement", "is required")
+	}
+	if !h.Status.Valid() {
+		errs.add("status", "must be one of proposed, investigating, confirmed, rejected")
+	}
+	if h.Confidence < 0 || h.Confidence > 1 {
+		errs.add("confidence", "must be between 0 and 1")
+	}
+
+	return errs
+}
+
+func (s *HTTPServer) handleAPIHypotheses(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	f := store.HypothesisFilter{IncidentID: inc.ID}
+
+	var errs fieldErrors
+	if st := store.HypothesisStatus(v.Get("status")); st != "" {
+		if !st.Valid() {
+			errs.add("status", "must be one of proposed, investigating, confirmed, rejected")
+		}
+		f.Status = st
+	}
+	limit, after := pageParams[float64](v, &errs)
+	if len(errs) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+	f.After, f.Limit = after, limit+1
+
+	hypotheses, err := s.db.ListHypotheses(r.Context(), f)
+	if err != nil {
+		writeStoreError(w, r, "api list hypotheses", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page(hypotheses, limit, (*store.Hypothesis).Cursor, toHypothesisJSON))
+}
+
+func (s *HTTPServer) handleAPIHypothesisCreate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	var in hypothesisInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	h := &store.Hypothesis{IncidentID: inc.ID, Status: store.HypothesisProposed}
+	if errs := in.apply(h); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.CreateHypothesis(r.Context(), h); err != nil {
+		writeStoreError(w, r, "api create hypothesis", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/hypotheses/%d", h.ID))
+	writeEntity(w, r, http.StatusCreated, toHypothesisJSON(h))
+}
+
+func (s *HTTPServer) handleAPIHypothesis(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	h, err := s.db.GetHypothesis(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get hypothesis", err)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, toHypothesisJSON(h))
+}
+
+func (s *HTTPServer) handleAPIHypothesisUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var in hypothesisInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	h, err := s.db.GetHypothesis(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get hypothesis", err)
+		return
+	}
+	if errs := in.apply(h); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.UpdateHypothesis(ifMatch(r, toHypothesisJSON), h); err != nil {
+		writeStoreError(w, r, "api update hypothesis", err)
+		return
+	}
+
+	if h, err = s.db.GetHypothesis(r.Context(), id); err != nil {
+		writeStoreError(w, r, "api get hypothesis", err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, toHypothesisJSON(h))
+}
+
+type actionItemJSON struct {
+	ID          int64                  `json:"id"`
+	IncidentID  int64                  `json:"incident_id"`
+	Title       string                 `json:"title"`
+	Description string                 `json:"description"`
+	Owner       string                 `json:"owner"`
+	Status      store.ActionItemStatus `json:"status"`
+	DueAt       *time.Time             `json:"due_at"`
+	CreatedAt   time.Time              `json:"created_at"`
+	UpdatedAt   time.Time              `json:"updated_at"`
+}
+
+type actionItemInput struct {
+	Title       *string                 `json:"title"`
+	Description *string                 `json:"description"`
+	Owner       *string                 `json:"owner"`
+	Status      *store.ActionItemStatus `json:"status"`
+	DueAt       *time.Time              `json:"due_at"`
+}
+
+func toActionItemJSON(ai *store.ActionItem) actionItemJSON {
+	return actionItemJSON{
+		ID:          ai.ID,
+		IncidentID:  ai.IncidentID,
+		Title:       ai.Title,
+		Description: ai.Description,
+		Owner:       ai.Owner,
+		Status:      ai.Status,
+		DueAt:       ai.DueAt,
+		CreatedAt:   ai.CreatedAt,
+		UpdatedAt:   ai.UpdatedAt,
+	}
+}
+
+func (in *actionItemInput) apply(ai *store.ActionItem) fieldErrors {
+	if in.Title != nil {
+		ai.Title = strings.TrimSpace(*in.Title)
+	}
+	if in.Description != nil {
+		ai.Description = *in.Description
+	}
+	if in.Owner != nil {
+		ai.Owner = strings.TrimSpace(*in.Owner)
+	}
+	if in.Status != nil {
+		ai.Status = *in.Status
+	}
+	if in.DueAt != nil {
+		ai.DueAt = in.DueAt
+	}
+
+	var errs fieldErrors
+	switch {
+	case ai.Title == "":
+		errs.add("title", "is required")
+	case len(ai.Title) > maxTitleLen:
+		errs.add("title", "must be at most %d bytes", maxTitleLen)
+	}
+	if !ai.Status.Valid() {
+		errs.add("status", "must be one of open, in_progress, done, wont_do")
+	}
+
+	return errs
+}
+
+func (s *HTTPServer) handleAPIActionItems(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	v := r.URL.Query()
+	f := store.ActionItemFilter{IncidentID: inc.ID, Owner: v.Get("owner")}
+
+	var errs fieldErrors
+	if st := store.ActionItemStatus(v.Get("status")); st != "" {
+		if !st.Valid() {
+			errs.add("status", "must be one of open, in_progress, done, wont_do")
+		}
+		f.Status = st
+	}
+	limit, after := pageParams[time.Time](v, &errs)
+	if len(errs) > 0 {
+		writeProblem(w, r, http.StatusBadRequest, "invalid query parameters", errs...)
+		return
+	}
+	f.After, f.Limit = after, limit+1
+
+	items, err := s.db.ListActionItems(r.Context(), f)
+	if err != nil {
+		writeStoreError(w, r, "api list action items", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, page(items, limit, (*store.ActionItem).Cursor, toActionItemJSON))
+}
+
+func (s *HTTPServer) handleAPIActionItemCreate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.apiIncident(w, r)
+	if !ok {
+		return
+	}
+	var in actionItemInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	ai := &store.ActionItem{IncidentID: inc.ID, Status: store.ActionItemOpen}
+	if errs := in.apply(ai); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.CreateActionItem(r.Context(), ai); err != nil {
+		writeStoreError(w, r, "api create action item", err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/v1/action-items/%d", ai.ID))
+	writeEntity(w, r, http.StatusCreated, toActionItemJSON(ai))
+}
+
+func (s *HTTPServer) handleAPIActionItem(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+
+	ai, err := s.db.GetActionItem(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get action item", err)
+		return
+	}
+
+	writeEntity(w, r, http.StatusOK, toActionItemJSON(ai))
+}
+
+func (s *HTTPServer) handleAPIActionItemUpdate(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	var in actionItemInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+
+	ai, err := s.db.GetActionItem(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, r, "api get action item", err)
+		return
+	}
+	if errs := in.apply(ai); len(errs) > 0 {
+		writeInvalid(w, r, errs)
+		return
+	}
+	if err := s.db.UpdateActionItem(ifMatch(r, toActionItemJSON), ai); err != nil {
+		writeStoreError(w, r, "api update action item", err)
+		return
+	}
+
+	if ai, err = s.db.GetActionItem(r.Context(), id); err != nil {
+		writeStoreError(w, r, "api get action item", err)
+		return
+	}
+	writeEntity(w, r, http.StatusOK, toActionItemJSON(ai))
+}
@@ -0,0 +1,219 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestAPIRecords(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Redis timeouts"}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	base := fmt.Sprintf("/api/v1/incidents/%d", inc.ID)
+
+	create := func(t *testing.T, path string, body map[string]any, v any) string {
+		t.Helper()
+		w := apiDo(t, h, http.MethodPost, path, body)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusCreated, w.Body)
+		}
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+		return w.Header().Get("Location")
+	}
+
+	t.Run("handleAPIEvidenceCreate: created and listed", func(t *testing.T) {
+		at := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+		var ids []int64
+		for i := range 3 {
+			var ev evidenceJSON
+			create(t, base+"/evidence", map[string]any{
+				"title":        fmt.Sprintf("log %d", i),
+				"content":      "dial redis:6379: i/o timeout",
+				"collected_at": at.Add(time.Duration(i) * time.Minute),
+			}, &ev)
+			if ev.Kind != "log" || ev.IncidentID != inc.ID {
+				t.Errorf("unexpected evidence: %+v", ev)
+			}
+			ids = append(ids, ev.ID)
+		}
+
+		var got []int64
+		path := base + "/evidence?limit=2"
+		for path != "" {
+			w := apiDo(t, h, http.MethodGet, path, nil)
+			var res listJSON[evidenceJSON]
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			for _, ev := range res.Data {
+				got = append(got, ev.ID)
+			}
+			path = ""
+			if res.NextCursor != "" {
+				path = base + "/evidence?limit=2&cursor=" + res.NextCursor
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(ids) {
+			t.Errorf("pages: got %v want %v", got, ids)
+		}
+	})
+
+	t.Run("handleAPIEvidenceCreate: invalid", func(t *testing.T) {
+		p := decodeProblem(t, apiDo(t, h, http.MethodPost, base+"/evidence", map[string]any{"service_id": 99}), http.StatusUnprocessableEntity)
+		for _, field := range []string{"service_id", "title", "content"} {
+			if !hasFieldError(p, field) {
+				t.Errorf("missing %s error: %+v", field, p.Errors)
+			}
+		}
+		decodeProblem(t, apiDo(t, h, http.MethodPost, "/api/v1/incidents/9999/evidence", map[string]any{"title": "x", "content": "y"}), http.StatusNotFound)
+	})
+
+	t.Run("handleAPIEvidenceUpdate: If-Match", func(t *testing.T) {
+		var ev evidenceJSON
+		loc := create(t, base+"/evidence", map[string]any{"title": "metrics", "kind": "metric", "content": "p99 2s"}, &ev)
+		tag := apiDo(t, h, http.MethodGet, loc, nil).Header().Get("ETag")
+
+		w := apiDo(t, h, http.MethodPatch, loc, map[string]any{"title": "latency"}, "If-Match", tag)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		decodeProblem(t, apiDo(t, h, http.MethodPatch, loc, map[string]any{"title": "stale"}, "If-Match", tag), http.StatusPreconditionFailed)
+
+		if w := apiDo(t, h, http.MethodDelete, loc, nil); w.Code != http.StatusNoContent {
+			t.Fatalf("delete: got %d want %d", w.Code, http.StatusNoContent)
+		}
+		decodeProblem(t, apiDo(t, h, http.MethodGet, loc, nil), http.StatusNotFound)
+	})
+
+	t.Run("handleAPITimeline: since and until", func(t *testing.T) {
+		at := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+		for i := range 3 {
+			var ev timelineEventJSON
+			create(t, base+"/timeline", map[string]any{
+				"description": fmt.Sprintf("event %d", i),
+				"occurred_at": at.Add(time.Duration(i) * time.Hour),
+			}, &ev)
+			if ev.Kind != "note" {
+				t.Errorf("kind: got %q", ev.Kind)
+			}
+		}
+
+		w := apiDo(t, h, http.MethodGet, base+"/timeline?since=2025-04-10T10:00:00Z", nil)
+		var res listJSON[timelineEventJSON]
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Data) != 2 || res.Data[0].Description != "event 1" {
+			t.Errorf("got %+v", res.Data)
+		}
+		decodeProblem(t, apiDo(t, h, http.MethodGet, base+"/timeline?until=someday", nil), http.StatusBadRequest)
+		decodeProblem(t, apiDo(t, h, http.MethodPost, base+"/timeline", map[string]any{"description": " "}), http.StatusUnprocessableEntity)
+	})
+
+	t.Run("handleAPIHypotheses: confidence", func(t *testing.T) {
+		for _, c := range []float64{0.2, 0.9, 0.5} {
+			var hyp hypothesisJSON
+			create(t, base+"/hypotheses", map[string]any{"statement": fmt.Sprintf("cause %g", c), "confidence": c}, &hyp)
+		}
+
+		var got []float64
+		path := base + "/hypotheses?limit=1"
+		for path != "" {
+			w := apiDo(t, h, http.MethodGet, path, nil)
+			var res listJSON[hypothesisJSON]
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			for _, hyp := range res.Data {
+				got = append(got, hyp.Confidence)
+			}
+			path = ""
+			if res.NextCursor != "" {
+				path = base + "/hypotheses?limit=1&cursor=" + res.NextCursor
+			}
+		}
+		if fmt.Sprint(got) != "[0.9 0.5 0.2]" {
+			t.Errorf("pages: got %v", got)
+		}
+
+		p := decodeProblem(t, apiDo(t, h, http.MethodPost, base+"/hypotheses", map[string]any{"statement": "x", "confidence": 1.5, "status": "maybe"}), http.StatusUnprocessableEntity)
+		if !hasFieldError(p, "confidence") || !hasFieldError(p, "status") {
+			t.Errorf("got %+v", p.Errors)
+		}
+	})
+
+	t.Run("handleAPIHypothesisUpdate: status", func(t *testing.T) {
+		var hyp hypothesisJSON
+		loc := create(t, base+"/hypotheses", map[string]any{"statement": "pool exhausted"}, &hyp)
+
+		w := apiDo(t, h, http.MethodPatch, loc, map[string]any{"status": "confirmed"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		got, err := svr.db.GetHypothesis(ctx, hyp.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != store.HypothesisConfirmed {
+			t.Errorf("status: got %s", got.Status)
+		}
+
+		res := apiDo(t, h, http.MethodGet, base+"/hypotheses?status=confirmed", nil)
+		var list listJSON[hypothesisJSON]
+		if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Data) != 1 || list.Data[0].ID != hyp.ID {
+			t.Errorf("filter: got %+v", list.Data)
+		}
+	})
+
+	t.Run("handleAPIActionItems: undated last", func(t *testing.T) {
+		due := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+		var undated, dated actionItemJSON
+		create(t, base+"/action-items", map[string]any{"title": "write runbook", "owner": "sam"}, &undated)
+		create(t, base+"/action-items", map[string]any{"title": "raise pool size", "owner": "sam", "due_at": due}, &dated)
+
+		var got []int64
+		path := base + "/action-items?owner=sam&limit=1"
+		for path != "" {
+			w := apiDo(t, h, http.MethodGet, path, nil)
+			var res listJSON[actionItemJSON]
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatal(err)
+			}
+			for _, ai := range res.Data {
+				got = append(got, ai.ID)
+			}
+			path = ""
+			if res.NextCursor != "" {
+				path = base + "/action-items?owner=sam&limit=1&cursor=" + res.NextCursor
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint([]int64{dated.ID, undated.ID}) {
+			t.Errorf("pages: got %v", got)
+		}
+
+		loc := fmt.Sprintf("/api/v1/action-items/%d", dated.ID)
+		if w := apiDo(t, h, http.MethodPatch, loc, map[string]any{"status": "done"}); w.Code != http.StatusOK {
+			t.Errorf("update: got %d: %s", w.Code, w.Body)
+		}
+		p := decodeProblem(t, apiDo(t, h, http.MethodPatch, loc, map[string]any{"status": "later", "title": ""}), http.StatusUnprocessableEntity)
+		if !hasFieldError(p, "status") || !hasFieldError(p, "title") {
+			t.Errorf("got %+v", p.Errors)
+		}
+	})
+}
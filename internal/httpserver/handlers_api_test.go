@@ -0,0 +1,180 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// apiDo sends a JSON request to h; body is sent as is when it is a string.
+func apiDo(t *testing.T, h http.Handler, method, path string, body any, header ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var rd io.Reader
+	switch b := body.(type) {
+	case nil:
+	case string:
+		rd = strings.NewReader(b)
+	default:
+		buf, err := json.Marshal(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rd = strings.NewReader(string(buf))
+	}
+	req := httptest.NewRequest(method, path, rd)
+	if rd != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+
+	return w
+}
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder, status int) problem {
+	t.Helper()
+
+	if w.Code != status {
+		t.Fatalf("status: got %d want %d: %s", w.Code, status, w.Body)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("content type: got %q", ct)
+	}
+	var p problem
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Status != status {
+		t.Errorf("problem status: got %d want %d", p.Status, status)
+	}
+
+	return p
+}
+
+func hasFieldError(p problem, field string) bool {
+	for _, e := range p.Errors {
+		if e.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAPIHelpers(t *testing.T) {
+	svr := setupServerWithDB(t)
+	h := loopback(svr.routes())
+
+	t.Run("decodeJSON: content type", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/incidents", strings.NewReader(`{"title":"x"}`))
+		req.Header.Set("Content-Type", "text/plain")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		decodeProblem(t, w, http.StatusUnsupportedMediaType)
+	})
+
+	t.Run("decodeJSON: malformed", func(t *testing.T) {
+		decodeProblem(t, apiDo(t, h, http.MethodPost, "/api/v1/incidents", `{"title":`), http.StatusBadRequest)
+		decodeProblem(t, apiDo(t, h, http.MethodPost, "/api/v1/incidents", `{"title":"a"} {}`), http.StatusBadRequest)
+	})
+
+	t.Run("decodeJSON: field errors", func(t *testing.T) {
+		p := decodeProblem(t, apiDo(t, h, http.MethodPost, "/api/v1/incidents", `{"title":1}`), http.StatusUnprocessableEntity)
+		if !hasFieldError(p, "title") {
+			t.Errorf("type error: %+v", p.Errors)
+		}
+		p = decodeProblem(t, apiDo(t, h, http.MethodPost, "/api/v1/incidents", `{"title":"a","colour":"red"}`), http.StatusUnprocessableEntity)
+		if !hasFieldError(p, "colour") {
+			t.Errorf("unknown field: %+v", p.Errors)
+		}
+	})
+
+	t.Run("handleAPINotFound: problem", func(t *testing.T) {
+		p := decodeProblem(t, apiDo(t, h, http.MethodGet, "/api/v1/nothing", nil), http.StatusNotFound)
+		if p.Instance != "/api/v1/nothing" {
+			t.Errorf("instance: got %q", p.Instance)
+		}
+		decodeProblem(t, apiDo(t, h, http.MethodGet, "/api/v1/incidents/abc", nil), http.StatusNotFound)
+	})
+
+	t.Run("matchesETag: lists and wildcards", func(t *testing.T) {
+		tag := etag(map[string]int{"a": 1})
+		for header, want := range map[string]bool{
+			tag:           true,
+			`"x", ` + tag: true,
+			"W/" + tag:    true,
+			"*":           true,
+			`"x"`:         false,
+			"":            false,
+		} {
+			if got := matchesETag(header, tag, true); got != want {
+				t.Errorf("%q: got %v want %v", header, got, want)
+			}
+		}
+	})
+
+	t.Run("matchesETag: strong comparison", func(t *testing.T) {
+		tag := etag(map[string]int{"a": 1})
+		for header, want := range map[string]bool{
+			tag:             true,
+			`W/"x", ` + tag: true,
+			"W/" + tag:      false,
+			"*":             true,
+			`"x"`:           false,
+			"":              false,
+		} {
+			if got := matchesETag(header, tag, false); got != want {
+				t.Errorf("%q: got %v want %v", header, got, want)
+			}
+		}
+		if matchesETag(tag, "W/"+tag, false) {
+			t.Error("weak tag matched strongly")
+		}
+	})
+
+	t.Run("decodeCursor: round trip", func(t *testing.T) {
+		at := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+		c, err := decodeCursor[time.Time](encodeCursor(store.Cursor{Key: at, ID: 7}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key, _ := c.Key.(time.Time); !key.Equal(at) || c.ID != 7 {
+			t.Errorf("got %+v", c)
+		}
+
+		c, err = decodeCursor[time.Time](encodeCursor(store.Cursor{ID: 3}))
+		if err != nil || c.Key != nil || c.ID != 3 {
+			t.Errorf("null key: got %+v, %v", c, err)
+		}
+
+		for _, bad := range []string{"!!", "e30", encodeCursor(store.Cursor{Key: "x", ID: 1})} {
+			if _, err := decodeCursor[time.Time](bad); err == nil {
+				t.Errorf("%q: expected error", bad)
+			}
+		}
+	})
+
+	t.Run("pageParams: limit", func(t *testing.T) {
+		var errs fieldErrors
+		if limit, _ := pageParams[time.Time](url.Values{}, &errs); limit != defaultPageSize || len(errs) > 0 {
+			t.Errorf("default: got %d, %v", limit, errs)
+		}
+		for _, bad := range []string{"0", "-1", "x", "201"} {
+			errs = nil
+			pageParams[time.Time](url.Values{"limit": {bad}}, &errs)
+			if len(errs) != 1 || errs[0].Field != "limit" {
+				t.Errorf("%q: got %v", bad, errs)
+			}
+		}
+	})
+}
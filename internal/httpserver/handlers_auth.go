@@ -0,0 +1,603 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/auth"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+// sessionCookie carries the token of the session a browser signed in with.
+const sessionCookie = "session"
+
+// sessionTTL is how long a sign-in lasts.
+const sessionTTL = 7 * 24 * time.Hour
+
+// A user name that failed to sign in loginMaxPerPair times from one client
+// address is refused there until loginWindow has passed since its first
+// failure. Elsewhere, so that nobody can lock a user out, failures only
+// slow it down: past loginFreePerUser failures of the name, or past
+// loginFreePerAddr of the address, each try waits twice as long after the
+// last failure as the one before, from loginDelay up to loginMaxDelay.
+// Addresses get more tries as many users may share one.
+const (
+	loginWindow      = 15 * time.Minute
+	loginMaxPerPair  = 5
+	loginFreePerUser = 3
+	loginFreePerAddr = 20
+	loginDelay       = time.Second
+	loginMaxDelay    = time.Minute
+	// loginMaxTracked is how many names and addresses are tracked before
+	// the expired ones are dropped.
+	loginMaxTracked = 10000
+)
+
+type userKey struct{}
+
+// publicRoutes are served to everyone, signed in or not.
+var publicRoutes = map[string]bool{
+	"GET /healthz": true,
+	"GET /readyz":  true,
+	"/static/":     true,
+}
+
+// routeScopes are the routes an API token may call, with the scope each
+// needs. Every other route needs a signed-in browser.
+var routeScopes = map[string]auth.Scope{
+	"GET /api/v1/search":                       auth.ScopeReadIncidents,
+	"GET /api/v1/incidents":                    auth.ScopeReadIncidents,
+	"POST /api/v1/incidents":                   auth.ScopeWriteIncidents,
+	"GET /api/v1/incidents/{id}":               auth.ScopeReadIncidents,
+	"PATCH /api/v1/incidents/{id}":             auth.ScopeWriteIncidents,
+	"DELETE /api/v1/incidents/{id}":            auth.ScopeWriteIncidents,
+	"GET /api/v1/incidents/{id}/evidence":      auth.ScopeReadIncidents,
+	"POST /api/v1/incidents/{id}/evidence":     auth.ScopeWriteEvidence,
+	"GET /api/v1/incidents/{id}/timeline":      auth.ScopeReadIncidents,
+	"POST /api/v1/incidents/{id}/timeline":     auth.ScopeWriteEvidence,
+	"GET /api/v1/incidents/{id}/hypotheses":    auth.ScopeReadIncidents,
+	"POST /api/v1/incidents/{id}/hypotheses":   auth.ScopeWriteIncidents,
+	"GET /api/v1/incidents/{id}/action-items":  auth.ScopeReadIncidents,
+	"POST /api/v1/incidents/{id}/action-items": auth.ScopeWriteIncidents,
+	"GET /api/v1/evidence/{id}":                auth.ScopeReadIncidents,
+	"PATCH /api/v1/evidence/{id}":              auth.ScopeWriteEvidence,
+	"DELETE /api/v1/evidence/{id}":             auth.ScopeWriteEvidence,
+	"GET /api/v1/timeline/{id}":                auth.ScopeReadIncidents,
+	"PATCH /api/v1/timeline/{id}":              auth.ScopeWriteEvidence,
+	"DELETE /api/v1/timeline/{id}":             auth.ScopeWriteEvidence,
+	"GET /api/v1/hypotheses/{id}":              auth.ScopeReadIncidents,
+	"PATCH /api/v1/hypotheses/{id}":            auth.ScopeWriteIncidents,
+	"GET /api/v1/action-items/{id}":            auth.ScopeReadIncidents,
+	"PATCH /api/v1/action-items/{id}":          auth.ScopeWriteIncidents,
+	"GET /incidents/{id}/export":               auth.ScopeReadIncidents,
+	"POST /incidents/{id}/analyses":            auth.ScopeRunAnalyses,
+	"GET /runs/{id}/events":                    auth.ScopeRunAnalyses,
+	"POST /runs/{id}/cancel":                   auth.ScopeRunAnalyses,
+	"GET /analyses/{id}":                       auth.ScopeReadIncidents,
+	"POST /analyses/{id}/critique":             auth.ScopeRunAnalyses,
+	"GET /incidents/{id}/history":              auth.ScopeReadIncidents,
+	"GET /incidents/{id}/history/{audit}":      auth.ScopeReadIncidents,
+	"GET /incidents/{id}/chat.md":              auth.ScopeReadIncidents,
+}
+
+// withAuth lets a request through once it is signed in with the session
+// cookie or carries an API token with the scope of its route; routes of
+// mux decide the scope. Until the first user is added, clients on the
+// loopback interface need not sign in, but admin routes stay closed to
+// them. Admin routes are for admin users only. Public routes skip next and
+// go to mux directly.
+func (s *HTTPServer) withAuth(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.db == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		_, pattern := mux.Handler(r)
+		if publicRoutes[pattern] {
+			// Straight to the route: public routes have no workspace, and
+			// the probes must answer while the database does not.
+			mux.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if h := r.Header.Get("Authorization"); h != "" {
+			u, ok := s.tokenUser(w, r, h, pattern)
+			if !ok {
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, userKey{}, u)))
+			return
+		}
+
+		u, err := s.sessionUser(r)
+		if err != nil {
+			log.Printf("session user error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		if u == nil {
+			n, err := s.db.CountUsers(ctx)
+			if err != nil {
+				log.Printf("count users error: %v", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			if n > 0 || !isLoopback(r) {
+				unauthorized(w, r)
+				return
+			}
+			if adminRoute(r, pattern) {
+				forbidden(w, r, "add an admin with RootTensor users add NAME admin and sign in first")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if adminRoute(r, pattern) && !u.Admin {
+			forbidden(w, r, "only admins can do this")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, userKey{}, u)))
+	})
+}
+
+// adminRoute reports whether r, routed to pattern, is for admin users
+// only: the admin pages and the instance settings.
+func adminRoute(r *http.Request, pattern string) bool {
+	return strings.HasPrefix(r.URL.Path, "/admin/") || pattern == "POST /settings"
+}
+
+// isLoopback reports whether r comes from the loopback interface. Behind a
+// proxy on the same host every client does, so the proxy must not be set
+// up before the first user is.
+func isLoopback(r *http.Request) bool {
+	ip := net.ParseIP(remoteIP(r))
+	return ip != nil && ip.IsLoopback()
+}
+
+// remoteIP returns the address of the client of r without the port.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// tokenUser authenticates the API token in the Authorization header h for
+// the route pattern. Failures are written to w.
+func (s *HTTPServer) tokenUser(w http.ResponseWriter, r *http.Request, h, pattern string) (*store.User, bool) {
+	scheme, token, _ := strings.Cut(h, " ")
+	if !strings.EqualFold(scheme, "Bearer") || token == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="RootTensor"`)
+		writeProblem(w, r, http.StatusUnauthorized, "use an Authorization header of the form Bearer TOKEN")
+		return nil, false
+	}
+
+	u, t, err := s.db.TokenUser(r.Context(), auth.HashToken(strings.TrimSpace(token)))
+	if errors.Is(err, store.ErrNotFound) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="RootTensor", error="invalid_token"`)
+		writeProblem(w, r, http.StatusUnauthorized, "the token is unknown, revoked or expired")
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("token user error: %v", err)
+		writeProblem(w, r, http.StatusInternalServerError, "")
+		return nil, false
+	}
+
+	scope, ok := routeScopes[pattern]
+	if !ok {
+		writeProblem(w, r, http.StatusForbidden, "API tokens cannot be used for this route")
+		return nil, false
+	}
+	if !t.HasScope(string(scope)) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="RootTensor", error="insufficient_scope", scope=%q`, scope))
+		writeProblem(w, r, http.StatusForbidden, fmt.Sprintf("the token lacks the %s scope", scope))
+		return nil, false
+	}
+
+	return u, true
+}
+
+// sessionUser returns the user signed in with the session cookie of r, or
+// nil.
+func (s *HTTPServer) sessionUser(r *http.Request) (*store.User, error) {
+	c, err := r.Cookie(sessionCookie)
+	if err != nil || c.Value == "" {
+		return nil, nil
+	}
+
+	u, err := s.db.SessionUser(r.Context(), auth.HashToken(c.Value))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, nil
+	}
+
+	return u, err
+}
+
+// unauthorized asks for credentials: API clients get a problem, browsers
+// go to the sign-in page and come back afterwards.
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="RootTensor"`)
+		writeProblem(w, r, http.StatusUnauthorized, "sign in or pass an API token")
+		return
+	}
+
+	login := "/login?next=" + url.QueryEscape(r.URL.RequestURI())
+	switch {
+	case isHTMX(r):
+		w.Header().Set("HX-Redirect", login)
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.Method == http.MethodGet || r.Method == http.MethodHead:
+		http.Redirect(w, r, login, http.StatusSeeOther)
+	default:
+		http.Error(w, "sign in first", http.StatusUnauthorized)
+	}
+}
+
+// currentUser returns the signed-in user, or nil when nobody needs to sign
+// in.
+func currentUser(ctx context.Context) *store.User {
+	u, _ := ctx.Value(userKey{}).(*store.User)
+	return u
+}
+
+func (s *HTTPServer) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	s.render(w, r, "Sign in", templates.LoginPage(localPath(r.URL.Query().Get("next")), false))
+}
+
+// handleLogin signs a user in with a new session. An unknown name takes as
+// long to reject as a wrong password.
+func (s *HTTPServer) handleLogin(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.PostFormValue("username"))
+	pw := r.PostFormValue("password")
+	next := localPath(r.PostFormValue("next"))
+
+	addr := remoteIP(r)
+	if wait := s.logins.retryAfter(name, addr, time.Now()); wait > 0 {
+		log.Printf("throttled sign-in as %q from %s", name, r.RemoteAddr)
+		w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+		http.Error(w, "too many failed sign-ins, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	ok := false
+	u, err := s.db.GetUserByName(r.Context(), name)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		auth.RejectPassword(pw)
+	case err != nil:
+		log.Printf("get user error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	default:
+		if ok, err = auth.VerifyPassword(u.PasswordHash, pw); err != nil {
+			log.Printf("verify password of %s error: %v", u.Username, err)
+		}
+	}
+	if !ok {
+		log.Printf("failed sign-in as %q from %s", name, r.RemoteAddr)
+		s.logins.fail(name, addr, time.Now())
+		s.render(w, r, "Sign in", templates.LoginPage(next, true))
+		return
+	}
+
+	s.logins.succeed(name, addr)
+
+	token, err := auth.NewToken("")
+	if err != nil {
+		log.Printf("session token error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	expires := time.Now().Add(sessionTTL)
+	if err := s.db.CreateSession(r.Context(), auth.HashToken(token), u.ID, expires); err != nil {
+		log.Printf("create session error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+func (s *HTTPServer) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(sessionCookie); err == nil && c.Value != "" {
+		if err := s.db.DeleteSession(r.Context(), auth.HashToken(c.Value)); err != nil {
+			log.Printf("delete session error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// loginLimit counts failed sign-ins by user name, by client address and
+// by the two together.
+type loginLimit struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	n           int
+	first, last time.Time
+}
+
+// loginRule is how the failures under one key of loginLimit.failures hold
+// off further tries.
+type loginRule struct {
+	key string
+	// max refuses tries after that many failures; free delays them after
+	// that many.
+	max, free int
+	// shared counts the failures at other accounts too, which a sign-in
+	// does not reset.
+	shared bool
+}
+
+// loginRules returns the rules for signing in as name from the client
+// address addr. The address comes first in the pair, as it has no spaces.
+func loginRules(name, addr string) []loginRule {
+	name = strings.ToLower(name)
+
+	return []loginRule{
+		{key: "pair " + addr + " " + name, max: loginMaxPerPair},
+		{key: "user " + name, free: loginFreePerUser},
+		{key: "addr " + addr, free: loginFreePerAddr, shared: true},
+	}
+}
+
+// wait returns how long f holds off the next try under rule, or 0.
+func (rule loginRule) wait(f *loginFailures, now time.Time) time.Duration {
+	if rule.max > 0 {
+		if f.n < rule.max {
+			return 0
+		}
+		return f.first.Add(loginWindow).Sub(now)
+	}
+	if f.n < rule.free {
+		return 0
+	}
+	delay := loginMaxDelay
+	if n := f.n - rule.free; n < 16 {
+		delay = min(loginDelay<<n, loginMaxDelay)
+	}
+
+	return f.last.Add(delay).Sub(now)
+}
+
+// retryAfter returns how long sign-ins as name from addr are held off, or
+// 0 when they are not.
+func (l *loginLimit) retryAfter(name, addr string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for _, rule := range loginRules(name, addr) {
+		f := l.failures[rule.key]
+		if f == nil || now.Sub(f.first) >= loginWindow {
+			continue
+		}
+		wait = max(wait, rule.wait(f, now))
+	}
+
+	return wait
+}
+
+// fail records a failed sign-in as name from addr.
+func (l *loginLimit) fail(name, addr string, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.failures == nil {
+		l.failures = make(map[string]*loginFailures)
+	}
+	if len(l.failures) >= loginMaxTracked {
+		for key, f := range l.failures {
+			if now.Sub(f.first) >= loginWindow {
+				delete(l.failures, key)
+			}
+		}
+	}
+	for _, rule := range loginRules(name, addr) {
+		f := l.failures[rule.key]
+		if f == nil || now.Sub(f.first) >= loginWindow {
+			f = &loginFailures{first: now}
+			l.failures[rule.key] = f
+		}
+		f.n++
+		f.last = now
+	}
+}
+
+// succeed forgets the failures of name, from addr and elsewhere. Those of
+// the address stay, so that signing in to one account does not reset
+// guessing at others.
+func (l *loginLimit) succeed(name, addr string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, rule := range loginRules(name, addr) {
+		if !rule.shared {
+			delete(l.failures, rule.key)
+		}
+	}
+}
+
+// localPath returns next when it is a path on this server, else the home
+// page, so that the sign-in form cannot send anyone elsewhere.
+func localPath(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+
+	return next
+}
+
+func (s *HTTPServer) handleAccount(w http.ResponseWriter, r *http.Request) {
+	s.renderAccount(w, r, "")
+}
+
+func (s *HTTPServer) renderAccount(w http.ResponseWriter, r *http.Request, newToken string) {
+	v := templates.AccountView{User: currentUser(r.Context()), NewToken: newToken}
+	if v.User != nil {
+		var err error
+		if v.Tokens, err = s.db.ListAPITokens(r.Context(), v.User.ID); err != nil {
+			log.Printf("list api tokens error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.render(w, r, "Account", templates.AccountPage(v))
+}
+
+// handleTokenCreate creates an API token of the signed-in user and shows
+// it once.
+func (s *HTTPServer) handleTokenCreate(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r.Context())
+	if u == nil {
+		http.Error(w, "sign in first", http.StatusUnauthorized)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	t := &store.APIToken{UserID: u.ID, Name: strings.TrimSpace(r.PostFormValue("name"))}
+	if t.Name == "" {
+		http.Error(w, "name is required", http.StatusBadRequest)
+		return
+	}
+	for _, scope := range r.PostForm["scope"] {
+		if !auth.ValidScope(scope) {
+			http.Error(w, fmt.Sprintf("unknown scope %q", scope), http.StatusBadRequest)
+			return
+		}
+		t.Scopes = append(t.Scopes, scope)
+	}
+	if len(t.Scopes) == 0 {
+		http.Error(w, "pick at least one scope", http.StatusBadRequest)
+		return
+	}
+	if days := r.PostFormValue("expires_days"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 1 {
+			http.Error(w, "expiry must be a positive number of days", http.StatusBadRequest)
+			return
+		}
+		exp := time.Now().AddDate(0, 0, n)
+		t.ExpiresAt = &exp
+	}
+
+	token, err := auth.NewToken(auth.TokenPrefix)
+	if err != nil {
+		log.Printf("api token error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.CreateAPIToken(r.Context(), t, auth.HashToken(token)); err != nil {
+		log.Printf("create api token error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.renderAccount(w, r, token)
+}
+
+func (s *HTTPServer) handleTokenDelete(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r.Context())
+	if u == nil {
+		http.Error(w, "sign in first", http.StatusUnauthorized)
+		return
+	}
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid token id", http.StatusBadRequest)
+		return
+	}
+
+	err = s.db.DeleteAPIToken(r.Context(), u.ID, id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("delete api token error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/account#tokens", http.StatusSeeOther)
+}
+
+// handlePasswordChange sets a new password for the signed-in user, which
+// ends all of its sessions.
+func (s *HTTPServer) handlePasswordChange(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r.Context())
+	if u == nil {
+		http.Error(w, "sign in first", http.StatusUnauthorized)
+		return
+	}
+
+	ok, err := auth.VerifyPassword(u.PasswordHash, r.PostFormValue("current"))
+	if err != nil {
+		log.Printf("verify password of %s error: %v", u.Username, err)
+	}
+	if !ok {
+		http.Error(w, "the current password is wrong", http.StatusForbidden)
+		return
+	}
+	pw := r.PostFormValue("password")
+	if err := auth.CheckPassword(pw); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	hash, err := auth.HashPassword(pw)
+	if err != nil {
+		log.Printf("hash password error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if err := s.db.SetUserPassword(r.Context(), u.ID, hash); err != nil {
+		log.Printf("set password error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
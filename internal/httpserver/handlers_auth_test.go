@@ -0,0 +1,393 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/auth"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+const testPassword = "correct horse battery staple"
+
+func testUser(t *testing.T, svr *HTTPServer, name string, admin bool) *store.User {
+	t.Helper()
+
+	hash, err := auth.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &store.User{Username: name, PasswordHash: hash, Admin: admin}
+	if err := svr.db.CreateUser(context.Background(), u); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	return u
+}
+
+// signIn returns the session cookie of name.
+func signIn(t *testing.T, h http.Handler, name string) *http.Cookie {
+	t.Helper()
+
+	form := url.Values{"username": {name}, "password": {testPassword}}
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	for _, c := range w.Result().Cookies() {
+		if c.Name == sessionCookie {
+			return c
+		}
+	}
+	t.Fatalf("no session cookie for %s: %d %s", name, w.Code, w.Body.String())
+	return nil
+}
+
+func TestAuth(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := svr.routes()
+
+	alice := testUser(t, svr, "alice", false)
+	testUser(t, svr, "root", true)
+	ops := &store.Workspace{Slug: "ops", Name: "Operations"}
+	if err := svr.db.CreateWorkspace(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.db.AddWorkspaceMember(ctx, ops.ID, "root", store.DefaultRole); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string, form url.Values, set func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if set != nil {
+			set(req)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	withCookie := func(c *http.Cookie) func(*http.Request) {
+		return func(r *http.Request) { r.AddCookie(c) }
+	}
+	withToken := func(token string) func(*http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	t.Run("auth: signed out", func(t *testing.T) {
+		w := do(http.MethodGet, "/search?q=x", nil, nil)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/login?next=%2Fsearch%3Fq%3Dx" {
+			t.Errorf("page: got %d to %q", w.Code, w.Header().Get("Location"))
+		}
+		w = do(http.MethodGet, "/api/v1/incidents", nil, nil)
+		if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("api: got %d %v", w.Code, w.Header())
+		}
+		if w := do(http.MethodGet, "/healthz", nil, nil); w.Code != http.StatusOK {
+			t.Errorf("healthz: got %d want %d", w.Code, http.StatusOK)
+		}
+		if w := do(http.MethodGet, "/login", nil, nil); w.Code != http.StatusOK {
+			t.Errorf("login page: got %d want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("auth: sign in", func(t *testing.T) {
+		w := do(http.MethodPost, "/login", url.Values{"username": {"alice"}, "password": {"wrong password!"}}, nil)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "wrong password") || len(w.Result().Cookies()) != 0 {
+			t.Errorf("wrong password: got %d %v", w.Code, w.Result().Cookies())
+		}
+		w = do(http.MethodPost, "/login", url.Values{"username": {"nobody"}, "password": {testPassword}}, nil)
+		if len(w.Result().Cookies()) != 0 {
+			t.Errorf("unknown user: got cookies %v", w.Result().Cookies())
+		}
+
+		for next, want := range map[string]string{"/search": "/search", "//evil.example": "/", "https://evil.example": "/"} {
+			w := do(http.MethodPost, "/login", url.Values{"username": {"ALICE"}, "password": {testPassword}, "next": {next}}, nil)
+			if w.Code != http.StatusSeeOther || w.Header().Get("Location") != want {
+				t.Errorf("next %q: got %d to %q want %q", next, w.Code, w.Header().Get("Location"), want)
+			}
+			c := w.Result().Cookies()
+			if len(c) != 1 || c[0].Name != sessionCookie || !c[0].HttpOnly || c[0].SameSite != http.SameSiteLaxMode {
+				t.Errorf("next %q: cookie %+v", next, c)
+			}
+		}
+	})
+
+	t.Run("auth: signed in", func(t *testing.T) {
+		c := signIn(t, h, "alice")
+		w := do(http.MethodGet, "/", nil, withCookie(c))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Sign out") {
+			t.Fatalf("home: got %d", w.Code)
+		}
+
+		body := strings.NewReader(`{"title":"Queue backlog"}`)
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/incidents", body)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(c)
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusCreated {
+			t.Fatalf("create incident: got %d %s", w.Code, w.Body.String())
+		}
+		entries, _ := svr.db.ListAudit(ctx, store.AuditFilter{Entity: store.AuditIncident})
+		if len(entries) != 1 || entries[0].Actor != "alice" {
+			t.Errorf("audit: got %+v", entries)
+		}
+	})
+
+	t.Run("auth: workspace membership", func(t *testing.T) {
+		c := signIn(t, h, "alice")
+		if w := do(http.MethodGet, "/?workspace=ops", nil, withCookie(c)); w.Code != http.StatusForbidden {
+			t.Errorf("query: got %d want %d", w.Code, http.StatusForbidden)
+		}
+		if w := do(http.MethodPost, "/workspace", url.Values{"workspace": {"ops"}}, withCookie(c)); w.Code != http.StatusForbidden {
+			t.Errorf("switch: got %d want %d", w.Code, http.StatusForbidden)
+		}
+		w := do(http.MethodGet, "/", nil, func(r *http.Request) {
+			r.AddCookie(c)
+			r.AddCookie(&http.Cookie{Name: workspaceCookie, Value: "ops"})
+		})
+		if w.Code != http.StatusOK || strings.Contains(w.Body.String(), "Operations") {
+			t.Errorf("stale cookie: got %d, ops offered %v", w.Code, strings.Contains(w.Body.String(), "Operations"))
+		}
+
+		root := signIn(t, h, "root")
+		if w := do(http.MethodGet, "/?workspace=ops", nil, withCookie(root)); w.Code != http.StatusOK {
+			t.Errorf("member: got %d want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("auth: admin pages", func(t *testing.T) {
+		if w := do(http.MethodGet, "/admin/workspaces", nil, withCookie(signIn(t, h, "alice"))); w.Code != http.StatusForbidden {
+			t.Errorf("user: got %d want %d", w.Code, http.StatusForbidden)
+		}
+		if w := do(http.MethodGet, "/admin/workspaces", nil, withCookie(signIn(t, h, "root"))); w.Code != http.StatusOK {
+			t.Errorf("admin: got %d want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("auth: API tokens", func(t *testing.T) {
+		c := signIn(t, h, "alice")
+		w := do(http.MethodPost, "/account/tokens", url.Values{"name": {"ci"}, "scope": {"incidents:read"}}, withCookie(c))
+		i := strings.Index(w.Body.String(), auth.TokenPrefix)
+		if w.Code != http.StatusOK || i < 0 {
+			t.Fatalf("create token: got %d", w.Code)
+		}
+		token := w.Body.String()[i : i+len(auth.TokenPrefix)+43]
+
+		w = do(http.MethodGet, "/api/v1/incidents", nil, withToken(token))
+		if w.Code != http.StatusOK {
+			t.Errorf("read: got %d want %d", w.Code, http.StatusOK)
+		}
+		w = do(http.MethodPost, "/api/v1/incidents", nil, withToken(token))
+		var p problem
+		json.NewDecoder(w.Body).Decode(&p)
+		if w.Code != http.StatusForbidden || !strings.Contains(p.Detail, "incidents:write") {
+			t.Errorf("write: got %d %+v", w.Code, p)
+		}
+		if w := do(http.MethodGet, "/account", nil, withToken(token)); w.Code != http.StatusForbidden {
+			t.Errorf("account: got %d want %d", w.Code, http.StatusForbidden)
+		}
+		if w := do(http.MethodGet, "/api/v1/incidents", nil, withToken("rt_nope")); w.Code != http.StatusUnauthorized {
+			t.Errorf("unknown token: got %d want %d", w.Code, http.StatusUnauthorized)
+		}
+
+		tokens, _ := svr.db.ListAPITokens(ctx, alice.ID)
+		if len(tokens) != 1 || tokens[0].LastUsedAt == nil {
+			t.Fatalf("tokens: got %+v", tokens)
+		}
+		w = do(http.MethodPost, fmt.Sprintf("/account/tokens/%d/delete", tokens[0].ID), nil, withCookie(c))
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("revoke: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+		if w := do(http.MethodGet, "/api/v1/incidents", nil, withToken(token)); w.Code != http.StatusUnauthorized {
+			t.Errorf("revoked token: got %d want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("auth: sign out", func(t *testing.T) {
+		c := signIn(t, h, "alice")
+		if w := do(http.MethodPost, "/logout", nil, withCookie(c)); w.Code != http.StatusSeeOther {
+			t.Fatalf("logout: got %d", w.Code)
+		}
+		if w := do(http.MethodGet, "/", nil, withCookie(c)); w.Code != http.StatusSeeOther {
+			t.Errorf("after logout: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+	})
+
+	t.Run("auth: cross-origin form", func(t *testing.T) {
+		c := signIn(t, h, "alice")
+		w := do(http.MethodPost, "/workspace", url.Values{"workspace": {"default"}}, func(r *http.Request) {
+			r.AddCookie(c)
+			r.Header.Set("Sec-Fetch-Site", "cross-site")
+		})
+		if w.Code != http.StatusForbidden {
+			t.Errorf("got %d want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("auth: secure cookie", func(t *testing.T) {
+		svr.SecureCookies(true)
+		defer svr.SecureCookies(false)
+
+		if c := signIn(t, h, "alice"); !c.Secure {
+			t.Errorf("cookie not secure: %+v", c)
+		}
+	})
+
+	t.Run("auth: failed sign-ins throttled", func(t *testing.T) {
+		testUser(t, svr, "bob", false)
+		// Earlier failures, long enough ago for their delays to be over.
+		earlier := time.Now().Add(-loginMaxDelay)
+		for range loginMaxPerPair - 1 {
+			svr.logins.fail("bob", "192.0.2.1", earlier)
+		}
+		wrong := url.Values{"username": {"bob"}, "password": {"wrong password!"}}
+		if w := do(http.MethodPost, "/login", wrong, nil); w.Code != http.StatusOK {
+			t.Fatalf("failure: got %d want %d", w.Code, http.StatusOK)
+		}
+
+		right := url.Values{"username": {"Bob"}, "password": {testPassword}}
+		w := do(http.MethodPost, "/login", right, nil)
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || len(w.Result().Cookies()) != 0 {
+			t.Errorf("throttled: got %d %v", w.Code, w.Header())
+		}
+		// From elsewhere bob is only slowed down.
+		w = do(http.MethodPost, "/login", right, func(r *http.Request) { r.RemoteAddr = "198.51.100.1:1234" })
+		if retry, _ := strconv.Atoi(w.Header().Get("Retry-After")); w.Code != http.StatusTooManyRequests || retry > int(loginMaxDelay/time.Second) {
+			t.Errorf("other address: got %d, retry after %ds", w.Code, retry)
+		}
+		// Other users still sign in from the same address.
+		signIn(t, h, "alice")
+	})
+}
+
+func TestLoginLimit(t *testing.T) {
+	now := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+
+	t.Run("loginLimit: per user and address", func(t *testing.T) {
+		var l loginLimit
+		at := now
+		for i := range loginMaxPerPair {
+			if wait := l.retryAfter("alice", "192.0.2.1", at); wait != 0 {
+				t.Fatalf("try %d: refused for %v", i, wait)
+			}
+			l.fail("alice", "192.0.2.1", at)
+			at = at.Add(loginMaxDelay)
+		}
+		if wait := l.retryAfter("ALICE", "192.0.2.1", at); wait != loginWindow-(at.Sub(now)) {
+			t.Errorf("wait: got %v want %v", wait, loginWindow-(at.Sub(now)))
+		}
+		if wait := l.retryAfter("alice", "198.51.100.1", at); wait != 0 {
+			t.Errorf("other address: refused for %v", wait)
+		}
+		if wait := l.retryAfter("alice", "192.0.2.1", now.Add(loginWindow)); wait != 0 {
+			t.Errorf("after the window: refused for %v", wait)
+		}
+
+		l.succeed("alice", "192.0.2.1")
+		if wait := l.retryAfter("alice", "192.0.2.1", at); wait != 0 {
+			t.Errorf("after success: refused for %v", wait)
+		}
+	})
+
+	t.Run("loginLimit: delays per user", func(t *testing.T) {
+		var l loginLimit
+		for i := range loginFreePerUser {
+			l.fail("alice", fmt.Sprintf("192.0.2.%d", i), now)
+		}
+		for i, want := range []time.Duration{loginDelay, 2 * loginDelay, 4 * loginDelay} {
+			if wait := l.retryAfter("alice", "198.51.100.1", now); wait != want {
+				t.Errorf("failure %d: wait %v want %v", loginFreePerUser+i, wait, want)
+			}
+			l.fail("alice", fmt.Sprintf("198.51.100.%d", i), now)
+		}
+		for i := range 20 {
+			l.fail("alice", fmt.Sprintf("203.0.113.%d", i), now)
+		}
+		if wait := l.retryAfter("alice", "198.51.100.1", now); wait != loginMaxDelay {
+			t.Errorf("capped: wait %v want %v", wait, loginMaxDelay)
+		}
+		if wait := l.retryAfter("alice", "198.51.100.1", now.Add(loginMaxDelay)); wait != 0 {
+			t.Errorf("after the delay: refused for %v", wait)
+		}
+		if wait := l.retryAfter("bob", "198.51.100.1", now); wait != 0 {
+			t.Errorf("other user: refused for %v", wait)
+		}
+	})
+
+	t.Run("loginLimit: delays per address", func(t *testing.T) {
+		var l loginLimit
+		for i := range loginFreePerAddr {
+			l.fail(fmt.Sprintf("user%d", i), "192.0.2.1", now)
+		}
+		if wait := l.retryAfter("someone", "192.0.2.1", now); wait != loginDelay {
+			t.Errorf("wait: got %v want %v", wait, loginDelay)
+		}
+		l.succeed("someone", "192.0.2.1")
+		if wait := l.retryAfter("someone", "192.0.2.1", now); wait == 0 {
+			t.Error("a sign-in reset the failures of the address")
+		}
+		if wait := l.retryAfter("someone", "192.0.2.2", now); wait != 0 {
+			t.Errorf("other address: refused for %v", wait)
+		}
+	})
+}
+
+func TestAuthNoUsers(t *testing.T) {
+	svr := setupServerWithDB(t)
+	h := svr.routes()
+
+	do := func(method, path, remote string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(url.Values{"chat_model": {"other"}}.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = remote
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("auth: remote client", func(t *testing.T) {
+		if w := do(http.MethodGet, "/", "203.0.113.7:4711"); w.Code != http.StatusSeeOther {
+			t.Errorf("page: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+		if w := do(http.MethodGet, "/api/v1/incidents", "203.0.113.7:4711"); w.Code != http.StatusUnauthorized {
+			t.Errorf("api: got %d want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("auth: loopback client", func(t *testing.T) {
+		for _, remote := range []string{"127.0.0.1:4711", "[::1]:4711"} {
+			if w := do(http.MethodGet, "/", remote); w.Code != http.StatusOK {
+				t.Errorf("%s: got %d want %d", remote, w.Code, http.StatusOK)
+			}
+		}
+	})
+
+	t.Run("auth: admin routes stay closed", func(t *testing.T) {
+		for _, path := range []string{"/admin/workspaces", "/admin/workspaces/default"} {
+			if w := do(http.MethodGet, path, "127.0.0.1:4711"); w.Code != http.StatusForbidden {
+				t.Errorf("%s: got %d want %d", path, w.Code, http.StatusForbidden)
+			}
+		}
+		if w := do(http.MethodPost, "/settings", "127.0.0.1:4711"); w.Code != http.StatusForbidden {
+			t.Errorf("instance settings: got %d want %d", w.Code, http.StatusForbidden)
+		}
+		settings, err := svr.db.GetSettings(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if settings.ChatModel == "other" {
+			t.Error("instance settings changed")
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+// maxBundleUpload bounds the compressed upload; the store bounds the
+// unpacked size separately.
+const maxBundleUpload = store.MaxBundleSize
+
+func (s *HTTPServer) handleIncidentExport(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return
+	}
+
+	// Export to a temporary file first so that a failure can still be
+	// reported with a proper status; attached files can be large.
+	f, err := os.CreateTemp("", "rt-export-*.tar.gz")
+	if err != nil {
+		log.Printf("export incident error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := s.db.ExportIncident(r.Context(), s.blobs, id, f); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		log.Printf("export incident error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Printf("export incident error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="incident-%d.tar.gz"`, id))
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	if _, err := io.Copy(w, f); err != nil {
+		log.Printf("error writing response: %s; error: %v", r.URL, err)
+	}
+}
+
+func (s *HTTPServer) handleBundleImport(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBundleUpload)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, "invalid upload", http.StatusBadRequest)
+		return
+	}
+
+	policy, err := store.ParseConflictPolicy(r.PostFormValue("on_conflict"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	f, _, err := r.FormFile("bundle")
+	if err != nil {
+		http.Error(w, "bundle is required", http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	res, err := s.db.ImportIncident(r.Context(), s.blobs, f, store.ImportOptions{OnConflict: policy})
+	switch {
+	case errors.Is(err, store.ErrInvalidBundle):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.Is(err, store.ErrConflict):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrForbidden):
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	case err != nil:
+		log.Printf("import incident error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, "Import Incident Bundle", templates.ImportBundleResultPage(res))
+}
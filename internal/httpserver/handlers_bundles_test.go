@@ -0,0 +1,122 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleBundles(t *testing.T) {
+	src := setupServerWithDB(t)
+	ctx := context.Background()
+
+	inc := &store.Incident{Title: "Redis timeouts", StartedAt: time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)}
+	if err := src.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	ev := &store.Evidence{IncidentID: inc.ID, Title: "app log", Content: "i/o timeout", CollectedAt: inc.StartedAt}
+	if err := src.db.CreateEvidence(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+
+	var bundle []byte
+	t.Run("export: download", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/incidents/%d/export", inc.ID), nil)
+		w := httptest.NewRecorder()
+		loopback(src.routes()).ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/gzip" {
+			t.Errorf("content type: got %q", ct)
+		}
+		if cd := w.Header().Get("Content-Disposition"); !strings.Contains(cd, "attachment") {
+			t.Errorf("content disposition: got %q", cd)
+		}
+		bundle = w.Body.Bytes()
+	})
+
+	t.Run("export: errors", func(t *testing.T) {
+		for path, want := range map[string]int{
+			"/incidents/999/export": http.StatusNotFound,
+			"/incidents/x/export":   http.StatusBadRequest,
+		} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			loopback(src.routes()).ServeHTTP(w, req)
+
+			if w.Code != want {
+				t.Errorf("%s: status got %d want %d", path, w.Code, want)
+			}
+		}
+	})
+
+	dst := setupServerWithDB(t)
+	h := loopback(dst.routes())
+
+	t.Run("import: form", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/bundles/import", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `name="bundle"`) {
+			t.Errorf("status %d, body:\n%s", w.Code, w.Body)
+		}
+	})
+
+	tests := []struct {
+		name     string
+		bundle   []byte
+		conflict string
+		want     int
+		contains string
+	}{
+		{"import: created", bundle, "", http.StatusOK, "was imported as incident"},
+		{"import: conflict", bundle, "fail", http.StatusConflict, "already exists"},
+		{"import: skipped", bundle, "skip", http.StatusOK, "left unchanged"},
+		{"import: unknown policy", bundle, "merge", http.StatusBadRequest, "unknown conflict policy"},
+		{"import: not a bundle", []byte("hello"), "copy", http.StatusBadRequest, "invalid bundle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body bytes.Buffer
+			mw := multipart.NewWriter(&body)
+			mw.WriteField("on_conflict", tt.conflict)
+			fw, err := mw.CreateFormFile("bundle", "incident.tar.gz")
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(tt.bundle)
+			mw.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/bundles/import", &body)
+			req.Header.Set("Content-Type", mw.FormDataContentType())
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("status: got %d want %d: %s", w.Code, tt.want, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.contains) {
+				t.Errorf("body does not contain %q:\n%s", tt.contains, w.Body)
+			}
+		})
+	}
+
+	got, err := dst.db.GetIncidentByUID(ctx, inc.UID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Title != inc.Title {
+		t.Errorf("imported incident: %+v", got)
+	}
+}
@@ -0,0 +1,325 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-h/templ"
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+// chatTimeout bounds the answer to one question.
+const chatTimeout = 10 * time.Minute
+
+// handleChatMessage stores a question asked inside an incident and starts
+// answering it. htmx requests get the question and the answer as it is
+// written, everything else goes back to the conversation.
+func (s *HTTPServer) handleChatMessage(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+	if s.llm == nil {
+		http.Error(w, "no model backend configured", http.StatusServiceUnavailable)
+		return
+	}
+	question := strings.TrimSpace(r.PostFormValue("message"))
+	if question == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+
+	m := &store.ChatMessage{IncidentID: inc.ID, Role: string(llm.RoleUser), Content: question}
+	run, err := s.startAnswer(r, inc, m, nil)
+	if errors.Is(err, errChatBusy) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("chat answer of incident %d error: %v", inc.ID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.chatResponse(w, r, inc.ID, templ.Join(
+		templates.ComponentChatMessage(*m, false),
+		templates.ComponentChatAnswer(toTemplateRun(run)),
+	))
+}
+
+// handleChatRegenerate answers the question before the latest answer
+// again and replaces that answer once the new one is done. A question
+// whose answer failed, which ends the conversation, is answered again.
+func (s *HTTPServer) handleChatRegenerate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid message id", http.StatusBadRequest)
+		return
+	}
+	m, err := s.db.GetChatMessage(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("get chat message %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if s.llm == nil {
+		http.Error(w, "no model backend configured", http.StatusServiceUnavailable)
+		return
+	}
+	inc, err := s.db.GetIncident(r.Context(), m.IncidentID)
+	if err != nil {
+		log.Printf("get incident %d error: %v", m.IncidentID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	run, err := s.startAnswer(r, inc, nil, m)
+	switch {
+	case errors.Is(err, errChatBusy):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	case errors.Is(err, errNotLatestAnswer):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("chat answer of incident %d error: %v", inc.ID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.chatResponse(w, r, inc.ID, templates.ComponentChatAnswer(toTemplateRun(run)))
+}
+
+func (s *HTTPServer) chatResponse(w http.ResponseWriter, r *http.Request, incidentID int64, c templ.Component) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, fmt.Sprintf("/incidents/%d#chat", incidentID), http.StatusSeeOther)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := c.Render(r.Context(), w); err != nil {
+		log.Printf("chat render error: %v", err)
+	}
+}
+
+// errNotLatestAnswer rejects regenerating an answer the conversation has
+// moved past.
+var errNotLatestAnswer = errors.New("only the latest answer or an unanswered question can be answered again")
+
+// errChatBusy rejects a question while the previous one is answered.
+var errChatBusy = errors.New("the previous question is still being answered")
+
+// startAnswer starts answering the conversation of inc in the background,
+// after adding question to it unless that is nil. With again set, the
+// conversation is answered anew: again is the latest answer, which the
+// new one replaces, or the question that ends the conversation unanswered.
+func (s *HTTPServer) startAnswer(r *http.Request, inc *store.Incident, question, again *store.ChatMessage) (*analysisRun, error) {
+	ctx := r.Context()
+
+	// Like an analysis the answer outlives the request. The run is claimed
+	// before anything is stored, so that one answer is written at a time.
+	runCtx, cancel := s.background(ctx, chatTimeout)
+	run := s.runs.startIfIdle(runKindChat, inc.ID, cancel)
+	if run == nil {
+		cancel()
+		return nil, errChatBusy
+	}
+
+	prompt, replace, err := s.chatPrompt(ctx, inc, question, again)
+	if err != nil {
+		s.runs.drop(run)
+		return nil, err
+	}
+	retry := again
+	if question != nil {
+		retry = question
+	}
+	s.tasks.Go(func() { s.runAnswer(runCtx, s.chatter(runCtx), run, prompt, replace, retry.ID) })
+
+	return run, nil
+}
+
+// chatPrompt stores question, unless it is nil, and returns the prompt
+// that answers the conversation of inc, with the answer to replace; see
+// startAnswer.
+func (s *HTTPServer) chatPrompt(ctx context.Context, inc *store.Incident, question, again *store.ChatMessage) ([]llm.Message, *store.ChatMessage, error) {
+	if question != nil {
+		if err := s.db.CreateChatMessage(ctx, question); err != nil {
+			return nil, nil, err
+		}
+	}
+	msgs, err := s.db.ListChatMessages(ctx, inc.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var replace *store.ChatMessage
+	if again != nil {
+		n := len(msgs)
+		if n == 0 || msgs[n-1].ID != again.ID {
+			return nil, nil, errNotLatestAnswer
+		}
+		if again.Role == string(llm.RoleAssistant) {
+			replace = again
+			msgs = msgs[:n-1]
+		}
+	}
+
+	persona, err := s.chatPersona(ctx, inc.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	evidence, err := s.chatEvidence(ctx, inc.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	conversation := make([]llm.Message, 0, len(msgs))
+	for _, m := range msgs {
+		conversation = append(conversation, llm.Message{Role: llm.Role(m.Role), Content: m.Content})
+	}
+	incident := inc.Title
+	if inc.Summary != "" {
+		incident += "\n" + inc.Summary
+	}
+
+	return llm.BuildChatPrompt(toLLMPersona(persona), incident, evidence, conversation), replace, nil
+}
+
+// runAnswer writes the answer to prompt and stores it, replacing replace
+// unless that is nil. When it fails, the done event offers to answer
+// again from the message retry.
+func (s *HTTPServer) runAnswer(ctx context.Context, chat llm.Chatter, run *analysisRun, prompt []llm.Message, replace *store.ChatMessage, retry int64) {
+	defer run.cancel()
+
+	answer, err := llm.StreamAnswer(ctx, chat, prompt, func(d llm.Delta) {
+		if d.Thinking != "" {
+			run.publish(eventThinking, html.EscapeString(d.Thinking))
+		}
+		if d.Content != "" {
+			run.publish(eventToken, html.EscapeString(d.Content))
+		}
+	})
+	if err == nil && answer == "" {
+		err = errors.New("the model returned an empty answer")
+	}
+	if err != nil {
+		log.Printf("chat answer of incident %d error: %v", run.incidentID, err)
+		msg := "No answer: " + err.Error()
+		if errors.Is(ctx.Err(), context.Canceled) {
+			msg = "Stopped."
+		}
+		var b strings.Builder
+		fmt.Fprintf(&b, `<p class="run-failed" role="alert">%s</p>`, html.EscapeString(msg))
+		if err := templates.ComponentChatRetry(retry).Render(context.WithoutCancel(ctx), &b); err != nil {
+			log.Printf("chat retry render error: %v", err)
+		}
+		run.publish(eventDone, b.String())
+		return
+	}
+
+	ctx = context.WithoutCancel(ctx)
+	m := &store.ChatMessage{IncidentID: run.incidentID, Role: string(llm.RoleAssistant), Content: answer}
+	if replace != nil {
+		m.ID = replace.ID
+		err = s.db.UpdateChatMessage(ctx, m)
+	} else {
+		err = s.db.CreateChatMessage(ctx, m)
+	}
+	if err != nil {
+		log.Printf("store chat answer of incident %d error: %v", run.incidentID, err)
+		run.publish(eventDone, `<p class="run-failed" role="alert">The answer could not be saved.</p>`)
+		return
+	}
+
+	var b strings.Builder
+	if err := templates.ComponentChatActions(*m, true).Render(ctx, &b); err != nil {
+		log.Printf("chat actions render error: %v", err)
+	}
+	run.publish(eventDone, b.String())
+}
+
+// chatPersona is the persona chosen for the chat of an incident, else the
+// one of the incident, else nil.
+func (s *HTTPServer) chatPersona(ctx context.Context, incidentID int64) (*store.Persona, error) {
+	for _, subject := range []store.PersonaSubject{store.PersonaSubjectChat, store.PersonaSubjectIncident} {
+		p, err := s.db.AssignedPersona(ctx, subject, incidentID)
+		if !errors.Is(err, store.ErrNotFound) {
+			return p, err
+		}
+	}
+
+	return nil, nil
+}
+
+// chatEvidence is what the model knows about an incident when it answers:
+// its evidence and its timeline.
+func (s *HTTPServer) chatEvidence(ctx context.Context, incidentID int64) ([]llm.Evidence, error) {
+	evidence, err := s.db.ListEvidence(ctx, store.EvidenceFilter{IncidentID: incidentID})
+	if err != nil {
+		return nil, err
+	}
+	events, err := s.db.ListTimelineEvents(ctx, store.TimelineFilter{IncidentID: incidentID})
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]llm.Evidence, 0, len(evidence)+len(events))
+	for _, ev := range evidence {
+		content := ev.Content
+		if content == "" {
+			content = ev.Title + " (attached file)"
+		}
+		out = append(out, llm.Evidence{Kind: ev.Kind, Content: content})
+	}
+	for _, ev := range events {
+		out = append(out, llm.Evidence{
+			Kind:    ev.Kind,
+			Content: ev.OccurredAt.UTC().Format("2006-01-02 15:04:05 UTC") + " " + ev.Description,
+		})
+	}
+
+	return out, nil
+}
+
+// handleChatMarkdown returns the conversation of an incident as Markdown.
+func (s *HTTPServer) handleChatMarkdown(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+	msgs, err := s.db.ListChatMessages(r.Context(), inc.ID)
+	if err != nil {
+		log.Printf("list chat messages error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n", inc.Title)
+	for _, m := range msgs {
+		switch m.Role {
+		case string(llm.RoleUser):
+			fmt.Fprintf(&b, "\n> %s\n", strings.ReplaceAll(m.Content, "\n", "\n> "))
+		case string(llm.RoleAssistant):
+			fmt.Fprintf(&b, "\n%s\n", m.Content)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	if _, err := w.Write([]byte(b.String())); err != nil {
+		log.Printf("chat markdown write error: %v", err)
+	}
+}
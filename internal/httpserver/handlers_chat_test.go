@@ -0,0 +1,252 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// recordingChatter answers every question with its next response and
+// keeps the prompts it was given.
+type recordingChatter struct {
+	mu        sync.Mutex
+	responses []string
+	prompts   [][]llm.Message
+}
+
+func (c *recordingChatter) Chat(ctx context.Context, msgs []llm.Message, opts *llm.CallOptions) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.prompts = append(c.prompts, msgs)
+	resp := c.responses[0]
+	if len(c.responses) > 1 {
+		c.responses = c.responses[1:]
+	}
+	return resp, nil
+}
+
+func (c *recordingChatter) lastPrompt() []llm.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.prompts[len(c.prompts)-1]
+}
+
+func TestIncidentChat(t *testing.T) {
+	svr := setupServerWithDB(t)
+	chat := &recordingChatter{responses: []string{"<think>deploys?</think>payments **v2.3.1** [1]", "a config change [2]"}}
+	svr.llm = chat
+	ctx := context.Background()
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Redis timeouts"}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.db.CreateEvidence(ctx, &store.Evidence{IncidentID: inc.ID, Kind: "deploy", Title: "deploys", Content: "payments v2.3.1"}); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2026, 3, 1, 13, 58, 0, 0, time.UTC)
+	if err := svr.db.CreateTimelineEvent(ctx, &store.TimelineEvent{IncidentID: inc.ID, OccurredAt: at, Kind: "alert", Description: "redis latency"}); err != nil {
+		t.Fatal(err)
+	}
+	ask := fmt.Sprintf("/incidents/%d/chat", inc.ID)
+
+	post := func(path string, form url.Values, htmx bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	// finish waits for the answer of the incident and returns its events.
+	finish := func(t *testing.T) string {
+		t.Helper()
+		run := latestRun(t, svr, runKindChat, inc.ID)
+		deadline := time.Now().Add(5 * time.Second)
+		for run.running() {
+			if time.Now().After(deadline) {
+				t.Fatal("answer did not finish")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/runs/"+run.id+"/events", nil))
+		return w.Body.String()
+	}
+
+	t.Run("handleChatMessage: streamed and stored answer", func(t *testing.T) {
+		w := post(ask, url.Values{"message": {"what changed before 14:00?"}}, true)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d: %s", w.Code, w.Body)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "what changed before 14:00?</pre>") || !strings.Contains(body, `sse-connect="/runs/`) {
+			t.Errorf("unexpected fragment: %s", body)
+		}
+
+		events := finish(t)
+		for _, want := range []string{
+			"event: thinking\ndata: deploys?",
+			"event: token\ndata: payments **v2.3.1** [1]",
+			"event: done\ndata: <div class=\"chat-actions\">",
+			`data-markdown="payments **v2.3.1** [1]"`,
+			"/regenerate",
+		} {
+			if !strings.Contains(events, want) {
+				t.Errorf("missing %q in:\n%s", want, events)
+			}
+		}
+
+		msgs, err := svr.db.ListChatMessages(ctx, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 2 || msgs[1].Role != "assistant" || msgs[1].Content != "payments **v2.3.1** [1]" {
+			t.Errorf("unexpected conversation: %+v", msgs)
+		}
+
+		prompt := chat.lastPrompt()
+		if len(prompt) != 2 || prompt[1].Content != "what changed before 14:00?" {
+			t.Fatalf("unexpected prompt: %+v", prompt)
+		}
+		for _, want := range []string{"## Incident\nRedis timeouts", "(deploy) payments v2.3.1", "(alert) 2026-03-01 13:58:00 UTC redis latency"} {
+			if !strings.Contains(prompt[0].Content, want) {
+				t.Errorf("missing %q in system prompt", want)
+			}
+		}
+	})
+
+	t.Run("handleChatRegenerate: replaces the latest answer", func(t *testing.T) {
+		msgs, err := svr.db.ListChatMessages(ctx, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := post(fmt.Sprintf("/chat-messages/%d/regenerate", msgs[0].ID), nil, true); w.Code != http.StatusBadRequest {
+			t.Errorf("question: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+
+		w := post(fmt.Sprintf("/chat-messages/%d/regenerate", msgs[1].ID), nil, false)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != fmt.Sprintf("/incidents/%d#chat", inc.ID) {
+			t.Fatalf("got %d to %q", w.Code, w.Header().Get("Location"))
+		}
+		finish(t)
+		if prompt := chat.lastPrompt(); len(prompt) != 2 {
+			t.Errorf("regenerated prompt: got %d messages want 2", len(prompt))
+		}
+		got, err := svr.db.GetChatMessage(ctx, msgs[1].ID)
+		if err != nil || got.Content != "a config change [2]" {
+			t.Errorf("regenerated: got %+v, %v", got, err)
+		}
+		if all, _ := svr.db.ListChatMessages(ctx, inc.ID); len(all) != 2 {
+			t.Errorf("conversation: got %d messages want 2", len(all))
+		}
+	})
+
+	t.Run("handleChatMarkdown: conversation", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/incidents/%d/chat.md", inc.ID), nil))
+		if ct := w.Header().Get("Content-Type"); ct != "text/markdown; charset=utf-8" {
+			t.Errorf("content type: got %q", ct)
+		}
+		if want := "# Redis timeouts\n\n> what changed before 14:00?\n\na config change [2]\n"; w.Body.String() != want {
+			t.Errorf("got %q want %q", w.Body.String(), want)
+		}
+	})
+
+	t.Run("handleChatMessage: one answer at a time", func(t *testing.T) {
+		gate := &gateChatter{release: make(chan struct{})}
+		svr.llm = gate
+		defer func() { svr.llm = chat }()
+
+		if w := post(ask, url.Values{"message": {"and after?"}}, true); w.Code != http.StatusOK {
+			t.Fatalf("status: got %d", w.Code)
+		}
+		if w := post(ask, url.Values{"message": {"hello?"}}, true); w.Code != http.StatusConflict {
+			t.Errorf("second question: got %d want %d", w.Code, http.StatusConflict)
+		}
+		page := httptest.NewRecorder()
+		h.ServeHTTP(page, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/incidents/%d", inc.ID), nil))
+		if !strings.Contains(page.Body.String(), `class="chat-message chat-assistant" id="run-`) {
+			t.Error("page does not follow the running answer")
+		}
+		close(gate.release)
+		finish(t)
+	})
+
+	t.Run("handleChatRegenerate: answers an unanswered question", func(t *testing.T) {
+		gate := &gateChatter{release: make(chan struct{})}
+		svr.llm = gate
+		defer func() { svr.llm = chat }()
+
+		if w := post(ask, url.Values{"message": {"why redis?"}}, true); w.Code != http.StatusOK {
+			t.Fatalf("status: got %d", w.Code)
+		}
+		run := latestRun(t, svr, runKindChat, inc.ID)
+		if w := post("/runs/"+run.id+"/cancel", nil, true); w.Code != http.StatusNoContent {
+			t.Fatalf("cancel: got %d", w.Code)
+		}
+		if events := finish(t); !strings.Contains(events, "Stopped.") || !strings.Contains(events, "Try again") {
+			t.Errorf("no retry offered:\n%s", events)
+		}
+		msgs, err := svr.db.ListChatMessages(ctx, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		question := msgs[len(msgs)-1]
+		if question.Role != "user" {
+			t.Fatalf("conversation does not end in the question: %+v", question)
+		}
+		page := httptest.NewRecorder()
+		h.ServeHTTP(page, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/incidents/%d", inc.ID), nil))
+		if !strings.Contains(page.Body.String(), fmt.Sprintf("/chat-messages/%d/regenerate", question.ID)) {
+			t.Error("page does not offer to answer the question again")
+		}
+
+		svr.llm = chat
+		if w := post(fmt.Sprintf("/chat-messages/%d/regenerate", question.ID), nil, true); w.Code != http.StatusOK {
+			t.Fatalf("status: got %d: %s", w.Code, w.Body)
+		}
+		finish(t)
+		if prompt := chat.lastPrompt(); prompt[len(prompt)-1].Content != "why redis?" {
+			t.Errorf("prompt does not end in the question: %+v", prompt[len(prompt)-1])
+		}
+		all, err := svr.db.ListChatMessages(ctx, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != len(msgs)+1 || all[len(all)-1].Role != "assistant" {
+			t.Errorf("question not answered: %+v", all)
+		}
+		if w := post(fmt.Sprintf("/chat-messages/%d/regenerate", question.ID), nil, true); w.Code != http.StatusBadRequest {
+			t.Errorf("answered question: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("handleChatMessage: errors", func(t *testing.T) {
+		if w := post(ask, url.Values{"message": {"  "}}, false); w.Code != http.StatusBadRequest {
+			t.Errorf("empty: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+		if w := post("/incidents/9999/chat", url.Values{"message": {"x"}}, false); w.Code != http.StatusNotFound {
+			t.Errorf("unknown incident: got %d want %d", w.Code, http.StatusNotFound)
+		}
+		if w := post("/chat-messages/9999/regenerate", nil, false); w.Code != http.StatusNotFound {
+			t.Errorf("unknown message: got %d want %d", w.Code, http.StatusNotFound)
+		}
+		svr.llm = nil
+		if w := post(ask, url.Values{"message": {"x"}}, false); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("no backend: got %d want %d", w.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
@@ -0,0 +1,176 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+// debateTimeout bounds a background debate; every turn is a full model call
+// and a local model can take minutes per turn.
+const debateTimeout = 30 * time.Minute
+
+// defaultDebatePersonas are preselected on the new debate form.
+var defaultDebatePersonas = []string{"sre", "dba", "network"}
+
+func (s *HTTPServer) handleDebateNew(w http.ResponseWriter, r *http.Request) {
+	personas, err := s.db.ListPersonas(r.Context())
+	if err != nil {
+		log.Printf("list personas error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, "New Debate", templates.NewDebatePage(personas, defaultDebatePersonas))
+}
+
+func (s *HTTPServer) handleDebateCreate(w http.ResponseWriter, r *http.Request) {
+	if s.llm == nil {
+		http.Error(w, "no model backend configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	incidentID, err := strconv.ParseInt(r.PostFormValue("incident_id"), 10, 64)
+	if err != nil || incidentID <= 0 {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return
+	}
+
+	problem := strings.TrimSpace(r.PostFormValue("problem"))
+	if problem == "" {
+		http.Error(w, "problem is required", http.StatusBadRequest)
+		return
+	}
+
+	rounds := llm.DefaultDebateRounds
+	if v := r.PostFormValue("rounds"); v != "" {
+		rounds, err = strconv.Atoi(v)
+		if err != nil || rounds < 0 || rounds > llm.MaxDebateRounds {
+			http.Error(w, fmt.Sprintf("rounds must be between 0 and %d", llm.MaxDebateRounds), http.StatusBadRequest)
+			return
+		}
+	}
+
+	agents, err := s.selectAgents(r.Context(), r.PostForm["role"])
+	if err != nil {
+		log.Printf("select debate agents error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(agents) < 2 {
+		http.Error(w, "select at least two roles", http.StatusBadRequest)
+		return
+	}
+
+	deb := &store.Debate{
+		IncidentID: incidentID,
+		Problem:    problem,
+		Rounds:     rounds,
+	}
+	for _, a := range agents {
+		deb.Agents = append(deb.Agents, a.Name)
+	}
+	err = s.db.CreateDebate(r.Context(), deb)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "unknown incident", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("create debate error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The debate outlives the request; the transcript is persisted turn by
+	// turn so the debate page can be refreshed while it runs. Its writes are
+	// attributed to whoever started it and stay in its workspace.
+	ctx, cancel := s.background(r.Context(), debateTimeout)
+	s.tasks.Go(func() {
+		defer cancel()
+		s.runDebate(ctx, s.chatter(ctx), deb.ID, llm.Debate{Agents: agents, Rounds: rounds}, problem)
+	})
+
+	http.Redirect(w, r, fmt.Sprintf("/debates/%d", deb.ID), http.StatusSeeOther)
+}
+
+func (s *HTTPServer) runDebate(ctx context.Context, chat llm.Chatter, id int64, d llm.Debate, problem string) {
+	d.OnTurn = func(t llm.DebateTurn) error {
+		return s.db.AddDebateTurn(ctx, &store.DebateTurn{
+			DebateID: id,
+			Round:    t.Round,
+			Agent:    t.Agent,
+			Content:  t.Content,
+		})
+	}
+
+	var verdict string
+	res, err := llm.RunDebate(ctx, chat, d, problem)
+	if res != nil {
+		verdict = res.Verdict
+	}
+	if err != nil {
+		log.Printf("debate %d error: %v", id, err)
+	}
+	// The run context may have timed out; recording the outcome must not.
+	if err := s.db.FinishDebate(context.WithoutCancel(ctx), id, verdict, err); err != nil {
+		log.Printf("finish debate %d error: %v", id, err)
+	}
+}
+
+func (s *HTTPServer) handleDebate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid debate id", http.StatusBadRequest)
+		return
+	}
+
+	deb, err := s.db.GetDebate(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("get debate %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, fmt.Sprintf("Debate #%d", deb.ID), templates.DebatePage(deb))
+}
+
+// selectAgents turns submitted persona keys into debate agents, ignoring
+// unknown keys and duplicates.
+func (s *HTTPServer) selectAgents(ctx context.Context, keys []string) ([]llm.Agent, error) {
+	var out []llm.Agent
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+
+		p, err := s.db.GetPersona(ctx, k)
+		if errors.Is(err, store.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, toLLMPersona(p).Agent())
+	}
+
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleDebateCreate(t *testing.T) {
+	svr := setupServerWithDB(t)
+	svr.llm = &fakeChatter{resp: "it was the deploy"}
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Checkout timeouts"}
+	if err := svr.db.CreateIncident(context.Background(), inc); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/debates", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("debate: runs and stores transcript", func(t *testing.T) {
+		w := post(url.Values{
+			"incident_id": {strconv.FormatInt(inc.ID, 10)},
+			"problem":     {"checkout timeouts since 14:00"},
+			"role":        {"sre", "dba"},
+			"rounds":      {"1"},
+		})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d; body: %s", w.Code, http.StatusSeeOther, w.Body)
+		}
+		loc := w.Header().Get("Location")
+		id, err := strconv.ParseInt(strings.TrimPrefix(loc, "/debates/"), 10, 64)
+		if err != nil {
+			t.Fatalf("unexpected redirect: %q", loc)
+		}
+
+		var deb *store.Debate
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			var err error
+			deb, err = svr.db.GetDebate(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deb.Status != store.DebateRunning || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+
+		if deb.Status != store.DebateFinished {
+			t.Fatalf("debate status: got %q want %q (%s)", deb.Status, store.DebateFinished, deb.Error)
+		}
+		// 2 agents * 2 rounds + judge
+		if len(deb.Turns) != 5 {
+			t.Errorf("turns: got %d want 5", len(deb.Turns))
+		}
+		if deb.Verdict != "it was the deploy" {
+			t.Errorf("verdict: got %q", deb.Verdict)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, loc, nil)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "Round 1 - DBA") {
+			t.Errorf("debate page missing transcript: %d %s", rw.Code, rw.Body)
+		}
+	})
+
+	t.Run("debate: needs two known roles", func(t *testing.T) {
+		w := post(url.Values{
+			"incident_id": {strconv.FormatInt(inc.ID, 10)},
+			"problem":     {"x"},
+			"role":        {"sre", "astrologer"},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("debate: unknown incident", func(t *testing.T) {
+		w := post(url.Values{
+			"incident_id": {"999"},
+			"problem":     {"x"},
+			"role":        {"sre", "dba"},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("debate: invalid rounds", func(t *testing.T) {
+		w := post(url.Values{
+			"incident_id": {strconv.FormatInt(inc.ID, 10)},
+			"problem":     {"x"},
+			"role":        {"sre", "dba"},
+			"rounds":      {"99"},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
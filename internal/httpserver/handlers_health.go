@@ -0,0 +1,148 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// readyTTL is how long a readiness report is served before the checks run
+// again, so that frequent probes stay cheap for the database and Ollama.
+const readyTTL = 10 * time.Second
+
+// readyCheckTimeout bounds each dependency check.
+const readyCheckTimeout = 3 * time.Second
+
+// Check statuses of a readiness report.
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+// checkResult is the outcome of checking one dependency. Detail and Error
+// name files, hosts and models, so they go to the log rather than to the
+// unauthenticated probe.
+type checkResult struct {
+	Status     string `json:"status"`
+	Detail     string `json:"-"`
+	Error      string `json:"-"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// readyReport is the answer of /readyz: ok only when every check is.
+type readyReport struct {
+	Status    string                 `json:"status"`
+	CheckedAt time.Time              `json:"checked_at"`
+	Checks    map[string]checkResult `json:"checks"`
+}
+
+// readiness caches the last readiness report.
+type readiness struct {
+	mu     sync.Mutex
+	report *readyReport
+}
+
+// handleReadyz reports whether the server can do its work: the database
+// answers and is migrated, and the model backend is reachable with the
+// configured model installed. It answers 503 when a check fails. Reports
+// are cached for readyTTL. Only the status and duration of each check are
+// reported; failures are logged with their cause.
+func (s *HTTPServer) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	rep := s.readiness(r.Context())
+
+	status := http.StatusOK
+	if rep.Status != checkOK {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, rep)
+}
+
+// readiness returns the cached report, or checks again once it is older
+// than readyTTL. Probes arriving while the checks run wait for them rather
+// than starting their own.
+func (s *HTTPServer) readiness(ctx context.Context) *readyReport {
+	s.ready.mu.Lock()
+	defer s.ready.mu.Unlock()
+	if rep := s.ready.report; rep != nil && time.Since(rep.CheckedAt) < readyTTL {
+		return rep
+	}
+
+	// A probe that gives up must not leave a failed report behind.
+	ctx = context.WithoutCancel(ctx)
+	rep := &readyReport{Status: checkOK, CheckedAt: time.Now().UTC(), Checks: map[string]checkResult{}}
+	check := func(name string, fn func(context.Context) (string, error)) bool {
+		ctx, cancel := context.WithTimeout(ctx, readyCheckTimeout)
+		defer cancel()
+
+		start := time.Now()
+		detail, err := fn(ctx)
+		res := checkResult{Status: checkOK, Detail: detail, DurationMS: time.Since(start).Milliseconds()}
+		if err != nil {
+			res.Status, res.Error = checkFail, err.Error()
+			rep.Status = checkFail
+			slog.WarnContext(ctx, "readiness check failed", "check", name, "detail", detail, "error", err)
+		}
+		rep.Checks[name] = res
+		return err == nil
+	}
+
+	if s.db != nil {
+		// The migrator opens the file by path and would create it afresh.
+		if check("database", s.checkDatabase) {
+			check("migrations", s.checkMigrations)
+		}
+	}
+	if lister, ok := s.llm.(llm.ModelLister); ok {
+		var models []string
+		reachable := check("model_backend", func(ctx context.Context) (string, error) {
+			var err error
+			models, err = lister.ListModels(ctx)
+			return fmt.Sprintf("%d models installed", len(models)), err
+		})
+		if m, ok := s.llm.(interface{ Model() string }); ok {
+			check("model", func(context.Context) (string, error) {
+				switch {
+				case !reachable:
+					return m.Model(), fmt.Errorf("model backend is unreachable")
+				case !llm.HasModel(models, m.Model()):
+					return m.Model(), fmt.Errorf("model %s is not installed", m.Model())
+				}
+				return m.Model(), nil
+			})
+		}
+	}
+
+	s.ready.report = rep
+	return rep
+}
+
+func (s *HTTPServer) checkDatabase(ctx context.Context) (string, error) {
+	return "", s.db.Ping(ctx)
+}
+
+func (s *HTTPServer) checkMigrations(context.Context) (string, error) {
+	v, dirty, err := s.db.Version()
+	if err != nil {
+		return "", err
+	}
+	latest, err := store.LatestVersion()
+	if err != nil {
+		return "", err
+	}
+
+	detail := fmt.Sprintf("version %d of %d", v, latest)
+	switch {
+	case dirty:
+		return detail, fmt.Errorf("migration %d failed halfway", v)
+	case v < latest:
+		return detail, fmt.Errorf("%d migrations pending", latest-v)
+	}
+	return detail, nil
+}
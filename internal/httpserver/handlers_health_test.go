@@ -0,0 +1,154 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listerChatter is a model backend with model configured and models
+// installed; err makes it unreachable.
+type listerChatter struct {
+	fakeChatter
+	model  string
+	models []string
+	err    error
+	calls  int
+}
+
+func (l *listerChatter) Model() string { return l.model }
+
+func (l *listerChatter) ListModels(ctx context.Context) ([]string, error) {
+	l.calls++
+	return l.models, l.err
+}
+
+func TestHandleReadyz(t *testing.T) {
+	get := func(t *testing.T, svr *HTTPServer) (int, readyReport) {
+		t.Helper()
+		// Every case checks afresh.
+		svr.ready.report = nil
+
+		w := httptest.NewRecorder()
+		svr.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if got := w.Header().Get("Cache-Control"); got != "no-store" {
+			t.Errorf("cache-control: got %q", got)
+		}
+		var rep readyReport
+		if err := json.NewDecoder(w.Body).Decode(&rep); err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		return w.Code, rep
+	}
+
+	t.Run("readyz: ready", func(t *testing.T) {
+		svr := setupServerWithDB(t)
+		svr.llm = &listerChatter{model: "llama3.2", models: []string{"llama3.2:latest", "nomic-embed-text:latest"}}
+
+		code, rep := get(t, svr)
+		if code != http.StatusOK || rep.Status != checkOK {
+			t.Fatalf("got %d %+v", code, rep)
+		}
+		for _, name := range []string{"database", "migrations", "model_backend", "model"} {
+			if rep.Checks[name].Status != checkOK {
+				t.Errorf("%s: got %+v", name, rep.Checks[name])
+			}
+		}
+	})
+
+	t.Run("readyz: model not installed", func(t *testing.T) {
+		svr := setupServerWithDB(t)
+		svr.llm = &listerChatter{model: "llama3.2", models: []string{"mistral:latest"}}
+
+		code, rep := get(t, svr)
+		if code != http.StatusServiceUnavailable || rep.Checks["model"].Status != checkFail {
+			t.Errorf("got %d %+v", code, rep)
+		}
+		if rep.Checks["model_backend"].Status != checkOK {
+			t.Errorf("model_backend: got %+v", rep.Checks["model_backend"])
+		}
+	})
+
+	t.Run("readyz: model backend down", func(t *testing.T) {
+		svr := setupServerWithDB(t)
+		svr.llm = &listerChatter{model: "llama3.2", err: errors.New("dial tcp 10.0.0.7:11434: connection refused")}
+		logs := captureLog(t)
+
+		code, rep := get(t, svr)
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("got %d want %d", code, http.StatusServiceUnavailable)
+		}
+		if rep.Checks["model_backend"].Status != checkFail {
+			t.Errorf("model_backend: got %+v", rep.Checks["model_backend"])
+		}
+		if rep.Checks["model"].Status != checkFail {
+			t.Errorf("model: got %+v", rep.Checks["model"])
+		}
+
+		// The causes are logged, not told to whoever probes.
+		w := httptest.NewRecorder()
+		svr.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		for _, secret := range []string{"10.0.0.7", "llama3.2", "connection refused"} {
+			if strings.Contains(w.Body.String(), secret) {
+				t.Errorf("body reveals %q: %s", secret, w.Body)
+			}
+			if !strings.Contains(logs.String(), secret) {
+				t.Errorf("log lacks %q: %s", secret, logs)
+			}
+		}
+	})
+
+	t.Run("readyz: pending migrations", func(t *testing.T) {
+		svr := setupServerWithDB(t)
+		if err := svr.db.Steps(-1); err != nil {
+			t.Fatal(err)
+		}
+
+		code, rep := get(t, svr)
+		if code != http.StatusServiceUnavailable || rep.Checks["migrations"].Status != checkFail {
+			t.Errorf("got %d %+v", code, rep)
+		}
+	})
+
+	t.Run("readyz: database gone", func(t *testing.T) {
+		svr := setupServerWithDB(t)
+		svr.db.Close()
+
+		code, rep := get(t, svr)
+		if code != http.StatusServiceUnavailable || rep.Checks["database"].Status != checkFail {
+			t.Errorf("got %d %+v", code, rep)
+		}
+		if _, ok := rep.Checks["migrations"]; ok {
+			t.Errorf("migrations checked without a database")
+		}
+
+		w := httptest.NewRecorder()
+		svr.routes().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("healthz: got %d want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("readyz: cached", func(t *testing.T) {
+		svr := setupServerWithDB(t)
+		chat := &listerChatter{model: "llama3.2", models: []string{"llama3.2:latest"}}
+		svr.llm = chat
+
+		first := svr.readiness(context.Background())
+		second := svr.readiness(context.Background())
+		if chat.calls != 1 || first != second {
+			t.Errorf("got %d checks", chat.calls)
+		}
+
+		svr.ready.report.CheckedAt = time.Now().Add(-readyTTL)
+		svr.readiness(context.Background())
+		if chat.calls != 2 {
+			t.Errorf("expired: got %d checks", chat.calls)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+// webActor is who changes made through the web UI are attributed to in the
+// audit log while nobody needs to sign in.
+const webActor = "web"
+
+// withActor attributes every change made while serving a request to the
+// signed-in user.
+func withActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := webActor
+		if u := currentUser(r.Context()); u != nil {
+			actor = u.Username
+		}
+		next.ServeHTTP(w, r.WithContext(store.WithActor(r.Context(), actor)))
+	})
+}
+
+func (s *HTTPServer) handleIncidentHistory(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := s.db.ListAudit(r.Context(), store.AuditFilter{IncidentID: id})
+	if err != nil {
+		log.Printf("list audit error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// The history outlives a deleted incident.
+	title := fmt.Sprintf("Incident #%d", id)
+	inc, err := s.db.GetIncident(r.Context(), id)
+	switch {
+	case err == nil:
+		title = inc.Title
+	case !errors.Is(err, store.ErrNotFound):
+		log.Printf("get incident error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	case len(entries) == 0:
+		http.NotFound(w, r)
+		return
+	}
+
+	s.render(w, r, "History", templates.IncidentHistoryPage(id, title, entries))
+}
+
+func (s *HTTPServer) handleIncidentVersion(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return
+	}
+	auditID, err := strconv.ParseInt(r.PathValue("audit"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid version", http.StatusBadRequest)
+		return
+	}
+
+	inc, err := s.db.IncidentVersion(r.Context(), id, auditID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("incident version error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	entry, err := s.db.GetAuditEntry(r.Context(), auditID)
+	if err != nil {
+		log.Printf("get audit entry error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, inc.Title, templates.IncidentVersionPage(inc, entry))
+}
@@ -0,0 +1,95 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleIncidentHistory(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := store.WithActor(context.Background(), "alice")
+
+	inc := &store.Incident{Title: "Redis timeouts", RCA: "published <b>rca</b>", StartedAt: time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	inc.RCA = "edited after the fact"
+	if err := svr.db.UpdateIncident(context.Background(), inc); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := svr.db.ListAudit(ctx, store.AuditFilter{IncidentID: inc.ID})
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("entries: %v, %v", entries, err)
+	}
+	h := loopback(svr.routes())
+
+	t.Run("history: lists changes", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/incidents/%d/history", inc.ID), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		body := w.Body.String()
+		for _, want := range []string{"alice", store.SystemActor, "edited after the fact", fmt.Sprintf("/history/%d", entries[1].ID)} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body does not contain %q", want)
+			}
+		}
+	})
+
+	t.Run("version: renders the past RCA", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, fmt.Sprintf("/incidents/%d/history/%d", inc.ID, entries[1].ID), nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "published &lt;b&gt;rca&lt;/b&gt;") || strings.Contains(body, "edited after the fact") {
+			t.Errorf("unexpected version:\n%s", body)
+		}
+	})
+
+	t.Run("web changes are attributed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/incidents/%d/persona", inc.ID), strings.NewReader("role=sre"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		got, err := svr.db.ListAudit(ctx, store.AuditFilter{IncidentID: inc.ID, Entity: store.AuditPersonaAssignment})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Actor != webActor {
+			t.Errorf("status %d, entries %+v", w.Code, got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		for path, want := range map[string]int{
+			"/incidents/999/history":                                         http.StatusNotFound,
+			"/incidents/x/history":                                           http.StatusBadRequest,
+			fmt.Sprintf("/incidents/%d/history/999", inc.ID):                 http.StatusNotFound,
+			fmt.Sprintf("/incidents/%d/history/x", inc.ID):                   http.StatusBadRequest,
+			fmt.Sprintf("/incidents/%d/history/%d", inc.ID+1, entries[0].ID): http.StatusNotFound,
+		} {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != want {
+				t.Errorf("%s: status got %d want %d", path, w.Code, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,487 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/a-h/templ"
+	"github.com/dtoebe/RootTensor/internal/blobs"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+const (
+	// maxUpload bounds an evidence upload from the incident page.
+	maxUpload = 1 << 30
+	// Uploaded text up to maxInlineUpload is stored in the evidence row,
+	// where it is searchable and read by analyses; anything larger or
+	// binary goes to the blob store.
+	maxInlineUpload = 1 << 20
+	// uploadMemory is how much of a multipart form is held in memory
+	// before it spills to temporary files.
+	uploadMemory = 32 << 20
+)
+
+func (s *HTTPServer) handleIncident(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+
+	v, err := s.incidentView(r.Context(), inc)
+	if err != nil {
+		log.Printf("incident %d view error: %v", inc.ID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, inc.Title, templates.IncidentPage(v))
+}
+
+// incidentView loads what the incident page shows of inc.
+func (s *HTTPServer) incidentView(ctx context.Context, inc *store.Incident) (templates.IncidentView, error) {
+	v := templates.IncidentView{Incident: inc, Runs: s.runs.running(runKindAnalysis, inc.ID)}
+
+	var err error
+	if v.Timeline, err = s.db.ListTimelineEvents(ctx, store.TimelineFilter{IncidentID: inc.ID}); err != nil {
+		return v, err
+	}
+	if v.Evidence, err = s.db.ListEvidence(ctx, store.EvidenceFilter{IncidentID: inc.ID}); err != nil {
+		return v, err
+	}
+	if v.Hypotheses, err = s.db.ListHypotheses(ctx, store.HypothesisFilter{IncidentID: inc.ID}); err != nil {
+		return v, err
+	}
+	if v.ActionItems, err = s.db.ListActionItems(ctx, store.ActionItemFilter{IncidentID: inc.ID}); err != nil {
+		return v, err
+	}
+	if v.Analyses, err = s.db.ListAnalyses(ctx, inc.ID); err != nil {
+		return v, err
+	}
+	if v.Chat, err = s.db.ListChatMessages(ctx, inc.ID); err != nil {
+		return v, err
+	}
+	if answers := s.runs.running(runKindChat, inc.ID); len(answers) > 0 {
+		v.Answer = &answers[0]
+	}
+	if v.Personas, err = s.db.ListPersonas(ctx); err != nil {
+		return v, err
+	}
+	p, err := s.db.AssignedPersona(ctx, store.PersonaSubjectIncident, inc.ID)
+	switch {
+	case err == nil:
+		v.Selected = []string{p.Key}
+	case !errors.Is(err, store.ErrNotFound):
+		return v, err
+	}
+
+	return v, nil
+}
+
+func (s *HTTPServer) loadIncident(w http.ResponseWriter, r *http.Request) (*store.Incident, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid incident id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	inc, err := s.db.GetIncident(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("get incident %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return inc, true
+}
+
+// handleTimelineCreate adds an event to the timeline of an incident. A
+// blank time is now; the time is read as UTC.
+func (s *HTTPServer) handleTimelineCreate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+
+	in := timelineEventInput{Kind: formValue(r, "kind"), Description: formValue(r, "description")}
+	ev := &store.TimelineEvent{IncidentID: inc.ID, OccurredAt: time.Now()}
+	var errs fieldErrors
+	if v := r.PostFormValue("occurred_at"); v != "" {
+		t, err := parseFormTime(v)
+		if err != nil {
+			errs.add("occurred_at", "%v", err)
+		}
+		in.OccurredAt = &t
+	}
+	errs = append(errs, in.apply(ev)...)
+	if len(errs) > 0 {
+		formError(w, errs)
+		return
+	}
+	if err := s.db.CreateTimelineEvent(r.Context(), ev); err != nil {
+		log.Printf("create timeline event error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.incidentSection(w, r, inc.ID, "timeline", func(ctx context.Context) (templ.Component, error) {
+		events, err := s.db.ListTimelineEvents(ctx, store.TimelineFilter{IncidentID: inc.ID})
+		return templates.ComponentTimeline(inc.ID, events), err
+	})
+}
+
+// handleEvidenceUpload adds evidence to an incident, either an uploaded
+// file or pasted text.
+func (s *HTTPServer) handleEvidenceUpload(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUpload)
+	if err := r.ParseMultipartForm(uploadMemory); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("evidence files are limited to %d MiB", maxUpload>>20), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	ev := &store.Evidence{IncidentID: inc.ID, CollectedAt: time.Now()}
+	in := evidenceInput{Kind: formValue(r, "kind"), Title: formValue(r, "title"), Content: formValue(r, "content")}
+	f, fh, err := r.FormFile("file")
+	switch {
+	case errors.Is(err, http.ErrMissingFile), errors.Is(err, http.ErrNotMultipart):
+	case err != nil:
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	default:
+		defer f.Close()
+		if *in.Content != "" {
+			http.Error(w, "upload a file or paste its content, not both", http.StatusBadRequest)
+			return
+		}
+		if *in.Title == "" {
+			*in.Title = filepath.Base(fh.Filename)
+		}
+		ev.Source = fh.Filename
+		switch err := s.storeUpload(f, fh.Size, ev); {
+		case errors.Is(err, errNoAttachments):
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		case errors.Is(err, blobs.ErrTooLarge):
+			http.Error(w, fmt.Sprintf("evidence files are limited to %d MiB", maxUpload>>20), http.StatusRequestEntityTooLarge)
+			return
+		case err != nil:
+			log.Printf("store upload error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+		in.Content = nil
+	}
+
+	errs, err := s.applyEvidence(r, &in, ev)
+	if err != nil {
+		log.Printf("apply evidence error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if len(errs) > 0 {
+		formError(w, errs)
+		return
+	}
+	if err := s.db.CreateEvidence(r.Context(), ev); err != nil {
+		log.Printf("create evidence error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.incidentSection(w, r, inc.ID, "evidence", func(ctx context.Context) (templ.Component, error) {
+		evidence, err := s.db.ListEvidence(ctx, store.EvidenceFilter{IncidentID: inc.ID})
+		return templates.ComponentEvidence(inc.ID, evidence), err
+	})
+}
+
+// errNoAttachments is returned for files that need the blob store when
+// the server has none.
+var errNoAttachments = errors.New("file attachments are not available on this server")
+
+// storeUpload puts an uploaded file of size bytes into ev: small text
+// inline, everything else in the blob store.
+func (s *HTTPServer) storeUpload(f io.Reader, size int64, ev *store.Evidence) error {
+	if size <= maxInlineUpload {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			return err
+		}
+		if utf8.Valid(b) {
+			ev.Content = string(b)
+			return nil
+		}
+		f = bytes.NewReader(b)
+	}
+
+	if s.blobs == nil {
+		return errNoAttachments
+	}
+	b, err := s.blobs.Put(f, maxUpload)
+	if err != nil {
+		return err
+	}
+	ev.BlobSHA256, ev.BlobSize = b.SHA256, b.Size
+
+	return nil
+}
+
+// handleEvidenceFile downloads the attached file of evidence.
+func (s *HTTPServer) handleEvidenceFile(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid evidence id", http.StatusBadRequest)
+		return
+	}
+	if s.blobs == nil {
+		http.NotFound(w, r)
+		return
+	}
+	ev, err := s.db.GetEvidence(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("get evidence %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	rc, err := s.db.OpenEvidenceBlob(r.Context(), s.blobs, ev.ID)
+	if errors.Is(err, store.ErrNotFound) || errors.Is(err, blobs.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("open evidence %d blob error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.FormatInt(ev.BlobSize, 10))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(ev.Title)))
+	if _, err := io.Copy(w, rc); err != nil {
+		// The headers are out; a corrupt blob can only cut the download.
+		log.Printf("evidence %d download error: %v", id, err)
+	}
+}
+
+func (s *HTTPServer) handleHypothesisCreate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+
+	in := hypothesisInput{Statement: formValue(r, "statement"), Rationale: formValue(r, "rationale")}
+	h := &store.Hypothesis{IncidentID: inc.ID, Status: store.HypothesisProposed}
+	var errs fieldErrors
+	if v := strings.TrimSpace(r.PostFormValue("confidence")); v != "" {
+		pct, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			errs.add("confidence", "must be a percentage")
+		}
+		confidence := pct / 100
+		in.Confidence = &confidence
+	}
+	errs = append(errs, in.apply(h)...)
+	if len(errs) > 0 {
+		formError(w, errs)
+		return
+	}
+	if err := s.db.CreateHypothesis(r.Context(), h); err != nil {
+		log.Printf("create hypothesis error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.hypothesesSection(w, r, inc.ID)
+}
+
+// handleHypothesisStatus moves a hypothesis to the posted status.
+func (s *HTTPServer) handleHypothesisStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid hypothesis id", http.StatusBadRequest)
+		return
+	}
+	h, err := s.db.GetHypothesis(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("get hypothesis %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := store.HypothesisStatus(r.PostFormValue("status"))
+	if errs := (&hypothesisInput{Status: &status}).apply(h); len(errs) > 0 {
+		formError(w, errs)
+		return
+	}
+	if err := s.db.UpdateHypothesis(r.Context(), h); err != nil {
+		log.Printf("update hypothesis %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.hypothesesSection(w, r, h.IncidentID)
+}
+
+func (s *HTTPServer) hypothesesSection(w http.ResponseWriter, r *http.Request, incidentID int64) {
+	s.incidentSection(w, r, incidentID, "hypotheses", func(ctx context.Context) (templ.Component, error) {
+		hypotheses, err := s.db.ListHypotheses(ctx, store.HypothesisFilter{IncidentID: incidentID})
+		return templates.ComponentHypotheses(incidentID, hypotheses), err
+	})
+}
+
+func (s *HTTPServer) handleActionItemCreate(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+
+	in := actionItemInput{Title: formValue(r, "title"), Owner: formValue(r, "owner")}
+	ai := &store.ActionItem{IncidentID: inc.ID, Status: store.ActionItemOpen}
+	var errs fieldErrors
+	if v := r.PostFormValue("due_at"); v != "" {
+		due, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			errs.add("due_at", "%q is not YYYY-MM-DD", v)
+		}
+		in.DueAt = &due
+	}
+	errs = append(errs, in.apply(ai)...)
+	if len(errs) > 0 {
+		formError(w, errs)
+		return
+	}
+	if err := s.db.CreateActionItem(r.Context(), ai); err != nil {
+		log.Printf("create action item error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.actionItemsSection(w, r, inc.ID)
+}
+
+// handleActionItemStatus moves an action item to the posted status.
+func (s *HTTPServer) handleActionItemStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.Error(w, "invalid action item id", http.StatusBadRequest)
+		return
+	}
+	ai, err := s.db.GetActionItem(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("get action item %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	status := store.ActionItemStatus(r.PostFormValue("status"))
+	if errs := (&actionItemInput{Status: &status}).apply(ai); len(errs) > 0 {
+		formError(w, errs)
+		return
+	}
+	if err := s.db.UpdateActionItem(r.Context(), ai); err != nil {
+		log.Printf("update action item %d error: %v", id, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.actionItemsSection(w, r, ai.IncidentID)
+}
+
+func (s *HTTPServer) actionItemsSection(w http.ResponseWriter, r *http.Request, incidentID int64) {
+	s.incidentSection(w, r, incidentID, "action-items", func(ctx context.Context) (templ.Component, error) {
+		items, err := s.db.ListActionItems(ctx, store.ActionItemFilter{IncidentID: incidentID})
+		return templates.ComponentActionItems(incidentID, items), err
+	})
+}
+
+// incidentSection answers a form posted from the incident page: htmx gets
+// the section the form changed, a plain form post is sent back to it.
+func (s *HTTPServer) incidentSection(w http.ResponseWriter, r *http.Request, incidentID int64, section string, load func(context.Context) (templ.Component, error)) {
+	if !isHTMX(r) {
+		http.Redirect(w, r, fmt.Sprintf("/incidents/%d#%s", incidentID, section), http.StatusSeeOther)
+		return
+	}
+
+	c, err := load(r.Context())
+	if err != nil {
+		log.Printf("incident %d %s error: %v", incidentID, section, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := c.Render(r.Context(), w); err != nil {
+		log.Printf("incident %d %s render error: %v", incidentID, section, err)
+	}
+}
+
+// isHTMX reports whether r was sent by htmx rather than as a plain form
+// post or link.
+func isHTMX(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// formValue returns a pointer to the posted value of key, for the input
+// structs the API shares with the forms.
+func formValue(r *http.Request, key string) *string {
+	v := r.PostFormValue(key)
+	return &v
+}
+
+// formError rejects a form with the problems found in it.
+func formError(w http.ResponseWriter, errs fieldErrors) {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		msgs = append(msgs, strings.ReplaceAll(e.Field, "_", " ")+" "+e.Detail)
+	}
+	http.Error(w, strings.Join(msgs, "; "), http.StatusBadRequest)
+}
+
+// parseFormTime reads the value of a datetime-local input as UTC.
+func parseFormTime(v string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02T15:04", "2006-01-02T15:04:05", time.RFC3339} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("%q is not a date and time", v)
+}
@@ -0,0 +1,258 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/blobs"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleIncident(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Redis timeouts <script>"}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	for _, draft := range []string{"Pool too small.", "Pool exhausted."} {
+		if err := svr.db.CreateAnalysis(ctx, &store.Analysis{IncidentID: inc.ID, Model: "llama3", Draft: draft}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := svr.db.CreateHypothesis(ctx, &store.Hypothesis{IncidentID: inc.ID, Statement: "Pool exhausted", Confidence: 0.8}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("handleIncident: page", func(t *testing.T) {
+		w := get(fmt.Sprintf("/incidents/%d", inc.ID))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		body := w.Body.String()
+		for _, want := range []string{
+			"Redis timeouts &lt;script&gt;",
+			`<section id="timeline">`,
+			`<section id="evidence">`,
+			"Pool exhausted</strong>",
+			"80% confidence",
+			"Current draft #2",
+			"Pool exhausted.</pre>",
+			`href="/analyses/1"`,
+			fmt.Sprintf(`hx-post="/incidents/%d/analyses"`, inc.ID),
+			`<section id="action-items">`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("missing %q", want)
+			}
+		}
+	})
+
+	t.Run("handleIncident: not found", func(t *testing.T) {
+		if w := get("/incidents/9999"); w.Code != http.StatusNotFound {
+			t.Errorf("unknown: got %d want %d", w.Code, http.StatusNotFound)
+		}
+		if w := get("/incidents/abc"); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
+
+func TestIncidentForms(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Redis timeouts"}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	page := fmt.Sprintf("/incidents/%d", inc.ID)
+
+	post := func(path string, form url.Values, htmx bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	upload := func(fields map[string]string, name string, content []byte) *httptest.ResponseRecorder {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for k, v := range fields {
+			mw.WriteField(k, v)
+		}
+		if name != "" {
+			fw, err := mw.CreateFormFile("file", name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fw.Write(content)
+		}
+		mw.Close()
+		req := httptest.NewRequest(http.MethodPost, page+"/evidence", &body)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("handleTimelineCreate: redirect and fragment", func(t *testing.T) {
+		w := post(page+"/timeline", url.Values{"kind": {"deploy"}, "description": {"payments v2.3.1"}, "occurred_at": {"2026-03-01T14:00"}}, false)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != page+"#timeline" {
+			t.Fatalf("got %d to %q: %s", w.Code, w.Header().Get("Location"), w.Body)
+		}
+
+		w = post(page+"/timeline", url.Values{"kind": {"alert"}, "description": {"redis latency"}, "occurred_at": {"2026-03-01T13:58"}}, true)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		body := w.Body.String()
+		if !strings.HasPrefix(body, `<section id="timeline">`) || strings.Contains(body, "<html") {
+			t.Errorf("not a fragment: %s", body)
+		}
+		if i, j := strings.Index(body, "redis latency"), strings.Index(body, "payments v2.3.1"); i < 0 || j < i {
+			t.Errorf("events not in order: %s", body)
+		}
+	})
+
+	t.Run("handleTimelineCreate: invalid", func(t *testing.T) {
+		w := post(page+"/timeline", url.Values{"kind": {"note"}, "occurred_at": {"yesterday"}}, false)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+		if body := w.Body.String(); !strings.Contains(body, "occurred at") || !strings.Contains(body, "description is required") {
+			t.Errorf("message: got %q", body)
+		}
+	})
+
+	t.Run("handleEvidenceUpload: pasted and small text file", func(t *testing.T) {
+		w := post(page+"/evidence", url.Values{"kind": {"log"}, "title": {"app log"}, "content": {"redis: pool exhausted"}}, false)
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("pasted: got %d: %s", w.Code, w.Body)
+		}
+		if w := upload(map[string]string{"kind": "config"}, "redis.conf", []byte("maxclients 100\n")); w.Code != http.StatusSeeOther {
+			t.Fatalf("file: got %d: %s", w.Code, w.Body)
+		}
+
+		evidence, err := svr.db.ListEvidence(ctx, store.EvidenceFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evidence) != 2 || evidence[1].Title != "redis.conf" || evidence[1].Content != "maxclients 100\n" || evidence[1].BlobSHA256 != "" {
+			t.Errorf("unexpected evidence: %+v", evidence)
+		}
+	})
+
+	t.Run("handleEvidenceUpload: binary file", func(t *testing.T) {
+		content := []byte{0xff, 0xfe, 0x00, 0x01}
+		if w := upload(map[string]string{"kind": "trace"}, "core.bin", content); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("without blob store: got %d want %d", w.Code, http.StatusServiceUnavailable)
+		}
+
+		bs, err := blobs.NewStore(t.TempDir(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svr.UseBlobs(bs)
+		if w := upload(map[string]string{"kind": "trace", "title": "core dump"}, "core.bin", content); w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d: %s", w.Code, w.Body)
+		}
+		evidence, err := svr.db.ListEvidence(ctx, store.EvidenceFilter{IncidentID: inc.ID, Kind: "trace"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evidence) != 1 || evidence[0].BlobSize != int64(len(content)) {
+			t.Fatalf("unexpected evidence: %+v", evidence)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/evidence/%d/file", evidence[0].ID), nil))
+		if got, _ := io.ReadAll(w.Body); w.Code != http.StatusOK || !bytes.Equal(got, content) {
+			t.Errorf("download: got %d %x", w.Code, got)
+		}
+		if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename="core dump"` {
+			t.Errorf("disposition: got %q", cd)
+		}
+	})
+
+	t.Run("handleEvidenceUpload: invalid", func(t *testing.T) {
+		if w := post(page+"/evidence", url.Values{"kind": {"log"}, "title": {"empty"}}, false); w.Code != http.StatusBadRequest {
+			t.Errorf("no content: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+		if w := upload(map[string]string{"kind": "log", "content": "pasted"}, "app.log", []byte("file")); w.Code != http.StatusBadRequest {
+			t.Errorf("file and content: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("handleHypothesisStatus: status control", func(t *testing.T) {
+		if w := post(page+"/hypotheses", url.Values{"statement": {"Pool exhausted"}, "confidence": {"70"}}, false); w.Code != http.StatusSeeOther {
+			t.Fatalf("create: got %d: %s", w.Code, w.Body)
+		}
+		if w := post(page+"/hypotheses", url.Values{"statement": {"Bad deploy"}, "confidence": {"150"}}, false); w.Code != http.StatusBadRequest {
+			t.Errorf("confidence over 100: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+		hs, err := svr.db.ListHypotheses(ctx, store.HypothesisFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(hs) != 1 || hs[0].Confidence != 0.7 || hs[0].Status != store.HypothesisProposed {
+			t.Fatalf("unexpected hypotheses: %+v", hs)
+		}
+
+		w := post(fmt.Sprintf("/hypotheses/%d/status", hs[0].ID), url.Values{"status": {"confirmed"}}, true)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<option value="confirmed" selected>`) {
+			t.Errorf("status: got %d: %s", w.Code, w.Body)
+		}
+		if w := post(fmt.Sprintf("/hypotheses/%d/status", hs[0].ID), url.Values{"status": {"maybe"}}, false); w.Code != http.StatusBadRequest {
+			t.Errorf("unknown status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+		if w := post("/hypotheses/9999/status", url.Values{"status": {"confirmed"}}, false); w.Code != http.StatusNotFound {
+			t.Errorf("unknown hypothesis: got %d want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("handleActionItemStatus: status control", func(t *testing.T) {
+		if w := post(page+"/action-items", url.Values{"title": {"Raise pool size"}, "owner": {"alice"}, "due_at": {"2026-04-01"}}, false); w.Code != http.StatusSeeOther {
+			t.Fatalf("create: got %d: %s", w.Code, w.Body)
+		}
+		items, err := svr.db.ListActionItems(ctx, store.ActionItemFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(items) != 1 || items[0].DueAt == nil || items[0].Status != store.ActionItemOpen {
+			t.Fatalf("unexpected action items: %+v", items)
+		}
+
+		w := post(fmt.Sprintf("/action-items/%d/status", items[0].ID), url.Values{"status": {"done"}}, false)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != page+"#action-items" {
+			t.Errorf("status: got %d to %q", w.Code, w.Header().Get("Location"))
+		}
+		ai, err := svr.db.GetActionItem(ctx, items[0].ID)
+		if err != nil || ai.Status != store.ActionItemDone {
+			t.Errorf("not done: %+v, %v", ai, err)
+		}
+		if w := post(page+"/action-items", url.Values{"title": {"Later"}, "due_at": {"soon"}}, false); w.Code != http.StatusBadRequest {
+			t.Errorf("invalid due date: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+}
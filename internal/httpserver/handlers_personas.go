@@ -0,0 +1,68 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+func (s *HTTPServer) handlePersonas(w http.ResponseWriter, r *http.Request) {
+	personas, err := s.db.ListPersonas(r.Context())
+	if err != nil {
+		log.Printf("list personas error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, "Personas", templates.PersonasPage(personas))
+}
+
+// handleAssignPersona persists the persona chosen for an incident or the
+// chat of an incident and sends the user back to the incident page.
+func (s *HTTPServer) handleAssignPersona(subject store.PersonaSubject) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+		if err != nil || id <= 0 {
+			http.Error(w, "invalid "+string(subject)+" id", http.StatusBadRequest)
+			return
+		}
+
+		err = s.db.AssignPersona(r.Context(), subject, id, r.PostFormValue("role"))
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "unknown "+string(subject)+" or role", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Printf("assign persona error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		section := "rca"
+		if subject == store.PersonaSubjectChat {
+			section = "chat"
+		}
+		http.Redirect(w, r, fmt.Sprintf("/incidents/%d#%s", id, section), http.StatusSeeOther)
+	}
+}
+
+// toLLMPersona converts a stored persona for the prompt builder.
+func toLLMPersona(p *store.Persona) *llm.Persona {
+	if p == nil {
+		return nil
+	}
+
+	return &llm.Persona{
+		Key:            p.Key,
+		Name:           p.Name,
+		SystemPrompt:   p.SystemPrompt,
+		EvidenceTypes:  p.EvidenceTypes,
+		ReportEmphasis: p.ReportEmphasis,
+	}
+}
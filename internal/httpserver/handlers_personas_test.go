@@ -0,0 +1,80 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleAssignPersona(t *testing.T) {
+	svr := setupServerWithDB(t)
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Suspicious logins"}
+	if err := svr.db.CreateIncident(context.Background(), inc); err != nil {
+		t.Fatal(err)
+	}
+	page := fmt.Sprintf("/incidents/%d", inc.ID)
+
+	post := func(path, role string) *httptest.ResponseRecorder {
+		form := url.Values{"role": {role}}
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", "https://evil.example/")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("persona: assigned to incident", func(t *testing.T) {
+		w := post(page+"/persona", "security")
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+		if loc := w.Header().Get("Location"); loc != page+"#rca" {
+			t.Errorf("redirect: got %q want %q", loc, page+"#rca")
+		}
+
+		p, err := svr.db.AssignedPersona(context.Background(), store.PersonaSubjectIncident, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Key != "security" {
+			t.Errorf("persona: got %q want %q", p.Key, "security")
+		}
+	})
+
+	t.Run("persona: assigned to chat", func(t *testing.T) {
+		w := post(fmt.Sprintf("/chats/%d/persona", inc.ID), "sre")
+		if loc := w.Header().Get("Location"); loc != page+"#chat" {
+			t.Errorf("redirect: got %q want %q", loc, page+"#chat")
+		}
+	})
+
+	t.Run("persona: unknown role", func(t *testing.T) {
+		if w := post("/chats/4/persona", "astrologer"); w.Code != http.StatusBadRequest {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("persona: unknown incident", func(t *testing.T) {
+		if w := post("/incidents/999/persona", "sre"); w.Code != http.StatusBadRequest {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("personas: listed", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/personas", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Security Analyst") {
+			t.Errorf("personas page: %d %s", w.Code, w.Body)
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package httpserver
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// routePermissions are the routes that change something, with the
+// permission each needs in the current workspace. Every role may use the
+// other routes. The store checks the same permissions again.
+var routePermissions = map[string]store.Permission{
+	"POST /settings":                           store.PermManageWorkspace,
+	"POST /settings/workspace":                 store.PermManageWorkspace,
+	"POST /settings/retention":                 store.PermManageWorkspace,
+	"POST /settings/retention/{kind}/delete":   store.PermManageWorkspace,
+	"POST /api/v1/incidents":                   store.PermEditIncidents,
+	"PATCH /api/v1/incidents/{id}":             store.PermEditIncidents,
+	"DELETE /api/v1/incidents/{id}":            store.PermDeleteIncidents,
+	"POST /api/v1/incidents/{id}/evidence":     store.PermWriteEvidence,
+	"POST /api/v1/incidents/{id}/timeline":     store.PermWriteEvidence,
+	"POST /api/v1/incidents/{id}/hypotheses":   store.PermEditIncidents,
+	"POST /api/v1/incidents/{id}/action-items": store.PermEditIncidents,
+	"PATCH /api/v1/evidence/{id}":              store.PermWriteEvidence,
+	"DELETE /api/v1/evidence/{id}":             store.PermWriteEvidence,
+	"PATCH /api/v1/timeline/{id}":              store.PermWriteEvidence,
+	"DELETE /api/v1/timeline/{id}":             store.PermWriteEvidence,
+	"PATCH /api/v1/hypotheses/{id}":            store.PermEditIncidents,
+	"PATCH /api/v1/action-items/{id}":          store.PermEditIncidents,
+	"POST /analyses/{id}/critique":             store.PermRunAnalyses,
+	"POST /analyses/{id}/publish":              store.PermPublish,
+	"GET /debates/new":                         store.PermRunAnalyses,
+	"POST /debates":                            store.PermRunAnalyses,
+	"POST /incidents/{id}/timeline":            store.PermWriteEvidence,
+	"POST /incidents/{id}/evidence":            store.PermWriteEvidence,
+	"POST /incidents/{id}/hypotheses":          store.PermEditIncidents,
+	"POST /hypotheses/{id}/status":             store.PermEditIncidents,
+	"POST /incidents/{id}/action-items":        store.PermEditIncidents,
+	"POST /action-items/{id}/status":           store.PermEditIncidents,
+	"POST /incidents/{id}/analyses":            store.PermRunAnalyses,
+	"POST /incidents/{id}/chat":                store.PermRunAnalyses,
+	"POST /chat-messages/{id}/regenerate":      store.PermRunAnalyses,
+	"POST /runs/{id}/cancel":                   store.PermRunAnalyses,
+	"GET /bundles/import":                      store.PermEditIncidents,
+	"POST /bundles/import":                     store.PermEditIncidents,
+	"POST /incidents/{id}/persona":             store.PermEditIncidents,
+	"POST /chats/{id}/persona":                 store.PermEditIncidents,
+}
+
+// withPolicy turns away requests whose route needs a permission the role
+// of the user in the current workspace lacks; routes of mux decide the
+// permission. Instance settings are left to withAuth, which keeps them to
+// admin users.
+func withPolicy(mux *http.ServeMux, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		perm, ok := routePermissions[pattern]
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx := r.Context()
+		if !store.Can(ctx, perm) {
+			role, _ := store.RoleFrom(ctx)
+			forbidden(w, r, fmt.Sprintf("a %s cannot %s", role, perm))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forbidden writes a 403 with detail: a problem for API clients, plain
+// text for browsers.
+func forbidden(w http.ResponseWriter, r *http.Request, detail string) {
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeProblem(w, r, http.StatusForbidden, detail)
+		return
+	}
+
+	http.Error(w, detail, http.StatusForbidden)
+}
@@ -0,0 +1,125 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestPolicy(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := loopback(svr.routes())
+
+	ops := &store.Workspace{Slug: "ops", Name: "Operations"}
+	if err := svr.db.CreateWorkspace(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+	for name, role := range map[string]store.Role{
+		"vic": store.RoleViewer, "rita": store.RoleResponder, "cora": store.RoleCommander, "ada": store.RoleAdmin,
+	} {
+		testUser(t, svr, name, false)
+		if err := svr.db.AddWorkspaceMember(ctx, ops.ID, name, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inc := &store.Incident{Title: "Queue backlog"}
+	if err := svr.db.CreateIncident(store.WithWorkspace(ctx, ops.ID), inc); err != nil {
+		t.Fatal(err)
+	}
+	an := &store.Analysis{IncidentID: inc.ID, Model: "test", Draft: "The consumer was stuck."}
+	if err := svr.db.CreateAnalysis(ctx, an); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hashing passwords is slow on purpose; every user signs in once.
+	cookies := map[string]*http.Cookie{}
+	for _, name := range []string{"vic", "rita", "cora", "ada"} {
+		cookies[name] = signIn(t, h, name)
+	}
+	do := func(name, method, path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path+"?workspace=ops", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(cookies[name])
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	event := url.Values{"kind": {"note"}, "description": {"Paged the on-call"}}
+	incidentPath := fmt.Sprintf("/incidents/%d", inc.ID)
+	publishPath := fmt.Sprintf("/analyses/%d/publish", an.ID)
+
+	t.Run("policy: viewer", func(t *testing.T) {
+		w := do("vic", http.MethodGet, incidentPath, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("incident page: got %d", w.Code)
+		}
+		for _, control := range []string{"Add event", "Add evidence", "Analyze", "Publish as RCA"} {
+			if strings.Contains(w.Body.String(), control) {
+				t.Errorf("incident page offers %q", control)
+			}
+		}
+		if w := do("vic", http.MethodPost, incidentPath+"/timeline", event); w.Code != http.StatusForbidden {
+			t.Errorf("add event: got %d want %d", w.Code, http.StatusForbidden)
+		}
+		w = do("vic", http.MethodDelete, fmt.Sprintf("/api/v1/incidents/%d", inc.ID), nil)
+		if w.Code != http.StatusForbidden || w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("api delete: got %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("policy: responder", func(t *testing.T) {
+		w := do("rita", http.MethodGet, incidentPath, nil)
+		if !strings.Contains(w.Body.String(), "Add event") || strings.Contains(w.Body.String(), "Publish as RCA") {
+			t.Errorf("incident page: got %d", w.Code)
+		}
+		if w := do("rita", http.MethodPost, incidentPath+"/timeline", event); w.Code >= http.StatusBadRequest {
+			t.Errorf("add event: got %d", w.Code)
+		}
+		if w := do("rita", http.MethodPost, publishPath, nil); w.Code != http.StatusForbidden {
+			t.Errorf("publish: got %d want %d", w.Code, http.StatusForbidden)
+		}
+
+		body := strings.NewReader(`{"rca":"The consumer was stuck."}`)
+		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/api/v1/incidents/%d?workspace=ops", inc.ID), body)
+		req.Header.Set("Content-Type", "application/json")
+		req.AddCookie(cookies["rita"])
+		w = httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("api publish: got %d want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("policy: commander", func(t *testing.T) {
+		if w := do("cora", http.MethodPost, publishPath, nil); w.Code != http.StatusSeeOther {
+			t.Fatalf("publish: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+		got, _ := svr.db.GetIncident(ctx, inc.ID)
+		if got.RCA != an.Draft {
+			t.Errorf("rca: got %q want %q", got.RCA, an.Draft)
+		}
+		if w := do("cora", http.MethodPost, "/settings/retention", url.Values{"kind": {"log"}, "days": {"30"}}); w.Code != http.StatusForbidden {
+			t.Errorf("retention: got %d want %d", w.Code, http.StatusForbidden)
+		}
+	})
+
+	t.Run("policy: workspace admin", func(t *testing.T) {
+		w := do("ada", http.MethodGet, "/settings", nil)
+		if w.Code != http.StatusOK || strings.Count(w.Body.String(), "<fieldset disabled") != 1 {
+			t.Errorf("settings page: got %d with %d disabled forms", w.Code, strings.Count(w.Body.String(), "<fieldset disabled"))
+		}
+		if w := do("ada", http.MethodPost, "/settings/retention", url.Values{"kind": {"log"}, "days": {"30"}}); w.Code != http.StatusSeeOther {
+			t.Errorf("retention: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+		if w := do("ada", http.MethodPost, "/settings", url.Values{"chat_model": {"other"}}); w.Code != http.StatusForbidden {
+			t.Errorf("instance settings: got %d want %d", w.Code, http.StatusForbidden)
+		}
+	})
+}
@@ -0,0 +1,482 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+const (
+	// analysisTimeout bounds a background analysis; a local model can take
+	// minutes for the draft alone.
+	analysisTimeout = 30 * time.Minute
+	// finishedRunTTL is how long the events of a finished run stay
+	// available to clients reconnecting to its stream.
+	finishedRunTTL = 15 * time.Minute
+	// sseKeepAlive is how often an idle stream sends a comment so proxies
+	// do not close it.
+	sseKeepAlive = 15 * time.Second
+)
+
+// Stream event names. Event data is an HTML fragment the incident page
+// swaps in as it is.
+const (
+	eventStage    = "stage"
+	eventStep     = "step"
+	eventThinking = "thinking"
+	eventToken    = "token"
+	// eventDone ends every run, whether it succeeded, failed or was
+	// cancelled.
+	eventDone = "done"
+)
+
+var stageLabels = map[llm.Stage]string{
+	llm.StageEvidence: "Reading the evidence",
+	llm.StageDraft:    "Drafting the RCA",
+	llm.StageCritique: "Checking the claims against the evidence",
+}
+
+// runKind tells what a run produces: an RCA draft or a chat answer.
+type runKind string
+
+const (
+	runKindAnalysis runKind = "analysis"
+	runKindChat     runKind = "chat"
+)
+
+type runEvent struct {
+	id   int
+	name string
+	data string
+}
+
+// analysisRun is an analysis or chat answer running in the background. It
+// keeps every event it published so that clients can join late or
+// reconnect.
+type analysisRun struct {
+	id         string
+	kind       runKind
+	incidentID int64
+	startedAt  time.Time
+	cancel     context.CancelFunc
+
+	mu       sync.Mutex
+	events   []runEvent
+	finished time.Time
+	// wake is closed and replaced whenever an event is published.
+	wake chan struct{}
+}
+
+func (run *analysisRun) publish(name, data string) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	if !run.finished.IsZero() {
+		return
+	}
+	run.events = append(run.events, runEvent{id: len(run.events) + 1, name: name, data: data})
+	if name == eventDone {
+		run.finished = time.Now()
+	}
+	close(run.wake)
+	run.wake = make(chan struct{})
+}
+
+// after returns the events after the one with id last, whether the run has
+// finished, and a channel that is closed on the next event.
+func (run *analysisRun) after(last int) ([]runEvent, bool, <-chan struct{}) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	var out []runEvent
+	if last >= 0 && last < len(run.events) {
+		out = append(out, run.events[last:]...)
+	}
+
+	return out, !run.finished.IsZero(), run.wake
+}
+
+func (run *analysisRun) running() bool {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	return run.finished.IsZero()
+}
+
+// analysisRuns are the runs of this process, running or recently finished.
+// They do not survive a restart; the drafts they produce do.
+type analysisRuns struct {
+	mu   sync.Mutex
+	runs map[string]*analysisRun
+}
+
+func (rs *analysisRuns) start(kind runKind, incidentID int64, cancel context.CancelFunc) *analysisRun {
+	run := newAnalysisRun(kind, incidentID, cancel)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.add(run)
+
+	return run
+}
+
+// startIfIdle starts a run of kind for an incident unless one is running,
+// and returns nil then. Checking and starting under one lock keeps two
+// requests from both starting one.
+func (rs *analysisRuns) startIfIdle(kind runKind, incidentID int64, cancel context.CancelFunc) *analysisRun {
+	run := newAnalysisRun(kind, incidentID, cancel)
+
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, r := range rs.runs {
+		if r.kind == kind && r.incidentID == incidentID && r.running() {
+			return nil
+		}
+	}
+	rs.add(run)
+
+	return run
+}
+
+// drop forgets a run that never got going and cancels it.
+func (rs *analysisRuns) drop(run *analysisRun) {
+	rs.mu.Lock()
+	delete(rs.runs, run.id)
+	rs.mu.Unlock()
+
+	run.cancel()
+}
+
+func newAnalysisRun(kind runKind, incidentID int64, cancel context.CancelFunc) *analysisRun {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("run id error: %v", err))
+	}
+
+	return &analysisRun{
+		id:         hex.EncodeToString(b),
+		kind:       kind,
+		incidentID: incidentID,
+		startedAt:  time.Now(),
+		cancel:     cancel,
+		wake:       make(chan struct{}),
+	}
+}
+
+// add adds run and forgets the runs finished more than finishedRunTTL
+// ago. rs.mu must be held.
+func (rs *analysisRuns) add(run *analysisRun) {
+	if rs.runs == nil {
+		rs.runs = map[string]*analysisRun{}
+	}
+	for id, r := range rs.runs {
+		r.mu.Lock()
+		expired := !r.finished.IsZero() && time.Since(r.finished) > finishedRunTTL
+		r.mu.Unlock()
+		if expired {
+			delete(rs.runs, id)
+		}
+	}
+	rs.runs[run.id] = run
+}
+
+// cancelAll cancels every run; the ones still going end with a done
+// event.
+func (rs *analysisRuns) cancelAll() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for _, r := range rs.runs {
+		r.cancel()
+	}
+}
+
+func (rs *analysisRuns) get(id string) *analysisRun {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.runs[id]
+}
+
+// running returns the unfinished runs of kind of an incident, oldest
+// first.
+func (rs *analysisRuns) running(kind runKind, incidentID int64) []templates.AnalysisRun {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	var out []templates.AnalysisRun
+	for _, r := range rs.runs {
+		if r.kind == kind && r.incidentID == incidentID && r.running() {
+			out = append(out, toTemplateRun(r))
+		}
+	}
+	slices.SortFunc(out, func(a, b templates.AnalysisRun) int {
+		return a.StartedAt.Compare(b.StartedAt)
+	})
+
+	return out
+}
+
+func toTemplateRun(r *analysisRun) templates.AnalysisRun {
+	return templates.AnalysisRun{ID: r.id, IncidentID: r.incidentID, StartedAt: r.startedAt}
+}
+
+// handleAnalysisStart starts an analysis of the incident's evidence in the
+// background. htmx requests get the progress panel of the run, everything
+// else goes back to the incident page, which shows it too.
+func (s *HTTPServer) handleAnalysisStart(w http.ResponseWriter, r *http.Request) {
+	inc, ok := s.loadIncident(w, r)
+	if !ok {
+		return
+	}
+	if s.llm == nil {
+		http.Error(w, "no model backend configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	var persona *store.Persona
+	var err error
+	if key := r.PostFormValue("role"); key != "" {
+		persona, err = s.db.GetPersona(r.Context(), key)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "unknown role", http.StatusBadRequest)
+			return
+		}
+	} else {
+		persona, err = s.db.AssignedPersona(r.Context(), store.PersonaSubjectIncident, inc.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			persona, err = nil, nil
+		}
+	}
+	if err != nil {
+		log.Printf("analysis persona error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	evidence, err := s.db.ListEvidence(r.Context(), store.EvidenceFilter{IncidentID: inc.ID})
+	if err != nil {
+		log.Printf("analysis evidence error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	a := llm.Analysis{
+		Persona:  toLLMPersona(persona),
+		Critique: r.PostFormValue("critique") != "",
+	}
+	for _, ev := range evidence {
+		content := ev.Content
+		if content == "" {
+			// Attachments are too large for a prompt.
+			content = ev.Title + " (attached file)"
+		}
+		a.Evidence = append(a.Evidence, llm.Evidence{Kind: ev.Kind, Content: content})
+	}
+
+	// Like a debate the run outlives the request, and its writes are
+	// attributed to whoever started it and stay in its workspace.
+	ctx, cancel := s.background(r.Context(), analysisTimeout)
+	run := s.runs.start(runKindAnalysis, inc.ID, cancel)
+	task := r.PostFormValue("task")
+	s.tasks.Go(func() { s.runAnalysis(ctx, s.chatter(ctx), run, a, task, s.chatModel(ctx)) })
+
+	if isHTMX(r) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := templates.ComponentAnalysisRun(toTemplateRun(run)).Render(r.Context(), w); err != nil {
+			log.Printf("analysis run render error: %v", err)
+		}
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/incidents/%d", inc.ID), http.StatusSeeOther)
+}
+
+func (s *HTTPServer) runAnalysis(ctx context.Context, chat llm.Chatter, run *analysisRun, a llm.Analysis, task, model string) {
+	defer run.cancel()
+
+	a.OnEvent = func(e llm.AnalysisEvent) {
+		switch {
+		case e.Stage != "":
+			run.publish(eventStage, fmt.Sprintf(`<li class="run-stage">%s</li>`, html.EscapeString(stageLabels[e.Stage])))
+		case e.Step != "":
+			run.publish(eventStep, fmt.Sprintf(`<li class="run-step">%s</li>`, html.EscapeString(e.Step)))
+		default:
+			if e.Delta.Thinking != "" {
+				run.publish(eventThinking, html.EscapeString(e.Delta.Thinking))
+			}
+			if e.Delta.Content != "" {
+				run.publish(eventToken, html.EscapeString(e.Delta.Content))
+			}
+		}
+	}
+
+	res, err := llm.RunAnalysis(ctx, chat, a, task)
+	if err != nil {
+		log.Printf("analysis of incident %d error: %v", run.incidentID, err)
+		msg := "The analysis failed: " + err.Error()
+		if errors.Is(ctx.Err(), context.Canceled) {
+			msg = "The analysis was cancelled."
+		}
+		run.publish(eventDone, fmt.Sprintf(`<p class="run-failed" role="alert">%s</p>`, html.EscapeString(msg)))
+		return
+	}
+
+	// The run context may have timed out; recording the draft must not.
+	ctx = context.WithoutCancel(ctx)
+	an := &store.Analysis{IncidentID: run.incidentID, Model: model, Draft: res.Draft, Evidence: res.Evidence}
+	err = s.db.CreateAnalysis(ctx, an)
+	if err == nil && res.Critique != nil {
+		var b []byte
+		if b, err = json.Marshal(res.Critique); err == nil {
+			err = s.db.SetAnalysisCritique(ctx, an.ID, string(b))
+		}
+	}
+	if err != nil {
+		log.Printf("store analysis of incident %d error: %v", run.incidentID, err)
+		run.publish(eventDone, `<p class="run-failed" role="alert">The draft could not be saved.</p>`)
+		return
+	}
+
+	run.publish(eventDone, fmt.Sprintf(`<p class="run-done"><a href="/analyses/%d">RCA Draft #%d</a> is ready.</p>`, an.ID, an.ID))
+}
+
+// handleRunEvents streams the events of a run as Server-Sent Events. A
+// client that reconnects with Last-Event-ID gets only what it missed;
+// one that comes after the end gets 204, which stops EventSource from
+// reconnecting. Leaving only ends the stream, not the run; so does the
+// server shutting down.
+func (s *HTTPServer) handleRunEvents(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.loadRun(w, r)
+	if !ok {
+		return
+	}
+
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("last_event_id")
+	}
+	lastID, err := strconv.Atoi(last)
+	if err != nil || lastID < 0 {
+		lastID = 0
+	}
+
+	events, done, wake := run.after(lastID)
+	if done && len(events) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		for _, e := range events {
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			lastID = e.id
+		}
+		if err := rc.Flush(); err != nil || done {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.life.Done():
+			return
+		case <-wake:
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		events, done, wake = run.after(lastID)
+	}
+}
+
+// writeEvent writes e in the text/event-stream format; every line of the
+// data becomes a data field of its own.
+func writeEvent(w io.Writer, e runEvent) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id: %d\nevent: %s\n", e.id, e.name)
+	for _, line := range strings.Split(e.data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// handleRunCancel stops a run; the stream then ends with a done event.
+func (s *HTTPServer) handleRunCancel(w http.ResponseWriter, r *http.Request) {
+	run, ok := s.loadRun(w, r)
+	if !ok {
+		return
+	}
+	run.cancel()
+
+	if isHTMX(r) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, fmt.Sprintf("/incidents/%d", run.incidentID), http.StatusSeeOther)
+}
+
+// loadRun finds the run of the id path value. Runs of incidents outside
+// the current workspace do not exist.
+func (s *HTTPServer) loadRun(w http.ResponseWriter, r *http.Request) (*analysisRun, bool) {
+	run := s.runs.get(r.PathValue("id"))
+	if run == nil {
+		http.NotFound(w, r)
+		return nil, false
+	}
+
+	_, err := s.db.GetIncident(r.Context(), run.incidentID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("get incident %d error: %v", run.incidentID, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return run, true
+}
+
+// chatModel is the model the chatter of ctx asks, for the record.
+func (s *HTTPServer) chatModel(ctx context.Context) string {
+	if ws := currentWorkspace(ctx); ws != nil && ws.ChatModel != "" {
+		return ws.ChatModel
+	}
+	if m, ok := s.llm.(interface{ Model() string }); ok {
+		return m.Model()
+	}
+
+	return ""
+}
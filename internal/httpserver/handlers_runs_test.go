@@ -0,0 +1,366 @@
+package httpserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+// gateChatter streams "Pool " and waits for release, or for the run to be
+// cancelled, before it streams the rest of the draft.
+type gateChatter struct {
+	release chan struct{}
+}
+
+func (g *gateChatter) Chat(ctx context.Context, msgs []llm.Message, opts *llm.CallOptions) (string, error) {
+	return `{"claims":[]}`, nil
+}
+
+func (g *gateChatter) ChatStream(ctx context.Context, msgs []llm.Message, opts *llm.CallOptions, onDelta func(llm.Delta)) (string, error) {
+	onDelta(llm.Delta{Content: "Pool "})
+	select {
+	case <-g.release:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	onDelta(llm.Delta{Content: "exhausted <b>[1]</b>"})
+
+	return "Pool exhausted <b>[1]</b>", nil
+}
+
+// analysisChatter drafts with draft and answers the critic with an empty
+// critique.
+type analysisChatter struct {
+	draft string
+}
+
+func (a *analysisChatter) Chat(ctx context.Context, msgs []llm.Message, opts *llm.CallOptions) (string, error) {
+	if opts != nil && opts.Format == "json" {
+		return `{"claims":[]}`, nil
+	}
+	return a.draft, nil
+}
+
+// latestRun returns the run of kind last started for the incident, running
+// or not; a fast fake model may already be done when the test looks.
+func latestRun(t *testing.T, svr *HTTPServer, kind runKind, incidentID int64) *analysisRun {
+	t.Helper()
+
+	svr.runs.mu.Lock()
+	defer svr.runs.mu.Unlock()
+	var latest *analysisRun
+	for _, r := range svr.runs.runs {
+		if r.kind == kind && r.incidentID == incidentID && (latest == nil || r.startedAt.After(latest.startedAt)) {
+			latest = r
+		}
+	}
+	if latest == nil {
+		t.Fatalf("no %v run of incident %d", kind, incidentID)
+	}
+
+	return latest
+}
+
+func TestAnalysisRuns(t *testing.T) {
+	svr := setupServerWithDB(t)
+	svr.llm = &analysisChatter{draft: "<think>pool?</think>Pool exhausted [1]."}
+	ctx := context.Background()
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Redis timeouts"}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	ev := &store.Evidence{IncidentID: inc.ID, Title: "app log", Content: "redis: connection pool exhausted"}
+	if err := svr.db.CreateEvidence(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	start := fmt.Sprintf("/incidents/%d/analyses", inc.ID)
+
+	post := func(path string, form url.Values, htmx bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if htmx {
+			req.Header.Set("HX-Request", "true")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	events := func(id, lastEventID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/runs/"+id+"/events", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	// startRun starts a run over htmx and returns its id.
+	startRun := func(t *testing.T, form url.Values) string {
+		t.Helper()
+		w := post(start, form, true)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		_, rest, ok := strings.Cut(w.Body.String(), `sse-connect="/runs/`)
+		if !ok {
+			t.Fatalf("no stream in panel: %s", w.Body)
+		}
+		id, _, _ := strings.Cut(rest, "/")
+		return id
+	}
+	wait := func(t *testing.T, id string) {
+		t.Helper()
+		run := svr.runs.get(id)
+		deadline := time.Now().Add(5 * time.Second)
+		for run.running() {
+			if time.Now().After(deadline) {
+				t.Fatal("run did not finish")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("handleAnalysisStart: redirect without htmx", func(t *testing.T) {
+		w := post(start, nil, false)
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != fmt.Sprintf("/incidents/%d", inc.ID) {
+			t.Fatalf("got %d to %q", w.Code, w.Header().Get("Location"))
+		}
+		for _, run := range svr.runs.running(runKindAnalysis, inc.ID) {
+			wait(t, run.ID)
+		}
+	})
+
+	t.Run("handleRunEvents: replay after the end", func(t *testing.T) {
+		id := startRun(t, url.Values{"critique": {"on"}})
+		wait(t, id)
+
+		w := events(id, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("content type: got %q", ct)
+		}
+		body := w.Body.String()
+		for _, want := range []string{
+			"id: 1\nevent: stage\ndata: <li class=\"run-stage\">Reading the evidence</li>\n\n",
+			"event: step\ndata: <li class=\"run-step\">1 evidence item</li>",
+			"event: thinking\ndata: pool?",
+			"event: token\ndata: Pool exhausted [1].",
+			"event: stage\ndata: <li class=\"run-stage\">Checking the claims against the evidence</li>",
+			"event: done\ndata: <p class=\"run-done\"><a href=\"/analyses/",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("missing %q in:\n%s", want, body)
+			}
+		}
+
+		analyses, err := svr.db.ListAnalyses(ctx, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(analyses) == 0 || analyses[0].Draft != "Pool exhausted [1]." || analyses[0].Critique == "" {
+			t.Errorf("unexpected analyses: %+v", analyses)
+		}
+		if len(analyses[0].Evidence) != 1 || analyses[0].Evidence[0] != "(log) redis: connection pool exhausted" {
+			t.Errorf("evidence: got %q", analyses[0].Evidence)
+		}
+
+		last := strings.Count(body, "\nevent: ")
+		w = events(id, fmt.Sprint(last-1))
+		if got := w.Body.String(); !strings.HasPrefix(got, fmt.Sprintf("id: %d\nevent: done\n", last)) {
+			t.Errorf("Last-Event-ID: got %q", got)
+		}
+		if w := events(id, fmt.Sprint(last)); w.Code != http.StatusNoContent {
+			t.Errorf("after the end: got %d want %d", w.Code, http.StatusNoContent)
+		}
+	})
+
+	t.Run("handleRunEvents: live stream", func(t *testing.T) {
+		gate := &gateChatter{release: make(chan struct{})}
+		svr.llm = gate
+		defer func() { svr.llm = &analysisChatter{draft: "Pool exhausted [1]."} }()
+		id := startRun(t, nil)
+
+		ts := httptest.NewServer(h)
+		defer ts.Close()
+		resp, err := http.Get(ts.URL + "/runs/" + id + "/events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		sc := bufio.NewScanner(resp.Body)
+		readUntil := func(line string) {
+			t.Helper()
+			for sc.Scan() {
+				if sc.Text() == line {
+					return
+				}
+			}
+			t.Fatalf("stream ended before %q: %v", line, sc.Err())
+		}
+		readUntil("data: Pool ")
+		close(gate.release)
+		readUntil("data: exhausted &lt;b&gt;[1]&lt;/b&gt;")
+		readUntil("event: done")
+	})
+
+	t.Run("handleRunCancel: cancelled", func(t *testing.T) {
+		svr.llm = &gateChatter{release: make(chan struct{})}
+		defer func() { svr.llm = &analysisChatter{draft: "Pool exhausted [1]."} }()
+		id := startRun(t, nil)
+
+		if w := post("/runs/"+id+"/cancel", nil, true); w.Code != http.StatusNoContent {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusNoContent)
+		}
+		wait(t, id)
+		if body := events(id, "").Body.String(); !strings.Contains(body, "The analysis was cancelled.") {
+			t.Errorf("no cancellation in:\n%s", body)
+		}
+	})
+
+	t.Run("handleRunEvents: unknown or other workspace", func(t *testing.T) {
+		if w := events("nope", ""); w.Code != http.StatusNotFound {
+			t.Errorf("unknown: got %d want %d", w.Code, http.StatusNotFound)
+		}
+
+		ops := &store.Workspace{Slug: "ops", Name: "Operations"}
+		if err := svr.db.CreateWorkspace(ctx, ops); err != nil {
+			t.Fatal(err)
+		}
+		other := &store.Incident{Title: "Payroll batch stuck"}
+		if err := svr.db.CreateIncident(store.WithWorkspace(ctx, ops.ID), other); err != nil {
+			t.Fatal(err)
+		}
+		w := post(fmt.Sprintf("/incidents/%d/analyses?workspace=ops", other.ID), nil, true)
+		if w.Code != http.StatusOK {
+			t.Fatalf("start: got %d", w.Code)
+		}
+		run := latestRun(t, svr, runKindAnalysis, other.ID)
+		if w := events(run.id, ""); w.Code != http.StatusNotFound {
+			t.Errorf("other workspace: got %d want %d", w.Code, http.StatusNotFound)
+		}
+		wait(t, run.id)
+	})
+
+	t.Run("handleAnalysisStart: errors", func(t *testing.T) {
+		if w := post("/incidents/9999/analyses", nil, false); w.Code != http.StatusNotFound {
+			t.Errorf("unknown incident: got %d want %d", w.Code, http.StatusNotFound)
+		}
+		if w := post(start, url.Values{"role": {"nope"}}, false); w.Code != http.StatusBadRequest {
+			t.Errorf("unknown role: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+		svr.llm = nil
+		if w := post(start, nil, false); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("no backend: got %d want %d", w.Code, http.StatusServiceUnavailable)
+		}
+	})
+}
+
+func TestAnalysisRunsShutdown(t *testing.T) {
+	svr := setupServerWithDB(t)
+	gate := &gateChatter{release: make(chan struct{})}
+	svr.llm = gate
+	h := loopback(svr.routes())
+
+	inc := &store.Incident{Title: "Redis timeouts"}
+	if err := svr.db.CreateIncident(context.Background(), inc); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/incidents/%d/analyses", inc.ID), nil)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	run := latestRun(t, svr, runKindAnalysis, inc.ID)
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/runs/" + run.id + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() && sc.Text() != "data: Pool " {
+	}
+
+	t.Run("stop: ends streams and cancels runs", func(t *testing.T) {
+		ended := make(chan struct{})
+		go func() {
+			for sc.Scan() {
+			}
+			close(ended)
+		}()
+		svr.stop()
+
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Fatal("stream did not end")
+		}
+		deadline := time.Now().Add(5 * time.Second)
+		for run.running() {
+			if time.Now().After(deadline) {
+				t.Fatal("run was not cancelled")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		events, _, _ := run.after(0)
+		if last := events[len(events)-1]; !strings.Contains(last.data, "The analysis was cancelled.") {
+			t.Errorf("last event: got %+v", last)
+		}
+	})
+}
+
+func TestAnalysisRunsStartIfIdle(t *testing.T) {
+	t.Run("startIfIdle: one run at a time", func(t *testing.T) {
+		var rs analysisRuns
+		var wg sync.WaitGroup
+		started := make(chan *analysisRun, 10)
+		for range 10 {
+			wg.Go(func() {
+				if run := rs.startIfIdle(runKindChat, 1, func() {}); run != nil {
+					started <- run
+				}
+			})
+		}
+		wg.Wait()
+		close(started)
+		if len(started) != 1 {
+			t.Fatalf("started: got %d runs want 1", len(started))
+		}
+		run := <-started
+
+		if rs.startIfIdle(runKindChat, 2, func() {}) == nil {
+			t.Error("other incident: not started")
+		}
+		if rs.startIfIdle(runKindAnalysis, 1, func() {}) == nil {
+			t.Error("other kind: not started")
+		}
+		run.publish(eventDone, "")
+		if rs.startIfIdle(runKindChat, 1, func() {}) == nil {
+			t.Error("after the run ended: not started")
+		}
+	})
+}
+
+func TestWriteEvent(t *testing.T) {
+	var b strings.Builder
+	if err := writeEvent(&b, runEvent{id: 3, name: eventToken, data: "a\nb"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := "id: 3\nevent: token\ndata: a\ndata: b\n\n"; b.String() != want {
+		t.Errorf("got %q want %q", b.String(), want)
+	}
+}
@@ -0,0 +1,151 @@
+package httpserver
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+type searchSnippetJSON struct {
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"`
+}
+
+type searchHitJSON struct {
+	Kind          store.SearchKind    `json:"kind"`
+	ID            int64               `json:"id"`
+	IncidentID    int64               `json:"incident_id"`
+	IncidentTitle string              `json:"incident_title"`
+	StartedAt     time.Time           `json:"started_at"`
+	Snippet       []searchSnippetJSON `json:"snippet"`
+	Score         float64             `json:"score"`
+}
+
+type searchResponseJSON struct {
+	Query   string          `json:"query"`
+	Results []searchHitJSON `json:"results"`
+}
+
+func (s *HTTPServer) handleSearch(w http.ResponseWriter, r *http.Request) {
+	f, err := parseSearchFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var results []store.SearchResult
+	if f.Query != "" {
+		results, err = s.db.Search(r.Context(), f)
+		if err != nil {
+			log.Printf("search error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	s.render(w, r, "Search", templates.SearchPage(f.Query, results))
+}
+
+func (s *HTTPServer) handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	f, err := parseSearchFilter(r.URL.Query())
+	if err != nil {
+		writeProblem(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if f.Query == "" {
+		writeProblem(w, r, http.StatusBadRequest, "missing q", fieldError{Field: "q", Detail: "is required"})
+		return
+	}
+
+	results, err := s.db.Search(r.Context(), f)
+	if err != nil {
+		writeStoreError(w, r, "search", err)
+		return
+	}
+
+	res := searchResponseJSON{Query: f.Query, Results: []searchHitJSON{}}
+	for _, hit := range results {
+		h := searchHitJSON{
+			Kind:          hit.Kind,
+			ID:            hit.RefID,
+			IncidentID:    hit.IncidentID,
+			IncidentTitle: hit.IncidentTitle,
+			StartedAt:     hit.StartedAt,
+			Snippet:       []searchSnippetJSON{},
+			Score:         hit.Score,
+		}
+		for _, p := range hit.Snippet {
+			h.Snippet = append(h.Snippet, searchSnippetJSON{Text: p.Text, Match: p.Match})
+		}
+		res.Results = append(res.Results, h)
+	}
+
+	writeJSON(w, http.StatusOK, res)
+}
+
+// parseSearchFilter reads q, kind (repeatable), incident_id, since, until
+// and limit. Dates are either RFC 3339 or YYYY-MM-DD; a bare until date
+// includes the whole day.
+func parseSearchFilter(v url.Values) (store.SearchFilter, error) {
+	f := store.SearchFilter{Query: v.Get("q")}
+
+	for _, k := range v["kind"] {
+		switch kind := store.SearchKind(k); kind {
+		case store.SearchIncident, store.SearchAnalysis, store.SearchEvidence, store.SearchTimeline:
+			f.Kinds = append(f.Kinds, kind)
+		default:
+			return f, fmt.Errorf("invalid kind %q", k)
+		}
+	}
+
+	if s := v.Get("incident_id"); s != "" {
+		id, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || id <= 0 {
+			return f, fmt.Errorf("invalid incident_id %q", s)
+		}
+		f.IncidentID = id
+	}
+
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > store.MaxSearchLimit {
+			return f, fmt.Errorf("invalid limit %q: must be between 1 and %d", s, store.MaxSearchLimit)
+		}
+		f.Limit = n
+	}
+
+	var err error
+	if f.Since, err = parseSearchTime(v.Get("since"), false); err != nil {
+		return f, fmt.Errorf("invalid since: %v", err)
+	}
+	if f.Until, err = parseSearchTime(v.Get("until"), true); err != nil {
+		return f, fmt.Errorf("invalid until: %v", err)
+	}
+
+	return f, nil
+}
+
+func parseSearchTime(s string, endOfDay bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not RFC 3339 or YYYY-MM-DD", s)
+	}
+	if endOfDay {
+		t = t.Add(24*time.Hour - time.Nanosecond)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,116 @@
+package httpserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleSearch(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+
+	started := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+	inc := &store.Incident{Title: "Redis timeouts on checkout", StartedAt: started}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	ev := &store.Evidence{IncidentID: inc.ID, Title: "app log", Content: "dial redis:6379: i/o timeout <script>", CollectedAt: started}
+	if err := svr.db.CreateEvidence(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	h := loopback(svr.routes())
+
+	t.Run("api: results", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/search?q=redis&since=2025-04-01&until=2025-04-10", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("content type: got %q", ct)
+		}
+
+		var res searchResponseJSON
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Results) != 2 {
+			t.Fatalf("results: got %d want 2: %+v", len(res.Results), res)
+		}
+		if res.Results[0].Kind != store.SearchIncident || res.Results[0].IncidentID != inc.ID {
+			t.Errorf("unexpected first hit: %+v", res.Results[0])
+		}
+	})
+
+	t.Run("api: kind filter", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/search?q=redis&kind=evidence", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		var res searchResponseJSON
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		if len(res.Results) != 1 || res.Results[0].ID != ev.ID {
+			t.Errorf("unexpected hits: %+v", res.Results)
+		}
+	})
+
+	t.Run("api: bad requests", func(t *testing.T) {
+		for _, q := range []string{
+			"",
+			"?q=redis&kind=planet",
+			"?q=redis&limit=0",
+			"?q=redis&limit=1000",
+			"?q=redis&since=last-spring",
+			"?q=redis&incident_id=x",
+		} {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/search"+q, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("%q: status got %d want %d", q, w.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("page: highlighted and escaped", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/search?q=timeout", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "<mark>timeout</mark>") {
+			t.Errorf("match not highlighted:\n%s", body)
+		}
+		if strings.Contains(body, "<script>") {
+			t.Errorf("snippet not escaped:\n%s", body)
+		}
+	})
+
+	t.Run("page: empty query", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/search", nil)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		if !strings.Contains(w.Body.String(), `name="q"`) {
+			t.Error("search box missing")
+		}
+	})
+}
@@ -0,0 +1,190 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+// logRetentionKind is the evidence kind whose instance-wide retention is on
+// the Settings form; logs are the bulk of the evidence. Other kinds are set
+// per workspace or on the command line.
+const logRetentionKind = "log"
+
+func (s *HTTPServer) handleSettings(w http.ResponseWriter, r *http.Request) {
+	settings, err := s.db.GetSettings(r.Context())
+	if err != nil {
+		log.Printf("get settings error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	logDays, err := s.instanceRetention(r.Context(), logRetentionKind)
+	if err != nil {
+		log.Printf("list retention policies error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	policies, err := s.db.ListRetentionPolicies(r.Context())
+	if err != nil {
+		log.Printf("list retention policies error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	// A workspace picks from every persona, not only the ones it offers.
+	personas, err := s.db.ListPersonas(allWorkspaces(r.Context()))
+	if err != nil {
+		log.Printf("list personas error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	u := currentUser(r.Context())
+	instance := store.Can(r.Context(), store.PermManageWorkspace) && u != nil && u.Admin
+	s.render(w, r, "Settings", templates.SettingsPage(settings, logDays, currentWorkspace(r.Context()), personas, policies, instance))
+}
+
+// handleSettingsSave validates and stores the instance settings and
+// applies them to the running server.
+func (s *HTTPServer) handleSettingsSave(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	settings, err := s.db.GetSettings(r.Context())
+	if err != nil {
+		log.Printf("get settings error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	for _, key := range store.SettingKeys {
+		if _, ok := r.PostForm[key]; !ok {
+			continue
+		}
+		if err := settings.Set(key, r.PostFormValue(key)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := settings.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// Blank keeps log evidence forever.
+	logDays := 0
+	if v := strings.TrimSpace(r.PostFormValue("log_retention")); v != "" {
+		logDays, err = strconv.Atoi(v)
+		if err != nil || logDays <= 0 {
+			http.Error(w, "log retention must be a positive number of days", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// The settings and the log retention are saved together, so that the
+	// running server is only reconfigured once both are.
+	ctx := allWorkspaces(r.Context())
+	err = s.db.WithTx(ctx, func(tx *store.SQliteDB) error {
+		if err := tx.SaveSettings(ctx, settings); err != nil {
+			return err
+		}
+		if logDays > 0 {
+			return tx.SetRetentionPolicy(ctx, &store.RetentionPolicy{Kind: logRetentionKind, MaxAge: logDays})
+		}
+		if err := tx.DeleteRetentionPolicy(ctx, logRetentionKind); !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("save settings error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if s.onSettings != nil {
+		s.onSettings(settings)
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// instanceRetention returns the days of the instance-wide policy for kind,
+// 0 when there is none.
+func (s *HTTPServer) instanceRetention(ctx context.Context, kind string) (int, error) {
+	policies, err := s.db.ListRetentionPolicies(allWorkspaces(ctx))
+	if err != nil {
+		return 0, err
+	}
+	for _, p := range policies {
+		if p.WorkspaceID == 0 && p.Kind == kind {
+			return p.MaxAge, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// handleWorkspaceSettings changes the chat model and the personas of the
+// current workspace. No personas checked means all of them are offered.
+func (s *HTTPServer) handleWorkspaceSettings(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	ws := *currentWorkspace(r.Context())
+	ws.ChatModel = strings.TrimSpace(r.PostFormValue("chat_model"))
+	ws.Personas = r.PostForm["role"]
+	if err := s.db.UpdateWorkspace(r.Context(), &ws); err != nil {
+		log.Printf("update workspace settings error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// handleRetentionSet sets a retention policy for the current workspace,
+// overriding the instance-wide one for that kind.
+func (s *HTTPServer) handleRetentionSet(w http.ResponseWriter, r *http.Request) {
+	days, err := strconv.Atoi(r.PostFormValue("days"))
+	if err != nil || days <= 0 {
+		http.Error(w, "days must be a positive number", http.StatusBadRequest)
+		return
+	}
+	kind := strings.TrimSpace(r.PostFormValue("kind"))
+	if kind == "" {
+		http.Error(w, "kind is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.db.SetRetentionPolicy(r.Context(), &store.RetentionPolicy{Kind: kind, MaxAge: days}); err != nil {
+		log.Printf("set retention policy error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
+
+// handleRetentionDelete removes a retention policy of the current
+// workspace; the instance-wide policy for the kind applies again.
+func (s *HTTPServer) handleRetentionDelete(w http.ResponseWriter, r *http.Request) {
+	err := s.db.DeleteRetentionPolicy(r.Context(), r.PathValue("kind"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("delete retention policy error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusSeeOther)
+}
@@ -0,0 +1,170 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestHandleSettings(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := svr.routes()
+	// The instance settings are for admins only.
+	testUser(t, svr, "root", true)
+	c := signIn(t, h, "root")
+
+	inc := &store.Incident{Title: "Redis timeouts"}
+	if err := svr.db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.db.CreateEvidence(ctx, &store.Evidence{IncidentID: inc.ID, Kind: "log", Title: "app log", Content: "i/o timeout"}); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/settings", nil)
+		req.AddCookie(c)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(c)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("settings: page", func(t *testing.T) {
+		w := get()
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusOK, w.Body)
+		}
+		for _, want := range []string{"Workspace Default", "log: archived after 90 days (instance default)"} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("body does not contain %q:\n%s", want, w.Body)
+			}
+		}
+	})
+
+	t.Run("settings: instance settings apply live", func(t *testing.T) {
+		var applied *store.Settings
+		svr.OnSettingsChange(func(s *store.Settings) { applied = s })
+
+		w := post("/settings", url.Values{
+			"provider_url":    {"http://gpu-box:11434"},
+			"chat_model":      {"llama3:70b"},
+			"embedding_model": {"nomic-embed-text"},
+			"temperature":     {"0.3"},
+			"log_retention":   {"30"},
+		})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+		}
+		if applied == nil || applied.ChatModel != "llama3:70b" || applied.Temperature != 0.3 {
+			t.Errorf("applied: got %+v", applied)
+		}
+
+		got, err := svr.db.GetSettings(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ProviderURL != "http://gpu-box:11434" {
+			t.Errorf("provider url: got %q", got.ProviderURL)
+		}
+		if body := get().Body.String(); !strings.Contains(body, "log: archived after 30 days (instance default)") {
+			t.Errorf("log retention not changed:\n%s", body)
+		}
+	})
+
+	t.Run("settings: invalid instance settings", func(t *testing.T) {
+		for _, form := range []url.Values{
+			{"provider_url": {"gpu-box"}},
+			{"temperature": {"hot"}},
+			{"temperature": {"3"}},
+			{"log_retention": {"-1"}},
+		} {
+			if w := post("/settings", form); w.Code != http.StatusBadRequest {
+				t.Errorf("%v: got %d want %d", form, w.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("settings: workspace model and roles", func(t *testing.T) {
+		w := post("/settings/workspace", url.Values{"chat_model": {"llama3:70b"}, "role": {"sre", "dba"}})
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+		}
+
+		ws, err := svr.db.GetWorkspace(ctx, store.DefaultWorkspaceID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ws.ChatModel != "llama3:70b" || len(ws.Personas) != 2 {
+			t.Errorf("workspace: got model %q personas %v", ws.ChatModel, ws.Personas)
+		}
+	})
+
+	t.Run("settings: workspace retention", func(t *testing.T) {
+		if w := post("/settings/retention", url.Values{"kind": {"log"}, "days": {"7"}}); w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+		}
+		if body := get().Body.String(); !strings.Contains(body, "log: archived after 7 days (this workspace)") {
+			t.Errorf("workspace policy not shown:\n%s", body)
+		}
+
+		if w := post("/settings/retention/log/delete", nil); w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d: %s", w.Code, http.StatusSeeOther, w.Body)
+		}
+		if body := get().Body.String(); !strings.Contains(body, "log: archived after 30 days (instance default)") {
+			t.Errorf("instance policy not back:\n%s", body)
+		}
+		if w := post("/settings/retention/log/delete", nil); w.Code != http.StatusNotFound {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("settings: invalid retention", func(t *testing.T) {
+		if w := post("/settings/retention", url.Values{"kind": {"log"}, "days": {"0"}}); w.Code != http.StatusBadRequest {
+			t.Errorf("status: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("settings: saved with the log retention or not at all", func(t *testing.T) {
+		for _, stmt := range []string{
+			`CREATE TRIGGER fail_insert BEFORE INSERT ON retention_policies BEGIN SELECT RAISE(ABORT, 'disk full'); END`,
+			`CREATE TRIGGER fail_update BEFORE UPDATE ON retention_policies BEGIN SELECT RAISE(ABORT, 'disk full'); END`,
+		} {
+			if _, err := svr.db.Exec(stmt); err != nil {
+				t.Fatal(err)
+			}
+		}
+		defer svr.db.Exec(`DROP TRIGGER fail_insert`)
+		defer svr.db.Exec(`DROP TRIGGER fail_update`)
+
+		var applied *store.Settings
+		svr.OnSettingsChange(func(s *store.Settings) { applied = s })
+		w := post("/settings", url.Values{"chat_model": {"mistral"}, "log_retention": {"45"}})
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusInternalServerError)
+		}
+		if applied != nil {
+			t.Errorf("applied: got %+v", applied)
+		}
+		got, err := svr.db.GetSettings(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ChatModel == "mistral" {
+			t.Error("settings saved without the log retention")
+		}
+	})
+}
@@ -1,10 +1,17 @@
 package httpserver
 
 import (
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
 )
 
 func TestHandleHealthz(t *testing.T) {
@@ -21,6 +28,9 @@ func TestHandleHealthz(t *testing.T) {
 			t.Errorf("handler returned wrong status code: got: %d; want: %d",
 				res.StatusCode, http.StatusOK)
 		}
+		if got := res.Header.Get("Content-Type"); got != "text/plain; charset=utf-8" {
+			t.Errorf("handler returned wrong content type: got: %s", got)
+		}
 
 		b, err := io.ReadAll(res.Body)
 		if err != nil {
@@ -50,10 +60,79 @@ func TestHandleHealthz(t *testing.T) {
 func setupServer(t *testing.T) *HTTPServer {
 	t.Helper()
 
-	svr, err := NewHTTPServer("127.0.0.1:0", "../../web/templates", nil)
+	svr, err := NewHTTPServer("127.0.0.1:0", "../../web/templates", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	return svr
 }
+
+// loopback serves h as if to a client on the loopback interface, which
+// needs no sign-in until the first user is added.
+func loopback(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.RemoteAddr = "127.0.0.1:1234"
+		h.ServeHTTP(w, r)
+	})
+}
+
+type fakeChatter struct {
+	resp string
+}
+
+func (f *fakeChatter) Chat(ctx context.Context, msgs []llm.Message, opts *llm.CallOptions) (string, error) {
+	return f.resp, nil
+}
+
+func setupServerWithDB(t *testing.T) *HTTPServer {
+	t.Helper()
+
+	f, err := os.CreateTemp("", "rt-httpserver-*.db")
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+
+	db, err := store.NewSQLiteDB(f.Name())
+	if err != nil {
+		t.Fatalf("failed to open test db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+
+	svr, err := NewHTTPServer("127.0.0.1:0", "../../web/templates", db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svr
+}
+
+func TestHandleHome(t *testing.T) {
+	svr := setupServerWithDB(t)
+	inc := &store.Incident{Title: "Redis timeouts"}
+	if err := svr.db.CreateIncident(context.Background(), inc); err != nil {
+		t.Fatal(err)
+	}
+	h := loopback(svr.routes())
+
+	t.Run("handleHome: incidents", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), fmt.Sprintf(`<a href="/incidents/%d">Redis timeouts</a>`, inc.ID)) {
+			t.Errorf("got %d: %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("handleHome: unknown path", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/nope", nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("got %d want %d", w.Code, http.StatusNotFound)
+		}
+	})
+}
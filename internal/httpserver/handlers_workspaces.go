@@ -0,0 +1,357 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+	"github.com/dtoebe/RootTensor/internal/templates"
+)
+
+// workspaceCookie remembers the workspace picked with the switcher.
+const workspaceCookie = "workspace"
+
+type workspaceKey struct{}
+
+// errNotMember rejects a workspace the signed-in user is not a member of.
+var errNotMember = errors.New("you are not a member of this workspace")
+
+// withWorkspace scopes every request to one workspace: the one named by the
+// workspace query parameter, else the one remembered in the cookie, else
+// the default workspace. Store calls made with the request context then
+// only see that workspace and may only make the changes the role of the
+// user there allows. A cookie naming a workspace that is gone falls back to
+// the default; an unknown query parameter is a 404. Signed-in users only
+// enter workspaces they are members of, or that have no members; without a
+// choice they land in the first of those.
+func (s *HTTPServer) withWorkspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.db == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ws, role, err := s.requestWorkspace(r)
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "unknown workspace", http.StatusNotFound)
+			return
+		}
+		if errors.Is(err, errNotMember) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Printf("resolve workspace error: %v", err)
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		ctx := store.WithRole(store.WithWorkspace(r.Context(), ws.ID), role)
+		ctx = context.WithValue(ctx, workspaceKey{}, ws)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func (s *HTTPServer) requestWorkspace(r *http.Request) (*store.Workspace, store.Role, error) {
+	ctx := r.Context()
+	if slug := r.URL.Query().Get("workspace"); slug != "" {
+		ws, err := s.db.GetWorkspaceBySlug(ctx, slug)
+		if err != nil {
+			return nil, "", err
+		}
+		role, err := s.workspaceRole(ctx, ws)
+		if err != nil {
+			return nil, "", err
+		}
+		if role == "" {
+			return nil, "", errNotMember
+		}
+		return ws, role, nil
+	}
+
+	var candidates []*store.Workspace
+	if c, err := r.Cookie(workspaceCookie); err == nil && c.Value != "" {
+		ws, err := s.db.GetWorkspaceBySlug(ctx, c.Value)
+		if err == nil {
+			candidates = append(candidates, ws)
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return nil, "", err
+		}
+	}
+	ws, err := s.db.GetWorkspace(ctx, store.DefaultWorkspaceID)
+	if err != nil {
+		return nil, "", err
+	}
+	candidates = append(candidates, ws)
+
+	for _, ws := range candidates {
+		if role, err := s.workspaceRole(ctx, ws); err != nil || role != "" {
+			return ws, role, err
+		}
+	}
+	all, err := s.db.ListWorkspacesOf(ctx, currentUser(ctx).Username)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(all) == 0 {
+		return nil, "", errNotMember
+	}
+	role, err := s.workspaceRole(ctx, &all[0])
+
+	return &all[0], role, err
+}
+
+// workspaceRole returns the role of the signed-in user in ws, or "" when
+// they may not enter it. Admins and, while there are no users, local
+// clients are admins of every workspace.
+func (s *HTTPServer) workspaceRole(ctx context.Context, ws *store.Workspace) (store.Role, error) {
+	u := currentUser(ctx)
+	if u == nil || u.Admin {
+		return store.RoleAdmin, nil
+	}
+
+	return s.db.WorkspaceRole(ctx, ws.ID, u.Username)
+}
+
+// currentWorkspace returns the workspace withWorkspace resolved, or nil
+// without a database.
+func currentWorkspace(ctx context.Context) *store.Workspace {
+	ws, _ := ctx.Value(workspaceKey{}).(*store.Workspace)
+	return ws
+}
+
+// allWorkspaces lifts the workspace scope for the admin view.
+func allWorkspaces(ctx context.Context) context.Context {
+	return store.WithWorkspace(ctx, 0)
+}
+
+// chatter is the model backend with the chat model of the current
+// workspace, when it sets one.
+func (s *HTTPServer) chatter(ctx context.Context) llm.Chatter {
+	if ws := currentWorkspace(ctx); ws != nil {
+		return llm.WithModel(s.llm, ws.ChatModel)
+	}
+
+	return s.llm
+}
+
+// workspaceNav is what the layout needs for the workspace switcher and the
+// account links. Only the workspaces the user may enter are offered.
+func (s *HTTPServer) workspaceNav(ctx context.Context) (templates.WorkspaceNav, error) {
+	nav := templates.WorkspaceNav{Current: currentWorkspace(ctx), User: currentUser(ctx)}
+	if nav.Current == nil {
+		return nav, nil
+	}
+
+	var err error
+	if nav.User != nil && !nav.User.Admin {
+		nav.All, err = s.db.ListWorkspacesOf(allWorkspaces(ctx), nav.User.Username)
+	} else {
+		nav.All, err = s.db.ListWorkspaces(allWorkspaces(ctx))
+	}
+	return nav, err
+}
+
+// handleSwitchWorkspace remembers the chosen workspace and goes back to the
+// home page; the page the form was on may belong to the old workspace.
+func (s *HTTPServer) handleSwitchWorkspace(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.db.GetWorkspaceBySlug(allWorkspaces(r.Context()), r.PostFormValue("workspace"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "unknown workspace", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("switch workspace error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	role, err := s.workspaceRole(r.Context(), ws)
+	if err != nil {
+		log.Printf("switch workspace error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	if role == "" {
+		http.Error(w, errNotMember.Error(), http.StatusForbidden)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     workspaceCookie,
+		Value:    ws.Slug,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   s.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func (s *HTTPServer) handleAdminWorkspaces(w http.ResponseWriter, r *http.Request) {
+	ctx := allWorkspaces(r.Context())
+	stats, err := s.db.ListWorkspaceStats(ctx)
+	if err != nil {
+		log.Printf("list workspace stats error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+	usage, err := s.db.StorageUsage(ctx)
+	if err != nil {
+		log.Printf("storage usage error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, "Workspaces", templates.AdminWorkspacesPage(stats, usage))
+}
+
+func (s *HTTPServer) handleAdminWorkspaceCreate(w http.ResponseWriter, r *http.Request) {
+	ws := &store.Workspace{
+		Slug: strings.TrimSpace(r.PostFormValue("slug")),
+		Name: strings.TrimSpace(r.PostFormValue("name")),
+	}
+	if !store.ValidSlug(ws.Slug) {
+		http.Error(w, "slug must be lower case letters, digits and dashes", http.StatusBadRequest)
+		return
+	}
+	if _, err := s.db.GetWorkspaceBySlug(allWorkspaces(r.Context()), ws.Slug); err == nil {
+		http.Error(w, "workspace already exists", http.StatusConflict)
+		return
+	}
+	if err := s.db.CreateWorkspace(allWorkspaces(r.Context()), ws); err != nil {
+		log.Printf("create workspace error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, workspaceURL(ws), http.StatusSeeOther)
+}
+
+func (s *HTTPServer) handleAdminWorkspace(w http.ResponseWriter, r *http.Request) {
+	ws, ok := s.loadWorkspace(w, r)
+	if !ok {
+		return
+	}
+	members, err := s.db.ListWorkspaceMembers(allWorkspaces(r.Context()), ws.ID)
+	if err != nil {
+		log.Printf("list workspace members error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	s.render(w, r, "Workspace "+ws.Name, templates.AdminWorkspacePage(ws, members))
+}
+
+func (s *HTTPServer) handleAdminWorkspaceRename(w http.ResponseWriter, r *http.Request) {
+	ws, ok := s.loadWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	ws.Name = strings.TrimSpace(r.PostFormValue("name"))
+	if err := s.db.UpdateWorkspace(allWorkspaces(r.Context()), ws); err != nil {
+		log.Printf("rename workspace error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, workspaceURL(ws), http.StatusSeeOther)
+}
+
+func (s *HTTPServer) handleAdminWorkspaceDelete(w http.ResponseWriter, r *http.Request) {
+	ws, ok := s.loadWorkspace(w, r)
+	if !ok {
+		return
+	}
+	if ws.ID == store.DefaultWorkspaceID {
+		http.Error(w, "the default workspace cannot be deleted", http.StatusBadRequest)
+		return
+	}
+
+	err := s.db.DeleteWorkspace(allWorkspaces(r.Context()), ws.ID)
+	if errors.Is(err, store.ErrWorkspaceNotEmpty) {
+		http.Error(w, "move or delete the incidents of the workspace first", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("delete workspace error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin/workspaces", http.StatusSeeOther)
+}
+
+func (s *HTTPServer) handleAdminMemberAdd(w http.ResponseWriter, r *http.Request) {
+	ws, ok := s.loadWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	member := strings.TrimSpace(r.PostFormValue("member"))
+	if member == "" {
+		http.Error(w, "member is required", http.StatusBadRequest)
+		return
+	}
+	role := store.Role(r.PostFormValue("role"))
+	if role == "" {
+		role = store.DefaultRole
+	}
+	if !store.ValidRole(string(role)) {
+		http.Error(w, "unknown role", http.StatusBadRequest)
+		return
+	}
+	if err := s.db.AddWorkspaceMember(allWorkspaces(r.Context()), ws.ID, member, role); err != nil {
+		log.Printf("add workspace member error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, workspaceURL(ws), http.StatusSeeOther)
+}
+
+func (s *HTTPServer) handleAdminMemberRemove(w http.ResponseWriter, r *http.Request) {
+	ws, ok := s.loadWorkspace(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.db.RemoveWorkspaceMember(allWorkspaces(r.Context()), ws.ID, r.PathValue("member"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("remove workspace member error: %v", err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, workspaceURL(ws), http.StatusSeeOther)
+}
+
+func (s *HTTPServer) loadWorkspace(w http.ResponseWriter, r *http.Request) (*store.Workspace, bool) {
+	slug := r.PathValue("slug")
+	ws, err := s.db.GetWorkspaceBySlug(allWorkspaces(r.Context()), slug)
+	if errors.Is(err, store.ErrNotFound) {
+		http.NotFound(w, r)
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("get workspace %s error: %v", slug, err)
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return ws, true
+}
+
+// workspaceURL is the admin page of ws.
+func workspaceURL(ws *store.Workspace) string {
+	return fmt.Sprintf("/admin/workspaces/%s", ws.Slug)
+}
@@ -0,0 +1,160 @@
+package httpserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/llm"
+	"github.com/dtoebe/RootTensor/internal/store"
+)
+
+func TestWorkspaces(t *testing.T) {
+	svr := setupServerWithDB(t)
+	ctx := context.Background()
+	h := svr.routes()
+	// The admin pages are for admins only; admins enter every workspace.
+	testUser(t, svr, "root", true)
+	session := signIn(t, h, "root")
+
+	ops := &store.Workspace{Slug: "ops", Name: "Operations"}
+	if err := svr.db.CreateWorkspace(ctx, ops); err != nil {
+		t.Fatal(err)
+	}
+	inc := &store.Incident{Title: "Payroll batch stuck"}
+	if err := svr.db.CreateIncident(store.WithWorkspace(ctx, ops.ID), inc); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(method, path string, form url.Values, cookie string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.AddCookie(session)
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: workspaceCookie, Value: cookie})
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+	history := fmt.Sprintf("/incidents/%d/history", inc.ID)
+
+	t.Run("workspaces: incidents stay in their workspace", func(t *testing.T) {
+		if w := do(http.MethodGet, history, nil, ""); w.Code != http.StatusNotFound {
+			t.Errorf("default workspace: got %d want %d", w.Code, http.StatusNotFound)
+		}
+		if w := do(http.MethodGet, history, nil, "ops"); w.Code != http.StatusOK {
+			t.Errorf("ops workspace: got %d want %d", w.Code, http.StatusOK)
+		}
+		if w := do(http.MethodGet, history+"?workspace=ops", nil, ""); w.Code != http.StatusOK {
+			t.Errorf("ops by query: got %d want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("workspaces: unknown workspace", func(t *testing.T) {
+		if w := do(http.MethodGet, "/?workspace=nope", nil, ""); w.Code != http.StatusNotFound {
+			t.Errorf("query: got %d want %d", w.Code, http.StatusNotFound)
+		}
+		if w := do(http.MethodGet, "/", nil, "gone"); w.Code != http.StatusOK {
+			t.Errorf("stale cookie: got %d want %d", w.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("workspaces: switch", func(t *testing.T) {
+		w := do(http.MethodPost, "/workspace", url.Values{"workspace": {"ops"}}, "")
+		if w.Code != http.StatusSeeOther {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+		cookies := w.Result().Cookies()
+		if len(cookies) != 1 || cookies[0].Name != workspaceCookie || cookies[0].Value != "ops" {
+			t.Errorf("cookie: got %v", cookies)
+		}
+
+		if w := do(http.MethodPost, "/workspace", url.Values{"workspace": {"nope"}}, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("unknown: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("workspaces: admin view", func(t *testing.T) {
+		w := do(http.MethodGet, "/admin/workspaces", nil, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status: got %d want %d", w.Code, http.StatusOK)
+		}
+		for _, want := range []string{"Operations", "Default", "audit log"} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("body does not contain %q:\n%s", want, w.Body)
+			}
+		}
+	})
+
+	t.Run("workspaces: admin create, members and delete", func(t *testing.T) {
+		w := do(http.MethodPost, "/admin/workspaces", url.Values{"slug": {"data"}, "name": {"Data"}}, "")
+		if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/admin/workspaces/data" {
+			t.Fatalf("create: got %d %q", w.Code, w.Header().Get("Location"))
+		}
+		if w := do(http.MethodPost, "/admin/workspaces", url.Values{"slug": {"data"}}, ""); w.Code != http.StatusConflict {
+			t.Errorf("duplicate: got %d want %d", w.Code, http.StatusConflict)
+		}
+		if w := do(http.MethodPost, "/admin/workspaces", url.Values{"slug": {"Bad Slug"}}, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("bad slug: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+
+		if w := do(http.MethodPost, "/admin/workspaces/data/members", url.Values{"member": {"alice"}}, ""); w.Code != http.StatusSeeOther {
+			t.Fatalf("add member: got %d", w.Code)
+		}
+		if w := do(http.MethodGet, "/admin/workspaces/data", nil, ""); !strings.Contains(w.Body.String(), "alice") {
+			t.Errorf("member not listed:\n%s", w.Body)
+		}
+		if w := do(http.MethodPost, "/admin/workspaces/data/members/alice/delete", nil, ""); w.Code != http.StatusSeeOther {
+			t.Errorf("remove member: got %d", w.Code)
+		}
+
+		if w := do(http.MethodPost, "/admin/workspaces/ops/delete", nil, ""); w.Code != http.StatusConflict {
+			t.Errorf("delete non-empty: got %d want %d", w.Code, http.StatusConflict)
+		}
+		if w := do(http.MethodPost, "/admin/workspaces/default/delete", nil, ""); w.Code != http.StatusBadRequest {
+			t.Errorf("delete default: got %d want %d", w.Code, http.StatusBadRequest)
+		}
+		if w := do(http.MethodPost, "/admin/workspaces/data/delete", nil, ""); w.Code != http.StatusSeeOther {
+			t.Errorf("delete: got %d want %d", w.Code, http.StatusSeeOther)
+		}
+		if w := do(http.MethodGet, "/admin/workspaces/data", nil, ""); w.Code != http.StatusNotFound {
+			t.Errorf("deleted: got %d want %d", w.Code, http.StatusNotFound)
+		}
+	})
+}
+
+type modelChatter struct {
+	model string
+}
+
+func (m *modelChatter) Chat(ctx context.Context, msgs []llm.Message, opts *llm.CallOptions) (string, error) {
+	m.model = opts.Model
+	return "", nil
+}
+
+func TestChatterWorkspaceModel(t *testing.T) {
+	svr := setupServerWithDB(t)
+	backend := &modelChatter{}
+	svr.llm = backend
+
+	ws := &store.Workspace{Slug: "ml", Name: "ML", ChatModel: "mistral"}
+	if err := svr.db.CreateWorkspace(context.Background(), ws); err != nil {
+		t.Fatal(err)
+	}
+	if err := svr.db.UpdateWorkspace(context.Background(), ws); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.WithValue(context.Background(), workspaceKey{}, ws)
+	if _, err := svr.chatter(ctx).Chat(ctx, nil, &llm.CallOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if backend.model != "mistral" {
+		t.Errorf("model: got %q want %q", backend.model, "mistral")
+	}
+}
@@ -5,33 +5,108 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/dtoebe/RootTensor/internal/blobs"
+	"github.com/dtoebe/RootTensor/internal/llm"
 	"github.com/dtoebe/RootTensor/internal/store"
 )
 
 type HTTPServer struct {
 	addr string
 	// TODO: Move DB to a service in-between
-	db *store.SQliteDB
+	db  *store.SQliteDB
+	llm llm.Chatter
+	// blobs keeps files uploaded as evidence; nil accepts only text small
+	// enough for the evidence row.
+	blobs *blobs.Store
+
+	// onSettings is called with the instance settings after they were
+	// changed on the Settings page.
+	onSettings func(*store.Settings)
+
+	// runs are the analyses started from the incident page.
+	runs analysisRuns
+
+	// ready caches the dependency checks of /readyz.
+	ready readiness
+
+	// secureCookies marks cookies Secure, so that browsers only send them
+	// over HTTPS.
+	secureCookies bool
+	// logins throttles failed sign-ins.
+	logins loginLimit
+
+	// life ends when the server starts shutting down, and with it the
+	// event streams and the work started in the background.
+	life     context.Context
+	shutdown context.CancelFunc
+	// tasks is the work started in the background, which Run waits for.
+	tasks sync.WaitGroup
 }
 
-func NewHTTPServer(addr, tmplRoot string, db *store.SQliteDB) (*HTTPServer, error) {
+// NewHTTPServer returns a server listening on addr. Its cookies are Secure
+// unless addr is a loopback address; see SecureCookies.
+func NewHTTPServer(addr, tmplRoot string, db *store.SQliteDB, chat llm.Chatter) (*HTTPServer, error) {
+	life, shutdown := context.WithCancel(context.Background())
+
 	return &HTTPServer{
-		addr: addr,
-		db:   db,
+		addr:          addr,
+		db:            db,
+		llm:           chat,
+		secureCookies: !loopbackAddr(addr),
+		life:          life,
+		shutdown:      shutdown,
 	}, nil
 }
 
+// SecureCookies sets whether cookies are Secure. Turn it off only when
+// browsers reach the server over plain HTTP; behind a proxy that ends TLS
+// it stays on.
+func (s *HTTPServer) SecureCookies(on bool) {
+	s.secureCookies = on
+}
+
+// loopbackAddr reports whether the listen address addr is on the loopback
+// interface only.
+func loopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// OnSettingsChange registers fn to apply changed instance settings to the
+// running server, e.g. to reconfigure the model provider.
+func (s *HTTPServer) OnSettingsChange(fn func(*store.Settings)) {
+	s.onSettings = fn
+}
+
+// UseBlobs stores evidence files uploaded on the incident page in bs.
+func (s *HTTPServer) UseBlobs(bs *blobs.Store) {
+	s.blobs = bs
+}
+
 func (s *HTTPServer) Run(ctx context.Context) error {
 	srv := &http.Server{
 		Addr:    s.addr,
 		Handler: s.routes(),
 	}
+	// Shutdown waits for requests to finish but does not cancel them;
+	// streams would keep it waiting until they end on their own.
+	srv.RegisterOnShutdown(s.stop)
 
 	srvErr := make(chan error, 1)
 
@@ -63,6 +138,40 @@ func (s *HTTPServer) Run(ctx context.Context) error {
 		return fmt.Errorf("server shutdown failed: %w", err)
 	}
 
+	// The background work was cancelled when the shutdown started; wait
+	// for it to record how it ended before the database goes away.
+	done := make(chan struct{})
+	go func() {
+		s.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-shutdownCtx.Done():
+		return fmt.Errorf("server shutdown failed: background work still running")
+	}
+
 	log.Println("server shut down")
 	return nil
 }
+
+// stop ends the event streams of s and cancels its runs. Run calls it when
+// the server starts shutting down.
+func (s *HTTPServer) stop() {
+	s.shutdown()
+	s.runs.cancelAll()
+}
+
+// background returns the context of work that outlives its request: it
+// keeps the values of ctx, such as who started the work and in which
+// workspace, but ends after timeout or when the server shuts down. Start
+// the work with s.tasks.Go so that Run waits for it.
+func (s *HTTPServer) background(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	stop := context.AfterFunc(s.life, cancel)
+
+	return ctx, func() {
+		stop()
+		cancel()
+	}
+}
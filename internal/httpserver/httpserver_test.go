@@ -10,7 +10,7 @@ import (
 
 func TestServerLifecycle(t *testing.T) {
 	addr := ":3333"
-	srv, err := NewHTTPServer(addr, "../../web/templates", nil)
+	srv, err := NewHTTPServer(addr, "../../web/templates", nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,3 +47,53 @@ func TestServerLifecycle(t *testing.T) {
 		t.Fatal("server failed to shutdown in time")
 	}
 }
+
+func TestNewHTTPServer(t *testing.T) {
+	for addr, want := range map[string]bool{
+		":3333":          true,
+		"0.0.0.0:3333":   true,
+		"10.0.0.5:3333":  true,
+		"127.0.0.1:3333": false,
+		"[::1]:3333":     false,
+		"localhost:3333": false,
+	} {
+		srv, err := NewHTTPServer(addr, "../../web/templates", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if srv.secureCookies != want {
+			t.Errorf("NewHTTPServer: %s: secure cookies %v want %v", addr, srv.secureCookies, want)
+		}
+	}
+}
+
+func TestServerBackground(t *testing.T) {
+	srv, err := NewHTTPServer("127.0.0.1:0", "../../web/templates", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type key struct{}
+
+	ctx, cancel := srv.background(context.WithValue(context.Background(), key{}, "alice"), time.Hour)
+	defer cancel()
+	var seen any
+	srv.tasks.Go(func() {
+		<-ctx.Done()
+		seen = ctx.Value(key{})
+	})
+
+	srv.stop()
+	done := make(chan struct{})
+	go func() {
+		srv.tasks.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("background: work not cancelled on shutdown")
+	}
+	if seen != "alice" {
+		t.Errorf("background: value got %v want alice", seen)
+	}
+}
@@ -0,0 +1,320 @@
+package httpserver
+
+import (
+	"compress/gzip"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/requestid"
+)
+
+// middleware wraps a handler with behaviour shared by every route.
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in mws; the first middleware sees the request first.
+func chain(h http.Handler, mws ...middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	return h
+}
+
+// defaultTimeout bounds how long a route may take to answer.
+const defaultTimeout = 30 * time.Second
+
+// routeTimeouts are the routes that need more or less time than
+// defaultTimeout; 0 is no limit. Streams are not limited, as a timeout
+// buffers the whole response: event streams would never get through and
+// downloads would be held in memory.
+var routeTimeouts = map[string]time.Duration{
+	"GET /runs/{id}/events":         0,
+	"GET /evidence/{id}/file":       0,
+	"POST /incidents/{id}/evidence": 30 * time.Minute,
+	"POST /bundles/import":          5 * time.Minute,
+	"GET /incidents/{id}/export":    5 * time.Minute,
+	"POST /analyses/{id}/critique":  5 * time.Minute,
+}
+
+// withRequestID gives every request an id: the one sent in the
+// X-Request-ID header when it is well-formed, else a new one. The id is
+// sent back in the same header and travels in the request context to the
+// logs and on to the model backend.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+		w.Header().Set(requestid.Header, id)
+
+		next.ServeHTTP(w, r.WithContext(requestid.With(r.Context(), id)))
+	})
+}
+
+// probePaths are polled by load balancers and orchestrators; their requests
+// are logged at debug level so as not to drown the others.
+var probePaths = map[string]bool{
+	"/healthz": true,
+	"/readyz":  true,
+}
+
+// withAccessLog logs every request once it is answered.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		level := slog.LevelInfo
+		if probePaths[r.URL.Path] && rec.status() < http.StatusInternalServerError {
+			level = slog.LevelDebug
+		}
+		slog.Log(r.Context(), level, "request",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rec.status(),
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+			"remote", r.RemoteAddr,
+			"request_id", requestid.From(r.Context()),
+		)
+	})
+}
+
+// withRecovery turns a panicking handler into a 500 and logs the panic
+// with its stack. http.ErrAbortHandler is left to the server, which uses it
+// to cut a response short.
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			p := recover()
+			if p == nil {
+				return
+			}
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+
+			slog.ErrorContext(r.Context(), "handler panic",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(p),
+				"stack", string(debug.Stack()),
+				"request_id", requestid.From(r.Context()),
+			)
+			if rec.code != 0 {
+				// The response is under way; all that is left is to cut it.
+				panic(http.ErrAbortHandler)
+			}
+			if strings.HasPrefix(r.URL.Path, "/api/") {
+				writeProblem(w, r, http.StatusInternalServerError, "")
+				return
+			}
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
+
+// withTimeouts answers 503 once a request takes longer than its route
+// allows; routes of mux decide the timeout. The request context is
+// cancelled with it.
+func withTimeouts(mux *http.ServeMux) middleware {
+	return func(next http.Handler) http.Handler {
+		bounded := map[time.Duration]http.Handler{}
+		for _, d := range routeTimeouts {
+			if d > 0 {
+				bounded[d] = http.TimeoutHandler(next, d, "the request took too long")
+			}
+		}
+		bounded[defaultTimeout] = http.TimeoutHandler(next, defaultTimeout, "the request took too long")
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, pattern := mux.Handler(r)
+			d, ok := routeTimeouts[pattern]
+			if !ok {
+				d = defaultTimeout
+			}
+			if d == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			bounded[d].ServeHTTP(w, r)
+		})
+	}
+}
+
+// gzipMinSize is the smallest response worth compressing, when its size is
+// known up front.
+const gzipMinSize = 1024
+
+// gzipTypes are the content types compressed.
+var gzipTypes = map[string]bool{
+	"text/html":                true,
+	"text/plain":               true,
+	"text/css":                 true,
+	"text/javascript":          true,
+	"text/markdown":            true,
+	"application/javascript":   true,
+	"application/json":         true,
+	"application/problem+json": true,
+	"image/svg+xml":            true,
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// withGzip compresses text responses for clients that accept gzip. Event
+// streams, downloads and partial content pass through unchanged.
+func withGzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" {
+			return strings.ReplaceAll(params, " ", "") != "q=0"
+		}
+	}
+
+	return false
+}
+
+// statusRecorder remembers the status and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int64
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if s.code == 0 && code >= http.StatusOK {
+		s.code = code
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(b)
+	s.bytes += int64(n)
+	return n, err
+}
+
+// status is the status sent, 200 when the handler wrote nothing.
+func (s *statusRecorder) status() int {
+	if s.code == 0 {
+		return http.StatusOK
+	}
+	return s.code
+}
+
+// Unwrap lets http.ResponseController flush the response.
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// gzipResponseWriter decides with the headers whether to compress the
+// response, then writes it through gz when it does.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.decided || code < http.StatusOK {
+		g.ResponseWriter.WriteHeader(code)
+		return
+	}
+	g.decided = true
+
+	if compressible(code, g.Header()) {
+		h := g.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		g.gz = gzipWriters.Get().(*gzip.Writer)
+		g.gz.Reset(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.decided {
+		if g.Header().Get("Content-Type") == "" {
+			g.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz != nil {
+		return g.gz.Write(b)
+	}
+	return g.ResponseWriter.Write(b)
+}
+
+// FlushError sends what was compressed so far; http.ResponseController
+// calls it.
+func (g *gzipResponseWriter) FlushError() error {
+	if g.gz != nil {
+		if err := g.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(g.ResponseWriter).Flush()
+}
+
+func (g *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return g.ResponseWriter
+}
+
+func (g *gzipResponseWriter) close() {
+	if g.gz == nil {
+		return
+	}
+	g.gz.Close()
+	g.gz.Reset(nil)
+	gzipWriters.Put(g.gz)
+	g.gz = nil
+}
+
+// compressible reports whether a response with status code and headers h
+// is worth compressing.
+func compressible(code int, h http.Header) bool {
+	if code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < gzipMinSize {
+		return false
+	}
+	mt, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+
+	return err == nil && gzipTypes[mt]
+}
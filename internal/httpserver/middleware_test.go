@@ -0,0 +1,144 @@
+package httpserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/requestid"
+)
+
+// captureLog sends slog output to the returned buffer until the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+
+	var buf bytes.Buffer
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+	t.Cleanup(func() { slog.SetDefault(prev) })
+
+	return &buf
+}
+
+func TestMiddleware(t *testing.T) {
+	page := strings.Repeat("<p>The consumer was stuck.</p>\n", 100)
+	var seenID string
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /page", func(w http.ResponseWriter, r *http.Request) {
+		seenID = requestid.From(r.Context())
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, page)
+	})
+	mux.HandleFunc("GET /panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	})
+	mux.HandleFunc("GET /api/v1/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("nil map")
+	})
+	mux.HandleFunc("GET /slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("GET /events", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		io.WriteString(w, page)
+		if err := http.NewResponseController(w).Flush(); err != nil {
+			t.Errorf("flush: %v", err)
+		}
+	})
+	routeTimeouts["GET /slow"] = 10 * time.Millisecond
+	routeTimeouts["GET /events"] = 0
+	t.Cleanup(func() {
+		delete(routeTimeouts, "GET /slow")
+		delete(routeTimeouts, "GET /events")
+	})
+	h := chain(mux, withRequestID, withAccessLog, withGzip, withTimeouts(mux), withRecovery)
+
+	get := func(path string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, vs := range header {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("middleware: request id", func(t *testing.T) {
+		logs := captureLog(t)
+		w := get("/page", nil)
+		id := w.Header().Get(requestid.Header)
+		if !requestid.Valid(id) || seenID != id {
+			t.Errorf("generated: header %q, context %q", id, seenID)
+		}
+		if !strings.Contains(logs.String(), "request_id="+id) || !strings.Contains(logs.String(), "status=200") {
+			t.Errorf("access log: %s", logs)
+		}
+
+		w = get("/page", http.Header{requestid.Header: {"lb-7f3a"}})
+		if got := w.Header().Get(requestid.Header); got != "lb-7f3a" || seenID != "lb-7f3a" {
+			t.Errorf("propagated: header %q, context %q", got, seenID)
+		}
+		w = get("/page", http.Header{requestid.Header: {"a\nforged line"}})
+		if got := w.Header().Get(requestid.Header); got == "a\nforged line" || !requestid.Valid(got) {
+			t.Errorf("malformed: got %q", got)
+		}
+	})
+
+	t.Run("middleware: recovery", func(t *testing.T) {
+		logs := captureLog(t)
+		w := get("/panic", nil)
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("page: got %d want %d", w.Code, http.StatusInternalServerError)
+		}
+		for _, want := range []string{"handler panic", "panic=\"nil map\"", "middleware_test.go", "status=500"} {
+			if !strings.Contains(logs.String(), want) {
+				t.Errorf("log lacks %q: %s", want, logs)
+			}
+		}
+		w = get("/api/v1/panic", nil)
+		if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("api: got %d %s", w.Code, w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("middleware: timeout", func(t *testing.T) {
+		captureLog(t)
+		if w := get("/slow", nil); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("got %d want %d", w.Code, http.StatusServiceUnavailable)
+		}
+	})
+
+	t.Run("middleware: gzip", func(t *testing.T) {
+		captureLog(t)
+		w := get("/page", http.Header{"Accept-Encoding": {"gzip, deflate"}})
+		if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+			t.Fatalf("headers: %v", w.Header())
+		}
+		zr, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := io.ReadAll(zr); string(b) != page {
+			t.Errorf("body: got %d bytes want %d", len(b), len(page))
+		}
+
+		if w := get("/page", nil); w.Header().Get("Content-Encoding") != "" || w.Body.String() != page {
+			t.Errorf("not accepted: got %v", w.Header())
+		}
+		if w := get("/page", http.Header{"Accept-Encoding": {"gzip;q=0"}}); w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("refused: got %v", w.Header())
+		}
+		w = get("/events", http.Header{"Accept-Encoding": {"gzip"}})
+		if w.Header().Get("Content-Encoding") != "" || !w.Flushed || w.Body.String() != page {
+			t.Errorf("event stream: got %v, flushed %v", w.Header(), w.Flushed)
+		}
+	})
+}
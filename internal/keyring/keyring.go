@@ -0,0 +1,305 @@
+// Package keyring implements envelope encryption with AES-256-GCM. Every
+// value or stream is encrypted with a random data key of its own, and the
+// data key is stored beside it wrapped by a key encryption key from the
+// keyring. Rotating keys only rewraps data keys; the data is not touched.
+package keyring
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrUnknownKey = errors.New("value sealed with a key that is not in the keyring")
+	ErrMalformed  = errors.New("malformed sealed value")
+)
+
+// KeySize is the size of key encryption keys: AES-256.
+const KeySize = 32
+
+const (
+	idSize    = 8
+	nonceSize = 12
+	tagSize   = 16
+	// wrappedSize is a data key sealed by a key encryption key.
+	wrappedSize = KeySize + tagSize
+	// HeaderSize is magic, key id, wrap nonce and wrapped data key: the
+	// start of every sealed value and stream.
+	HeaderSize = 4 + idSize + nonceSize + wrappedSize
+)
+
+var valueMagic = []byte("RTE1")
+
+// TextPrefix starts every string sealed by SealString. Text never starts
+// with the control character, so sealed and plain values can share a
+// column.
+const TextPrefix = "\x01rte:"
+
+type key struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+// Keyring holds key encryption keys. The first one seals; all of them
+// open, so older keys stay usable until everything is rewrapped.
+type Keyring struct {
+	keys []key
+}
+
+// New returns a keyring of keys, primary first. Every key must be KeySize
+// bytes.
+func New(keys ...[]byte) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("keyring error: no keys")
+	}
+
+	k := &Keyring{}
+	seen := map[string]bool{}
+	for i, raw := range keys {
+		if len(raw) != KeySize {
+			return nil, fmt.Errorf("keyring error: key %d is %d bytes, want %d", i+1, len(raw), KeySize)
+		}
+		aead, err := newAEAD(raw)
+		if err != nil {
+			return nil, fmt.Errorf("keyring error: %v", err)
+		}
+		sum := sha256.Sum256(raw)
+		id := sum[:idSize]
+		if seen[string(id)] {
+			return nil, fmt.Errorf("keyring error: key %d is listed twice", i+1)
+		}
+		seen[string(id)] = true
+		k.keys = append(k.keys, key{id: id, aead: aead})
+	}
+
+	return k, nil
+}
+
+// Parse reads base64 keys, one per line or separated by commas, primary
+// first. Blank lines and lines starting with # are skipped.
+func Parse(text string) (*Keyring, error) {
+	var keys [][]byte
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, field := range strings.Split(line, ",") {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			raw, err := base64.StdEncoding.DecodeString(field)
+			if err != nil {
+				return nil, fmt.Errorf("keyring error: key %d is not base64: %v", len(keys)+1, err)
+			}
+			keys = append(keys, raw)
+		}
+	}
+
+	return New(keys...)
+}
+
+// Generate returns a new random key, base64 encoded as Parse reads it.
+func Generate() (string, error) {
+	raw := make([]byte, KeySize)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("generate key error: %v", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+// Seal encrypts plaintext under a new data key wrapped by the primary key.
+func (k *Keyring) Seal(plaintext []byte) ([]byte, error) {
+	dek, header, err := k.newHeader(valueMagic)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, fmt.Errorf("seal error: %v", err)
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("seal error: %v", err)
+	}
+
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plaintext, nil), nil
+}
+
+// Open decrypts a value returned by Seal.
+func (k *Keyring) Open(sealed []byte) ([]byte, error) {
+	if len(sealed) < HeaderSize+nonceSize+tagSize || !bytes.HasPrefix(sealed, valueMagic) {
+		return nil, ErrMalformed
+	}
+	header := sealed[:HeaderSize]
+	dek, err := k.unwrap(header)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, fmt.Errorf("open error: %v", err)
+	}
+
+	nonce := sealed[HeaderSize : HeaderSize+nonceSize]
+	plaintext, err := aead.Open(nil, nonce, sealed[HeaderSize+nonceSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("open error: %w", ErrMalformed)
+	}
+
+	return plaintext, nil
+}
+
+// SealString seals s and encodes it as text starting with TextPrefix.
+func (k *Keyring) SealString(s string) (string, error) {
+	b, err := k.Seal([]byte(s))
+	if err != nil {
+		return "", err
+	}
+
+	return TextPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// OpenString decrypts a value returned by SealString.
+func (k *Keyring) OpenString(s string) (string, error) {
+	if !IsSealedString(s) {
+		return "", ErrMalformed
+	}
+	b, err := base64.StdEncoding.DecodeString(s[len(TextPrefix):])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	plaintext, err := k.Open(b)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+// IsSealedString reports whether s was returned by SealString.
+func IsSealedString(s string) bool {
+	return strings.HasPrefix(s, TextPrefix)
+}
+
+// Current reports whether sealed, a value or the start of a stream, is
+// wrapped by the primary key.
+func (k *Keyring) Current(sealed []byte) bool {
+	return len(sealed) >= HeaderSize && bytes.Equal(sealed[4:4+idSize], k.keys[0].id)
+}
+
+// Rewrap returns sealed with its data key wrapped by the primary key. The
+// data itself is unchanged. For a stream, pass the first HeaderSize bytes.
+func (k *Keyring) Rewrap(sealed []byte) ([]byte, error) {
+	if len(sealed) < HeaderSize {
+		return nil, ErrMalformed
+	}
+	header := sealed[:HeaderSize]
+	if k.Current(header) {
+		return sealed, nil
+	}
+	dek, err := k.unwrap(header)
+	if err != nil {
+		return nil, err
+	}
+	newHeader, err := k.wrap(header[:4], dek)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(newHeader, sealed[HeaderSize:]...), nil
+}
+
+// RewrapString is Rewrap for a value returned by SealString.
+func (k *Keyring) RewrapString(s string) (string, error) {
+	if !IsSealedString(s) {
+		return "", ErrMalformed
+	}
+	b, err := base64.StdEncoding.DecodeString(s[len(TextPrefix):])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	if k.Current(b) {
+		return s, nil
+	}
+	b, err = k.Rewrap(b)
+	if err != nil {
+		return "", err
+	}
+
+	return TextPrefix + base64.StdEncoding.EncodeToString(b), nil
+}
+
+// CurrentString is Current for a value returned by SealString.
+func (k *Keyring) CurrentString(s string) bool {
+	if !IsSealedString(s) {
+		return false
+	}
+	b, err := base64.StdEncoding.DecodeString(s[len(TextPrefix):])
+	return err == nil && k.Current(b)
+}
+
+// newHeader creates a data key and the header carrying it wrapped by the
+// primary key.
+func (k *Keyring) newHeader(magic []byte) ([]byte, []byte, error) {
+	dek := make([]byte, KeySize)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, nil, fmt.Errorf("seal error: %v", err)
+	}
+	header, err := k.wrap(magic, dek)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dek, header, nil
+}
+
+func (k *Keyring) wrap(magic, dek []byte) ([]byte, error) {
+	primary := k.keys[0]
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("wrap key error: %v", err)
+	}
+
+	header := make([]byte, 0, HeaderSize)
+	header = append(header, magic...)
+	header = append(header, primary.id...)
+	header = append(header, nonce...)
+	return primary.aead.Seal(header, nonce, dek, primary.id), nil
+}
+
+func (k *Keyring) unwrap(header []byte) ([]byte, error) {
+	id := header[4 : 4+idSize]
+	for _, kek := range k.keys {
+		if !bytes.Equal(kek.id, id) {
+			continue
+		}
+		nonce := header[4+idSize : 4+idSize+nonceSize]
+		dek, err := kek.aead.Open(nil, nonce, header[4+idSize+nonceSize:HeaderSize], id)
+		if err != nil {
+			return nil, fmt.Errorf("unwrap key error: %w", ErrMalformed)
+		}
+		return dek, nil
+	}
+
+	return nil, ErrUnknownKey
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,206 @@
+package keyring
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func testKeyring(t *testing.T, n int) (*Keyring, [][]byte) {
+	t.Helper()
+
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = make([]byte, KeySize)
+		rand.Read(keys[i])
+	}
+	k, err := New(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k, keys
+}
+
+func TestParse(t *testing.T) {
+	a, _ := Generate()
+	b, _ := Generate()
+
+	tests := []struct {
+		name    string
+		text    string
+		keys    int
+		wantErr bool
+	}{
+		{"Parse: one key", a, 1, false},
+		{"Parse: file with comments", "# primary\n" + a + "\n\n# old\n" + b + "\n", 2, false},
+		{"Parse: comma separated", a + ", " + b, 2, false},
+		{"Parse: empty", "# nothing\n", 0, true},
+		{"Parse: not base64", "hunter2!", 0, true},
+		{"Parse: short key", "c2hvcnQ=", 0, true},
+		{"Parse: duplicate", a + "\n" + a, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := Parse(tt.text)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error: got %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && len(k.keys) != tt.keys {
+				t.Errorf("keys: got %d want %d", len(k.keys), tt.keys)
+			}
+		})
+	}
+}
+
+func TestKeyring_Seal(t *testing.T) {
+	k, keys := testKeyring(t, 1)
+
+	t.Run("Seal: round trip", func(t *testing.T) {
+		sealed, err := k.SealString("customer 4711 card declined")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealedString(sealed) || strings.Contains(sealed, "4711") {
+			t.Errorf("sealed: %q", sealed)
+		}
+		got, err := k.OpenString(sealed)
+		if err != nil || got != "customer 4711 card declined" {
+			t.Errorf("got %q, %v", got, err)
+		}
+	})
+
+	t.Run("Seal: fresh data key every time", func(t *testing.T) {
+		a, _ := k.Seal([]byte("x"))
+		b, _ := k.Seal([]byte("x"))
+		if bytes.Equal(a, b) {
+			t.Error("sealing twice gave the same output")
+		}
+	})
+
+	t.Run("Seal: tampering is detected", func(t *testing.T) {
+		sealed, _ := k.Seal([]byte("payload"))
+		sealed[len(sealed)-1] ^= 1
+		if _, err := k.Open(sealed); !errors.Is(err, ErrMalformed) {
+			t.Errorf("expected ErrMalformed, got %v", err)
+		}
+		if _, err := k.OpenString("plain text"); !errors.Is(err, ErrMalformed) {
+			t.Errorf("expected ErrMalformed, got %v", err)
+		}
+	})
+
+	t.Run("Seal: unknown key", func(t *testing.T) {
+		other, _ := testKeyring(t, 1)
+		sealed, _ := other.Seal([]byte("payload"))
+		if _, err := k.Open(sealed); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("expected ErrUnknownKey, got %v", err)
+		}
+	})
+
+	t.Run("Seal: rotation", func(t *testing.T) {
+		sealed, _ := k.SealString("payload")
+
+		fresh := make([]byte, KeySize)
+		rand.Read(fresh)
+		rotated, err := New(fresh, keys[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rotated.CurrentString(sealed) {
+			t.Error("value should not be current under the new primary key")
+		}
+		got, err := rotated.OpenString(sealed)
+		if err != nil || got != "payload" {
+			t.Fatalf("old key should still open: %q, %v", got, err)
+		}
+
+		rewrapped, err := rotated.RewrapString(sealed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rotated.CurrentString(rewrapped) {
+			t.Error("rewrapped value should be current")
+		}
+		only, _ := New(fresh)
+		if got, err := only.OpenString(rewrapped); err != nil || got != "payload" {
+			t.Errorf("new key alone: %q, %v", got, err)
+		}
+	})
+}
+
+func TestKeyring_Stream(t *testing.T) {
+	k, keys := testKeyring(t, 1)
+
+	seal := func(t *testing.T, plain []byte) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		w, err := k.NewWriter(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Odd-sized writes cross chunk boundaries.
+		for p := plain; len(p) > 0; {
+			n := min(len(p), 10007)
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	open := func(sealed []byte) ([]byte, error) {
+		r, err := k.NewReader(bytes.NewReader(sealed))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(r)
+	}
+
+	for _, size := range []int{0, 1, chunkSize, chunkSize + 1, 3*chunkSize + 17} {
+		plain := make([]byte, size)
+		rand.Read(plain)
+		sealed := seal(t, plain)
+
+		got, err := open(sealed)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("size %d: round trip failed: %v", size, err)
+		}
+		if size <= chunkSize {
+			continue
+		}
+
+		// Dropping the last chunk must not look like a shorter stream.
+		cut := sealed[:HeaderSize+prefixSize+chunkSize+tagSize]
+		if _, err := open(cut); !errors.Is(err, ErrMalformed) {
+			t.Errorf("size %d: truncation: expected ErrMalformed, got %v", size, err)
+		}
+	}
+
+	t.Run("Stream: rewrap", func(t *testing.T) {
+		plain := []byte("blob content")
+		sealed := seal(t, plain)
+
+		fresh := make([]byte, KeySize)
+		rand.Read(fresh)
+		rotated, _ := New(fresh)
+		both, _ := New(fresh, keys[0])
+		header, err := both.Rewrap(sealed[:HeaderSize])
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := rotated.NewReader(io.MultiReader(bytes.NewReader(header), bytes.NewReader(sealed[HeaderSize:])))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(got, plain) {
+			t.Errorf("got %q, %v", got, err)
+		}
+	})
+}
@@ -0,0 +1,189 @@
+package keyring
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A stream is a header followed by a nonce prefix and the plaintext sealed
+// in chunks. Each chunk's nonce is the prefix, its index and a flag marking
+// the last chunk, so chunks cannot be reordered, dropped or truncated
+// without Read failing.
+const (
+	chunkSize   = 64 << 10
+	prefixSize  = nonceSize - 5
+	lastChunk   = 1
+	maxChunkIdx = 1<<32 - 1
+)
+
+var streamMagic = []byte("RTS1")
+
+type writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	prefix []byte
+	idx    uint64
+	buf    []byte
+	closed bool
+}
+
+// NewWriter returns a writer that seals what is written to it into w. Close
+// must be called to write the last chunk; it does not close w.
+func (k *Keyring) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	dek, header, err := k.newHeader(streamMagic)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, fmt.Errorf("seal stream error: %v", err)
+	}
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("seal stream error: %v", err)
+	}
+	if _, err := w.Write(append(header, prefix...)); err != nil {
+		return nil, err
+	}
+
+	return &writer{w: w, aead: aead, prefix: prefix, buf: make([]byte, 0, chunkSize)}, nil
+}
+
+func (sw *writer) Write(p []byte) (int, error) {
+	if sw.closed {
+		return 0, errors.New("write to closed stream")
+	}
+
+	n := 0
+	for len(p) > 0 {
+		// A full chunk is only flushed once more data arrives, so that
+		// Close always has a last chunk to flag.
+		if len(sw.buf) == chunkSize {
+			if err := sw.flush(false); err != nil {
+				return n, err
+			}
+		}
+		m := copy(sw.buf[len(sw.buf):chunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+m]
+		p = p[m:]
+		n += m
+	}
+
+	return n, nil
+}
+
+func (sw *writer) Close() error {
+	if sw.closed {
+		return nil
+	}
+	sw.closed = true
+
+	return sw.flush(true)
+}
+
+func (sw *writer) flush(last bool) error {
+	if sw.idx > maxChunkIdx {
+		return errors.New("seal stream error: stream too long")
+	}
+	out := sw.aead.Seal(nil, chunkNonce(sw.prefix, sw.idx, last), sw.buf, nil)
+	sw.idx++
+	sw.buf = sw.buf[:0]
+
+	_, err := sw.w.Write(out)
+	return err
+}
+
+type reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	prefix []byte
+	idx    uint64
+	buf    []byte
+	// done is set once the last chunk has been read.
+	done bool
+}
+
+// NewReader returns a reader of the plaintext of a stream written by
+// NewWriter. A stream that was tampered with or cut short fails with
+// ErrMalformed instead of reaching io.EOF.
+func (k *Keyring) NewReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(r, chunkSize+tagSize+1)
+	head := make([]byte, HeaderSize+prefixSize)
+	if _, err := io.ReadFull(br, head); err != nil {
+		return nil, ErrMalformed
+	}
+	if !bytes.HasPrefix(head, streamMagic) {
+		return nil, ErrMalformed
+	}
+	dek, err := k.unwrap(head[:HeaderSize])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dek)
+	if err != nil {
+		return nil, fmt.Errorf("open stream error: %v", err)
+	}
+
+	return &reader{r: br, aead: aead, prefix: head[HeaderSize:]}, nil
+}
+
+func (sr *reader) Read(p []byte) (int, error) {
+	for len(sr.buf) == 0 {
+		if sr.done {
+			return 0, io.EOF
+		}
+		if err := sr.next(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, sr.buf)
+	sr.buf = sr.buf[n:]
+	return n, nil
+}
+
+func (sr *reader) next() error {
+	chunk := make([]byte, chunkSize+tagSize)
+	n, err := io.ReadFull(sr.r, chunk)
+	switch {
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		sr.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := sr.r.Peek(1); errors.Is(err, io.EOF) {
+			sr.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+	if sr.idx > maxChunkIdx {
+		return ErrMalformed
+	}
+
+	plain, err := sr.aead.Open(chunk[:0], chunkNonce(sr.prefix, sr.idx, sr.done), chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("open stream error: %w", ErrMalformed)
+	}
+	sr.idx++
+	sr.buf = plain
+
+	return nil
+}
+
+func chunkNonce(prefix []byte, idx uint64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[prefixSize:], uint32(idx))
+	if last {
+		nonce[nonceSize-1] = lastChunk
+	}
+
+	return nonce
+}
@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Streamer is a Chatter that can hand out its answer while the model is
+// still producing it. OllamaProvider satisfies it.
+type Streamer interface {
+	Chatter
+	ChatStream(ctx context.Context, msgs []Message, opts *CallOptions, onDelta func(Delta)) (string, error)
+}
+
+// StreamChat streams the answer of c to onDelta when c is a Streamer and
+// otherwise hands the whole answer over as a single delta.
+func StreamChat(ctx context.Context, c Chatter, msgs []Message, opts *CallOptions, onDelta func(Delta)) (string, error) {
+	if s, ok := c.(Streamer); ok {
+		return s.ChatStream(ctx, msgs, opts, onDelta)
+	}
+
+	resp, err := c.Chat(ctx, msgs, opts)
+	if err != nil {
+		return "", err
+	}
+	onDelta(Delta{Content: resp})
+
+	return resp, nil
+}
+
+// Stage is a phase of an analysis run.
+type Stage string
+
+const (
+	StageEvidence Stage = "evidence"
+	StageDraft    Stage = "draft"
+	StageCritique Stage = "critique"
+)
+
+// AnalysisEvent reports the progress of RunAnalysis. Stage is set on the
+// event that starts a stage; Step describes a step within the current
+// stage, such as a tool call; Delta carries streamed answer and thinking
+// text of the draft. Only one of them is set per event.
+type AnalysisEvent struct {
+	Stage Stage
+	Step  string
+	Delta Delta
+}
+
+// Analysis describes an RCA run over the evidence of an incident.
+type Analysis struct {
+	// Persona frames the draft; nil is the neutral analyst.
+	Persona  *Persona
+	Evidence []Evidence
+	// Critique has the critic verify the draft once it is written.
+	Critique bool
+	// OnEvent, when set, is called with the progress of the run as it
+	// happens. It must not block.
+	OnEvent func(AnalysisEvent)
+}
+
+// AnalysisResult is a finished run. Evidence is in the order the draft
+// cites it by number.
+type AnalysisResult struct {
+	Draft    string
+	Evidence []string
+	Critique *Critique
+}
+
+// DefaultAnalysisTask is what the model is asked when the caller does not
+// say.
+const DefaultAnalysisTask = "Write a root cause analysis of this incident with the sections " +
+	"Summary, Timeline, Root cause, Contributing factors and Remediation."
+
+// RunAnalysis drafts an RCA for task from the evidence and, when asked,
+// critiques the draft. A failed critique is reported as a step and leaves
+// the result without one. Reasoning the model wraps in <think> blocks is
+// reported as thinking and left out of the draft.
+func RunAnalysis(ctx context.Context, c Chatter, a Analysis, task string) (*AnalysisResult, error) {
+	if c == nil {
+		return nil, errors.New("analysis: nil chat provider")
+	}
+	if strings.TrimSpace(task) == "" {
+		task = DefaultAnalysisTask
+	}
+	emit := a.OnEvent
+	if emit == nil {
+		emit = func(AnalysisEvent) {}
+	}
+
+	emit(AnalysisEvent{Stage: StageEvidence})
+	ordered := a.Persona.OrderEvidence(a.Evidence)
+	res := &AnalysisResult{Evidence: make([]string, 0, len(ordered))}
+	for _, e := range ordered {
+		res.Evidence = append(res.Evidence, fmt.Sprintf("(%s) %s", e.Kind, strings.TrimSpace(e.Content)))
+	}
+	step := fmt.Sprintf("%d evidence items", len(ordered))
+	if len(ordered) == 1 {
+		step = "1 evidence item"
+	}
+	if a.Persona != nil && len(a.Persona.EvidenceTypes) > 0 {
+		step += ", " + strings.Join(a.Persona.EvidenceTypes, ", ") + " first"
+	}
+	emit(AnalysisEvent{Step: step})
+
+	emit(AnalysisEvent{Stage: StageDraft})
+	var (
+		split thinkSplitter
+		draft strings.Builder
+	)
+	onDelta := func(d Delta) {
+		answer, thinking := split.split(d.Content)
+		draft.WriteString(answer)
+		if answer != "" || thinking != "" || d.Thinking != "" {
+			emit(AnalysisEvent{Delta: Delta{Content: answer, Thinking: d.Thinking + thinking}})
+		}
+		for _, tc := range d.ToolCalls {
+			emit(AnalysisEvent{Step: "tool call " + formatToolCall(tc)})
+		}
+	}
+	if _, err := StreamChat(ctx, c, BuildPrompt(a.Persona, task, a.Evidence), nil, onDelta); err != nil {
+		return nil, fmt.Errorf("analysis draft error: %v", err)
+	}
+	answer, thinking := split.flush()
+	draft.WriteString(answer)
+	if answer != "" || thinking != "" {
+		emit(AnalysisEvent{Delta: Delta{Content: answer, Thinking: thinking}})
+	}
+	res.Draft = strings.TrimSpace(draft.String())
+	if res.Draft == "" {
+		return nil, errors.New("analysis: the model returned an empty draft")
+	}
+
+	if !a.Critique {
+		return res, nil
+	}
+	emit(AnalysisEvent{Stage: StageCritique})
+	critique, err := CritiqueDraft(ctx, c, res.Draft, res.Evidence)
+	if err != nil {
+		// The draft stands on its own; it can be critiqued again later.
+		emit(AnalysisEvent{Step: fmt.Sprintf("the claims could not be checked: %v", err)})
+		return res, nil
+	}
+	res.Critique = critique
+	emit(AnalysisEvent{Step: fmt.Sprintf("%d of %d claims flagged", len(critique.Flagged()), len(critique.Claims))})
+
+	return res, nil
+}
+
+func formatToolCall(tc ToolCall) string {
+	args, err := json.Marshal(tc.Function.Arguments)
+	if err != nil || tc.Function.Arguments == nil {
+		args = []byte("{}")
+	}
+
+	return tc.Function.Name + string(args)
+}
+
+// thinkSplitter separates <think> blocks from the answer of a streamed
+// response. A tag may be split across deltas, so the tail that could still
+// become one is held back until the next delta.
+type thinkSplitter struct {
+	thinking bool
+	pending  string
+}
+
+func (s *thinkSplitter) split(chunk string) (answer, thinking string) {
+	var a, t strings.Builder
+	buf := s.pending + chunk
+	s.pending = ""
+	for buf != "" {
+		tag, out := "<think>", &a
+		if s.thinking {
+			tag, out = "</think>", &t
+		}
+		if i := strings.Index(buf, tag); i >= 0 {
+			out.WriteString(buf[:i])
+			buf = buf[i+len(tag):]
+			s.thinking = !s.thinking
+			continue
+		}
+
+		keep := 0
+		for n := min(len(tag)-1, len(buf)); n > 0; n-- {
+			if strings.HasSuffix(buf, tag[:n]) {
+				keep = n
+				break
+			}
+		}
+		out.WriteString(buf[:len(buf)-keep])
+		s.pending = buf[len(buf)-keep:]
+		break
+	}
+
+	return a.String(), t.String()
+}
+
+// flush returns what was held back at the end of the stream.
+func (s *thinkSplitter) flush() (answer, thinking string) {
+	rest := s.pending
+	s.pending = ""
+	if s.thinking {
+		return "", rest
+	}
+
+	return rest, ""
+}
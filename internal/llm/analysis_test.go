@@ -0,0 +1,166 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// streamingChatter streams its draft in the given deltas and answers the
+// critic with critique.
+type streamingChatter struct {
+	deltas   []Delta
+	critique string
+	err      error
+}
+
+func (s *streamingChatter) Chat(ctx context.Context, msgs []Message, opts *CallOptions) (string, error) {
+	return s.critique, nil
+}
+
+func (s *streamingChatter) ChatStream(ctx context.Context, msgs []Message, opts *CallOptions, onDelta func(Delta)) (string, error) {
+	var b strings.Builder
+	for _, d := range s.deltas {
+		onDelta(d)
+		b.WriteString(d.Content)
+	}
+
+	return b.String(), s.err
+}
+
+func TestRunAnalysis(t *testing.T) {
+	evidence := []Evidence{
+		{Kind: "log", Content: "14:02 redis: connection pool exhausted"},
+		{Kind: "deploy", Content: "14:00 payments v2.3.1"},
+	}
+
+	t.Run("RunAnalysis: stages, thinking and draft", func(t *testing.T) {
+		c := &streamingChatter{
+			deltas: []Delta{
+				{Thinking: "pool?"},
+				{Content: "<thi"},
+				{Content: "nk>deploy first</th"},
+				{Content: "ink>Root cause: "},
+				{ToolCalls: []ToolCall{{Function: ToolFunction{Name: "search", Arguments: map[string]any{"q": "redis"}}}}},
+				{Content: "the deploy [1]."},
+			},
+			critique: `{"claims":[{"claim":"the deploy","verdict":"supported","evidence":[1]}]}`,
+		}
+		p := &Persona{EvidenceTypes: []string{"deploy"}}
+
+		var (
+			stages          []Stage
+			steps           []string
+			answer, thought strings.Builder
+		)
+		res, err := RunAnalysis(context.Background(), c, Analysis{
+			Persona:  p,
+			Evidence: evidence,
+			Critique: true,
+			OnEvent: func(e AnalysisEvent) {
+				if e.Stage != "" {
+					stages = append(stages, e.Stage)
+				}
+				if e.Step != "" {
+					steps = append(steps, e.Step)
+				}
+				answer.WriteString(e.Delta.Content)
+				thought.WriteString(e.Delta.Thinking)
+			},
+		}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fmt.Sprint(stages) != "[evidence draft critique]" {
+			t.Errorf("stages: got %v", stages)
+		}
+		want := []string{"2 evidence items, deploy first", `tool call search{"q":"redis"}`, "0 of 1 claims flagged"}
+		if strings.Join(steps, "|") != strings.Join(want, "|") {
+			t.Errorf("steps: got %q want %q", steps, want)
+		}
+		if res.Draft != "Root cause: the deploy [1]." || answer.String() != res.Draft {
+			t.Errorf("draft: got %q, streamed %q", res.Draft, answer.String())
+		}
+		if thought.String() != "pool?deploy first" {
+			t.Errorf("thinking: got %q", thought.String())
+		}
+		if len(res.Evidence) != 2 || !strings.HasPrefix(res.Evidence[0], "(deploy)") {
+			t.Errorf("evidence order: got %q", res.Evidence)
+		}
+		if res.Critique == nil || len(res.Critique.Claims) != 1 {
+			t.Errorf("critique: got %+v", res.Critique)
+		}
+	})
+
+	t.Run("RunAnalysis: without streaming", func(t *testing.T) {
+		c := &fakeChatter{resp: "<think>hmm</think>Pool exhausted [1]."}
+
+		var deltas int
+		res, err := RunAnalysis(context.Background(), c, Analysis{
+			Evidence: evidence,
+			OnEvent: func(e AnalysisEvent) {
+				if e.Delta.Content != "" {
+					deltas++
+				}
+			},
+		}, "Why?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Draft != "Pool exhausted [1]." || deltas != 1 || res.Critique != nil {
+			t.Errorf("got %+v after %d deltas", res, deltas)
+		}
+		if !strings.Contains(c.calls[0][1].Content, "## Task\nWhy?") {
+			t.Errorf("task not in prompt: %q", c.calls[0][1].Content)
+		}
+	})
+
+	t.Run("RunAnalysis: errors", func(t *testing.T) {
+		if _, err := RunAnalysis(context.Background(), nil, Analysis{}, ""); err == nil {
+			t.Error("nil chatter: expected error")
+		}
+		c := &streamingChatter{err: errors.New("model down")}
+		if _, err := RunAnalysis(context.Background(), c, Analysis{}, ""); err == nil || !strings.Contains(err.Error(), "model down") {
+			t.Errorf("stream error: got %v", err)
+		}
+		c = &streamingChatter{deltas: []Delta{{Content: "draft"}}, critique: "no JSON"}
+		res, err := RunAnalysis(context.Background(), c, Analysis{Critique: true}, "")
+		if err != nil || res.Draft != "draft" || res.Critique != nil {
+			t.Errorf("failed critique: got %+v, %v", res, err)
+		}
+		c = &streamingChatter{deltas: []Delta{{Content: "<think>only thinking</think>"}}}
+		if _, err := RunAnalysis(context.Background(), c, Analysis{}, ""); err == nil {
+			t.Error("empty draft: expected error")
+		}
+	})
+}
+
+func TestThinkSplitter(t *testing.T) {
+	var s thinkSplitter
+	var answer, thinking strings.Builder
+	for _, chunk := range []string{"a<", "think", ">b</think", ">c<t", "x"} {
+		a, th := s.split(chunk)
+		answer.WriteString(a)
+		thinking.WriteString(th)
+	}
+	a, th := s.flush()
+	answer.WriteString(a)
+	thinking.WriteString(th)
+
+	if answer.String() != "ac<tx" || thinking.String() != "b" {
+		t.Errorf("got answer %q thinking %q", answer.String(), thinking.String())
+	}
+}
+
+func TestWithModelStreams(t *testing.T) {
+	c := &streamingChatter{deltas: []Delta{{Content: "a"}, {Content: "b"}}}
+
+	var n int
+	got, err := StreamChat(context.Background(), WithModel(c, "llama3"), nil, nil, func(Delta) { n++ })
+	if err != nil || got != "ab" || n != 2 {
+		t.Errorf("got %q, %v after %d deltas", got, err, n)
+	}
+}
@@ -0,0 +1,62 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+const chatPrompt = "Engineers ask you follow-up questions about this incident. Answer in Markdown, " +
+	"keep it short and cite evidence by its [number]."
+
+// BuildChatPrompt assembles the messages for a question asked inside an
+// incident: the persona's system prompt with the incident and its evidence
+// numbered in the persona's preferred order, then the conversation so far,
+// which ends with the question.
+func BuildChatPrompt(p *Persona, incident string, evidence []Evidence, conversation []Message) []Message {
+	var b strings.Builder
+	b.WriteString(p.System())
+	b.WriteString("\n\n")
+	b.WriteString(chatPrompt)
+	b.WriteString("\n\n## Incident\n")
+	b.WriteString(strings.TrimSpace(incident))
+	b.WriteString("\n\n## Evidence\n")
+	ordered := p.OrderEvidence(evidence)
+	if len(ordered) == 0 {
+		b.WriteString("(no evidence provided)\n")
+	}
+	for i, e := range ordered {
+		fmt.Fprintf(&b, "[%d] (%s) %s\n", i+1, e.Kind, strings.TrimSpace(e.Content))
+	}
+
+	msgs := make([]Message, 0, len(conversation)+1)
+	msgs = append(msgs, Message{Role: RoleSystem, Content: b.String()})
+	return append(msgs, conversation...)
+}
+
+// StreamAnswer streams the answer of c to msgs to onDelta like StreamChat,
+// but reports reasoning the model wraps in <think> blocks as thinking and
+// leaves it out of the returned answer.
+func StreamAnswer(ctx context.Context, c Chatter, msgs []Message, onDelta func(Delta)) (string, error) {
+	var (
+		split  thinkSplitter
+		answer strings.Builder
+	)
+	emit := func(content, thinking string) {
+		answer.WriteString(content)
+		if content != "" || thinking != "" {
+			onDelta(Delta{Content: content, Thinking: thinking})
+		}
+	}
+
+	_, err := StreamChat(ctx, c, msgs, nil, func(d Delta) {
+		content, thinking := split.split(d.Content)
+		emit(content, d.Thinking+thinking)
+	})
+	if err != nil {
+		return "", err
+	}
+	emit(split.flush())
+
+	return strings.TrimSpace(answer.String()), nil
+}
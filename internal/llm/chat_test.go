@@ -0,0 +1,49 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBuildChatPrompt(t *testing.T) {
+	p := &Persona{SystemPrompt: "You are an SRE.", EvidenceTypes: []string{"deploy"}}
+	msgs := BuildChatPrompt(p, "Redis timeouts", []Evidence{
+		{Kind: "log", Content: "redis: pool exhausted"},
+		{Kind: "deploy", Content: "14:00 payments v2.3.1"},
+	}, []Message{
+		{Role: RoleUser, Content: "what changed?"},
+		{Role: RoleAssistant, Content: "a deploy [1]"},
+		{Role: RoleUser, Content: "which one?"},
+	})
+
+	if len(msgs) != 4 || msgs[0].Role != RoleSystem || msgs[3].Content != "which one?" {
+		t.Fatalf("unexpected messages: %+v", msgs)
+	}
+	sys := msgs[0].Content
+	for _, want := range []string{"You are an SRE.", "## Incident\nRedis timeouts", "[1] (deploy) 14:00 payments v2.3.1\n[2] (log)"} {
+		if !strings.Contains(sys, want) {
+			t.Errorf("missing %q in system prompt:\n%s", want, sys)
+		}
+	}
+
+	if msgs := BuildChatPrompt(nil, "x", nil, nil); !strings.Contains(msgs[0].Content, "(no evidence provided)") {
+		t.Errorf("no evidence: got %q", msgs[0].Content)
+	}
+}
+
+func TestStreamAnswer(t *testing.T) {
+	c := &streamingChatter{deltas: []Delta{{Content: "<think>dep"}, {Content: "loy?</think>The "}, {Content: "deploy [1]. "}}}
+
+	var answer, thinking strings.Builder
+	got, err := StreamAnswer(context.Background(), c, nil, func(d Delta) {
+		answer.WriteString(d.Content)
+		thinking.WriteString(d.Thinking)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "The deploy [1]." || answer.String() != "The deploy [1]. " || thinking.String() != "deploy?" {
+		t.Errorf("got %q, streamed %q, thinking %q", got, answer.String(), thinking.String())
+	}
+}
@@ -0,0 +1,190 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Chatter is anything that can answer a chat conversation. OllamaProvider
+// satisfies it; tests substitute a fake.
+type Chatter interface {
+	Chat(ctx context.Context, msgs []Message, opts *CallOptions) (string, error)
+}
+
+// WithModel returns a Chatter that asks c for model on every call that does
+// not name a model of its own. An empty model returns c as it is.
+func WithModel(c Chatter, model string) Chatter {
+	if c == nil || model == "" {
+		return c
+	}
+
+	return modelChatter{c: c, model: model}
+}
+
+type modelChatter struct {
+	c     Chatter
+	model string
+}
+
+func (m modelChatter) Chat(ctx context.Context, msgs []Message, opts *CallOptions) (string, error) {
+	o := CallOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Model == "" {
+		o.Model = m.model
+	}
+
+	return m.c.Chat(ctx, msgs, &o)
+}
+
+func (m modelChatter) ChatStream(ctx context.Context, msgs []Message, opts *CallOptions, onDelta func(Delta)) (string, error) {
+	o := CallOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	if o.Model == "" {
+		o.Model = m.model
+	}
+
+	return StreamChat(ctx, m.c, msgs, &o, onDelta)
+}
+
+type Verdict string
+
+const (
+	VerdictSupported    Verdict = "supported"
+	VerdictContradicted Verdict = "contradicted"
+	VerdictUnsupported  Verdict = "unsupported"
+)
+
+// ClaimReview is the critic's judgement of a single claim in a draft RCA.
+// Evidence holds the 1-based indices of the evidence items the critic cited.
+type ClaimReview struct {
+	Claim     string  `json:"claim"`
+	Verdict   Verdict `json:"verdict"`
+	Evidence  []int   `json:"evidence,omitempty"`
+	Rationale string  `json:"rationale,omitempty"`
+}
+
+type Critique struct {
+	Claims []ClaimReview `json:"claims"`
+}
+
+// Flagged returns the claims that are not backed by the evidence, i.e. the
+// ones a reviewer has to look at before publishing.
+func (c *Critique) Flagged() []ClaimReview {
+	var out []ClaimReview
+	for _, cl := range c.Claims {
+		if cl.Verdict != VerdictSupported {
+			out = append(out, cl)
+		}
+	}
+
+	return out
+}
+
+const criticSystemPrompt = `You are a strict reviewer of root cause analysis (RCA) drafts.
+You are given a draft RCA and a numbered list of evidence items.
+Split the draft into individual factual claims and judge each claim ONLY against the evidence:
+- "supported": at least one evidence item directly backs the claim.
+- "contradicted": at least one evidence item conflicts with the claim.
+- "unsupported": no evidence item backs or contradicts the claim.
+Do not use outside knowledge and do not give the draft the benefit of the doubt.
+Respond with JSON only, in this exact shape:
+{"claims":[{"claim":"...","verdict":"supported|contradicted|unsupported","evidence":[1],"rationale":"..."}]}`
+
+// CritiqueDraft asks the model to verify every claim in draft against evidence.
+// It uses its own system prompt so the verifier does not share the
+// generator's framing.
+func CritiqueDraft(ctx context.Context, c Chatter, draft string, evidence []string) (*Critique, error) {
+	if c == nil {
+		return nil, errors.New("critique: nil chat provider")
+	}
+	if strings.TrimSpace(draft) == "" {
+		return nil, errors.New("critique: empty draft")
+	}
+
+	msgs := []Message{
+		{Role: RoleSystem, Content: criticSystemPrompt},
+		{Role: RoleUser, Content: buildCritiquePrompt(draft, evidence)},
+	}
+
+	resp, err := c.Chat(ctx, msgs, &CallOptions{Format: "json"})
+	if err != nil {
+		return nil, fmt.Errorf("critique chat error: %v", err)
+	}
+
+	return parseCritique(resp, len(evidence))
+}
+
+func buildCritiquePrompt(draft string, evidence []string) string {
+	var b strings.Builder
+	b.WriteString("## Evidence\n")
+	if len(evidence) == 0 {
+		b.WriteString("(no evidence provided)\n")
+	}
+	for i, e := range evidence {
+		fmt.Fprintf(&b, "[%d] %s\n", i+1, strings.TrimSpace(e))
+	}
+	b.WriteString("\n## Draft RCA\n")
+	b.WriteString(strings.TrimSpace(draft))
+	b.WriteString("\n")
+
+	return b.String()
+}
+
+var thinkBlock = regexp.MustCompile(`(?s)<think>.*?</think>`)
+
+// parseCritique extracts the JSON object from a model response. Reasoning
+// models wrap their answer in <think> blocks or code fences, so everything
+// outside the outermost braces is discarded. Unknown verdicts and evidence
+// references that point outside the evidence set are treated as unsupported.
+func parseCritique(resp string, evidenceCount int) (*Critique, error) {
+	resp = thinkBlock.ReplaceAllString(resp, "")
+	start := strings.Index(resp, "{")
+	end := strings.LastIndex(resp, "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("critique response has no JSON object: %q", resp)
+	}
+
+	var raw Critique
+	if err := json.Unmarshal([]byte(resp[start:end+1]), &raw); err != nil {
+		return nil, fmt.Errorf("critique response decode error: %v", err)
+	}
+
+	out := &Critique{Claims: make([]ClaimReview, 0, len(raw.Claims))}
+	for _, cl := range raw.Claims {
+		cl.Claim = strings.TrimSpace(cl.Claim)
+		if cl.Claim == "" {
+			continue
+		}
+
+		refs := cl.Evidence[:0]
+		for _, n := range cl.Evidence {
+			if n >= 1 && n <= evidenceCount {
+				refs = append(refs, n)
+			}
+		}
+		cl.Evidence = refs
+
+		switch v := Verdict(strings.ToLower(strings.TrimSpace(string(cl.Verdict)))); v {
+		case VerdictSupported, VerdictContradicted:
+			cl.Verdict = v
+			// A verdict that cites nothing cannot be trusted.
+			if len(cl.Evidence) == 0 {
+				cl.Verdict = VerdictUnsupported
+			}
+		default:
+			cl.Verdict = VerdictUnsupported
+		}
+
+		out.Claims = append(out.Claims, cl)
+	}
+
+	return out, nil
+}
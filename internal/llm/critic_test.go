@@ -0,0 +1,148 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeChatter struct {
+	resp  string
+	err   error
+	calls [][]Message
+	opts  []*CallOptions
+}
+
+func (f *fakeChatter) Chat(ctx context.Context, msgs []Message, opts *CallOptions) (string, error) {
+	f.calls = append(f.calls, msgs)
+	f.opts = append(f.opts, opts)
+	return f.resp, f.err
+}
+
+func TestCritique(t *testing.T) {
+	evidence := []string{
+		"14:02 redis: connection pool exhausted (max=50)",
+		"14:05 deploy payments v2.3.1 rolled out",
+	}
+
+	t.Run("parses verdicts and uses critic prompt", func(t *testing.T) {
+		fc := &fakeChatter{resp: `<think>checking claims</think>` + "```json\n" + `{"claims":[
+			{"claim":"Redis pool was exhausted","verdict":"supported","evidence":[1],"rationale":"line 1"},
+			{"claim":"The deploy happened at 13:00","verdict":"Contradicted","evidence":[2]},
+			{"claim":"A kernel bug caused it","verdict":"unsupported"}
+		]}` + "\n```"}
+
+		got, err := CritiqueDraft(context.Background(), fc, "draft text", evidence)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		want := []ClaimReview{
+			{Claim: "Redis pool was exhausted", Verdict: VerdictSupported, Evidence: []int{1}, Rationale: "line 1"},
+			{Claim: "The deploy happened at 13:00", Verdict: VerdictContradicted, Evidence: []int{2}},
+			{Claim: "A kernel bug caused it", Verdict: VerdictUnsupported},
+		}
+		if !reflect.DeepEqual(got.Claims, want) {
+			t.Fatalf("claims: got %+v want %+v", got.Claims, want)
+		}
+
+		if len(fc.calls) != 1 {
+			t.Fatalf("calls: got %d want 1", len(fc.calls))
+		}
+		msgs := fc.calls[0]
+		if msgs[0].Role != RoleSystem || msgs[0].Content != criticSystemPrompt {
+			t.Errorf("system prompt not the critic prompt: %+v", msgs[0])
+		}
+		if !strings.Contains(msgs[1].Content, "[2] 14:05 deploy payments") {
+			t.Errorf("evidence not numbered in prompt: %q", msgs[1].Content)
+		}
+		if fc.opts[0] == nil || fc.opts[0].Format != "json" {
+			t.Errorf("expected json format option, got %+v", fc.opts[0])
+		}
+	})
+
+	t.Run("verdict without valid citation is unsupported", func(t *testing.T) {
+		fc := &fakeChatter{resp: `{"claims":[
+			{"claim":"a","verdict":"supported","evidence":[7]},
+			{"claim":"b","verdict":"maybe","evidence":[1]},
+			{"claim":"  ","verdict":"supported","evidence":[1]}
+		]}`}
+
+		got, err := CritiqueDraft(context.Background(), fc, "draft", evidence)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(got.Claims) != 2 {
+			t.Fatalf("claims: got %d want 2", len(got.Claims))
+		}
+		for _, cl := range got.Claims {
+			if cl.Verdict != VerdictUnsupported {
+				t.Errorf("claim %q: got %q want %q", cl.Claim, cl.Verdict, VerdictUnsupported)
+			}
+		}
+		if len(got.Flagged()) != 2 {
+			t.Errorf("flagged: got %d want 2", len(got.Flagged()))
+		}
+	})
+
+	t.Run("empty draft", func(t *testing.T) {
+		if _, err := CritiqueDraft(context.Background(), &fakeChatter{}, " ", evidence); err == nil {
+			t.Fatal("expected error for empty draft")
+		}
+	})
+
+	t.Run("chat error", func(t *testing.T) {
+		fc := &fakeChatter{err: errors.New("model down")}
+		_, err := CritiqueDraft(context.Background(), fc, "draft", evidence)
+		if err == nil || !strings.Contains(err.Error(), "model down") {
+			t.Fatalf("expected wrapped chat error, got %v", err)
+		}
+	})
+
+	t.Run("response without json", func(t *testing.T) {
+		fc := &fakeChatter{resp: "I think it is fine."}
+		if _, err := CritiqueDraft(context.Background(), fc, "draft", evidence); err == nil {
+			t.Fatal("expected error for non-json response")
+		}
+	})
+}
+
+func TestWithModel(t *testing.T) {
+	t.Run("sets the model when none is given", func(t *testing.T) {
+		fc := &fakeChatter{}
+		c := WithModel(fc, "llama3:70b")
+		if _, err := c.Chat(context.Background(), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Chat(context.Background(), nil, &CallOptions{Format: "json"}); err != nil {
+			t.Fatal(err)
+		}
+		for i, o := range fc.opts {
+			if o == nil || o.Model != "llama3:70b" {
+				t.Errorf("call %d: unexpected options %+v", i, o)
+			}
+		}
+		if fc.opts[1].Format != "json" {
+			t.Errorf("format lost: %+v", fc.opts[1])
+		}
+	})
+
+	t.Run("keeps an explicit model", func(t *testing.T) {
+		fc := &fakeChatter{}
+		if _, err := WithModel(fc, "a").Chat(context.Background(), nil, &CallOptions{Model: "b"}); err != nil {
+			t.Fatal(err)
+		}
+		if fc.opts[0].Model != "b" {
+			t.Errorf("model: got %q want b", fc.opts[0].Model)
+		}
+	})
+
+	t.Run("empty model", func(t *testing.T) {
+		fc := &fakeChatter{}
+		if c := WithModel(fc, ""); c != Chatter(fc) {
+			t.Error("expected the chatter unchanged")
+		}
+	})
+}
@@ -0,0 +1,162 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Agent is a role-conditioned participant in a debate.
+type Agent struct {
+	Name         string
+	SystemPrompt string
+}
+
+// AgentJudge synthesizes the verdict when a debate does not set its own
+// judge. Participants are usually built from personas with Persona.Agent.
+var AgentJudge = Agent{
+	Name: "Judge",
+	SystemPrompt: "You are an impartial incident commander. You weigh competing root cause " +
+		"hypotheses strictly on the evidence and the quality of the arguments.",
+}
+
+const (
+	DefaultDebateRounds = 2
+	MaxDebateRounds     = 5
+)
+
+type DebateTurn struct {
+	Round   int
+	Agent   string
+	Content string
+}
+
+// Debate describes a multi-agent debate. Round 0 is the opening proposal of
+// every agent; each following round lets every agent critique the others and
+// revise its own hypothesis. The judge synthesizes the final verdict from the
+// full transcript.
+type Debate struct {
+	Agents []Agent
+	Judge  Agent
+	Rounds int
+	// OnTurn, when set, is called after every completed turn so the caller
+	// can persist the transcript while the debate is still running.
+	OnTurn func(DebateTurn) error
+}
+
+type DebateResult struct {
+	Turns   []DebateTurn
+	Verdict string
+}
+
+func RunDebate(ctx context.Context, c Chatter, d Debate, problem string) (*DebateResult, error) {
+	if c == nil {
+		return nil, errors.New("debate: nil chat provider")
+	}
+	if strings.TrimSpace(problem) == "" {
+		return nil, errors.New("debate: empty problem statement")
+	}
+	if len(d.Agents) < 2 {
+		return nil, errors.New("debate: at least two agents are required")
+	}
+	if d.Rounds < 0 || d.Rounds > MaxDebateRounds {
+		return nil, fmt.Errorf("debate: rounds must be between 0 and %d", MaxDebateRounds)
+	}
+	if d.Judge.SystemPrompt == "" {
+		d.Judge = AgentJudge
+	}
+	if d.Judge.Name == "" {
+		d.Judge.Name = AgentJudge.Name
+	}
+
+	res := &DebateResult{}
+	record := func(t DebateTurn) error {
+		res.Turns = append(res.Turns, t)
+		if d.OnTurn != nil {
+			return d.OnTurn(t)
+		}
+		return nil
+	}
+
+	latest := make(map[string]string, len(d.Agents))
+	for round := 0; round <= d.Rounds; round++ {
+		// Every agent in a round answers the positions of the previous
+		// round, so the order of agents within a round does not matter.
+		next := make(map[string]string, len(d.Agents))
+		for _, a := range d.Agents {
+			msgs := []Message{
+				{Role: RoleSystem, Content: a.SystemPrompt},
+				{Role: RoleUser, Content: debatePrompt(a, round, problem, d.Agents, latest)},
+			}
+
+			resp, err := c.Chat(ctx, msgs, nil)
+			if err != nil {
+				return res, fmt.Errorf("debate round %d agent %s error: %v", round, a.Name, err)
+			}
+
+			resp = strings.TrimSpace(thinkBlock.ReplaceAllString(resp, ""))
+			next[a.Name] = resp
+			if err := record(DebateTurn{Round: round, Agent: a.Name, Content: resp}); err != nil {
+				return res, err
+			}
+		}
+		latest = next
+	}
+
+	msgs := []Message{
+		{Role: RoleSystem, Content: d.Judge.SystemPrompt},
+		{Role: RoleUser, Content: judgePrompt(problem, res.Turns)},
+	}
+	verdict, err := c.Chat(ctx, msgs, nil)
+	if err != nil {
+		return res, fmt.Errorf("debate judge error: %v", err)
+	}
+
+	res.Verdict = strings.TrimSpace(thinkBlock.ReplaceAllString(verdict, ""))
+	if err := record(DebateTurn{Round: d.Rounds + 1, Agent: d.Judge.Name, Content: res.Verdict}); err != nil {
+		return res, err
+	}
+
+	return res, nil
+}
+
+func debatePrompt(self Agent, round int, problem string, agents []Agent, latest map[string]string) string {
+	var b strings.Builder
+	b.WriteString("## Incident\n")
+	b.WriteString(strings.TrimSpace(problem))
+	b.WriteString("\n\n")
+
+	if round == 0 {
+		b.WriteString("Propose the single most likely root cause from your perspective. " +
+			"Cite the evidence you rely on and state what would prove you wrong.")
+		return b.String()
+	}
+
+	fmt.Fprintf(&b, "## Positions after round %d\n", round-1)
+	for _, a := range agents {
+		label := a.Name
+		if a.Name == self.Name {
+			label += " (you)"
+		}
+		fmt.Fprintf(&b, "### %s\n%s\n\n", label, latest[a.Name])
+	}
+	b.WriteString("Critique the other positions where the evidence does not support them, " +
+		"then restate your root cause hypothesis, revised if you were persuaded.")
+
+	return b.String()
+}
+
+func judgePrompt(problem string, turns []DebateTurn) string {
+	var b strings.Builder
+	b.WriteString("## Incident\n")
+	b.WriteString(strings.TrimSpace(problem))
+	b.WriteString("\n\n## Debate transcript\n")
+	for _, t := range turns {
+		fmt.Fprintf(&b, "### Round %d - %s\n%s\n\n", t.Round, t.Agent, t.Content)
+	}
+	b.WriteString("Synthesize the most likely root cause. Say which hypotheses you rejected and why, " +
+		"and list the open questions that the evidence cannot settle.")
+
+	return b.String()
+}
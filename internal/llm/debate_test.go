@@ -0,0 +1,123 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// scriptedChatter answers with "<agent>:<call#>" so tests can tell which
+// agent produced which turn.
+type scriptedChatter struct {
+	calls  int
+	failAt int
+	prompt []string
+}
+
+func (s *scriptedChatter) Chat(ctx context.Context, msgs []Message, opts *CallOptions) (string, error) {
+	s.calls++
+	if s.failAt == s.calls {
+		return "", errors.New("model down")
+	}
+	s.prompt = append(s.prompt, msgs[len(msgs)-1].Content)
+
+	return fmt.Sprintf("<think>hmm</think>%s:%d", strings.Fields(msgs[0].Content)[3], s.calls), nil
+}
+
+func TestRunDebate(t *testing.T) {
+	agents := []Agent{
+		{Name: "A", SystemPrompt: "You are a alpha agent."},
+		{Name: "B", SystemPrompt: "You are a bravo agent."},
+	}
+	judge := Agent{Name: "J", SystemPrompt: "You are a judge agent."}
+
+	t.Run("runs rounds and judge", func(t *testing.T) {
+		sc := &scriptedChatter{}
+		var persisted []DebateTurn
+		d := Debate{
+			Agents: agents,
+			Judge:  judge,
+			Rounds: 2,
+			OnTurn: func(t DebateTurn) error {
+				persisted = append(persisted, t)
+				return nil
+			},
+		}
+
+		res, err := RunDebate(context.Background(), sc, d, "checkout is timing out")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// 2 agents * 3 rounds (opening + 2) + judge
+		if sc.calls != 7 {
+			t.Fatalf("calls: got %d want 7", sc.calls)
+		}
+		if len(res.Turns) != 7 || len(persisted) != 7 {
+			t.Fatalf("turns: got %d persisted %d want 7", len(res.Turns), len(persisted))
+		}
+		if res.Turns[0].Round != 0 || res.Turns[6].Round != 3 || res.Turns[6].Agent != judge.Name {
+			t.Errorf("unexpected turn layout: %+v", res.Turns)
+		}
+		if res.Verdict != "judge:7" {
+			t.Errorf("verdict: got %q want %q", res.Verdict, "judge:7")
+		}
+		if strings.Contains(res.Turns[0].Content, "<think>") {
+			t.Errorf("think block not stripped: %q", res.Turns[0].Content)
+		}
+
+		// Round 1 prompts see round 0 positions.
+		if !strings.Contains(sc.prompt[2], "alpha:1") || !strings.Contains(sc.prompt[2], "bravo:2") {
+			t.Errorf("round 1 prompt missing previous positions: %q", sc.prompt[2])
+		}
+		if !strings.Contains(sc.prompt[6], "Round 2 - B") {
+			t.Errorf("judge prompt missing transcript: %q", sc.prompt[6])
+		}
+	})
+
+	t.Run("invalid setup", func(t *testing.T) {
+		sc := &scriptedChatter{}
+		if _, err := RunDebate(context.Background(), sc, Debate{Agents: agents[:1]}, "x"); err == nil {
+			t.Error("expected error for a single agent")
+		}
+		if _, err := RunDebate(context.Background(), sc, Debate{Agents: agents, Rounds: MaxDebateRounds + 1}, "x"); err == nil {
+			t.Error("expected error for too many rounds")
+		}
+		if _, err := RunDebate(context.Background(), sc, Debate{Agents: agents}, " "); err == nil {
+			t.Error("expected error for empty problem")
+		}
+		if sc.calls != 0 {
+			t.Errorf("calls: got %d want 0", sc.calls)
+		}
+	})
+
+	t.Run("chat error keeps partial transcript", func(t *testing.T) {
+		sc := &scriptedChatter{failAt: 3}
+		res, err := RunDebate(context.Background(), sc, Debate{Agents: agents, Judge: judge, Rounds: 1}, "x")
+		if err == nil || !strings.Contains(err.Error(), "model down") {
+			t.Fatalf("expected chat error, got %v", err)
+		}
+		if res == nil || len(res.Turns) != 2 {
+			t.Fatalf("expected 2 partial turns, got %+v", res)
+		}
+	})
+	t.Run("verdict recorded under the judge's name", func(t *testing.T) {
+		for _, tc := range []struct {
+			judge Agent
+			want  string
+		}{
+			{Agent{}, AgentJudge.Name},
+			{Agent{SystemPrompt: "You are a judge agent."}, AgentJudge.Name},
+		} {
+			res, err := RunDebate(context.Background(), &scriptedChatter{}, Debate{Agents: agents, Judge: tc.judge}, "x")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := res.Turns[len(res.Turns)-1].Agent; got != tc.want {
+				t.Errorf("judge %+v: got %q want %q", tc.judge, got, tc.want)
+			}
+		}
+	})
+}
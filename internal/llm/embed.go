@@ -0,0 +1,82 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultEmbeddingModel is used when no embedding model is configured.
+const DefaultEmbeddingModel = "nomic-embed-text"
+
+type ollamaEmbedRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+type ollamaEmbedResponse struct {
+	Embeddings [][]float32 `json:"embeddings"`
+	Error      string      `json:"error,omitempty"`
+}
+
+// Embed returns one embedding per input, in order, computed by model or by
+// DefaultEmbeddingModel when model is empty.
+func (p *OllamaProvider) Embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	if len(input) == 0 {
+		return nil, nil
+	}
+	if model == "" {
+		model = DefaultEmbeddingModel
+	}
+
+	start := time.Now()
+	embeddings, err := p.embed(ctx, model, input)
+	logCall(ctx, "embed", model, start, err)
+
+	return embeddings, err
+}
+
+func (p *OllamaProvider) embed(ctx context.Context, model string, input []string) ([][]float32, error) {
+	b, err := json.Marshal(ollamaEmbedRequest{Model: model, Input: input})
+	if err != nil {
+		return nil, fmt.Errorf("ollama embed marshal error: %v", err)
+	}
+
+	url, err := url.JoinPath(p.BaseURL(), "/api/embed")
+	if err != nil {
+		return nil, fmt.Errorf("ollama embed build url error: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("ollama embed create request error: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ollama embed request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var parsed ollamaEmbedResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("ollama embed decode error: %v (status %d)", err, resp.StatusCode)
+	}
+	if parsed.Error != "" {
+		return nil, fmt.Errorf("ollama returned error: %v", parsed.Error)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("ollama response returned status code: %d", resp.StatusCode)
+	}
+	if len(parsed.Embeddings) != len(input) {
+		return nil, fmt.Errorf("ollama embed returned %d embeddings for %d inputs", len(parsed.Embeddings), len(input))
+	}
+
+	return parsed.Embeddings, nil
+}
@@ -0,0 +1,83 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOllamaProvider_Embed(t *testing.T) {
+	respond := func(status int, body string) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Body:       io.NopCloser(strings.NewReader(body)),
+			Header:     make(http.Header),
+		}
+	}
+
+	t.Run("Embed: success", func(t *testing.T) {
+		p := newProviderWithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.URL.Path != "/api/embed" {
+				t.Errorf("path got %q want %q", r.URL.Path, "/api/embed")
+			}
+			var req ollamaEmbedRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				t.Fatal(err)
+			}
+			if req.Model != DefaultEmbeddingModel || !reflect.DeepEqual(req.Input, []string{"a", "b"}) {
+				t.Errorf("unexpected request: %+v", req)
+			}
+			return respond(http.StatusOK, `{"embeddings":[[0.1,0.2],[0.3,0.4]]}`), nil
+		}))
+
+		got, err := p.Embed(context.Background(), "", []string{"a", "b"})
+		if err != nil {
+			t.Fatalf("failed to embed: %v", err)
+		}
+		want := [][]float32{{0.1, 0.2}, {0.3, 0.4}}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("Embed: no input", func(t *testing.T) {
+		p := newProviderWithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			t.Fatal("Round Trip should not be called without input")
+			return nil, nil
+		}))
+
+		if got, err := p.Embed(context.Background(), "m", nil); err != nil || got != nil {
+			t.Errorf("got %v, %v", got, err)
+		}
+	})
+
+	t.Run("Embed: errors", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			status  int
+			body    string
+			wantErr string
+		}{
+			{"model missing", http.StatusNotFound, `{"error":"model \"x\" not found"}`, "ollama returned error"},
+			{"bad status", http.StatusBadGateway, `{}`, "status code: 502"},
+			{"count mismatch", http.StatusOK, `{"embeddings":[[1]]}`, "1 embeddings for 2 inputs"},
+			{"bad body", http.StatusOK, `nope`, "decode error"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				p := newProviderWithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+					return respond(tt.status, tt.body), nil
+				}))
+
+				_, err := p.Embed(context.Background(), "x", []string{"a", "b"})
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got %v want %q", err, tt.wantErr)
+				}
+			})
+		}
+	})
+}
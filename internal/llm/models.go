@@ -0,0 +1,82 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ModelLister is a backend that can tell which models it has installed.
+type ModelLister interface {
+	ListModels(ctx context.Context) ([]string, error)
+}
+
+type ollamaTagsResponse struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// ListModels returns the names of the models installed on the server. A
+// successful answer also shows the server is reachable.
+func (p *OllamaProvider) ListModels(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	models, err := p.listModels(ctx)
+	logCall(ctx, "list models", "", start, err)
+
+	return models, err
+}
+
+func (p *OllamaProvider) listModels(ctx context.Context) ([]string, error) {
+	url, err := url.JoinPath(p.BaseURL(), "/api/tags")
+	if err != nil {
+		return nil, fmt.Errorf("ollama tags build url error: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("ollama tags create request error: %v", err)
+	}
+	setRequestID(ctx, req)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("ollama tags request error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("ollama response returned status code: %d", resp.StatusCode)
+	}
+
+	var parsed ollamaTagsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("ollama tags decode error: %v", err)
+	}
+	models := make([]string, 0, len(parsed.Models))
+	for _, m := range parsed.Models {
+		models = append(models, m.Name)
+	}
+
+	return models, nil
+}
+
+// HasModel reports whether name is among models. A name without a tag
+// means the latest tag, as it does to Ollama.
+func HasModel(models []string, name string) bool {
+	return slices.ContainsFunc(models, func(m string) bool {
+		return withTag(m) == withTag(name)
+	})
+}
+
+func withTag(name string) string {
+	if strings.Contains(name, ":") {
+		return name
+	}
+	return name + ":latest"
+}
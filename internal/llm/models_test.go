@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestOllamaProvider_ListModels(t *testing.T) {
+	t.Run("ListModels: installed", func(t *testing.T) {
+		p := newProviderWithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			if r.Method != http.MethodGet || r.URL.Path != "/api/tags" {
+				t.Fatalf("request: got %s %s", r.Method, r.URL.Path)
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`{"models":[{"name":"deepseek-r1:8b"},{"name":"nomic-embed-text:latest"}]}`)),
+				Header:     make(http.Header),
+			}, nil
+		}))
+
+		got, err := p.ListModels(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"deepseek-r1:8b", "nomic-embed-text:latest"}; !slices.Equal(got, want) {
+			t.Errorf("got %v want %v", got, want)
+		}
+	})
+
+	t.Run("ListModels: server error", func(t *testing.T) {
+		p := newProviderWithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: 502, Body: io.NopCloser(strings.NewReader("")), Header: make(http.Header)}, nil
+		}))
+
+		if _, err := p.ListModels(context.Background()); err == nil || !strings.Contains(err.Error(), "502") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func TestHasModel(t *testing.T) {
+	models := []string{"deepseek-r1:8b", "nomic-embed-text:latest"}
+	tests := []struct {
+		name  string
+		model string
+		want  bool
+	}{
+		{"HasModel: exact", "deepseek-r1:8b", true},
+		{"HasModel: latest implied", "nomic-embed-text", true},
+		{"HasModel: other tag", "deepseek-r1:70b", false},
+		{"HasModel: latest not installed", "deepseek-r1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasModel(models, tt.model); got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
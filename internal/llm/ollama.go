@@ -8,16 +8,25 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
+
+	"github.com/dtoebe/RootTensor/internal/requestid"
 )
 
 type OllamaProvider struct {
-	baseURL string
-	model   string
-	client  *http.Client
+	// mu guards the settings below, which Configure changes while requests
+	// are in flight.
+	mu          sync.RWMutex
+	baseURL     string
+	model       string
+	temperature float32
+
+	client *http.Client
 }
 
 type Role string
@@ -70,16 +79,58 @@ func IsURL(webURL string) bool {
 }
 
 func (p *OllamaProvider) Model() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.model
 }
 
 func (p *OllamaProvider) BaseURL() string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 	return p.baseURL
 }
 
+// Configure points the provider at another server, default model and
+// default temperature. Requests already sent finish as they were; empty or
+// invalid values keep the current setting, and a temperature of 0 leaves
+// it to the model.
+func (p *OllamaProvider) Configure(baseURL, model string, temperature float32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if IsURL(baseURL) {
+		p.baseURL = baseURL
+	}
+	if model != "" {
+		p.model = model
+	}
+	p.temperature = temperature
+}
+
 type Message struct {
 	Role    Role   `json:"role"`
 	Content string `json:"content"`
+	// Thinking is the reasoning a thinking model gives before its answer.
+	Thinking string `json:"thinking,omitempty"`
+	// ToolCalls are the tools the model asks to call instead of answering.
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+}
+
+// ToolCall is one call of a tool requested by the model.
+type ToolCall struct {
+	Function ToolFunction `json:"function"`
+}
+
+type ToolFunction struct {
+	Name      string         `json:"name"`
+	Arguments map[string]any `json:"arguments"`
+}
+
+// Delta is one piece of a streamed answer.
+type Delta struct {
+	Content   string
+	Thinking  string
+	ToolCalls []ToolCall
 }
 
 type CallOptions struct {
@@ -87,6 +138,8 @@ type CallOptions struct {
 	MaxTokens   int
 	Stream      bool
 	Model       string
+	// Format constrains the response, e.g. "json".
+	Format string
 }
 
 type ollamaChatRequest struct {
@@ -94,13 +147,13 @@ type ollamaChatRequest struct {
 	Messages []Message      `json:"messages"`
 	Stream   bool           `json:"stream"`
 	Options  map[string]any `json:"options,omitempty"`
+	Format   string         `json:"format,omitempty"`
 }
 
 type ollamaChatResponse struct {
 	Message Message `json:"message"`
 	Error   string  `json:"error,omitempty"`
 	Done    bool    `json:"done"`
-	Content string  `json:"content,omitempty"`
 }
 
 func (p *OllamaProvider) Chat(
@@ -109,19 +162,13 @@ func (p *OllamaProvider) Chat(
 	opts *CallOptions,
 ) (string, error) {
 	if opts != nil && opts.Stream {
-		var buf bytes.Buffer
-		err := p.chatStream(ctx, msgs, opts, func(chunk string) {
-			buf.WriteString(chunk)
-		})
-		if err != nil {
-			return "", err
-		}
-
-		return buf.String(), nil
+		return p.ChatStream(ctx, msgs, opts, func(Delta) {})
 	}
 
 	req := p.buildRequest(msgs, opts)
+	start := time.Now()
 	resp, err := p.doRequest(ctx, req)
+	logCall(ctx, "chat", req.Model, start, err)
 	if err != nil {
 		return "", err
 	}
@@ -129,31 +176,34 @@ func (p *OllamaProvider) Chat(
 	return resp, nil
 }
 
-func (p *OllamaProvider) chatStream(
+// ChatStream answers like Chat but calls onDelta with every piece of the
+// answer as the model produces it. It returns the whole answer.
+func (p *OllamaProvider) ChatStream(
 	ctx context.Context,
 	msgs []Message,
 	opts *CallOptions,
-	onChunk func(string),
-) error {
-	if onChunk == nil {
-		return errors.New("onChunk callback cannot be nil")
+	onDelta func(Delta),
+) (string, error) {
+	if onDelta == nil {
+		return "", errors.New("onDelta callback cannot be nil")
 	}
 
 	req := p.buildRequest(msgs, opts)
-	_, err := p.doRequest(ctx, req)
-	if err != nil {
-		return err
-	}
+	start := time.Now()
+	resp, err := p.doRequestStream(ctx, req, onDelta)
+	logCall(ctx, "chat stream", req.Model, start, err)
 
-	_, err = p.doRequestStream(ctx, req, onChunk)
-	return err
+	return resp, err
 }
 
 func (p *OllamaProvider) buildRequest(msgs []Message, opts *CallOptions) *ollamaChatRequest {
+	p.mu.RLock()
 	model := p.model
-	temp := 0.0
+	temp := float64(p.temperature)
+	p.mu.RUnlock()
 	maxTokens := 0
 	isStream := false
+	format := ""
 
 	if opts != nil {
 		if opts.Model != "" {
@@ -168,6 +218,7 @@ func (p *OllamaProvider) buildRequest(msgs []Message, opts *CallOptions) *ollama
 		if opts.Stream {
 			isStream = opts.Stream
 		}
+		format = opts.Format
 	}
 
 	ollamaMsgs := make([]Message, 0, len(msgs))
@@ -195,6 +246,7 @@ func (p *OllamaProvider) buildRequest(msgs []Message, opts *CallOptions) *ollama
 		Messages: ollamaMsgs,
 		Stream:   isStream,
 		Options:  options,
+		Format:   format,
 	}
 }
 
@@ -204,7 +256,7 @@ func (p *OllamaProvider) doRequest(ctx context.Context, reqBody *ollamaChatReque
 		return "", fmt.Errorf("marshal error: %v", err)
 	}
 
-	url, err := url.JoinPath(p.baseURL, "/api/chat")
+	url, err := url.JoinPath(p.BaseURL(), "/api/chat")
 	if err != nil {
 		return "", fmt.Errorf("ollama stream build url error: %v", err)
 	}
@@ -214,6 +266,7 @@ func (p *OllamaProvider) doRequest(ctx context.Context, reqBody *ollamaChatReque
 		return "", fmt.Errorf("newRequest error: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
 
 	resp, err := p.client.Do(req)
 	if err != nil {
@@ -239,7 +292,7 @@ func (p *OllamaProvider) doRequest(ctx context.Context, reqBody *ollamaChatReque
 func (p *OllamaProvider) doRequestStream(
 	ctx context.Context,
 	reqBody *ollamaChatRequest,
-	onChunk func(string),
+	onDelta func(Delta),
 ) (string, error) {
 	reqBody.Stream = true
 
@@ -248,7 +301,7 @@ func (p *OllamaProvider) doRequestStream(
 		return "", fmt.Errorf("ollama stream marshal error: %v", err)
 	}
 
-	url, err := url.JoinPath(p.baseURL, "/api/chat")
+	url, err := url.JoinPath(p.BaseURL(), "/api/chat")
 	if err != nil {
 		return "", fmt.Errorf("ollama stream build url error: %v", err)
 	}
@@ -258,8 +311,13 @@ func (p *OllamaProvider) doRequestStream(
 		return "", fmt.Errorf("ollama stream create request error: %v", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setRequestID(ctx, req)
 
-	resp, err := p.client.Do(req)
+	// A streamed answer may take longer than the client timeout allows for
+	// a whole response; ctx bounds it instead.
+	client := *p.client
+	client.Timeout = 0
+	resp, err := client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("ollama streaming request error: %v", err)
 	}
@@ -285,9 +343,10 @@ func (p *OllamaProvider) doRequestStream(
 		if chunk.Error != "" {
 			return "", fmt.Errorf("ollama stream error: %s", chunk.Error)
 		}
-		if chunk.Content != "" {
-			onChunk(chunk.Content)
-			full.WriteString(chunk.Content)
+		m := chunk.Message
+		if m.Content != "" || m.Thinking != "" || len(m.ToolCalls) > 0 {
+			onDelta(Delta{Content: m.Content, Thinking: m.Thinking, ToolCalls: m.ToolCalls})
+			full.WriteString(m.Content)
 		}
 		if chunk.Done {
 			break
@@ -299,3 +358,29 @@ func (p *OllamaProvider) doRequestStream(
 
 	return full.String(), nil
 }
+
+// setRequestID passes the id of the request req is made for on to the
+// backend, so that its logs can be matched with ours.
+func setRequestID(ctx context.Context, req *http.Request) {
+	if id := requestid.From(ctx); id != "" {
+		req.Header.Set(requestid.Header, id)
+	}
+}
+
+// logCall logs a model call that started at start. Calls made for a
+// request are logged with its id; the others, e.g. background indexing,
+// only at debug level.
+func logCall(ctx context.Context, op, model string, start time.Time, err error) {
+	level := slog.LevelDebug
+	id := requestid.From(ctx)
+	if id != "" {
+		level = slog.LevelInfo
+	}
+	attrs := []any{"op", op, "model", model, "duration", time.Since(start), "request_id", id}
+	if err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, "error", err)
+	}
+
+	slog.Log(ctx, level, "model call", attrs...)
+}
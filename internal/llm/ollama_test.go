@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"io"
 	"math"
@@ -12,6 +13,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/dtoebe/RootTensor/internal/requestid"
 )
 
 func TestNewOllamaProvider(t *testing.T) {
@@ -127,6 +130,37 @@ func TestBaseURL(t *testing.T) {
 	}
 }
 
+func TestConfigure(t *testing.T) {
+	olm, baseURL, model := initOllamaProvider(t)
+
+	t.Run("configure: invalid values kept", func(t *testing.T) {
+		olm.Configure("not a url", "", 0)
+		if olm.BaseURL() != baseURL || olm.Model() != model {
+			t.Errorf("got %s %s; want %s %s", olm.BaseURL(), olm.Model(), baseURL, model)
+		}
+	})
+
+	t.Run("configure: applies to new requests", func(t *testing.T) {
+		olm.Configure("http://gpu-box:11434", "llama3:70b", 0.3)
+		if olm.BaseURL() != "http://gpu-box:11434" {
+			t.Errorf("baseURL: got %s", olm.BaseURL())
+		}
+
+		req := olm.buildRequest(nil, nil)
+		if req.Model != "llama3:70b" {
+			t.Errorf("model: got %s", req.Model)
+		}
+		if got, _ := req.Options["temperature"].(float64); float32(got) != 0.3 {
+			t.Errorf("temperature: got %v", req.Options["temperature"])
+		}
+
+		req = olm.buildRequest(nil, &CallOptions{Temperature: 1.2, Model: "mistral"})
+		if req.Model != "mistral" || float32(req.Options["temperature"].(float64)) != 1.2 {
+			t.Errorf("call options not preferred: %+v", req)
+		}
+	})
+}
+
 func TestOllamaProvider_buildRequest(t *testing.T) {
 	defaultModel := "deepseek-r1:8b"
 	provider := &OllamaProvider{model: defaultModel}
@@ -227,6 +261,18 @@ func TestOllamaProvider_buildRequest(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "opts sets format",
+			msgs: msgs,
+			opts: &CallOptions{Format: "json"},
+			want: &ollamaChatRequest{
+				Model:    defaultModel,
+				Messages: msgs,
+				Stream:   false,
+				Options:  nil,
+				Format:   "json",
+			},
+		},
 		{
 			name: "empty messages",
 			msgs: []Message{},
@@ -250,6 +296,9 @@ func TestOllamaProvider_buildRequest(t *testing.T) {
 			if got.Stream != tt.want.Stream {
 				t.Errorf("Stream: got %v want %v", got.Stream, tt.want.Stream)
 			}
+			if got.Format != tt.want.Format {
+				t.Errorf("Format: got %q want %q", got.Format, tt.want.Format)
+			}
 			if tt.want.Options == nil {
 				if got.Options != nil {
 					t.Errorf("Options: got %v want nil", got.Options)
@@ -349,6 +398,26 @@ func TestOllamaProvider_doRequest(t *testing.T) {
 		}
 	})
 
+	t.Run("request id", func(t *testing.T) {
+		var got string
+		p := newProviderWithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			got = r.Header.Get(requestid.Header)
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`{"message":{"role":"assistant","content":"hi"}}`)),
+				Header:     make(http.Header),
+			}, nil
+		}))
+
+		ctx := requestid.With(context.Background(), "req-42")
+		if _, err := p.doRequest(ctx, &ollamaChatRequest{Model: "x"}); err != nil {
+			t.Fatal(err)
+		}
+		if got != "req-42" {
+			t.Errorf("%s got %q want %q", requestid.Header, got, "req-42")
+		}
+	})
+
 	t.Run("non-2xx status code", func(t *testing.T) {
 		p := newProviderWithTransport(roundTripFunc(func(r *http.Request) (*http.Response, error) {
 			return &http.Response{
@@ -489,7 +558,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 		reqBody := &ollamaChatRequest{Stream: false}
 
-		_, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		_, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -512,7 +581,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 			},
 		}
 
-		got, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		got, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if got != "" {
 			t.Fatalf("got %q want empty", got)
 		}
@@ -529,7 +598,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 		reqBody := &ollamaChatRequest{}
 
-		got, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		got, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if got != "" {
 			t.Fatalf("got %q want empty", got)
 		}
@@ -557,7 +626,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 		reqBody := &ollamaChatRequest{}
 
-		got, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		got, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if got != "" {
 			t.Fatalf("got %q want empty", got)
 		}
@@ -580,7 +649,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 		reqBody := &ollamaChatRequest{}
 
-		got, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		got, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if got != "" {
 			t.Fatalf("got %q want empty", got)
 		}
@@ -603,7 +672,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 		reqBody := &ollamaChatRequest{}
 
-		got, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		got, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if got != "" {
 			t.Fatalf("got %q want empty", got)
 		}
@@ -625,7 +694,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 		reqBody := &ollamaChatRequest{}
 
-		got, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		got, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if got != "" {
 			t.Fatalf("got %q want empty", got)
 		}
@@ -640,10 +709,10 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 		// - content chunk (should call onChunk + accumulate)
 		// - done chunk (break)
 		body := "\n" +
-			`{"content":"Hel"}` + "\n" +
-			`{"content":"lo"}` + "\n" +
+			`{"message":{"role":"assistant","content":"Hel"}}` + "\n" +
+			`{"message":{"role":"assistant","content":"lo"}}` + "\n" +
 			`{"done":true}` + "\n" +
-			`{"content":"ignored-after-done"}` + "\n"
+			`{"message":{"role":"assistant","content":"ignored-after-done"}}` + "\n"
 
 		p := newProviderForStream("http://example.com", roundTripFunc(func(r *http.Request) (*http.Response, error) {
 			return &http.Response{
@@ -656,8 +725,8 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 		reqBody := &ollamaChatRequest{}
 
 		var chunks []string
-		got, err := p.doRequestStream(context.Background(), reqBody, func(s string) {
-			chunks = append(chunks, s)
+		got, err := p.doRequestStream(context.Background(), reqBody, func(d Delta) {
+			chunks = append(chunks, d.Content)
 		})
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
@@ -672,7 +741,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 	t.Run("scanner.Err path (read stream error)", func(t *testing.T) {
 		// Provide one valid chunk, then force a reader error on the next Read.
-		data := []byte(`{"content":"Hi"}` + "\n")
+		data := []byte(`{"message":{"content":"Hi"}}` + "\n")
 		readErr := errors.New("read failed")
 
 		p := newProviderForStream("http://example.com", roundTripFunc(func(r *http.Request) (*http.Response, error) {
@@ -686,7 +755,7 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 
 		reqBody := &ollamaChatRequest{}
 
-		got, err := p.doRequestStream(context.Background(), reqBody, func(string) {})
+		got, err := p.doRequestStream(context.Background(), reqBody, func(Delta) {})
 		if got != "" {
 			t.Fatalf("got %q want empty", got)
 		}
@@ -702,3 +771,63 @@ func TestOllamaProvider_doRequestStream(t *testing.T) {
 	// so we don't try to force it. Everything else is fully covered.
 	_ = bufio.MaxScanTokenSize
 }
+
+func TestOllamaProvider_ChatStream(t *testing.T) {
+	t.Run("ChatStream: one request with thinking and tool calls", func(t *testing.T) {
+		body := `{"message":{"role":"assistant","thinking":"check the pool"}}` + "\n" +
+			`{"message":{"role":"assistant","tool_calls":[{"function":{"name":"search","arguments":{"q":"redis"}}}]}}` + "\n" +
+			`{"message":{"role":"assistant","content":"Pool "}}` + "\n" +
+			`{"message":{"role":"assistant","content":"exhausted."},"done":true}` + "\n"
+
+		var requests int
+		var sent ollamaChatRequest
+		p := newProviderForStream("http://example.com", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			requests++
+			if err := json.NewDecoder(r.Body).Decode(&sent); err != nil {
+				t.Fatal(err)
+			}
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(body)),
+				Header:     make(http.Header),
+			}, nil
+		}))
+
+		var deltas []Delta
+		got, err := p.ChatStream(context.Background(), []Message{{Role: RoleUser, Content: "why?"}}, nil, func(d Delta) {
+			deltas = append(deltas, d)
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if requests != 1 {
+			t.Errorf("requests: got %d want 1", requests)
+		}
+		if !sent.Stream {
+			t.Error("request: stream not set")
+		}
+		if got != "Pool exhausted." {
+			t.Errorf("answer: got %q", got)
+		}
+		if len(deltas) != 4 || deltas[0].Thinking != "check the pool" || len(deltas[1].ToolCalls) != 1 || deltas[1].ToolCalls[0].Function.Name != "search" {
+			t.Errorf("deltas: got %+v", deltas)
+		}
+	})
+
+	t.Run("Chat: stream option collects the answer", func(t *testing.T) {
+		var requests int
+		p := newProviderForStream("http://example.com", roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			requests++
+			return &http.Response{
+				StatusCode: 200,
+				Body:       io.NopCloser(strings.NewReader(`{"message":{"content":"ok"},"done":true}` + "\n")),
+				Header:     make(http.Header),
+			}, nil
+		}))
+
+		got, err := p.Chat(context.Background(), nil, &CallOptions{Stream: true})
+		if err != nil || got != "ok" || requests != 1 {
+			t.Errorf("got %q, %v after %d requests", got, err, requests)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Persona conditions an analysis on a role: its own system prompt, the
+// evidence types it reads first and what its report emphasises.
+type Persona struct {
+	Key            string
+	Name           string
+	SystemPrompt   string
+	EvidenceTypes  []string
+	ReportEmphasis string
+}
+
+// Evidence is one item of incident evidence handed to the model. Kind is a
+// free-form type such as "log", "metric" or "deploy".
+type Evidence struct {
+	Kind    string
+	Content string
+}
+
+const analystPrompt = "You help engineers find the root cause of production incidents. " +
+	"Only state what the evidence supports, cite evidence by its [number] " +
+	"and say plainly when the evidence is not enough."
+
+// System returns the full system prompt for the persona. A nil persona gets
+// the neutral analyst prompt.
+func (p *Persona) System() string {
+	if p == nil {
+		return analystPrompt
+	}
+
+	var b strings.Builder
+	b.WriteString(analystPrompt)
+	if p.SystemPrompt != "" {
+		b.WriteString("\n\n")
+		b.WriteString(p.SystemPrompt)
+	}
+	if p.ReportEmphasis != "" {
+		b.WriteString("\n\nWhen you write a report: ")
+		b.WriteString(p.ReportEmphasis)
+	}
+
+	return b.String()
+}
+
+// Agent turns the persona into a debate participant.
+func (p *Persona) Agent() Agent {
+	return Agent{Name: p.Name, SystemPrompt: p.System()}
+}
+
+// OrderEvidence returns evidence with the persona's preferred types first,
+// in the persona's order of preference. Everything else keeps its original
+// relative order after them.
+func (p *Persona) OrderEvidence(evidence []Evidence) []Evidence {
+	out := slices.Clone(evidence)
+	if p == nil || len(p.EvidenceTypes) == 0 {
+		return out
+	}
+
+	rank := func(kind string) int {
+		if i := slices.Index(p.EvidenceTypes, strings.ToLower(kind)); i >= 0 {
+			return i
+		}
+		return len(p.EvidenceTypes)
+	}
+	slices.SortStableFunc(out, func(a, b Evidence) int {
+		return rank(a.Kind) - rank(b.Kind)
+	})
+
+	return out
+}
+
+// BuildPrompt assembles the messages for an analysis task as seen by the
+// persona: its system prompt, then the task with the evidence numbered in
+// the persona's preferred order.
+func BuildPrompt(p *Persona, task string, evidence []Evidence) []Message {
+	var b strings.Builder
+	b.WriteString("## Evidence\n")
+	ordered := p.OrderEvidence(evidence)
+	if len(ordered) == 0 {
+		b.WriteString("(no evidence provided)\n")
+	}
+	for i, e := range ordered {
+		fmt.Fprintf(&b, "[%d] (%s) %s\n", i+1, e.Kind, strings.TrimSpace(e.Content))
+	}
+	b.WriteString("\n## Task\n")
+	b.WriteString(strings.TrimSpace(task))
+
+	return []Message{
+		{Role: RoleSystem, Content: p.System()},
+		{Role: RoleUser, Content: b.String()},
+	}
+}
@@ -0,0 +1,86 @@
+package llm
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPersona_OrderEvidence(t *testing.T) {
+	evidence := []Evidence{
+		{Kind: "log", Content: "l1"},
+		{Kind: "metric", Content: "m1"},
+		{Kind: "deploy", Content: "d1"},
+		{Kind: "log", Content: "l2"},
+		{Kind: "Query", Content: "q1"},
+	}
+
+	tests := []struct {
+		name    string
+		persona *Persona
+		want    []string
+	}{
+		{name: "nil persona keeps order", persona: nil, want: []string{"l1", "m1", "d1", "l2", "q1"}},
+		{name: "no preferences keeps order", persona: &Persona{}, want: []string{"l1", "m1", "d1", "l2", "q1"}},
+		{
+			name:    "preferred types first in preference order",
+			persona: &Persona{EvidenceTypes: []string{"query", "deploy"}},
+			want:    []string{"q1", "d1", "l1", "m1", "l2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range tt.persona.OrderEvidence(evidence) {
+				got = append(got, e.Content)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildPrompt(t *testing.T) {
+	p := &Persona{
+		Name:           "DBA",
+		SystemPrompt:   "You are a database administrator.",
+		EvidenceTypes:  []string{"query"},
+		ReportEmphasis: "Lead with the query plan.",
+	}
+	evidence := []Evidence{
+		{Kind: "log", Content: "timeout talking to db"},
+		{Kind: "query", Content: "SELECT took 30s"},
+	}
+
+	msgs := BuildPrompt(p, "Find the root cause.", evidence)
+	if len(msgs) != 2 {
+		t.Fatalf("messages: got %d want 2", len(msgs))
+	}
+
+	sys := msgs[0]
+	if sys.Role != RoleSystem {
+		t.Errorf("first message role: got %q want %q", sys.Role, RoleSystem)
+	}
+	for _, want := range []string{analystPrompt, p.SystemPrompt, p.ReportEmphasis} {
+		if !strings.Contains(sys.Content, want) {
+			t.Errorf("system prompt missing %q: %q", want, sys.Content)
+		}
+	}
+
+	user := msgs[1].Content
+	if !strings.Contains(user, "[1] (query) SELECT took 30s") || !strings.Contains(user, "[2] (log) timeout") {
+		t.Errorf("evidence not ordered for persona: %q", user)
+	}
+	if !strings.HasSuffix(user, "Find the root cause.") {
+		t.Errorf("task missing: %q", user)
+	}
+
+	if got := BuildPrompt(nil, "x", nil)[0].Content; got != analystPrompt {
+		t.Errorf("nil persona system prompt: got %q want %q", got, analystPrompt)
+	}
+	if got := p.Agent(); got.Name != "DBA" || got.SystemPrompt != sys.Content {
+		t.Errorf("agent: got %+v", got)
+	}
+}
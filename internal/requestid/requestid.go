@@ -0,0 +1,55 @@
+// Package requestid carries the id of an HTTP request through its context,
+// so that the log lines of the request and of the model calls made for it,
+// even those finishing in the background, can be told apart and matched.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// Header is the HTTP header a request id travels in, both from clients and
+// proxies in front of the server and on to the model backend.
+const Header = "X-Request-ID"
+
+// maxLength bounds the ids accepted from clients.
+const maxLength = 128
+
+type key struct{}
+
+// New returns a random id.
+func New() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Valid reports whether id, as sent by a client, is safe to log and pass
+// on: up to 128 letters, digits, dots, dashes and underscores.
+func Valid(id string) bool {
+	if id == "" || len(id) > maxLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '.', c == '-', c == '_':
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// With returns a context carrying id.
+func With(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, key{}, id)
+}
+
+// From returns the id carried by ctx, or "".
+func From(ctx context.Context) string {
+	id, _ := ctx.Value(key{}).(string)
+	return id
+}
@@ -0,0 +1,50 @@
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	a, b := New(), New()
+	if a == b {
+		t.Errorf("two ids are equal: %s", a)
+	}
+	if !Valid(a) {
+		t.Errorf("New: %q is not valid", a)
+	}
+}
+
+func TestValid(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		want bool
+	}{
+		{"Valid: uuid", "0b8e4c5e-3f0a-4c1e-9a55-6f3c2d1b7e90", true},
+		{"Valid: dotted", "trace.42_a", true},
+		{"Valid: empty", "", false},
+		{"Valid: too long", strings.Repeat("a", maxLength+1), false},
+		{"Valid: newline", "abc\nINFO forged", false},
+		{"Valid: space", "a b", false},
+		{"Valid: quote", `a"b`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Valid(tt.id); got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFrom(t *testing.T) {
+	ctx := context.Background()
+	if got := From(ctx); got != "" {
+		t.Errorf("without id: got %q", got)
+	}
+	if got := From(With(ctx, "abc")); got != "abc" {
+		t.Errorf("with id: got %q want %q", got, "abc")
+	}
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type ActionItemStatus string
+
+const (
+	ActionItemOpen       ActionItemStatus = "open"
+	ActionItemInProgress ActionItemStatus = "in_progress"
+	ActionItemDone       ActionItemStatus = "done"
+	ActionItemWontDo     ActionItemStatus = "wont_do"
+)
+
+// ActionItem is a follow-up task coming out of an incident review.
+type ActionItem struct {
+	ID          int64
+	IncidentID  int64
+	Title       string
+	Description string
+	Owner       string
+	Status      ActionItemStatus
+	DueAt       *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// ActionItemFilter narrows ListActionItems. Zero values do not filter.
+type ActionItemFilter struct {
+	IncidentID int64
+	Owner      string
+	Status     ActionItemStatus
+	// After continues the list past a cursor from ActionItem.Cursor.
+	After *Cursor
+	Limit int
+}
+
+// Cursor is the position of ai in ListActionItems. Items without a due
+// date come last and have a nil key.
+func (ai *ActionItem) Cursor() Cursor {
+	c := Cursor{ID: ai.ID}
+	if ai.DueAt != nil {
+		c.Key = *ai.DueAt
+	}
+	return c
+}
+
+// Valid reports whether s is a known status.
+func (s ActionItemStatus) Valid() bool {
+	switch s {
+	case ActionItemOpen, ActionItemInProgress, ActionItemDone, ActionItemWontDo:
+		return true
+	}
+	return false
+}
+
+const actionItemColumns = `id, incident_id, title, description, owner, status, due_at, created_at, updated_at`
+
+func (d *SQliteDB) CreateActionItem(ctx context.Context, ai *ActionItem) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	if ai.Status == "" {
+		ai.Status = ActionItemOpen
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, ai.IncidentID); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO action_items (incident_id, title, description, owner, status, due_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id, created_at, updated_at`,
+			ai.IncidentID, ai.Title, ai.Description, ai.Owner, ai.Status, nullTime(ai.DueAt))
+		if err := row.Scan(&ai.ID, &ai.CreatedAt, &ai.UpdatedAt); err != nil {
+			return fmt.Errorf("create action item error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditActionItem, ai.ID, ai.IncidentID, nil, ai)
+	})
+}
+
+func (d *SQliteDB) GetActionItem(ctx context.Context, id int64) (*ActionItem, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+actionItemColumns+` FROM action_items`+w.String(), w.args...)
+	ai, err := scanActionItem(row)
+	if err != nil {
+		return nil, notFound(err, "get action item")
+	}
+
+	return ai, nil
+}
+
+// ListActionItems returns the action items matching f, the ones due first
+// at the top and undated ones last.
+func (d *SQliteDB) ListActionItems(ctx context.Context, f ActionItemFilter) ([]ActionItem, error) {
+	var w where
+	if f.IncidentID != 0 {
+		w.add("incident_id = ?", f.IncidentID)
+	}
+	if f.Owner != "" {
+		w.add("owner = ?", f.Owner)
+	}
+	if f.Status != "" {
+		w.add("status = ?", f.Status)
+	}
+	switch {
+	case f.After == nil:
+	case f.After.Key == nil:
+		w.add("(due_at IS NULL AND id > ?)", f.After.ID)
+	default:
+		due := f.After.Key
+		if t, ok := due.(time.Time); ok {
+			due = t.UTC()
+		}
+		w.add("(due_at IS NULL OR due_at > ? OR (due_at = ? AND id > ?))", due, due, f.After.ID)
+	}
+	scopeIncidents(ctx, &w, "incident_id")
+
+	rows, err := d.r.QueryContext(ctx,
+		`SELECT `+actionItemColumns+` FROM action_items`+w.String()+` ORDER BY due_at IS NULL, due_at, id`+
+			limit(f.Limit), w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list action items error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []ActionItem
+	for rows.Next() {
+		ai, err := scanActionItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list action items error: %v", err)
+		}
+		out = append(out, *ai)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list action items error: %v", err)
+	}
+
+	return out, nil
+}
+
+func (d *SQliteDB) UpdateActionItem(ctx context.Context, ai *ActionItem) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetActionItem(ctx, ai.ID)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			UPDATE action_items
+			SET title = ?, description = ?, owner = ?, status = ?, due_at = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+			RETURNING updated_at`,
+			ai.Title, ai.Description, ai.Owner, ai.Status, nullTime(ai.DueAt), ai.ID)
+		if err := row.Scan(&ai.UpdatedAt); err != nil {
+			return notFound(err, "update action item")
+		}
+
+		cur, err := tx.GetActionItem(ctx, ai.ID)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditActionItem, ai.ID, old.IncidentID, old, cur)
+	})
+}
+
+func scanActionItem(s scanner) (*ActionItem, error) {
+	var (
+		ai    ActionItem
+		dueAt sql.NullTime
+	)
+
+	err := s.Scan(&ai.ID, &ai.IncidentID, &ai.Title, &ai.Description, &ai.Owner, &ai.Status,
+		&dueAt, &ai.CreatedAt, &ai.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	ai.DueAt = timePtr(dueAt)
+
+	return &ai, nil
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_ActionItems(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "redis timeouts")
+	due := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	alerts := &ActionItem{IncidentID: inc.ID, Title: "alert on pool usage", Owner: "sam"}
+	pool := &ActionItem{IncidentID: inc.ID, Title: "raise pool size", Owner: "alex", DueAt: &due}
+
+	t.Run("CreateActionItem: success", func(t *testing.T) {
+		for _, ai := range []*ActionItem{alerts, pool} {
+			if err := db.CreateActionItem(ctx, ai); err != nil {
+				t.Fatalf("failed to create action item: %v", err)
+			}
+		}
+		if alerts.Status != ActionItemOpen {
+			t.Errorf("default status: got %q want %q", alerts.Status, ActionItemOpen)
+		}
+	})
+
+	t.Run("ListActionItems: dated first and filtered", func(t *testing.T) {
+		got, err := db.ListActionItems(ctx, ActionItemFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].ID != pool.ID || got[0].DueAt == nil || !got[0].DueAt.Equal(due) {
+			t.Errorf("action items: got %+v", got)
+		}
+
+		got, err = db.ListActionItems(ctx, ActionItemFilter{Owner: "sam"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != alerts.ID {
+			t.Errorf("owner filter: got %+v", got)
+		}
+	})
+
+	t.Run("ListActionItems: pages", func(t *testing.T) {
+		var ids []int64
+		f := ActionItemFilter{IncidentID: inc.ID, Limit: 1}
+		for range 3 {
+			got, err := db.ListActionItems(ctx, f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) == 0 {
+				break
+			}
+			ids = append(ids, got[0].ID)
+			c := got[0].Cursor()
+			f.After = &c
+		}
+		if len(ids) != 2 || ids[0] != pool.ID || ids[1] != alerts.ID {
+			t.Errorf("pages: got %v want [%d %d]", ids, pool.ID, alerts.ID)
+		}
+	})
+
+	t.Run("UpdateActionItem: done", func(t *testing.T) {
+		pool.Status = ActionItemDone
+		if err := db.UpdateActionItem(ctx, pool); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetActionItem(ctx, pool.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != ActionItemDone {
+			t.Errorf("status: got %q want %q", got.Status, ActionItemDone)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := db.GetActionItem(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetActionItem: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.UpdateActionItem(ctx, &ActionItem{ID: 9999, Status: ActionItemOpen}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateActionItem: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Analysis is a generated RCA draft together with the evidence it was
+// generated from. Critique holds the verifier's JSON annotations and is
+// empty until the draft has been reviewed.
+type Analysis struct {
+	ID         int64
+	IncidentID int64
+	Model      string
+	Draft      string
+	Evidence   []string
+	Critique   string
+	CreatedAt  time.Time
+	ReviewedAt *time.Time
+}
+
+const analysisColumns = `id, incident_id, model, draft, evidence, critique, created_at, reviewed_at`
+
+func (d *SQliteDB) CreateAnalysis(ctx context.Context, a *Analysis) error {
+	if err := authorize(ctx, PermRunAnalyses); err != nil {
+		return err
+	}
+
+	evidence, err := json.Marshal(nonNil(a.Evidence))
+	if err != nil {
+		return fmt.Errorf("analysis evidence marshal error: %v", err)
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, a.IncidentID); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO analyses (incident_id, model, draft, evidence)
+			VALUES (?, ?, ?, ?)
+			RETURNING id, created_at`,
+			a.IncidentID, a.Model, a.Draft, string(evidence))
+		if err := row.Scan(&a.ID, &a.CreatedAt); err != nil {
+			return fmt.Errorf("create analysis error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditAnalysis, a.ID, a.IncidentID, nil, a)
+	})
+}
+
+func (d *SQliteDB) GetAnalysis(ctx context.Context, id int64) (*Analysis, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+analysisColumns+` FROM analyses`+w.String(), w.args...)
+	a, err := scanAnalysis(row)
+	if err != nil {
+		return nil, notFound(err, "get analysis")
+	}
+
+	return a, nil
+}
+
+// ListAnalyses returns the drafts of an incident, newest first.
+func (d *SQliteDB) ListAnalyses(ctx context.Context, incidentID int64) ([]Analysis, error) {
+	var w where
+	w.add("incident_id = ?", incidentID)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	rows, err := d.r.QueryContext(ctx,
+		`SELECT `+analysisColumns+` FROM analyses`+w.String()+` ORDER BY id DESC`, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list analyses error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []Analysis
+	for rows.Next() {
+		a, err := scanAnalysis(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list analyses error: %v", err)
+		}
+		out = append(out, *a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list analyses error: %v", err)
+	}
+
+	return out, nil
+}
+
+// SetAnalysisCritique stores the verifier output next to the draft it
+// reviewed. Re-running the critic overwrites the previous annotations.
+func (d *SQliteDB) SetAnalysisCritique(ctx context.Context, id int64, critique string) error {
+	if err := authorize(ctx, PermRunAnalyses); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetAnalysis(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			UPDATE analyses SET critique = ?, reviewed_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, critique, id)
+		if err != nil {
+			return fmt.Errorf("set analysis critique error: %v", err)
+		}
+
+		cur, err := tx.GetAnalysis(ctx, id)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditAnalysis, id, old.IncidentID, old, cur)
+	})
+}
+
+func scanAnalysis(s scanner) (*Analysis, error) {
+	var (
+		a          Analysis
+		evidence   string
+		critique   sql.NullString
+		reviewedAt sql.NullTime
+	)
+
+	err := s.Scan(&a.ID, &a.IncidentID, &a.Model, &a.Draft, &evidence,
+		&critique, &a.CreatedAt, &reviewedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(evidence), &a.Evidence); err != nil {
+		return nil, fmt.Errorf("analysis evidence decode error: %v", err)
+	}
+	a.Critique = critique.String
+	a.ReviewedAt = timePtr(reviewedAt)
+
+	return &a, nil
+}
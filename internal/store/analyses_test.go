@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteDB_Analyses(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "redis timeouts")
+	a := &Analysis{
+		IncidentID: inc.ID,
+		Model:      "deepseek-r1:8b",
+		Draft:      "Redis ran out of connections.",
+		Evidence:   []string{"redis: pool exhausted"},
+	}
+
+	t.Run("CreateAnalysis: success", func(t *testing.T) {
+		if err := db.CreateAnalysis(ctx, a); err != nil {
+			t.Fatalf("failed to create analysis: %v", err)
+		}
+		if a.ID == 0 {
+			t.Fatal("analysis id not set")
+		}
+		if a.CreatedAt.IsZero() {
+			t.Error("analysis created_at not set")
+		}
+	})
+
+	t.Run("GetAnalysis: unreviewed", func(t *testing.T) {
+		got, err := db.GetAnalysis(ctx, a.ID)
+		if err != nil {
+			t.Fatalf("failed to get analysis: %v", err)
+		}
+		if got.Draft != a.Draft || got.IncidentID != a.IncidentID || got.Model != a.Model {
+			t.Errorf("analysis: got %+v want %+v", got, a)
+		}
+		if !reflect.DeepEqual(got.Evidence, a.Evidence) {
+			t.Errorf("evidence: got %v want %v", got.Evidence, a.Evidence)
+		}
+		if got.Critique != "" || got.ReviewedAt != nil {
+			t.Errorf("expected no critique; got %q at %v", got.Critique, got.ReviewedAt)
+		}
+	})
+
+	t.Run("SetAnalysisCritique: stored next to draft", func(t *testing.T) {
+		want := `{"claims":[]}`
+		if err := db.SetAnalysisCritique(ctx, a.ID, want); err != nil {
+			t.Fatalf("failed to set critique: %v", err)
+		}
+
+		got, err := db.GetAnalysis(ctx, a.ID)
+		if err != nil {
+			t.Fatalf("failed to get analysis: %v", err)
+		}
+		if got.Critique != want {
+			t.Errorf("critique: got %q want %q", got.Critique, want)
+		}
+		if got.ReviewedAt == nil {
+			t.Error("reviewed_at not set")
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := db.GetAnalysis(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetAnalysis: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.SetAnalysisCritique(ctx, 9999, "{}"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("SetAnalysisCritique: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,354 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate AuditAction = "create"
+	AuditUpdate AuditAction = "update"
+	AuditDelete AuditAction = "delete"
+)
+
+// AuditEntity names the kind of row an audit entry is about.
+type AuditEntity string
+
+const (
+	AuditIncident          AuditEntity = "incident"
+	AuditIncidentService   AuditEntity = "incident_service"
+	AuditService           AuditEntity = "service"
+	AuditTimelineEvent     AuditEntity = "timeline_event"
+	AuditEvidence          AuditEntity = "evidence"
+	AuditAnalysis          AuditEntity = "analysis"
+	AuditHypothesis        AuditEntity = "hypothesis"
+	AuditActionItem        AuditEntity = "action_item"
+	AuditChatMessage       AuditEntity = "chat_message"
+	AuditDebate            AuditEntity = "debate"
+	AuditDebateTurn        AuditEntity = "debate_turn"
+	AuditPersona           AuditEntity = "persona"
+	AuditPersonaAssignment AuditEntity = "persona_assignment"
+	AuditRetentionPolicy   AuditEntity = "retention_policy"
+	AuditWorkspace         AuditEntity = "workspace"
+	AuditWorkspaceMember   AuditEntity = "workspace_member"
+	AuditSettings          AuditEntity = "settings"
+	AuditUser              AuditEntity = "user"
+	AuditAPIToken          AuditEntity = "api_token"
+)
+
+// SystemActor is recorded for changes made with no actor in the context.
+const SystemActor = "system"
+
+type actorKey struct{}
+
+// WithActor returns a context whose changes are attributed to actor in the
+// audit log.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFrom returns the actor set with WithActor, or SystemActor.
+func ActorFrom(ctx context.Context) string {
+	if actor, ok := ctx.Value(actorKey{}).(string); ok && actor != "" {
+		return actor
+	}
+
+	return SystemActor
+}
+
+// FieldChange holds the JSON values of a field before and after a change.
+// Old is empty for a create and New for a delete.
+type FieldChange struct {
+	Old json.RawMessage `json:"old,omitempty"`
+	New json.RawMessage `json:"new,omitempty"`
+}
+
+// AuditEntry records one create, update or delete. Diff is keyed by the
+// field names of the store type; a create lists every field and a delete
+// every field the row had. Deleting an incident records only the incident,
+// not the rows removed with it.
+type AuditEntry struct {
+	ID       int64
+	At       time.Time
+	Actor    string
+	Action   AuditAction
+	Entity   AuditEntity
+	EntityID string
+	// IncidentID is the incident the entity belongs to, 0 for none.
+	IncidentID int64
+	// WorkspaceID is the workspace the change was made in, 0 for changes
+	// to the whole instance such as personas.
+	WorkspaceID int64
+	Diff        map[string]FieldChange
+}
+
+// AuditFilter narrows ListAudit. Zero values do not filter. In a workspace
+// context only the entries of that workspace are listed.
+type AuditFilter struct {
+	IncidentID int64
+	Entity     AuditEntity
+	EntityID   string
+	Limit      int
+}
+
+// auditIgnored are fields that change with every write or never change;
+// they would only add noise to the diffs.
+var auditIgnored = []string{"ID", "CreatedAt", "UpdatedAt", "Turns"}
+
+const auditColumns = `id, at, actor, action, entity, entity_id, incident_id, workspace_id, diff`
+
+// audit appends an entry describing the change from old to new. Either may
+// be nil. An update that changed nothing is not recorded. The entry belongs
+// to the workspace of ctx or else to the workspace of the incident.
+func (d *SQliteDB) audit(ctx context.Context, action AuditAction, entity AuditEntity, id any, incidentID int64, old, new any) error {
+	diff, err := auditDiff(old, new)
+	if err != nil {
+		return fmt.Errorf("audit error: %v", err)
+	}
+	if action == AuditUpdate && len(diff) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(diff)
+	if err != nil {
+		return fmt.Errorf("audit error: %v", err)
+	}
+	var incident, workspace sql.NullInt64
+	if incidentID != 0 {
+		incident = sql.NullInt64{Int64: incidentID, Valid: true}
+	}
+	if ws, ok := WorkspaceFrom(ctx); ok {
+		workspace = sql.NullInt64{Int64: ws, Valid: true}
+	} else if incidentID != 0 {
+		if workspace, err = d.workspaceOf(ctx, incidentID); err != nil {
+			return fmt.Errorf("audit error: %v", err)
+		}
+	}
+
+	_, err = d.q.ExecContext(ctx, `
+		INSERT INTO audit_log (at, actor, action, entity, entity_id, incident_id, workspace_id, diff)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		time.Now().UTC(), ActorFrom(ctx), action, entity, fmt.Sprint(id), incident, workspace, string(b))
+	if err != nil {
+		return fmt.Errorf("audit error: %v", err)
+	}
+
+	return nil
+}
+
+// auditViewer is implemented by types whose audit record differs from the
+// stored row.
+type auditViewer interface {
+	auditView() any
+}
+
+func auditFields(v any) (map[string]json.RawMessage, error) {
+	if a, ok := v.(auditViewer); ok {
+		v = a.auditView()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	for _, k := range auditIgnored {
+		delete(m, k)
+	}
+
+	return m, nil
+}
+
+func auditDiff(old, new any) (map[string]FieldChange, error) {
+	o, err := auditFields(old)
+	if err != nil {
+		return nil, err
+	}
+	n, err := auditFields(new)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]FieldChange{}
+	for k, nv := range n {
+		if ov, ok := o[k]; !ok || !bytes.Equal(ov, nv) {
+			diff[k] = FieldChange{Old: ov, New: nv}
+		}
+	}
+	for k, ov := range o {
+		if _, ok := n[k]; !ok {
+			diff[k] = FieldChange{Old: ov}
+		}
+	}
+
+	return diff, nil
+}
+
+func (d *SQliteDB) GetAuditEntry(ctx context.Context, id int64) (*AuditEntry, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeAudit(ctx, &w)
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+auditColumns+` FROM audit_log`+w.String(), w.args...)
+	e, err := scanAuditEntry(row)
+	if err != nil {
+		return nil, notFound(err, "get audit entry")
+	}
+
+	return e, nil
+}
+
+// ListAudit returns the entries matching f, newest first.
+func (d *SQliteDB) ListAudit(ctx context.Context, f AuditFilter) ([]AuditEntry, error) {
+	var w where
+	if f.IncidentID != 0 {
+		w.add("incident_id = ?", f.IncidentID)
+	}
+	if f.Entity != "" {
+		w.add("entity = ?", f.Entity)
+	}
+	if f.EntityID != "" {
+		w.add("entity_id = ?", f.EntityID)
+	}
+	scopeAudit(ctx, &w)
+
+	query := `SELECT ` + auditColumns + ` FROM audit_log` + w.String() + ` ORDER BY id DESC`
+	if f.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", f.Limit)
+	}
+
+	rows, err := d.r.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []AuditEntry
+	for rows.Next() {
+		e, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list audit error: %v", err)
+		}
+		out = append(out, *e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list audit error: %v", err)
+	}
+
+	return out, nil
+}
+
+// IncidentVersion returns the incident as it was right after the change
+// recorded by audit entry auditID, or as it was when deleted for a delete.
+// It starts from the current row and undoes every later change, so it also
+// works for incidents created before the audit log existed. UpdatedAt is
+// the time of the change.
+func (d *SQliteDB) IncidentVersion(ctx context.Context, incidentID, auditID int64) (*Incident, error) {
+	key := fmt.Sprint(incidentID)
+	target, err := d.GetAuditEntry(ctx, auditID)
+	if err != nil {
+		return nil, err
+	}
+	if target.Entity != AuditIncident || target.EntityID != key {
+		return nil, ErrNotFound
+	}
+
+	var state map[string]json.RawMessage
+	cur, err := d.GetIncident(ctx, incidentID)
+	switch {
+	case err == nil:
+		if state, err = auditFields(cur); err != nil {
+			return nil, fmt.Errorf("incident version error: %v", err)
+		}
+	case !errors.Is(err, ErrNotFound):
+		return nil, err
+	}
+
+	later, err := d.ListAudit(ctx, AuditFilter{Entity: AuditIncident, EntityID: key})
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range later {
+		if e.ID <= auditID {
+			break
+		}
+		switch e.Action {
+		case AuditDelete:
+			state = oldValues(e.Diff)
+		case AuditUpdate:
+			if state == nil {
+				state = map[string]json.RawMessage{}
+			}
+			for k, c := range e.Diff {
+				if len(c.Old) > 0 {
+					state[k] = c.Old
+				}
+			}
+		}
+	}
+	if target.Action == AuditDelete {
+		state = oldValues(target.Diff)
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, fmt.Errorf("incident version error: %v", err)
+	}
+	var inc Incident
+	if err := json.Unmarshal(b, &inc); err != nil {
+		return nil, fmt.Errorf("incident version error: %v", err)
+	}
+	inc.ID = incidentID
+	inc.UpdatedAt = target.At
+	if cur != nil {
+		inc.CreatedAt = cur.CreatedAt
+	}
+
+	return &inc, nil
+}
+
+func scopeAudit(ctx context.Context, w *where) {
+	if ws, ok := WorkspaceFrom(ctx); ok {
+		w.add("workspace_id = ?", ws)
+	}
+}
+
+func oldValues(diff map[string]FieldChange) map[string]json.RawMessage {
+	m := map[string]json.RawMessage{}
+	for k, c := range diff {
+		if len(c.Old) > 0 {
+			m[k] = c.Old
+		}
+	}
+
+	return m
+}
+
+func scanAuditEntry(s scanner) (*AuditEntry, error) {
+	var (
+		e                   AuditEntry
+		incident, workspace sql.NullInt64
+		diff                string
+	)
+
+	err := s.Scan(&e.ID, &e.At, &e.Actor, &e.Action, &e.Entity, &e.EntityID, &incident, &workspace, &diff)
+	if err != nil {
+		return nil, err
+	}
+	e.IncidentID = incident.Int64
+	e.WorkspaceID = workspace.Int64
+	if err := json.Unmarshal([]byte(diff), &e.Diff); err != nil {
+		return nil, fmt.Errorf("audit diff decode error: %v", err)
+	}
+
+	return &e, nil
+}
@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_Audit(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := WithActor(context.Background(), "alice")
+
+	inc := &Incident{Title: "Redis timeouts", RCA: "unknown", StartedAt: time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)}
+	if err := db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	key := fmt.Sprint(inc.ID)
+
+	t.Run("audit: create records every field", func(t *testing.T) {
+		got, err := db.ListAudit(ctx, AuditFilter{Entity: AuditIncident, EntityID: key})
+		if err != nil {
+			t.Fatalf("failed to list audit: %v", err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("entries: got %d want 1", len(got))
+		}
+		e := got[0]
+		if e.Actor != "alice" || e.Action != AuditCreate || e.IncidentID != inc.ID || e.At.IsZero() {
+			t.Errorf("unexpected entry: %+v", e)
+		}
+		if string(e.Diff["Title"].New) != `"Redis timeouts"` || e.Diff["Title"].Old != nil {
+			t.Errorf("title change: %+v", e.Diff["Title"])
+		}
+		if _, ok := e.Diff["UpdatedAt"]; ok {
+			t.Error("UpdatedAt should not be audited")
+		}
+	})
+
+	t.Run("audit: update records only changed fields", func(t *testing.T) {
+		inc.RCA = "cache failover"
+		if err := db.UpdateIncident(WithActor(ctx, "bob"), inc); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.ListAudit(ctx, AuditFilter{Entity: AuditIncident, EntityID: key, Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := got[0]
+		if e.Actor != "bob" || e.Action != AuditUpdate || len(e.Diff) != 1 {
+			t.Fatalf("unexpected entry: %+v", e)
+		}
+		if c := e.Diff["RCA"]; string(c.Old) != `"unknown"` || string(c.New) != `"cache failover"` {
+			t.Errorf("rca change: old %s new %s", c.Old, c.New)
+		}
+
+		// Saving unchanged values is not a change.
+		if err := db.UpdateIncident(ctx, inc); err != nil {
+			t.Fatal(err)
+		}
+		all, _ := db.ListAudit(ctx, AuditFilter{Entity: AuditIncident, EntityID: key})
+		if len(all) != 2 {
+			t.Errorf("entries after no-op update: got %d want 2", len(all))
+		}
+	})
+
+	t.Run("audit: child rows carry the incident", func(t *testing.T) {
+		ev := &Evidence{IncidentID: inc.ID, Title: "app log", Content: "timeout"}
+		if err := db.CreateEvidence(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteEvidence(ctx, ev.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := db.ListAudit(ctx, AuditFilter{IncidentID: inc.ID, Entity: AuditEvidence})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Action != AuditDelete || got[1].Action != AuditCreate {
+			t.Fatalf("unexpected entries: %+v", got)
+		}
+		if string(got[0].Diff["Title"].Old) != `"app log"` || got[0].Diff["Title"].New != nil {
+			t.Errorf("delete should record the old row: %+v", got[0].Diff)
+		}
+		if _, ok := got[1].Diff["Content"]; ok || string(got[1].Diff["ContentSize"].New) != "7" {
+			t.Errorf("evidence content should be recorded by size and digest: %+v", got[1].Diff)
+		}
+	})
+
+	t.Run("audit: written with the change", func(t *testing.T) {
+		err := db.WithTx(ctx, func(tx *SQliteDB) error {
+			if err := tx.CreateIncident(ctx, &Incident{Title: "rolled back"}); err != nil {
+				return err
+			}
+			return errors.New("abort")
+		})
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM audit_log WHERE diff LIKE '%rolled back%'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("audit entries of a rolled back change: %d", n)
+		}
+	})
+
+	t.Run("audit: append-only", func(t *testing.T) {
+		if _, err := db.Exec(`UPDATE audit_log SET actor = 'mallory'`); err == nil {
+			t.Error("expected update to be refused")
+		}
+		if _, err := db.Exec(`DELETE FROM audit_log`); err == nil {
+			t.Error("expected delete to be refused")
+		}
+	})
+
+	t.Run("audit: system actor by default", func(t *testing.T) {
+		svc := &Service{Name: "payments"}
+		if err := db.CreateService(context.Background(), svc); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.ListAudit(ctx, AuditFilter{Entity: AuditService, EntityID: fmt.Sprint(svc.ID)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Actor != SystemActor || got[0].IncidentID != 0 {
+			t.Errorf("unexpected entries: %+v", got)
+		}
+	})
+}
+
+func TestSQLiteDB_IncidentVersion(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := &Incident{Title: "v1", RCA: "draft", StartedAt: time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)}
+	if err := db.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"v2", "v3"} {
+		inc.Title = title
+		inc.RCA = "rca " + title
+		if err := db.UpdateIncident(ctx, inc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := db.ListAudit(ctx, AuditFilter{Entity: AuditIncident, EntityID: fmt.Sprint(inc.ID)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("entries: got %d want 3", len(entries))
+	}
+
+	t.Run("IncidentVersion: every version", func(t *testing.T) {
+		want := []string{"v3", "v2", "v1"}
+		for i, e := range entries {
+			got, err := db.IncidentVersion(ctx, inc.ID, e.ID)
+			if err != nil {
+				t.Fatalf("failed to get version: %v", err)
+			}
+			if got.Title != want[i] || got.ID != inc.ID || !got.UpdatedAt.Equal(e.At) {
+				t.Errorf("version %d: got %+v want title %s", e.ID, got, want[i])
+			}
+		}
+		first, _ := db.IncidentVersion(ctx, inc.ID, entries[2].ID)
+		if first.RCA != "draft" || !first.StartedAt.Equal(inc.StartedAt) || first.UID != inc.UID {
+			t.Errorf("first version: %+v", first)
+		}
+	})
+
+	t.Run("IncidentVersion: after delete", func(t *testing.T) {
+		if err := db.DeleteIncident(ctx, inc.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.IncidentVersion(ctx, inc.ID, entries[1].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "v2" || got.RCA != "rca v2" {
+			t.Errorf("version: %+v", got)
+		}
+	})
+
+	t.Run("IncidentVersion: entry of another entity", func(t *testing.T) {
+		other := testIncident(t, db, "other")
+		if _, err := db.IncidentVersion(ctx, other.ID, entries[0].ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestAuditDiff(t *testing.T) {
+	type row struct {
+		ID    int64
+		Title string
+		Tags  []string
+	}
+
+	got, err := auditDiff(&row{ID: 1, Title: "a", Tags: []string{"x"}}, &row{ID: 2, Title: "b", Tags: []string{"x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := json.Marshal(got)
+	if string(b) != `{"Title":{"old":"a","new":"b"}}` {
+		t.Errorf("diff: %s", b)
+	}
+}
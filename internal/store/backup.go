@@ -0,0 +1,314 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// snapshotPrefix and snapshotLayout name the files Snapshot writes so that
+// they sort by age.
+const (
+	snapshotPrefix = "roottensor-"
+	snapshotLayout = "20060102T150405.000Z"
+)
+
+// Backup writes a consistent copy of the database to dest while the server
+// keeps running. It uses VACUUM INTO on a connection of its own, which only
+// holds a read transaction, so writers are not blocked in WAL mode. dest must
+// not exist; the copy appears there only once it is complete.
+func (d *SQliteDB) Backup(ctx context.Context, dest string) error {
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("backup error: %s already exists", dest)
+	}
+
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+
+	conn, err := sql.Open("sqlite3", dsn(d.path, d.opts, false))
+	if err != nil {
+		return fmt.Errorf("backup error: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `VACUUM INTO ?`, tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup error: %v", err)
+	}
+	if err := syncFile(tmp); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup error: %v", err)
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("backup error: %v", err)
+	}
+
+	return nil
+}
+
+// Snapshot backs the database up into dir under a timestamped name and then
+// deletes all but the keep most recent snapshots. It returns the path of
+// the new snapshot.
+func (d *SQliteDB) Snapshot(ctx context.Context, dir string, keep int) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("snapshot error: %v", err)
+	}
+
+	dest := filepath.Join(dir, snapshotPrefix+time.Now().UTC().Format(snapshotLayout)+".db")
+	if err := d.Backup(ctx, dest); err != nil {
+		return "", err
+	}
+
+	if err := rotateSnapshots(dir, keep); err != nil {
+		return dest, err
+	}
+
+	return dest, nil
+}
+
+// RunSnapshots takes a snapshot every interval until ctx is done. Failures
+// are logged and retried at the next tick.
+func (d *SQliteDB) RunSnapshots(ctx context.Context, dir string, every time.Duration, keep int) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			path, err := d.Snapshot(ctx, dir, keep)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("snapshot error: %v", err)
+				continue
+			}
+			log.Printf("snapshot written to %s", path)
+		}
+	}
+}
+
+// Snapshots lists the snapshots in dir, newest first.
+func Snapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots error: %v", err)
+	}
+
+	var out []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, snapshotPrefix) && strings.HasSuffix(name, ".db") {
+			out = append(out, filepath.Join(dir, name))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(out)))
+
+	return out, nil
+}
+
+func rotateSnapshots(dir string, keep int) error {
+	if keep < 1 {
+		return nil
+	}
+
+	snaps, err := Snapshots(dir)
+	if err != nil {
+		return err
+	}
+	for _, old := range snaps[min(keep, len(snaps)):] {
+		if err := os.Remove(old); err != nil {
+			return fmt.Errorf("rotate snapshots error: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// IntegrityCheck runs SQLite's integrity and foreign key checks and returns
+// the problems they report, none when the database is sound.
+func (d *SQliteDB) IntegrityCheck(ctx context.Context) ([]string, error) {
+	return integrityCheck(ctx, d.r)
+}
+
+func integrityCheck(ctx context.Context, q querier) ([]string, error) {
+	rows, err := q.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return nil, fmt.Errorf("integrity check error: %v", err)
+	}
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("integrity check error: %v", err)
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, fmt.Errorf("integrity check error: %v", err)
+	}
+
+	rows, err = q.QueryContext(ctx, `PRAGMA foreign_key_check`)
+	if err != nil {
+		return nil, fmt.Errorf("foreign key check error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			table, parent string
+			rowid         sql.NullInt64
+			fk            int
+		)
+		if err := rows.Scan(&table, &rowid, &parent, &fk); err != nil {
+			return nil, fmt.Errorf("foreign key check error: %v", err)
+		}
+		problems = append(problems, fmt.Sprintf("%s row %d references a missing %s", table, rowid.Int64, parent))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("foreign key check error: %v", err)
+	}
+
+	return problems, nil
+}
+
+// BackupInfo describes a backup file.
+type BackupInfo struct {
+	Version uint
+	Dirty   bool
+	// Problems are what the integrity check found.
+	Problems []string
+}
+
+// CheckBackup opens the database file at path read-only and reports its
+// schema version and integrity.
+func CheckBackup(ctx context.Context, path string) (*BackupInfo, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("check backup error: %v", err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("check backup error: %v", err)
+	}
+	defer db.Close()
+
+	var info BackupInfo
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&info.Version, &info.Dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("check backup error: not a RootTensor database: %v", err)
+	}
+
+	if info.Problems, err = integrityCheck(ctx, db); err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// Restore replaces the database at dest with the backup at src. The backup
+// must pass the integrity check and carry a clean schema version that this
+// binary knows; an older version is migrated up on the next start. The
+// server must not be running. The replaced database is kept next to dest
+// with a timestamped .pre-restore suffix; its path is returned.
+func Restore(ctx context.Context, src, dest string) (string, error) {
+	info, err := CheckBackup(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	latest, err := LatestVersion()
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case len(info.Problems) > 0:
+		return "", fmt.Errorf("restore error: %s failed the integrity check: %s", src, strings.Join(info.Problems, "; "))
+	case info.Version == 0:
+		return "", fmt.Errorf("restore error: %s has no schema version", src)
+	case info.Dirty:
+		return "", fmt.Errorf("restore error: %s is at dirty schema version %d", src, info.Version)
+	case info.Version > latest:
+		return "", fmt.Errorf("restore error: %s is at schema version %d, newer than %d", src, info.Version, latest)
+	}
+
+	tmp := dest + ".restore"
+	os.Remove(tmp)
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("restore error: %v", err)
+	}
+
+	// The WAL and shared memory files belong to the database being
+	// replaced; left in place SQLite would apply them to the backup.
+	var kept string
+	if _, err := os.Stat(dest); err == nil {
+		kept = dest + ".pre-restore-" + time.Now().UTC().Format(snapshotLayout)
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Rename(dest+suffix, kept+suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+				os.Remove(tmp)
+				return "", fmt.Errorf("restore error: %v", err)
+			}
+		}
+	}
+
+	if err := os.Rename(tmp, dest); err != nil {
+		return kept, fmt.Errorf("restore error: %v", err)
+	}
+
+	return kept, nil
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
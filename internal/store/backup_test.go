@@ -0,0 +1,256 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_Backup(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	t.Run("Backup: while writing", func(t *testing.T) {
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if err := db.CreateIncident(ctx, &Incident{Title: "busy", StartedAt: time.Now()}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+
+		time.Sleep(20 * time.Millisecond)
+		dest := filepath.Join(dir, "online.db")
+		err := db.Backup(ctx, dest)
+		close(stop)
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("failed to back up: %v", err)
+		}
+
+		info, err := CheckBackup(ctx, dest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		latest, _ := LatestVersion()
+		if info.Version != latest || info.Dirty || len(info.Problems) > 0 {
+			t.Errorf("unexpected backup: %+v", info)
+		}
+		if _, err := os.Stat(dest + ".tmp"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("temporary file left behind: %v", err)
+		}
+	})
+
+	t.Run("Backup: destination exists", func(t *testing.T) {
+		dest := filepath.Join(dir, "exists.db")
+		if err := os.WriteFile(dest, []byte("keep me"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Backup(ctx, dest); err == nil {
+			t.Fatal("expected error")
+		}
+		if b, _ := os.ReadFile(dest); string(b) != "keep me" {
+			t.Errorf("destination overwritten: %q", b)
+		}
+	})
+
+	t.Run("Snapshot: rotation", func(t *testing.T) {
+		snapDir := filepath.Join(dir, "snapshots")
+		if err := os.MkdirAll(snapDir, 0o750); err != nil {
+			t.Fatal(err)
+		}
+		for _, old := range []string{"20200101T000000.000Z", "20210101T000000.000Z", "20220101T000000.000Z"} {
+			if err := os.WriteFile(filepath.Join(snapDir, snapshotPrefix+old+".db"), nil, 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(filepath.Join(snapDir, "notes.txt"), nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		path, err := db.Snapshot(ctx, snapDir, 2)
+		if err != nil {
+			t.Fatalf("failed to snapshot: %v", err)
+		}
+
+		snaps, err := Snapshots(snapDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []string{path, filepath.Join(snapDir, snapshotPrefix+"20220101T000000.000Z.db")}
+		if strings.Join(snaps, ",") != strings.Join(want, ",") {
+			t.Errorf("snapshots: got %v want %v", snaps, want)
+		}
+		if _, err := os.Stat(filepath.Join(snapDir, "notes.txt")); err != nil {
+			t.Errorf("unrelated file removed: %v", err)
+		}
+	})
+
+	t.Run("RunSnapshots: stops with context", func(t *testing.T) {
+		snapDir := filepath.Join(dir, "scheduled")
+		cctx, cancel := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			db.RunSnapshots(cctx, snapDir, 10*time.Millisecond, 3)
+			close(done)
+		}()
+
+		deadline := time.After(5 * time.Second)
+		for {
+			snaps, _ := Snapshots(snapDir)
+			if len(snaps) >= 1 {
+				break
+			}
+			select {
+			case <-deadline:
+				t.Fatal("no scheduled snapshot written")
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		cancel()
+		<-done
+
+		snaps, _ := Snapshots(snapDir)
+		if len(snaps) > 3 {
+			t.Errorf("snapshots not rotated: %d", len(snaps))
+		}
+	})
+
+	t.Run("IntegrityCheck: sound", func(t *testing.T) {
+		problems, err := db.IntegrityCheck(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 0 {
+			t.Errorf("problems: %v", problems)
+		}
+	})
+
+	t.Run("CheckBackup: not a database", func(t *testing.T) {
+		junk := filepath.Join(dir, "junk.db")
+		if err := os.WriteFile(junk, []byte(strings.Repeat("junk", 1024)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CheckBackup(ctx, junk); err == nil {
+			t.Error("expected error")
+		}
+		if _, err := CheckBackup(ctx, filepath.Join(dir, "missing.db")); err == nil {
+			t.Error("expected error for missing file")
+		}
+	})
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	src := testDBMigrated(t)
+	testIncident(t, src, "from the backup")
+	backup := filepath.Join(dir, "backup.db")
+	if err := src.Backup(ctx, backup); err != nil {
+		t.Fatal(err)
+	}
+
+	// withSchemaVersion copies the backup and rewrites its version row.
+	withSchemaVersion := func(t *testing.T, name string, version int, dirty bool) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := copyFile(backup, path); err != nil {
+			t.Fatal(err)
+		}
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Exec(`UPDATE schema_migrations SET version = ?, dirty = ?`, version, dirty); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("Restore: rejects bad backups", func(t *testing.T) {
+		dest := filepath.Join(dir, "live.db")
+		latest, _ := LatestVersion()
+
+		tests := []struct {
+			name    string
+			src     string
+			wantErr string
+		}{
+			{"newer schema", withSchemaVersion(t, "newer.db", int(latest)+1, false), "newer than"},
+			{"dirty", withSchemaVersion(t, "dirty.db", int(latest), true), "dirty"},
+			{"missing", filepath.Join(dir, "nope.db"), "check backup error"},
+		}
+		for _, tt := range tests {
+			_, err := Restore(ctx, tt.src, dest)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got %v want %q", tt.name, err, tt.wantErr)
+			}
+			if _, err := os.Stat(dest); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("%s: destination touched", tt.name)
+			}
+		}
+	})
+
+	t.Run("Restore: swaps files", func(t *testing.T) {
+		live := testDBFileSetup(t)
+		defer testDBFileCleanup(t, live)
+
+		db, err := NewSQLiteDB(live)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		testIncident(t, db, "written after the backup")
+		db.Close()
+
+		kept, err := Restore(ctx, backup, live)
+		if err != nil {
+			t.Fatalf("failed to restore: %v", err)
+		}
+		t.Cleanup(func() {
+			for _, suffix := range []string{"", "-wal", "-shm"} {
+				os.Remove(kept + suffix)
+			}
+		})
+		if _, err := os.Stat(kept); err != nil {
+			t.Errorf("replaced database not kept: %v", err)
+		}
+		if _, err := os.Stat(live + "-wal"); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("stale WAL left next to the restored database")
+		}
+
+		db, err = NewSQLiteDB(live)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		got, err := db.ListIncidents(ctx, IncidentFilter{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].Title != "from the backup" {
+			t.Errorf("restored incidents: %+v", got)
+		}
+	})
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/blobs"
+)
+
+// BlobRef is a blob of the blob store and the number of evidence rows
+// referencing it.
+type BlobRef struct {
+	SHA256    string
+	Size      int64
+	Refs      int64
+	CreatedAt time.Time
+}
+
+// BlobGCResult reports what CollectBlobs did.
+type BlobGCResult struct {
+	Removed int
+	Freed   int64
+}
+
+// ListBlobs returns the blobs known to the database, referenced or not.
+func (d *SQliteDB) ListBlobs(ctx context.Context) ([]BlobRef, error) {
+	rows, err := d.r.QueryContext(ctx, `SELECT sha256, size, refs, created_at FROM blobs ORDER BY sha256`)
+	if err != nil {
+		return nil, fmt.Errorf("list blobs error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []BlobRef
+	for rows.Next() {
+		var b BlobRef
+		if err := rows.Scan(&b.SHA256, &b.Size, &b.Refs, &b.CreatedAt); err != nil {
+			return nil, fmt.Errorf("list blobs error: %v", err)
+		}
+		out = append(out, b)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list blobs error: %v", err)
+	}
+
+	return out, nil
+}
+
+// OpenEvidenceBlob returns a reader of the blob of evidence id. The reader
+// fails with blobs.ErrCorrupt at the end if the file does not match.
+func (d *SQliteDB) OpenEvidenceBlob(ctx context.Context, bs *blobs.Store, id int64) (io.ReadCloser, error) {
+	ev, err := d.GetEvidence(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ev.BlobSHA256 == "" {
+		return nil, ErrNotFound
+	}
+
+	return bs.Open(ev.BlobSHA256)
+}
+
+// CollectBlobs removes the files no evidence references any more and
+// forgets the unreferenced blobs. Files newer than grace are left alone so
+// that a blob put for evidence about to be created survives.
+func (d *SQliteDB) CollectBlobs(ctx context.Context, bs *blobs.Store, grace time.Duration) (*BlobGCResult, error) {
+	// Take the cutoff before reading the references: a file written after
+	// the query is then always newer than the cutoff.
+	cutoff := time.Now().Add(-grace)
+
+	refs, err := d.ListBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keep := make(map[string]bool, len(refs))
+	for _, b := range refs {
+		if b.Refs > 0 {
+			keep[b.SHA256] = true
+		}
+	}
+
+	removed, freed, err := bs.Sweep(func(sum string) bool { return keep[sum] }, cutoff)
+	res := &BlobGCResult{Removed: removed, Freed: freed}
+	if err != nil {
+		return res, err
+	}
+
+	if _, err := d.q.ExecContext(ctx, `DELETE FROM blobs WHERE refs = 0`); err != nil {
+		return res, fmt.Errorf("collect blobs error: %v", err)
+	}
+
+	return res, nil
+}
+
+// RunBlobGC collects unreferenced blobs every interval until ctx is done.
+// Failures are logged and retried at the next tick.
+func (d *SQliteDB) RunBlobGC(ctx context.Context, bs *blobs.Store, every, grace time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			res, err := d.CollectBlobs(ctx, bs, grace)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				log.Printf("blob gc error: %v", err)
+				continue
+			}
+			if res.Removed > 0 {
+				log.Printf("blob gc removed %d files, freed %d bytes", res.Removed, res.Freed)
+			}
+		}
+	}
+}
+
+// VerifyBlobs reads every referenced blob and returns a description of
+// each one that is missing or does not match its digest and size.
+func (d *SQliteDB) VerifyBlobs(ctx context.Context, bs *blobs.Store) ([]string, error) {
+	refs, err := d.ListBlobs(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+	for _, b := range refs {
+		if err := ctx.Err(); err != nil {
+			return problems, err
+		}
+		if b.Refs == 0 {
+			continue
+		}
+		if err := bs.Verify(blobs.Blob{SHA256: b.SHA256, Size: b.Size}); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", b.SHA256, err))
+		}
+	}
+
+	return problems, nil
+}
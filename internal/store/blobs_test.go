@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/blobs"
+)
+
+func TestSQLiteDB_Blobs(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	bs, err := blobs.NewStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inc := testIncident(t, db, "Redis timeouts")
+	b, err := bs.Put(strings.NewReader("ERROR redis: i/o timeout\n"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refs := func(t *testing.T) int64 {
+		t.Helper()
+		list, err := db.ListBlobs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range list {
+			if r.SHA256 == b.SHA256 {
+				return r.Refs
+			}
+		}
+		return -1
+	}
+
+	var evs []*Evidence
+	for _, title := range []string{"app.log", "copy of app.log"} {
+		ev := &Evidence{IncidentID: inc.ID, Title: title, BlobSHA256: b.SHA256, BlobSize: b.Size}
+		if err := db.CreateEvidence(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+		evs = append(evs, ev)
+	}
+
+	t.Run("Blobs: referenced by evidence", func(t *testing.T) {
+		if got := refs(t); got != 2 {
+			t.Errorf("refs: got %d want 2", got)
+		}
+		got, err := db.GetEvidence(ctx, evs[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.BlobSHA256 != b.SHA256 || got.BlobSize != b.Size {
+			t.Errorf("evidence: %+v", got)
+		}
+	})
+
+	t.Run("Blobs: read through evidence", func(t *testing.T) {
+		rc, err := db.OpenEvidenceBlob(ctx, bs, evs[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		got, _ := io.ReadAll(rc)
+		if string(got) != "ERROR redis: i/o timeout\n" {
+			t.Errorf("content: %q", got)
+		}
+
+		plain := &Evidence{IncidentID: inc.ID, Title: "note", Content: "inline"}
+		if err := db.CreateEvidence(ctx, plain); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.OpenEvidenceBlob(ctx, bs, plain.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Blobs: verify", func(t *testing.T) {
+		problems, err := db.VerifyBlobs(ctx, bs)
+		if err != nil || len(problems) != 0 {
+			t.Errorf("problems: %v, %v", problems, err)
+		}
+	})
+
+	t.Run("Blobs: kept while referenced", func(t *testing.T) {
+		if err := db.DeleteEvidence(ctx, evs[0].ID); err != nil {
+			t.Fatal(err)
+		}
+		if got := refs(t); got != 1 {
+			t.Errorf("refs: got %d want 1", got)
+		}
+		res, err := db.CollectBlobs(ctx, bs, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Removed != 0 {
+			t.Errorf("removed %d referenced blobs", res.Removed)
+		}
+	})
+
+	t.Run("Blobs: collected once unreferenced", func(t *testing.T) {
+		evs[1].BlobSHA256, evs[1].BlobSize = "", 0
+		if err := db.UpdateEvidence(ctx, evs[1]); err != nil {
+			t.Fatal(err)
+		}
+		if got := refs(t); got != 0 {
+			t.Errorf("refs: got %d want 0", got)
+		}
+
+		res, err := db.CollectBlobs(ctx, bs, -time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Removed != 1 || res.Freed != b.Size {
+			t.Errorf("unexpected result: %+v", res)
+		}
+		if got := refs(t); got != -1 {
+			t.Errorf("blob still listed with %d refs", got)
+		}
+	})
+
+	t.Run("Blobs: deleting the incident releases its blobs", func(t *testing.T) {
+		other := testIncident(t, db, "other")
+		if err := db.CreateEvidence(ctx, &Evidence{IncidentID: other.ID, BlobSHA256: b.SHA256, BlobSize: b.Size}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteIncident(ctx, other.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got := refs(t); got != 0 {
+			t.Errorf("refs: got %d want 0", got)
+		}
+	})
+}
@@ -0,0 +1,843 @@
+package store
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/blobs"
+)
+
+// A bundle is a tar.gz holding one incident and everything recorded for it,
+// so that it can be moved between installations. manifest.json lists every
+// other file with its size and SHA-256; evidence content is stored as
+// separate files next to the JSON documents, and the files of the blob store
+// attached to evidence as blobs/<sha256>. Version 2 added the blobs.
+const (
+	BundleFormat  = "roottensor-bundle"
+	BundleVersion = 2
+	// MaxBundleSize bounds the uncompressed size of the documents and
+	// evidence content ImportIncident reads into memory. Attached files are
+	// streamed to the blob store instead.
+	MaxBundleSize = 256 << 20
+
+	bundleManifest = "manifest.json"
+	bundleBlobDir  = "blobs"
+)
+
+var (
+	// ErrInvalidBundle is wrapped by the import errors caused by the bundle
+	// itself rather than by the store.
+	ErrInvalidBundle = errors.New("invalid bundle")
+	// ErrConflict is returned by ImportIncident when the incident already
+	// exists and the conflict policy is ConflictFail.
+	ErrConflict = errors.New("incident already exists")
+)
+
+// ConflictPolicy decides what ImportIncident does when an incident with the
+// bundle's uid already exists.
+type ConflictPolicy string
+
+const (
+	ConflictFail    ConflictPolicy = "fail"
+	ConflictSkip    ConflictPolicy = "skip"
+	ConflictReplace ConflictPolicy = "replace"
+	// ConflictCopy imports the bundle as a new incident with a new uid.
+	ConflictCopy ConflictPolicy = "copy"
+)
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case "":
+		return ConflictFail, nil
+	case ConflictFail, ConflictSkip, ConflictReplace, ConflictCopy:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown conflict policy %q", s)
+}
+
+type ImportOptions struct {
+	OnConflict ConflictPolicy
+}
+
+// ImportResult describes what ImportIncident did. IncidentID is the id the
+// incident has in this store; all ids in the bundle are remapped.
+type ImportResult struct {
+	IncidentID int64
+	UID        string
+	Title      string
+	Skipped    bool
+	Replaced   bool
+}
+
+type BundleManifest struct {
+	Format      string       `json:"format"`
+	Version     int          `json:"version"`
+	ExportedAt  time.Time    `json:"exported_at"`
+	IncidentUID string       `json:"incident_uid"`
+	Files       []BundleFile `json:"files"`
+}
+
+type BundleFile struct {
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+}
+
+type bundleIncident struct {
+	UID        string          `json:"uid"`
+	Title      string          `json:"title"`
+	Summary    string          `json:"summary"`
+	Status     IncidentStatus  `json:"status"`
+	Severity   Severity        `json:"severity"`
+	RCA        string          `json:"rca"`
+	StartedAt  time.Time       `json:"started_at"`
+	DetectedAt *time.Time      `json:"detected_at,omitempty"`
+	ResolvedAt *time.Time      `json:"resolved_at,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UpdatedAt  time.Time       `json:"updated_at"`
+	Services   []bundleService `json:"services"`
+}
+
+type bundleService struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Owner       string `json:"owner"`
+}
+
+type bundleTimelineEvent struct {
+	ID          int64     `json:"id"`
+	OccurredAt  time.Time `json:"occurred_at"`
+	Kind        string    `json:"kind"`
+	Description string    `json:"description"`
+	Source      string    `json:"source"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// bundleEvidence carries everything but the content, which is in File, and
+// the attached file, which is in Blob.
+type bundleEvidence struct {
+	ID          int64      `json:"id"`
+	Service     string     `json:"service,omitempty"`
+	Kind        string     `json:"kind"`
+	Title       string     `json:"title"`
+	Source      string     `json:"source"`
+	CollectedAt time.Time  `json:"collected_at"`
+	ArchivedAt  *time.Time `json:"archived_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	File        string     `json:"file"`
+	Blob        string     `json:"blob,omitempty"`
+}
+
+type bundleAnalysis struct {
+	ID         int64      `json:"id"`
+	Model      string     `json:"model"`
+	Draft      string     `json:"draft"`
+	Evidence   []string   `json:"evidence"`
+	Critique   string     `json:"critique,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ReviewedAt *time.Time `json:"reviewed_at,omitempty"`
+}
+
+type bundleHypothesis struct {
+	ID         int64            `json:"id"`
+	Statement  string           `json:"statement"`
+	Rationale  string           `json:"rationale"`
+	Status     HypothesisStatus `json:"status"`
+	Confidence float64          `json:"confidence"`
+	CreatedAt  time.Time        `json:"created_at"`
+	UpdatedAt  time.Time        `json:"updated_at"`
+}
+
+type bundleActionItem struct {
+	ID          int64            `json:"id"`
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Owner       string           `json:"owner"`
+	Status      ActionItemStatus `json:"status"`
+	DueAt       *time.Time       `json:"due_at,omitempty"`
+	CreatedAt   time.Time        `json:"created_at"`
+	UpdatedAt   time.Time        `json:"updated_at"`
+}
+
+type bundleChatMessage struct {
+	ID        int64     `json:"id"`
+	Role      string    `json:"role"`
+	Content   string    `json:"content"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// bundleContents is the decoded form of every document in a bundle.
+type bundleContents struct {
+	Incident    bundleIncident
+	Timeline    []bundleTimelineEvent
+	Evidence    []bundleEvidence
+	Analyses    []bundleAnalysis
+	Hypotheses  []bundleHypothesis
+	ActionItems []bundleActionItem
+	Chat        []bundleChatMessage
+	// Files holds the raw bundle files by path, evidence content included.
+	Files map[string][]byte
+	// Blobs are the attached files by path. On import they are already in
+	// the blob store.
+	Blobs map[string]blobs.Blob
+}
+
+// ExportIncident writes the incident with the given id as a bundle to w.
+// Files attached to its evidence are read from bs, which may be nil when
+// there are none.
+func (d *SQliteDB) ExportIncident(ctx context.Context, bs *blobs.Store, id int64, w io.Writer) error {
+	c, err := d.bundleContents(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(c.Blobs) > 0 && bs == nil {
+		return errors.New("export incident error: evidence has attached files but there is no blob store")
+	}
+
+	type file struct {
+		path string
+		data []byte
+	}
+	var files []file
+	add := func(p string, v any) error {
+		b, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return fmt.Errorf("export incident error: %v", err)
+		}
+		files = append(files, file{p, append(b, '\n')})
+		return nil
+	}
+
+	docs := []struct {
+		path string
+		v    any
+	}{
+		{"incident.json", c.Incident},
+		{"timeline.json", nonNil(c.Timeline)},
+		{"evidence.json", nonNil(c.Evidence)},
+		{"analyses.json", nonNil(c.Analyses)},
+		{"hypotheses.json", nonNil(c.Hypotheses)},
+		{"action_items.json", nonNil(c.ActionItems)},
+		{"chat.json", nonNil(c.Chat)},
+	}
+	for _, doc := range docs {
+		if err := add(doc.path, doc.v); err != nil {
+			return err
+		}
+	}
+	for _, ev := range c.Evidence {
+		files = append(files, file{ev.File, c.Files[ev.File]})
+	}
+
+	now := time.Now().UTC()
+	m := BundleManifest{Format: BundleFormat, Version: BundleVersion, ExportedAt: now, IncidentUID: c.Incident.UID}
+	for _, f := range files {
+		sum := sha256.Sum256(f.data)
+		m.Files = append(m.Files, BundleFile{Path: f.path, SHA256: hex.EncodeToString(sum[:]), Size: int64(len(f.data))})
+	}
+	// The blob store is content-addressed: the digest of a blob is known
+	// without reading it.
+	blobPaths := slices.Sorted(maps.Keys(c.Blobs))
+	for _, p := range blobPaths {
+		m.Files = append(m.Files, BundleFile{Path: p, SHA256: c.Blobs[p].SHA256, Size: c.Blobs[p].Size})
+	}
+	manifest, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("export incident error: %v", err)
+	}
+	files = append([]file{{bundleManifest, append(manifest, '\n')}}, files...)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.path, Mode: 0o644, Size: int64(len(f.data)), ModTime: now, Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("export incident error: %v", err)
+		}
+		if _, err := tw.Write(f.data); err != nil {
+			return fmt.Errorf("export incident error: %v", err)
+		}
+	}
+	for _, p := range blobPaths {
+		if err := writeBundleBlob(tw, bs, p, c.Blobs[p], now); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("export incident error: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("export incident error: %v", err)
+	}
+
+	return nil
+}
+
+// writeBundleBlob streams blob b into tw as p. Reading the blob verifies
+// it, so a corrupt file fails the export.
+func writeBundleBlob(tw *tar.Writer, bs *blobs.Store, p string, b blobs.Blob, now time.Time) error {
+	rc, err := bs.Open(b.SHA256)
+	if err != nil {
+		return fmt.Errorf("export incident error: blob %s: %v", b.SHA256, err)
+	}
+	defer rc.Close()
+
+	hdr := &tar.Header{Name: p, Mode: 0o644, Size: b.Size, ModTime: now, Typeflag: tar.TypeReg}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("export incident error: %v", err)
+	}
+	if _, err := io.Copy(tw, rc); err != nil {
+		return fmt.Errorf("export incident error: blob %s: %v", b.SHA256, err)
+	}
+
+	return nil
+}
+
+func (d *SQliteDB) bundleContents(ctx context.Context, id int64) (*bundleContents, error) {
+	inc, err := d.GetIncident(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	services, err := d.ListServices(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &bundleContents{
+		Incident: bundleIncident{
+			UID: inc.UID, Title: inc.Title, Summary: inc.Summary, Status: inc.Status,
+			Severity: inc.Severity, RCA: inc.RCA, StartedAt: inc.StartedAt,
+			DetectedAt: inc.DetectedAt, ResolvedAt: inc.ResolvedAt,
+			CreatedAt: inc.CreatedAt, UpdatedAt: inc.UpdatedAt,
+			Services: []bundleService{},
+		},
+		Files: map[string][]byte{},
+		Blobs: map[string]blobs.Blob{},
+	}
+	names := map[int64]string{}
+	for _, s := range services {
+		names[s.ID] = s.Name
+		c.Incident.Services = append(c.Incident.Services, bundleService{Name: s.Name, Description: s.Description, Owner: s.Owner})
+	}
+
+	timeline, err := d.ListTimelineEvents(ctx, TimelineFilter{IncidentID: id})
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range timeline {
+		c.Timeline = append(c.Timeline, bundleTimelineEvent{
+			ID: ev.ID, OccurredAt: ev.OccurredAt, Kind: ev.Kind,
+			Description: ev.Description, Source: ev.Source, CreatedAt: ev.CreatedAt,
+		})
+	}
+
+	evidence, err := d.ListEvidence(ctx, EvidenceFilter{IncidentID: id})
+	if err != nil {
+		return nil, err
+	}
+	for _, ev := range evidence {
+		b := bundleEvidence{
+			ID: ev.ID, Kind: ev.Kind, Title: ev.Title, Source: ev.Source,
+			CollectedAt: ev.CollectedAt, ArchivedAt: ev.ArchivedAt, CreatedAt: ev.CreatedAt,
+			File: fmt.Sprintf("evidence/%d.txt", ev.ID),
+		}
+		if ev.ServiceID != nil {
+			if name, ok := names[*ev.ServiceID]; ok {
+				b.Service = name
+			} else if svc, err := d.GetService(ctx, *ev.ServiceID); err == nil {
+				b.Service = svc.Name
+			}
+		}
+		if ev.BlobSHA256 != "" {
+			b.Blob = path.Join(bundleBlobDir, ev.BlobSHA256)
+			c.Blobs[b.Blob] = blobs.Blob{SHA256: ev.BlobSHA256, Size: ev.BlobSize}
+		}
+		c.Evidence = append(c.Evidence, b)
+		c.Files[b.File] = []byte(ev.Content)
+	}
+
+	analyses, err := d.ListAnalyses(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range analyses {
+		c.Analyses = append(c.Analyses, bundleAnalysis{
+			ID: a.ID, Model: a.Model, Draft: a.Draft, Evidence: nonNil(a.Evidence),
+			Critique: a.Critique, CreatedAt: a.CreatedAt, ReviewedAt: a.ReviewedAt,
+		})
+	}
+
+	hypotheses, err := d.ListHypotheses(ctx, HypothesisFilter{IncidentID: id})
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range hypotheses {
+		c.Hypotheses = append(c.Hypotheses, bundleHypothesis{
+			ID: h.ID, Statement: h.Statement, Rationale: h.Rationale, Status: h.Status,
+			Confidence: h.Confidence, CreatedAt: h.CreatedAt, UpdatedAt: h.UpdatedAt,
+		})
+	}
+
+	items, err := d.ListActionItems(ctx, ActionItemFilter{IncidentID: id})
+	if err != nil {
+		return nil, err
+	}
+	for _, ai := range items {
+		c.ActionItems = append(c.ActionItems, bundleActionItem{
+			ID: ai.ID, Title: ai.Title, Description: ai.Description, Owner: ai.Owner,
+			Status: ai.Status, DueAt: ai.DueAt, CreatedAt: ai.CreatedAt, UpdatedAt: ai.UpdatedAt,
+		})
+	}
+
+	chat, err := d.ListChatMessages(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range chat {
+		c.Chat = append(c.Chat, bundleChatMessage{ID: m.ID, Role: m.Role, Content: m.Content, CreatedAt: m.CreatedAt})
+	}
+
+	// Keep every list in creation order so that the ids assigned on import
+	// preserve it.
+	sort.Slice(c.Timeline, func(i, j int) bool { return c.Timeline[i].ID < c.Timeline[j].ID })
+	sort.Slice(c.Evidence, func(i, j int) bool { return c.Evidence[i].ID < c.Evidence[j].ID })
+	sort.Slice(c.Analyses, func(i, j int) bool { return c.Analyses[i].ID < c.Analyses[j].ID })
+	sort.Slice(c.Hypotheses, func(i, j int) bool { return c.Hypotheses[i].ID < c.Hypotheses[j].ID })
+	sort.Slice(c.ActionItems, func(i, j int) bool { return c.ActionItems[i].ID < c.ActionItems[j].ID })
+
+	return c, nil
+}
+
+// ImportIncident reads a bundle from r and stores its incident under new
+// ids. Services are matched by name and created when missing. The bundle is
+// rejected as a whole, wrapping ErrInvalidBundle, when a file is missing,
+// unlisted or does not match its checksum; nothing is written unless the
+// whole import succeeds. Attached files are put in bs, which may be nil for
+// bundles without any; files of a failed import are left to CollectBlobs.
+// The incident goes to the workspace of ctx; importing a published RCA
+// needs PermPublish.
+func (d *SQliteDB) ImportIncident(ctx context.Context, bs *blobs.Store, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	if err := authorize(ctx, PermEditIncidents, PermWriteEvidence); err != nil {
+		return nil, err
+	}
+
+	policy, err := ParseConflictPolicy(string(opts.OnConflict))
+	if err != nil {
+		return nil, fmt.Errorf("import incident error: %v", err)
+	}
+
+	c, err := readBundle(r, bs)
+	if err != nil {
+		return nil, err
+	}
+	if c.Incident.RCA != "" {
+		if err := authorize(ctx, PermPublish); err != nil {
+			return nil, err
+		}
+	}
+
+	res := &ImportResult{UID: c.Incident.UID, Title: c.Incident.Title}
+	err = d.WithTx(ctx, func(tx *SQliteDB) error {
+		existing, err := tx.GetIncidentByUID(ctx, c.Incident.UID)
+		switch {
+		case errors.Is(err, ErrNotFound):
+			// The uid may be taken in a workspace ctx cannot see; the
+			// bundle then comes in as a copy without telling.
+			if _, err := tx.GetIncidentByUID(WithWorkspace(ctx, 0), c.Incident.UID); err == nil {
+				res.UID = newUID()
+			} else if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+		case err != nil:
+			return err
+		case policy == ConflictSkip:
+			res.IncidentID, res.Skipped = existing.ID, true
+			return nil
+		case policy == ConflictReplace:
+			if err := tx.DeleteIncident(ctx, existing.ID); err != nil {
+				return err
+			}
+			res.Replaced = true
+		case policy == ConflictCopy:
+			res.UID = newUID()
+		default:
+			return fmt.Errorf("import incident error: %w: %q (uid %s)", ErrConflict, existing.Title, existing.UID)
+		}
+
+		res.IncidentID, err = tx.importBundle(ctx, c, res.UID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// importBundle inserts the bundle with SQL of its own rather than through
+// the Create methods so that the original timestamps are kept. Every row is
+// still recorded in the audit log.
+func (d *SQliteDB) importBundle(ctx context.Context, c *bundleContents, uid string) (int64, error) {
+	b := c.Incident
+	inc := &Incident{
+		UID: uid, WorkspaceID: targetWorkspace(ctx, 0),
+		Title: b.Title, Summary: b.Summary, Status: b.Status, Severity: b.Severity,
+		RCA: b.RCA, StartedAt: utc(b.StartedAt), DetectedAt: b.DetectedAt, ResolvedAt: b.ResolvedAt,
+		CreatedAt: utc(b.CreatedAt), UpdatedAt: utc(b.UpdatedAt),
+	}
+	err := d.q.QueryRowContext(ctx, `
+		INSERT INTO incidents (uid, workspace_id, title, summary, status, severity, rca, started_at, detected_at,
+			resolved_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`,
+		inc.UID, inc.WorkspaceID, inc.Title, inc.Summary, inc.Status, inc.Severity, inc.RCA, inc.StartedAt,
+		nullTime(inc.DetectedAt), nullTime(inc.ResolvedAt), inc.CreatedAt, inc.UpdatedAt).Scan(&inc.ID)
+	if err != nil {
+		return 0, fmt.Errorf("import incident error: %v", err)
+	}
+	if err := d.audit(ctx, AuditCreate, AuditIncident, inc.ID, inc.ID, nil, inc); err != nil {
+		return 0, err
+	}
+
+	services := map[string]int64{}
+	service := func(s bundleService) (int64, error) {
+		if id, ok := services[s.Name]; ok {
+			return id, nil
+		}
+		svc, err := d.GetServiceByName(ctx, s.Name)
+		if errors.Is(err, ErrNotFound) {
+			svc = &Service{Name: s.Name, Description: s.Description, Owner: s.Owner}
+			err = d.CreateService(ctx, svc)
+		}
+		if err != nil {
+			return 0, err
+		}
+		services[s.Name] = svc.ID
+		return svc.ID, nil
+	}
+	for _, s := range b.Services {
+		id, err := service(s)
+		if err != nil {
+			return 0, err
+		}
+		if err := d.AttachService(ctx, inc.ID, id); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, be := range c.Timeline {
+		ev := &TimelineEvent{
+			IncidentID: inc.ID, OccurredAt: utc(be.OccurredAt), Kind: be.Kind,
+			Description: be.Description, Source: be.Source, CreatedAt: utc(be.CreatedAt),
+		}
+		err := d.q.QueryRowContext(ctx, `
+			INSERT INTO timeline_events (incident_id, occurred_at, kind, description, source, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			ev.IncidentID, ev.OccurredAt, ev.Kind, ev.Description, ev.Source, ev.CreatedAt).Scan(&ev.ID)
+		if err != nil {
+			return 0, fmt.Errorf("import timeline event error: %v", err)
+		}
+		if err := d.audit(ctx, AuditCreate, AuditTimelineEvent, ev.ID, inc.ID, nil, ev); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, be := range c.Evidence {
+		ev := &Evidence{
+			IncidentID: inc.ID, Kind: be.Kind, Title: be.Title, Content: string(c.Files[be.File]),
+			Source: be.Source, CollectedAt: utc(be.CollectedAt), ArchivedAt: be.ArchivedAt,
+			CreatedAt: utc(be.CreatedAt),
+		}
+		if be.Service != "" {
+			id, err := service(bundleService{Name: be.Service})
+			if err != nil {
+				return 0, err
+			}
+			ev.ServiceID = &id
+		}
+		content, err := d.seal(ev.Content)
+		if err != nil {
+			return 0, err
+		}
+		if be.Blob != "" {
+			b := c.Blobs[be.Blob]
+			ev.BlobSHA256, ev.BlobSize = b.SHA256, b.Size
+		}
+		err = d.q.QueryRowContext(ctx, `
+			INSERT INTO evidence (incident_id, service_id, kind, title, content, blob_sha256, blob_size, source,
+				collected_at, archived_at, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			ev.IncidentID, nullInt64(ev.ServiceID), ev.Kind, ev.Title, content, nullString(ev.BlobSHA256), ev.BlobSize,
+			ev.Source, ev.CollectedAt, nullTime(ev.ArchivedAt), ev.CreatedAt).Scan(&ev.ID)
+		if err != nil {
+			return 0, fmt.Errorf("import evidence error: %v", err)
+		}
+		if err := d.audit(ctx, AuditCreate, AuditEvidence, ev.ID, inc.ID, nil, ev); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, ba := range c.Analyses {
+		a := &Analysis{
+			IncidentID: inc.ID, Model: ba.Model, Draft: ba.Draft, Evidence: nonNil(ba.Evidence),
+			Critique: ba.Critique, CreatedAt: utc(ba.CreatedAt), ReviewedAt: ba.ReviewedAt,
+		}
+		evidence, err := json.Marshal(a.Evidence)
+		if err != nil {
+			return 0, fmt.Errorf("import analysis error: %v", err)
+		}
+		var critique *string
+		if a.Critique != "" {
+			critique = &a.Critique
+		}
+		err = d.q.QueryRowContext(ctx, `
+			INSERT INTO analyses (incident_id, model, draft, evidence, critique, created_at, reviewed_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			a.IncidentID, a.Model, a.Draft, string(evidence), critique, a.CreatedAt, nullTime(a.ReviewedAt)).Scan(&a.ID)
+		if err != nil {
+			return 0, fmt.Errorf("import analysis error: %v", err)
+		}
+		if err := d.audit(ctx, AuditCreate, AuditAnalysis, a.ID, inc.ID, nil, a); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, bh := range c.Hypotheses {
+		h := &Hypothesis{
+			IncidentID: inc.ID, Statement: bh.Statement, Rationale: bh.Rationale, Status: bh.Status,
+			Confidence: bh.Confidence, CreatedAt: utc(bh.CreatedAt), UpdatedAt: utc(bh.UpdatedAt),
+		}
+		err := d.q.QueryRowContext(ctx, `
+			INSERT INTO hypotheses (incident_id, statement, rationale, status, confidence, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			h.IncidentID, h.Statement, h.Rationale, h.Status, h.Confidence, h.CreatedAt, h.UpdatedAt).Scan(&h.ID)
+		if err != nil {
+			return 0, fmt.Errorf("import hypothesis error: %v", err)
+		}
+		if err := d.audit(ctx, AuditCreate, AuditHypothesis, h.ID, inc.ID, nil, h); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, bi := range c.ActionItems {
+		ai := &ActionItem{
+			IncidentID: inc.ID, Title: bi.Title, Description: bi.Description, Owner: bi.Owner,
+			Status: bi.Status, DueAt: bi.DueAt, CreatedAt: utc(bi.CreatedAt), UpdatedAt: utc(bi.UpdatedAt),
+		}
+		err := d.q.QueryRowContext(ctx, `
+			INSERT INTO action_items (incident_id, title, description, owner, status, due_at, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id`,
+			ai.IncidentID, ai.Title, ai.Description, ai.Owner, ai.Status, nullTime(ai.DueAt),
+			ai.CreatedAt, ai.UpdatedAt).Scan(&ai.ID)
+		if err != nil {
+			return 0, fmt.Errorf("import action item error: %v", err)
+		}
+		if err := d.audit(ctx, AuditCreate, AuditActionItem, ai.ID, inc.ID, nil, ai); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, bm := range c.Chat {
+		m := &ChatMessage{IncidentID: inc.ID, Role: bm.Role, Content: bm.Content, CreatedAt: utc(bm.CreatedAt)}
+		err := d.q.QueryRowContext(ctx, `
+			INSERT INTO chat_messages (incident_id, role, content, created_at) VALUES (?, ?, ?, ?)
+			RETURNING id`,
+			m.IncidentID, m.Role, m.Content, m.CreatedAt).Scan(&m.ID)
+		if err != nil {
+			return 0, fmt.Errorf("import chat message error: %v", err)
+		}
+		if err := d.audit(ctx, AuditCreate, AuditChatMessage, m.ID, inc.ID, nil, m); err != nil {
+			return 0, err
+		}
+	}
+
+	return inc.ID, nil
+}
+
+// readBundle unpacks and verifies a bundle. At most MaxBundleSize bytes of
+// file content are read into memory; attached files are put in bs as they
+// come.
+func readBundle(r io.Reader, bs *blobs.Store) (*bundleContents, error) {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("import incident error: %w: "+format, append([]any{ErrInvalidBundle}, args...)...)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, invalid("%v", err)
+	}
+	defer gz.Close()
+
+	files := map[string][]byte{}
+	stored := map[string]blobs.Blob{}
+	var total int64
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, invalid("%v", err)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			continue
+		case tar.TypeReg:
+		default:
+			return nil, invalid("%s is not a regular file", hdr.Name)
+		}
+
+		name := path.Clean(hdr.Name)
+		_, dup := files[name]
+		if _, dupBlob := stored[name]; dup || dupBlob {
+			return nil, invalid("%s appears twice", name)
+		}
+		if path.Dir(name) == bundleBlobDir {
+			if bs == nil {
+				return nil, errors.New("import incident error: the bundle has attached files but there is no blob store")
+			}
+			src := &readErrRecorder{r: tr}
+			b, err := bs.Put(src, hdr.Size)
+			if src.err != nil {
+				return nil, invalid("%v", src.err)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("import incident error: %v", err)
+			}
+			if path.Base(name) != b.SHA256 {
+				return nil, invalid("%s does not match its checksum", name)
+			}
+			stored[name] = b
+			continue
+		}
+		if total += hdr.Size; hdr.Size < 0 || total > MaxBundleSize {
+			return nil, invalid("larger than %d bytes", MaxBundleSize)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, invalid("%v", err)
+		}
+		files[name] = b
+	}
+
+	raw, ok := files[bundleManifest]
+	if !ok {
+		return nil, invalid("no %s", bundleManifest)
+	}
+	delete(files, bundleManifest)
+
+	var m BundleManifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, invalid("%s: %v", bundleManifest, err)
+	}
+	if m.Format != BundleFormat {
+		return nil, invalid("format %q, want %q", m.Format, BundleFormat)
+	}
+	if m.Version < 1 || m.Version > BundleVersion {
+		return nil, invalid("unsupported version %d", m.Version)
+	}
+
+	listed := map[string]bool{}
+	for _, f := range m.Files {
+		if b, ok := stored[f.Path]; ok {
+			if b.Size != f.Size || b.SHA256 != f.SHA256 {
+				return nil, invalid("%s does not match its checksum", f.Path)
+			}
+			listed[f.Path] = true
+			continue
+		}
+		b, ok := files[f.Path]
+		if !ok {
+			return nil, invalid("%s is listed but missing", f.Path)
+		}
+		sum := sha256.Sum256(b)
+		if int64(len(b)) != f.Size || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return nil, invalid("%s does not match its checksum", f.Path)
+		}
+		listed[f.Path] = true
+	}
+	for name := range files {
+		if !listed[name] {
+			return nil, invalid("%s is not listed in the manifest", name)
+		}
+	}
+	for name := range stored {
+		if !listed[name] {
+			return nil, invalid("%s is not listed in the manifest", name)
+		}
+	}
+
+	c := &bundleContents{Files: files, Blobs: stored}
+	docs := []struct {
+		path string
+		v    any
+	}{
+		{"incident.json", &c.Incident},
+		{"timeline.json", &c.Timeline},
+		{"evidence.json", &c.Evidence},
+		{"analyses.json", &c.Analyses},
+		{"hypotheses.json", &c.Hypotheses},
+		{"action_items.json", &c.ActionItems},
+		{"chat.json", &c.Chat},
+	}
+	for _, doc := range docs {
+		b, ok := files[doc.path]
+		if !ok {
+			return nil, invalid("no %s", doc.path)
+		}
+		if err := json.Unmarshal(b, doc.v); err != nil {
+			return nil, invalid("%s: %v", doc.path, err)
+		}
+	}
+
+	if c.Incident.UID == "" || c.Incident.UID != m.IncidentUID {
+		return nil, invalid("incident uid %q does not match the manifest", c.Incident.UID)
+	}
+	if c.Incident.Title == "" {
+		return nil, invalid("incident has no title")
+	}
+	for _, ev := range c.Evidence {
+		if _, ok := files[ev.File]; !ok || path.Dir(ev.File) != "evidence" {
+			return nil, invalid("evidence %d: no content file %q", ev.ID, ev.File)
+		}
+		if _, ok := stored[ev.Blob]; ev.Blob != "" && !ok {
+			return nil, invalid("evidence %d: no attached file %q", ev.ID, ev.Blob)
+		}
+	}
+
+	return c, nil
+}
+
+// readErrRecorder keeps the error of reading r, so that a broken bundle
+// can be told apart from a failing blob store.
+type readErrRecorder struct {
+	r   io.Reader
+	err error
+}
+
+func (e *readErrRecorder) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	if err != nil && err != io.EOF {
+		e.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,329 @@
+package store
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dtoebe/RootTensor/internal/blobs"
+)
+
+func TestSQLiteDB_Bundle(t *testing.T) {
+	src := testDBMigrated(t)
+	ctx := context.Background()
+
+	base := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+	payments := &Service{Name: "payments", Owner: "team-pay"}
+	if err := src.CreateService(ctx, payments); err != nil {
+		t.Fatal(err)
+	}
+	inc := &Incident{Title: "Redis timeouts", Severity: Sev2, RCA: "failover", StartedAt: base}
+	if err := src.CreateIncident(ctx, inc); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.AttachService(ctx, inc.ID, payments.ID); err != nil {
+		t.Fatal(err)
+	}
+	for _, create := range []func() error{
+		func() error {
+			return src.CreateTimelineEvent(ctx, &TimelineEvent{IncidentID: inc.ID, OccurredAt: base, Kind: "alert", Description: "p99 up"})
+		},
+		func() error {
+			return src.CreateEvidence(ctx, &Evidence{IncidentID: inc.ID, ServiceID: &payments.ID, Title: "app log",
+				Content: "dial redis:6379: i/o timeout\n", CollectedAt: base})
+		},
+		func() error {
+			a := &Analysis{IncidentID: inc.ID, Model: "llama3", Draft: "Redis failed over", Evidence: []string{"app log"}}
+			if err := src.CreateAnalysis(ctx, a); err != nil {
+				return err
+			}
+			return src.SetAnalysisCritique(ctx, a.ID, `{"claims":[]}`)
+		},
+		func() error {
+			return src.CreateHypothesis(ctx, &Hypothesis{IncidentID: inc.ID, Statement: "failover", Confidence: 0.7})
+		},
+		func() error {
+			return src.CreateActionItem(ctx, &ActionItem{IncidentID: inc.ID, Title: "alert on failover"})
+		},
+		func() error {
+			return src.CreateChatMessage(ctx, &ChatMessage{IncidentID: inc.ID, Role: "user", Content: "why?"})
+		},
+	} {
+		if err := create(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var bundle bytes.Buffer
+	if err := src.ExportIncident(ctx, nil, inc.ID, &bundle); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+
+	dst := testDBMigrated(t)
+	// Occupy the low ids so that the import has to remap them.
+	testIncident(t, dst, "unrelated")
+
+	t.Run("ImportIncident: round trip", func(t *testing.T) {
+		res, err := dst.ImportIncident(ctx, nil, bytes.NewReader(bundle.Bytes()), ImportOptions{})
+		if err != nil {
+			t.Fatalf("failed to import: %v", err)
+		}
+		if res.IncidentID == inc.ID || res.UID != inc.UID || res.Skipped || res.Replaced {
+			t.Fatalf("unexpected result: %+v", res)
+		}
+
+		got, err := dst.GetIncident(ctx, res.IncidentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != inc.Title || got.RCA != inc.RCA || !got.StartedAt.Equal(base) || !got.CreatedAt.Equal(inc.CreatedAt) {
+			t.Errorf("incident: got %+v want %+v", got, inc)
+		}
+
+		svcs, err := dst.ListServices(ctx, res.IncidentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(svcs) != 1 || svcs[0].Name != "payments" || svcs[0].Owner != "team-pay" {
+			t.Fatalf("services: %+v", svcs)
+		}
+
+		ev, err := dst.ListEvidence(ctx, EvidenceFilter{IncidentID: res.IncidentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ev) != 1 || ev[0].Content != "dial redis:6379: i/o timeout\n" || ev[0].ServiceID == nil || *ev[0].ServiceID != svcs[0].ID {
+			t.Errorf("evidence: %+v", ev)
+		}
+
+		as, err := dst.ListAnalyses(ctx, res.IncidentID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(as) != 1 || as[0].Critique != `{"claims":[]}` || as[0].ReviewedAt == nil || len(as[0].Evidence) != 1 {
+			t.Errorf("analyses: %+v", as)
+		}
+
+		tl, _ := dst.ListTimelineEvents(ctx, TimelineFilter{IncidentID: res.IncidentID})
+		hs, _ := dst.ListHypotheses(ctx, HypothesisFilter{IncidentID: res.IncidentID})
+		ais, _ := dst.ListActionItems(ctx, ActionItemFilter{IncidentID: res.IncidentID})
+		chat, _ := dst.ListChatMessages(ctx, res.IncidentID)
+		if len(tl) != 1 || len(hs) != 1 || len(ais) != 1 || len(chat) != 1 {
+			t.Errorf("counts: timeline %d hypotheses %d action items %d chat %d", len(tl), len(hs), len(ais), len(chat))
+		}
+	})
+
+	t.Run("ImportIncident: conflicts", func(t *testing.T) {
+		_, err := dst.ImportIncident(ctx, nil, bytes.NewReader(bundle.Bytes()), ImportOptions{OnConflict: ConflictFail})
+		if !errors.Is(err, ErrConflict) {
+			t.Errorf("fail: expected ErrConflict, got %v", err)
+		}
+
+		existing, err := dst.GetIncidentByUID(ctx, inc.UID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := dst.ImportIncident(ctx, nil, bytes.NewReader(bundle.Bytes()), ImportOptions{OnConflict: ConflictSkip})
+		if err != nil || !res.Skipped || res.IncidentID != existing.ID {
+			t.Errorf("skip: got %+v, %v", res, err)
+		}
+
+		res, err = dst.ImportIncident(ctx, nil, bytes.NewReader(bundle.Bytes()), ImportOptions{OnConflict: ConflictCopy})
+		if err != nil || res.UID == inc.UID || res.IncidentID == existing.ID {
+			t.Errorf("copy: got %+v, %v", res, err)
+		}
+
+		res, err = dst.ImportIncident(ctx, nil, bytes.NewReader(bundle.Bytes()), ImportOptions{OnConflict: ConflictReplace})
+		if err != nil || !res.Replaced || res.UID != inc.UID {
+			t.Fatalf("replace: got %+v, %v", res, err)
+		}
+		if _, err := dst.GetIncident(ctx, existing.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("replaced incident still there: %v", err)
+		}
+		ev, _ := dst.ListEvidence(ctx, EvidenceFilter{IncidentID: res.IncidentID})
+		if len(ev) != 1 {
+			t.Errorf("evidence after replace: %d", len(ev))
+		}
+
+		if _, err := dst.ImportIncident(ctx, nil, bytes.NewReader(bundle.Bytes()), ImportOptions{OnConflict: "merge"}); err == nil {
+			t.Error("expected unknown policy error")
+		}
+	})
+
+	t.Run("ImportIncident: invalid bundles", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			modify func(files map[string][]byte)
+		}{
+			{"tampered evidence", func(f map[string][]byte) { f["evidence/1.txt"] = []byte("nothing to see") }},
+			{"missing file", func(f map[string][]byte) { delete(f, "chat.json") }},
+			{"unlisted file", func(f map[string][]byte) { f["extra.sh"] = []byte("rm -rf /") }},
+			{"no manifest", func(f map[string][]byte) { delete(f, "manifest.json") }},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				files := testUnpackBundle(t, bundle.Bytes())
+				tt.modify(files)
+
+				_, err := dst.ImportIncident(ctx, nil, testPackBundle(t, files), ImportOptions{OnConflict: ConflictCopy})
+				if !errors.Is(err, ErrInvalidBundle) {
+					t.Errorf("expected ErrInvalidBundle, got %v", err)
+				}
+			})
+		}
+
+		if _, err := dst.ImportIncident(ctx, nil, bytes.NewReader([]byte("not gzip")), ImportOptions{}); !errors.Is(err, ErrInvalidBundle) {
+			t.Errorf("garbage: expected ErrInvalidBundle, got %v", err)
+		}
+	})
+
+	t.Run("ExportIncident: not found", func(t *testing.T) {
+		if err := src.ExportIncident(ctx, nil, 999, io.Discard); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestSQLiteDB_BundleBlobs(t *testing.T) {
+	src := testDBMigrated(t)
+	ctx := context.Background()
+	srcBlobs, err := blobs.NewStore(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := strings.Repeat("ERROR redis: i/o timeout\n", 1000)
+	b, err := srcBlobs.Put(strings.NewReader(content), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inc := testIncident(t, src, "Redis timeouts")
+	if err := src.CreateEvidence(ctx, &Evidence{IncidentID: inc.ID, Title: "app.log", BlobSHA256: b.SHA256, BlobSize: b.Size}); err != nil {
+		t.Fatal(err)
+	}
+
+	var bundle bytes.Buffer
+	if err := src.ExportIncident(ctx, srcBlobs, inc.ID, &bundle); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	blobPath := "blobs/" + b.SHA256
+
+	t.Run("ExportIncident: attached file", func(t *testing.T) {
+		files := testUnpackBundle(t, bundle.Bytes())
+		if string(files[blobPath]) != content {
+			t.Errorf("%s: got %d bytes want %d", blobPath, len(files[blobPath]), len(content))
+		}
+		if !bytes.Contains(files["manifest.json"], []byte(b.SHA256)) {
+			t.Errorf("manifest does not list the blob: %s", files["manifest.json"])
+		}
+		if err := src.ExportIncident(ctx, nil, inc.ID, io.Discard); err == nil {
+			t.Error("expected an error without a blob store")
+		}
+	})
+
+	t.Run("ImportIncident: attached file", func(t *testing.T) {
+		dst := testDBMigrated(t)
+		dstBlobs, err := blobs.NewStore(t.TempDir(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := dst.ImportIncident(ctx, dstBlobs, bytes.NewReader(bundle.Bytes()), ImportOptions{})
+		if err != nil {
+			t.Fatalf("failed to import: %v", err)
+		}
+
+		ev, err := dst.ListEvidence(ctx, EvidenceFilter{IncidentID: res.IncidentID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ev) != 1 || ev[0].BlobSHA256 != b.SHA256 || ev[0].BlobSize != b.Size {
+			t.Fatalf("evidence: %+v", ev)
+		}
+		rc, err := dst.OpenEvidenceBlob(ctx, dstBlobs, ev[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		if got, err := io.ReadAll(rc); err != nil || string(got) != content {
+			t.Errorf("blob: got %d bytes, %v", len(got), err)
+		}
+		refs, err := dst.ListBlobs(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(refs) != 1 || refs[0].Refs != 1 {
+			t.Errorf("blob refs: %+v", refs)
+		}
+	})
+
+	t.Run("ImportIncident: tampered attached file", func(t *testing.T) {
+		dst := testDBMigrated(t)
+		dstBlobs, err := blobs.NewStore(t.TempDir(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := testUnpackBundle(t, bundle.Bytes())
+		files[blobPath] = []byte("nothing to see")
+
+		_, err = dst.ImportIncident(ctx, dstBlobs, testPackBundle(t, files), ImportOptions{})
+		if !errors.Is(err, ErrInvalidBundle) {
+			t.Errorf("expected ErrInvalidBundle, got %v", err)
+		}
+		if _, err := dst.ImportIncident(ctx, nil, bytes.NewReader(bundle.Bytes()), ImportOptions{}); err == nil {
+			t.Error("expected an error without a blob store")
+		}
+	})
+}
+
+func testUnpackBundle(t *testing.T, b []byte) map[string][]byte {
+	t.Helper()
+
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if files[hdr.Name], err = io.ReadAll(tr); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testPackBundle(t *testing.T, files map[string][]byte) io.Reader {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for name, data := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return &buf
+}
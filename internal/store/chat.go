@@ -0,0 +1,119 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ChatMessage is one message of the conversation held inside an incident.
+// Role is "system", "user" or "assistant".
+type ChatMessage struct {
+	ID         int64
+	IncidentID int64
+	Role       string
+	Content    string
+	CreatedAt  time.Time
+}
+
+const chatMessageColumns = `id, incident_id, role, content, created_at`
+
+func (d *SQliteDB) CreateChatMessage(ctx context.Context, m *ChatMessage) error {
+	if err := authorize(ctx, PermRunAnalyses); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, m.IncidentID); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO chat_messages (incident_id, role, content) VALUES (?, ?, ?)
+			RETURNING id, created_at`,
+			m.IncidentID, m.Role, m.Content)
+		if err := row.Scan(&m.ID, &m.CreatedAt); err != nil {
+			return fmt.Errorf("create chat message error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditChatMessage, m.ID, m.IncidentID, nil, m)
+	})
+}
+
+func (d *SQliteDB) GetChatMessage(ctx context.Context, id int64) (*ChatMessage, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+chatMessageColumns+` FROM chat_messages`+w.String(), w.args...)
+	m, err := scanChatMessage(row)
+	if err != nil {
+		return nil, notFound(err, "get chat message")
+	}
+
+	return m, nil
+}
+
+// ListChatMessages returns the conversation of an incident in the order
+// it was written.
+func (d *SQliteDB) ListChatMessages(ctx context.Context, incidentID int64) ([]ChatMessage, error) {
+	var w where
+	w.add("incident_id = ?", incidentID)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	rows, err := d.r.QueryContext(ctx,
+		`SELECT `+chatMessageColumns+` FROM chat_messages`+w.String()+` ORDER BY id`, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list chat messages error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []ChatMessage
+	for rows.Next() {
+		m, err := scanChatMessage(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list chat messages error: %v", err)
+		}
+		out = append(out, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list chat messages error: %v", err)
+	}
+
+	return out, nil
+}
+
+// UpdateChatMessage replaces the content of a message, as when an answer
+// is regenerated. The previous content stays in the audit log.
+func (d *SQliteDB) UpdateChatMessage(ctx context.Context, m *ChatMessage) error {
+	if err := authorize(ctx, PermRunAnalyses); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetChatMessage(ctx, m.ID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `UPDATE chat_messages SET content = ? WHERE id = ?`, m.Content, m.ID); err != nil {
+			return fmt.Errorf("update chat message error: %v", err)
+		}
+
+		cur, err := tx.GetChatMessage(ctx, m.ID)
+		if err != nil {
+			return err
+		}
+		*m = *cur
+		return tx.audit(ctx, AuditUpdate, AuditChatMessage, m.ID, old.IncidentID, old, cur)
+	})
+}
+
+func scanChatMessage(s scanner) (*ChatMessage, error) {
+	var m ChatMessage
+	if err := s.Scan(&m.ID, &m.IncidentID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestSQLiteDB_ChatMessages(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "redis timeouts")
+	other := testIncident(t, db, "dns outage")
+
+	t.Run("CreateChatMessage: conversation per incident", func(t *testing.T) {
+		msgs := []*ChatMessage{
+			{IncidentID: inc.ID, Role: "user", Content: "what changed before 14:00?"},
+			{IncidentID: inc.ID, Role: "assistant", Content: "payments v2.3.1 was deployed"},
+			{IncidentID: other.ID, Role: "user", Content: "unrelated"},
+		}
+		for _, m := range msgs {
+			if err := db.CreateChatMessage(ctx, m); err != nil {
+				t.Fatalf("failed to create chat message: %v", err)
+			}
+		}
+
+		got, err := db.ListChatMessages(ctx, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Role != "user" || got[1].Role != "assistant" {
+			t.Errorf("conversation: got %+v", got)
+		}
+	})
+
+	t.Run("CreateChatMessage: invalid role", func(t *testing.T) {
+		if err := db.CreateChatMessage(ctx, &ChatMessage{IncidentID: inc.ID, Role: "robot", Content: "x"}); err == nil {
+			t.Fatal("expected check constraint error")
+		}
+	})
+}
+
+func TestSQLiteDB_UpdateChatMessage(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	inc := testIncident(t, db, "redis timeouts")
+
+	m := &ChatMessage{IncidentID: inc.ID, Role: "assistant", Content: "first answer"}
+	if err := db.CreateChatMessage(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("UpdateChatMessage: regenerated answer", func(t *testing.T) {
+		m.Content = "second answer"
+		if err := db.UpdateChatMessage(ctx, m); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetChatMessage(ctx, m.ID)
+		if err != nil || got.Content != "second answer" || got.Role != "assistant" {
+			t.Errorf("got %+v, %v", got, err)
+		}
+
+		entries, err := db.ListAudit(ctx, AuditFilter{Entity: AuditChatMessage, EntityID: fmt.Sprint(m.ID)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 2 || entries[0].Action != AuditUpdate {
+			t.Errorf("audit: got %+v", entries)
+		}
+	})
+
+	t.Run("UpdateChatMessage: not found", func(t *testing.T) {
+		if err := db.UpdateChatMessage(ctx, &ChatMessage{ID: 9999, Content: "x"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type DebateStatus string
+
+const (
+	DebateRunning  DebateStatus = "running"
+	DebateFinished DebateStatus = "finished"
+	DebateFailed   DebateStatus = "failed"
+)
+
+// Debate is a stored multi-agent debate and its transcript.
+type Debate struct {
+	ID         int64
+	IncidentID int64
+	Problem    string
+	Agents     []string
+	Rounds     int
+	Status     DebateStatus
+	Verdict    string
+	Error      string
+	Turns      []DebateTurn
+	CreatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+type DebateTurn struct {
+	ID        int64
+	DebateID  int64
+	Round     int
+	Agent     string
+	Content   string
+	CreatedAt time.Time
+}
+
+func (d *SQliteDB) CreateDebate(ctx context.Context, deb *Debate) error {
+	if err := authorize(ctx, PermRunAnalyses); err != nil {
+		return err
+	}
+
+	agents, err := json.Marshal(nonNil(deb.Agents))
+	if err != nil {
+		return fmt.Errorf("debate agents marshal error: %v", err)
+	}
+
+	deb.Status = DebateRunning
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, deb.IncidentID); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO debates (incident_id, problem, agents, rounds, status)
+			VALUES (?, ?, ?, ?, ?)
+			RETURNING id, created_at`,
+			deb.IncidentID, deb.Problem, string(agents), deb.Rounds, deb.Status)
+		if err := row.Scan(&deb.ID, &deb.CreatedAt); err != nil {
+			return fmt.Errorf("create debate error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditDebate, deb.ID, deb.IncidentID, nil, deb)
+	})
+}
+
+// AddDebateTurn appends one turn to the transcript of a running debate.
+func (d *SQliteDB) AddDebateTurn(ctx context.Context, t *DebateTurn) error {
+	if err := authorize(ctx, PermRunAnalyses); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if _, ok := WorkspaceFrom(ctx); ok {
+			if _, err := tx.GetDebate(ctx, t.DebateID); err != nil {
+				return err
+			}
+		}
+
+		var incidentID int64
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO debate_turns (debate_id, round, agent, content)
+			VALUES (?, ?, ?, ?)
+			RETURNING id, created_at, (SELECT incident_id FROM debates WHERE id = debate_id)`,
+			t.DebateID, t.Round, t.Agent, t.Content)
+		if err := row.Scan(&t.ID, &t.CreatedAt, &incidentID); err != nil {
+			return fmt.Errorf("add debate turn error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditDebateTurn, t.ID, incidentID, nil, t)
+	})
+}
+
+// FinishDebate records the judge's verdict, or the error that stopped the
+// debate when runErr is not nil.
+func (d *SQliteDB) FinishDebate(ctx context.Context, id int64, verdict string, runErr error) error {
+	status, msg := DebateFinished, ""
+	if runErr != nil {
+		status, msg = DebateFailed, runErr.Error()
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetDebate(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			UPDATE debates SET status = ?, verdict = ?, error = ?, finished_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, status, verdict, msg, id)
+		if err != nil {
+			return fmt.Errorf("finish debate error: %v", err)
+		}
+
+		cur, err := tx.GetDebate(ctx, id)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditDebate, id, old.IncidentID, old, cur)
+	})
+}
+
+// GetDebate returns a debate with its transcript ordered by round.
+func (d *SQliteDB) GetDebate(ctx context.Context, id int64) (*Debate, error) {
+	var (
+		deb        Debate
+		agents     string
+		finishedAt sql.NullTime
+	)
+
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	row := d.r.QueryRowContext(ctx, `
+		SELECT id, incident_id, problem, agents, rounds, status, verdict, error, created_at, finished_at
+		FROM debates`+w.String(), w.args...)
+	err := row.Scan(&deb.ID, &deb.IncidentID, &deb.Problem, &agents, &deb.Rounds,
+		&deb.Status, &deb.Verdict, &deb.Error, &deb.CreatedAt, &finishedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get debate error: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(agents), &deb.Agents); err != nil {
+		return nil, fmt.Errorf("debate agents decode error: %v", err)
+	}
+	if finishedAt.Valid {
+		deb.FinishedAt = &finishedAt.Time
+	}
+
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT id, debate_id, round, agent, content, created_at
+		FROM debate_turns WHERE debate_id = ? ORDER BY round, id`, id)
+	if err != nil {
+		return nil, fmt.Errorf("get debate turns error: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var t DebateTurn
+		if err := rows.Scan(&t.ID, &t.DebateID, &t.Round, &t.Agent, &t.Content, &t.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan debate turn error: %v", err)
+		}
+		deb.Turns = append(deb.Turns, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get debate turns error: %v", err)
+	}
+
+	return &deb, nil
+}
@@ -0,0 +1,93 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteDB_Debates(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	deb := &Debate{
+		IncidentID: testIncident(t, db, "checkout latency").ID,
+		Problem:    "checkout latency spike",
+		Agents:     []string{"SRE", "DBA"},
+		Rounds:     1,
+	}
+
+	t.Run("CreateDebate: success", func(t *testing.T) {
+		if err := db.CreateDebate(ctx, deb); err != nil {
+			t.Fatalf("failed to create debate: %v", err)
+		}
+		if deb.ID == 0 || deb.Status != DebateRunning {
+			t.Fatalf("unexpected debate after create: %+v", deb)
+		}
+	})
+
+	t.Run("AddDebateTurn: transcript ordered by round", func(t *testing.T) {
+		turns := []DebateTurn{
+			{DebateID: deb.ID, Round: 1, Agent: "SRE", Content: "still the deploy"},
+			{DebateID: deb.ID, Round: 0, Agent: "SRE", Content: "the deploy"},
+			{DebateID: deb.ID, Round: 0, Agent: "DBA", Content: "lock contention"},
+		}
+		for i := range turns {
+			if err := db.AddDebateTurn(ctx, &turns[i]); err != nil {
+				t.Fatalf("failed to add turn: %v", err)
+			}
+		}
+
+		got, err := db.GetDebate(ctx, deb.ID)
+		if err != nil {
+			t.Fatalf("failed to get debate: %v", err)
+		}
+		var order []string
+		for _, tr := range got.Turns {
+			order = append(order, tr.Content)
+		}
+		want := []string{"the deploy", "lock contention", "still the deploy"}
+		if !reflect.DeepEqual(order, want) {
+			t.Errorf("turn order: got %v want %v", order, want)
+		}
+		if !reflect.DeepEqual(got.Agents, deb.Agents) {
+			t.Errorf("agents: got %v want %v", got.Agents, deb.Agents)
+		}
+	})
+
+	t.Run("FinishDebate: verdict", func(t *testing.T) {
+		if err := db.FinishDebate(ctx, deb.ID, "it was the deploy", nil); err != nil {
+			t.Fatalf("failed to finish debate: %v", err)
+		}
+		got, err := db.GetDebate(ctx, deb.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != DebateFinished || got.Verdict != "it was the deploy" || got.FinishedAt == nil {
+			t.Errorf("unexpected finished debate: %+v", got)
+		}
+	})
+
+	t.Run("FinishDebate: failure", func(t *testing.T) {
+		if err := db.FinishDebate(ctx, deb.ID, "", errors.New("model down")); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetDebate(ctx, deb.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != DebateFailed || got.Error != "model down" {
+			t.Errorf("unexpected failed debate: %+v", got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := db.GetDebate(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetDebate: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.FinishDebate(ctx, 9999, "", nil); !errors.Is(err, ErrNotFound) {
+			t.Errorf("FinishDebate: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,395 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrDimensions is returned when a vector does not match the dimension of
+// the embeddings it is compared with or stored next to.
+var ErrDimensions = errors.New("embedding dimension mismatch")
+
+// Embedding is the vector computed by Model from the text of the row Kind
+// and RefID point at. ContentHash is the hash of that text, so a changed
+// row can be told apart from an unchanged one.
+type Embedding struct {
+	Kind        SearchKind
+	RefID       int64
+	Model       string
+	Vector      []float32
+	ContentHash string
+	UpdatedAt   time.Time
+}
+
+// Neighbor is a nearest-neighbour hit; Score is the cosine similarity.
+type Neighbor struct {
+	Kind  SearchKind
+	RefID int64
+	Score float64
+}
+
+// embeddingIncident is the SQL for the incident of the row an embedding
+// was computed from, so that embeddings can be limited to a workspace.
+var embeddingIncident = func() string {
+	var b strings.Builder
+	b.WriteString("CASE embeddings.kind")
+	for _, s := range searchSources {
+		fmt.Fprintf(&b, " WHEN '%s' THEN (SELECT %s FROM %s s WHERE s.id = embeddings.ref_id)",
+			s.kind, strings.ReplaceAll(s.incident, "{r}", "s"), s.table)
+	}
+	b.WriteString(" END")
+
+	return b.String()
+}()
+
+// EmbedFunc computes one embedding per text, in order.
+type EmbedFunc func(ctx context.Context, texts []string) ([][]float32, error)
+
+// reembedBatch is how many texts are handed to an EmbedFunc at once.
+const reembedBatch = 32
+
+func (d *SQliteDB) UpsertEmbedding(ctx context.Context, e *Embedding) error {
+	if len(e.Vector) == 0 {
+		return fmt.Errorf("upsert embedding error: %w", ErrDimensions)
+	}
+
+	row := d.q.QueryRowContext(ctx, `
+		INSERT INTO embeddings (kind, ref_id, model, dims, vector, content_hash)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (kind, ref_id) DO UPDATE SET
+			model = excluded.model, dims = excluded.dims, vector = excluded.vector,
+			content_hash = excluded.content_hash, updated_at = CURRENT_TIMESTAMP
+		RETURNING updated_at`,
+		e.Kind, e.RefID, e.Model, len(e.Vector), encodeVector(e.Vector), e.ContentHash)
+	if err := row.Scan(&e.UpdatedAt); err != nil {
+		return fmt.Errorf("upsert embedding error: %v", err)
+	}
+
+	// A write inside a transaction that is rolled back leaves a stale node
+	// behind; searches check every hit against the table.
+	if idx := d.vectors.Load(); idx != nil {
+		key := EmbeddingKey{e.Kind, e.RefID}
+		if e.Model != idx.model || !idx.insert(key, e.Vector) {
+			idx.remove(key)
+		}
+	}
+
+	return nil
+}
+
+func (d *SQliteDB) GetEmbedding(ctx context.Context, kind SearchKind, refID int64) (*Embedding, error) {
+	e := Embedding{Kind: kind, RefID: refID}
+	var blob []byte
+	row := d.r.QueryRowContext(ctx, `
+		SELECT model, vector, content_hash, updated_at FROM embeddings WHERE kind = ? AND ref_id = ?`,
+		kind, refID)
+	if err := row.Scan(&e.Model, &blob, &e.ContentHash, &e.UpdatedAt); err != nil {
+		return nil, notFound(err, "get embedding")
+	}
+	e.Vector = decodeVector(blob)
+
+	return &e, nil
+}
+
+func (d *SQliteDB) DeleteEmbedding(ctx context.Context, kind SearchKind, refID int64) error {
+	res, err := d.q.ExecContext(ctx, `DELETE FROM embeddings WHERE kind = ? AND ref_id = ?`, kind, refID)
+	if err != nil {
+		return fmt.Errorf("delete embedding error: %v", err)
+	}
+	if idx := d.vectors.Load(); idx != nil {
+		idx.remove(EmbeddingKey{kind, refID})
+	}
+
+	return affectedOne(res, "delete embedding")
+}
+
+// NearestEmbeddings returns the k embeddings of model most similar to query,
+// optionally only of the given kinds. It uses the approximate index when
+// one was built for model and scans every embedding otherwise.
+func (d *SQliteDB) NearestEmbeddings(ctx context.Context, model string, query []float32, k int, kinds ...SearchKind) ([]Neighbor, error) {
+	if len(query) == 0 {
+		return nil, fmt.Errorf("nearest embeddings error: %w", ErrDimensions)
+	}
+	if k <= 0 {
+		return nil, nil
+	}
+
+	if idx := d.vectors.Load(); idx != nil && idx.model == model {
+		if dims := idx.dimensions(); dims != 0 && len(query) != dims {
+			return nil, fmt.Errorf("nearest embeddings error: %w: got %d want %d", ErrDimensions, len(query), dims)
+		}
+		return d.nearestIndexed(ctx, idx, query, k, kinds)
+	}
+
+	return d.nearestExact(ctx, model, query, k, kinds)
+}
+
+func (d *SQliteDB) nearestExact(ctx context.Context, model string, query []float32, k int, kinds []SearchKind) ([]Neighbor, error) {
+	var w where
+	w.add("model = ?", model)
+	w.add("dims = ?", len(query))
+	if len(kinds) > 0 {
+		w.add("kind IN ("+placeholders(len(kinds))+")", anySlice(kinds)...)
+	}
+	scopeIncidents(ctx, &w, embeddingIncident)
+
+	rows, err := d.r.QueryContext(ctx, `SELECT kind, ref_id, vector FROM embeddings`+w.String(), w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("nearest embeddings error: %v", err)
+	}
+	defer rows.Close()
+
+	q := normalize(query)
+	var top []Neighbor
+	for rows.Next() {
+		var (
+			n    Neighbor
+			blob []byte
+		)
+		if err := rows.Scan(&n.Kind, &n.RefID, &blob); err != nil {
+			return nil, fmt.Errorf("nearest embeddings error: %v", err)
+		}
+		n.Score = float64(dot(q, normalize(decodeVector(blob))))
+		top = insertTop(top, n, k)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("nearest embeddings error: %v", err)
+	}
+
+	return top, nil
+}
+
+// nearestIndexed asks the graph for candidates and keeps the ones that
+// still exist in the table with the model the index was built for. Hits
+// dropped by that check or by kinds are made up for by asking again for
+// twice as many.
+func (d *SQliteDB) nearestIndexed(ctx context.Context, idx *hnsw, query []float32, k int, kinds []SearchKind) ([]Neighbor, error) {
+	for want := k; ; want *= 2 {
+		hits := idx.search(query, want)
+		if len(hits) == 0 {
+			return nil, nil
+		}
+
+		live, err := d.liveEmbeddings(ctx, idx.model, hits)
+		if err != nil {
+			return nil, err
+		}
+
+		var out []Neighbor
+		for _, h := range hits {
+			if !live[h.key] || (len(kinds) > 0 && !slices.Contains(kinds, h.key.Kind)) {
+				continue
+			}
+			out = append(out, Neighbor{Kind: h.key.Kind, RefID: h.key.RefID, Score: h.score})
+			if len(out) == k {
+				return out, nil
+			}
+		}
+		if len(hits) < want {
+			return out, nil
+		}
+	}
+}
+
+func (d *SQliteDB) liveEmbeddings(ctx context.Context, model string, hits []hnswHit) (map[EmbeddingKey]bool, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for _, h := range hits {
+		conds = append(conds, "(?, ?)")
+		args = append(args, h.key.Kind, h.key.RefID)
+	}
+	var w where
+	w.add("model = ?", model)
+	w.add("(kind, ref_id) IN (VALUES "+strings.Join(conds, ", ")+")", args...)
+	scopeIncidents(ctx, &w, embeddingIncident)
+
+	rows, err := d.r.QueryContext(ctx, `SELECT kind, ref_id FROM embeddings`+w.String(), w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("nearest embeddings error: %v", err)
+	}
+	defer rows.Close()
+
+	live := map[EmbeddingKey]bool{}
+	for rows.Next() {
+		var key EmbeddingKey
+		if err := rows.Scan(&key.Kind, &key.RefID); err != nil {
+			return nil, fmt.Errorf("nearest embeddings error: %v", err)
+		}
+		live[key] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("nearest embeddings error: %v", err)
+	}
+
+	return live, nil
+}
+
+// BuildVectorIndex loads every embedding of model into an in-memory HNSW
+// graph that NearestEmbeddings uses from then on. It is meant to run at
+// startup; later upserts and deletes keep the graph current.
+func (d *SQliteDB) BuildVectorIndex(ctx context.Context, model string, cfg HNSWConfig) error {
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT kind, ref_id, vector FROM embeddings WHERE model = ?
+		AND dims = (SELECT dims FROM embeddings WHERE model = ? GROUP BY dims ORDER BY count(*) DESC LIMIT 1)
+		ORDER BY id`, model, model)
+	if err != nil {
+		return fmt.Errorf("build vector index error: %v", err)
+	}
+	defer rows.Close()
+
+	idx := newHNSW(model, cfg)
+	for rows.Next() {
+		var (
+			key  EmbeddingKey
+			blob []byte
+		)
+		if err := rows.Scan(&key.Kind, &key.RefID, &blob); err != nil {
+			return fmt.Errorf("build vector index error: %v", err)
+		}
+		idx.insert(key, decodeVector(blob))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("build vector index error: %v", err)
+	}
+
+	d.vectors.Store(idx)
+
+	return nil
+}
+
+// Reembed computes embeddings with model for every indexed row that has
+// none, has one from another model or has changed since it was embedded.
+// It returns how many rows were embedded. An index built for another model
+// is replaced by an empty one for model that fills up as rows are embedded.
+func (d *SQliteDB) Reembed(ctx context.Context, model string, embed EmbedFunc) (int, error) {
+	if idx := d.vectors.Load(); idx != nil && idx.model != model {
+		d.vectors.CompareAndSwap(idx, newHNSW(model, idx.cfg))
+	}
+
+	type pending struct {
+		key  EmbeddingKey
+		text string
+		hash string
+	}
+
+	var todo []pending
+	for _, s := range searchSources {
+		text := strings.ReplaceAll(s.title+` || char(10) || `+s.body, "{r}", "s")
+		rows, err := d.r.QueryContext(ctx, `
+			SELECT s.id, `+text+`, coalesce(e.model, ''), coalesce(e.content_hash, '')
+			FROM `+s.table+` s LEFT JOIN embeddings e ON e.kind = ? AND e.ref_id = s.id
+			ORDER BY s.id`, s.kind)
+		if err != nil {
+			return 0, fmt.Errorf("reembed error: %v", err)
+		}
+
+		for rows.Next() {
+			var (
+				p          pending
+				have, hash string
+			)
+			if err := rows.Scan(&p.key.RefID, &p.text, &have, &hash); err != nil {
+				rows.Close()
+				return 0, fmt.Errorf("reembed error: %v", err)
+			}
+			p.key.Kind = s.kind
+			p.hash = contentHash(p.text)
+			if have != model || hash != p.hash {
+				todo = append(todo, p)
+			}
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return 0, fmt.Errorf("reembed error: %v", err)
+		}
+	}
+
+	done := 0
+	for start := 0; start < len(todo); start += reembedBatch {
+		batch := todo[start:min(start+reembedBatch, len(todo))]
+		texts := make([]string, len(batch))
+		for i, p := range batch {
+			texts[i] = p.text
+		}
+
+		vecs, err := embed(ctx, texts)
+		if err != nil {
+			return done, fmt.Errorf("reembed error: %v", err)
+		}
+		if len(vecs) != len(batch) {
+			return done, fmt.Errorf("reembed error: got %d embeddings for %d texts", len(vecs), len(batch))
+		}
+
+		for i, p := range batch {
+			e := &Embedding{Kind: p.key.Kind, RefID: p.key.RefID, Model: model, Vector: vecs[i], ContentHash: p.hash}
+			if err := d.UpsertEmbedding(ctx, e); err != nil {
+				return done, err
+			}
+			done++
+		}
+	}
+
+	return done, nil
+}
+
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+// encodeVector stores a vector as little-endian float32s.
+func encodeVector(v []float32) []byte {
+	b := make([]byte, 4*len(v))
+	for i, f := range v {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(f))
+	}
+
+	return b
+}
+
+func decodeVector(b []byte) []float32 {
+	v := make([]float32, len(b)/4)
+	for i := range v {
+		v[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+
+	return v
+}
+
+// insertTop adds n to top, which holds at most k neighbours best first.
+func insertTop(top []Neighbor, n Neighbor, k int) []Neighbor {
+	i := sort.Search(len(top), func(i int) bool { return top[i].Score < n.Score })
+	if i >= k {
+		return top
+	}
+	top = append(top, Neighbor{})
+	copy(top[i+1:], top[i:])
+	top[i] = n
+
+	return top[:min(len(top), k)]
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+func anySlice[T any](s []T) []any {
+	out := make([]any, len(s))
+	for i, v := range s {
+		out[i] = v
+	}
+
+	return out
+}
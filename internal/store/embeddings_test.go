@@ -0,0 +1,213 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSQLiteDB_Embeddings(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	a := testIncident(t, db, "redis timeouts")
+	b := testIncident(t, db, "disk full")
+	c := testIncident(t, db, "cert expired")
+
+	upsert := func(t *testing.T, kind SearchKind, id int64, model string, vec ...float32) {
+		t.Helper()
+		if err := db.UpsertEmbedding(ctx, &Embedding{Kind: kind, RefID: id, Model: model, Vector: vec}); err != nil {
+			t.Fatalf("failed to upsert embedding: %v", err)
+		}
+	}
+
+	t.Run("UpsertEmbedding: round trip", func(t *testing.T) {
+		upsert(t, SearchIncident, a.ID, "m1", 1, 0, 0)
+		upsert(t, SearchIncident, a.ID, "m1", 1, 0.5, -0.25)
+
+		got, err := db.GetEmbedding(ctx, SearchIncident, a.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.Vector, []float32{1, 0.5, -0.25}) || got.Model != "m1" {
+			t.Errorf("unexpected embedding: %+v", got)
+		}
+	})
+
+	t.Run("UpsertEmbedding: empty vector", func(t *testing.T) {
+		err := db.UpsertEmbedding(ctx, &Embedding{Kind: SearchIncident, RefID: a.ID, Model: "m1"})
+		if !errors.Is(err, ErrDimensions) {
+			t.Errorf("got %v want %v", err, ErrDimensions)
+		}
+	})
+
+	upsert(t, SearchIncident, a.ID, "m1", 1, 0, 0)
+	upsert(t, SearchIncident, b.ID, "m1", 0.9, 0.1, 0)
+	upsert(t, SearchIncident, c.ID, "m1", 0, 1, 0)
+	upsert(t, SearchEvidence, 42, "m1", 1, 0.05, 0)
+	upsert(t, SearchTimeline, 7, "m2", 1, 0, 0)
+
+	t.Run("NearestEmbeddings: exact top k", func(t *testing.T) {
+		got, err := db.NearestEmbeddings(ctx, "m1", []float32{2, 0, 0}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []EmbeddingKey{{SearchIncident, a.ID}, {SearchEvidence, 42}, {SearchIncident, b.ID}}
+		if keys := neighborKeys(got); !reflect.DeepEqual(keys, want) {
+			t.Errorf("got %v want %v", keys, want)
+		}
+		if got[0].Score < 0.999 {
+			t.Errorf("identical direction should score 1: %v", got[0].Score)
+		}
+	})
+
+	t.Run("NearestEmbeddings: kinds and model", func(t *testing.T) {
+		got, err := db.NearestEmbeddings(ctx, "m1", []float32{1, 0, 0}, 5, SearchEvidence)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := neighborKeys(got); !reflect.DeepEqual(keys, []EmbeddingKey{{SearchEvidence, 42}}) {
+			t.Errorf("kinds: got %v", keys)
+		}
+
+		got, err = db.NearestEmbeddings(ctx, "m2", []float32{1, 0, 0}, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := neighborKeys(got); !reflect.DeepEqual(keys, []EmbeddingKey{{SearchTimeline, 7}}) {
+			t.Errorf("model: got %v", keys)
+		}
+	})
+
+	t.Run("NearestEmbeddings: approximate index", func(t *testing.T) {
+		if err := db.BuildVectorIndex(ctx, "m1", DefaultHNSWConfig()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.vectors.Store(nil) })
+
+		got, err := db.NearestEmbeddings(ctx, "m1", []float32{2, 0, 0}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []EmbeddingKey{{SearchIncident, a.ID}, {SearchEvidence, 42}, {SearchIncident, b.ID}}
+		if keys := neighborKeys(got); !reflect.DeepEqual(keys, want) {
+			t.Errorf("got %v want %v", keys, want)
+		}
+
+		if _, err := db.NearestEmbeddings(ctx, "m1", []float32{1, 0}, 3); !errors.Is(err, ErrDimensions) {
+			t.Errorf("dimension mismatch: got %v want %v", err, ErrDimensions)
+		}
+
+		// Deleting the incident removes its embedding by trigger; the stale
+		// graph node must not be returned.
+		if err := db.DeleteIncident(ctx, a.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, err = db.NearestEmbeddings(ctx, "m1", []float32{2, 0, 0}, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if keys := neighborKeys(got); !reflect.DeepEqual(keys, []EmbeddingKey{{SearchEvidence, 42}}) {
+			t.Errorf("after delete: got %v", keys)
+		}
+		if _, err := db.GetEmbedding(ctx, SearchIncident, a.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("embedding of deleted incident: got %v want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("DeleteEmbedding: not found", func(t *testing.T) {
+		if err := db.DeleteEmbedding(ctx, SearchAnalysis, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("got %v want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestSQLiteDB_Reembed(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "redis timeouts")
+	testIncident(t, db, "disk full")
+	if err := db.CreateAnalysis(ctx, &Analysis{IncidentID: inc.ID, Draft: "pool exhausted"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var embedded []string
+	embed := func(ctx context.Context, texts []string) ([][]float32, error) {
+		embedded = append(embedded, texts...)
+		out := make([][]float32, len(texts))
+		for i, s := range texts {
+			out[i] = []float32{float32(len(s)), 1}
+		}
+		return out, nil
+	}
+
+	run := func(t *testing.T, model string, want int) {
+		t.Helper()
+		embedded = nil
+		n, err := db.Reembed(ctx, model, embed)
+		if err != nil {
+			t.Fatalf("failed to reembed: %v", err)
+		}
+		if n != want || len(embedded) != want {
+			t.Errorf("embedded: got %d (%d texts) want %d", n, len(embedded), want)
+		}
+	}
+
+	t.Run("Reembed: everything missing", func(t *testing.T) {
+		run(t, "m1", 3)
+		if !strings.Contains(strings.Join(embedded, "|"), "pool exhausted") {
+			t.Errorf("analysis text not embedded: %q", embedded)
+		}
+	})
+
+	t.Run("Reembed: nothing changed", func(t *testing.T) {
+		run(t, "m1", 0)
+	})
+
+	t.Run("Reembed: changed row", func(t *testing.T) {
+		inc.Summary = "connection pool exhausted"
+		if err := db.UpdateIncident(ctx, inc); err != nil {
+			t.Fatal(err)
+		}
+		run(t, "m1", 1)
+	})
+
+	t.Run("Reembed: model changed", func(t *testing.T) {
+		if err := db.BuildVectorIndex(ctx, "m1", DefaultHNSWConfig()); err != nil {
+			t.Fatal(err)
+		}
+		run(t, "m2", 3)
+
+		if idx := db.vectors.Load(); idx.model != "m2" || idx.len() != 3 {
+			t.Errorf("index not rebuilt for new model: %s with %d vectors", idx.model, idx.len())
+		}
+		got, err := db.NearestEmbeddings(ctx, "m2", []float32{1, 0}, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 3 {
+			t.Errorf("neighbours: got %d want 3", len(got))
+		}
+	})
+
+	t.Run("Reembed: embed error", func(t *testing.T) {
+		fail := func(ctx context.Context, texts []string) ([][]float32, error) {
+			return nil, errors.New("model not pulled")
+		}
+		if _, err := db.Reembed(ctx, "m3", fail); err == nil || !strings.Contains(err.Error(), "model not pulled") {
+			t.Errorf("got %v", err)
+		}
+	})
+}
+
+func neighborKeys(ns []Neighbor) []EmbeddingKey {
+	var keys []EmbeddingKey
+	for _, n := range ns {
+		keys = append(keys, EmbeddingKey{n.Kind, n.RefID})
+	}
+
+	return keys
+}
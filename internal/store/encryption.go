@@ -0,0 +1,146 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/dtoebe/RootTensor/internal/keyring"
+)
+
+// ErrNoKey is returned when an encrypted value is read and no keyring is
+// set.
+var ErrNoKey = errors.New("value is encrypted and no key is configured")
+
+// sealedColumns are the columns encrypted when a keyring is set. They are
+// left out of the search index and the embeddings.
+var sealedColumns = []struct{ table, column string }{
+	{"evidence", "content"},
+}
+
+// SetKeyring turns on encryption of the sealed columns with keys. Values
+// written before stay readable as they are until Reencrypt is run. It must
+// be called before the database is used.
+func (d *SQliteDB) SetKeyring(keys *keyring.Keyring) {
+	d.keys = keys
+}
+
+// seal encrypts s when a keyring is set.
+func (d *SQliteDB) seal(s string) (string, error) {
+	if d.keys == nil {
+		return s, nil
+	}
+	sealed, err := d.keys.SealString(s)
+	if err != nil {
+		return "", fmt.Errorf("seal error: %v", err)
+	}
+
+	return sealed, nil
+}
+
+// unseal decrypts s if it was sealed.
+func (d *SQliteDB) unseal(s string) (string, error) {
+	if !keyring.IsSealedString(s) {
+		return s, nil
+	}
+	if d.keys == nil {
+		return "", ErrNoKey
+	}
+
+	return d.keys.OpenString(s)
+}
+
+// Reencrypt brings every sealed column up to the current keyring: plain
+// values are encrypted and the data keys of values sealed under an older
+// key are rewrapped with the primary one. The search index is then
+// optimized and the file vacuumed, so that no plaintext is left behind in
+// old index segments or free pages. It returns the number of values
+// rewritten.
+func (d *SQliteDB) Reencrypt(ctx context.Context) (int, error) {
+	if d.keys == nil {
+		return 0, errors.New("reencrypt error: no key is configured")
+	}
+
+	n := 0
+	for _, c := range sealedColumns {
+		ids, err := d.staleSealed(ctx, c.table, c.column)
+		if err != nil {
+			return n, err
+		}
+
+		for _, id := range ids {
+			err := d.inTx(ctx, func(tx *SQliteDB) error {
+				var v string
+				err := tx.q.QueryRowContext(ctx,
+					fmt.Sprintf(`SELECT %s FROM %s WHERE id = ?`, c.column, c.table), id).Scan(&v)
+				if err != nil {
+					return notFound(err, "reencrypt")
+				}
+
+				if keyring.IsSealedString(v) {
+					v, err = tx.keys.RewrapString(v)
+				} else {
+					v, err = tx.keys.SealString(v)
+				}
+				if err != nil {
+					return fmt.Errorf("reencrypt error: %s %d: %w", c.table, id, err)
+				}
+
+				_, err = tx.q.ExecContext(ctx,
+					fmt.Sprintf(`UPDATE %s SET %s = ? WHERE id = ?`, c.table, c.column), v, id)
+				if err != nil {
+					return fmt.Errorf("reencrypt error: %v", err)
+				}
+				return nil
+			})
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+	if _, err := d.q.ExecContext(ctx, `INSERT INTO search_index (search_index) VALUES ('optimize')`); err != nil {
+		return n, fmt.Errorf("optimize search index error: %v", err)
+	}
+	if _, err := d.q.ExecContext(ctx, `VACUUM`); err != nil {
+		return n, fmt.Errorf("vacuum error: %v", err)
+	}
+
+	return n, nil
+}
+
+// staleSealed returns the ids of the rows whose column is not sealed under
+// the primary key.
+func (d *SQliteDB) staleSealed(ctx context.Context, table, column string) ([]int64, error) {
+	rows, err := d.r.QueryContext(ctx, fmt.Sprintf(`SELECT id, %s FROM %s ORDER BY id`, column, table))
+	if err != nil {
+		return nil, fmt.Errorf("reencrypt error: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var (
+			id int64
+			v  string
+		)
+		if err := rows.Scan(&id, &v); err != nil {
+			return nil, fmt.Errorf("reencrypt error: %v", err)
+		}
+		if !d.keys.CurrentString(v) {
+			ids = append(ids, id)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("reencrypt error: %v", err)
+	}
+
+	return ids, nil
+}
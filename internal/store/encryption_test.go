@@ -0,0 +1,153 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dtoebe/RootTensor/internal/keyring"
+)
+
+func testKeys(t *testing.T) [][]byte {
+	t.Helper()
+
+	keys := make([][]byte, 2)
+	for i := range keys {
+		keys[i] = make([]byte, keyring.KeySize)
+		rand.Read(keys[i])
+	}
+
+	return keys
+}
+
+func testKeyring(t *testing.T, keys ...[]byte) *keyring.Keyring {
+	t.Helper()
+
+	k, err := keyring.New(keys...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return k
+}
+
+func TestSQLiteDB_Encryption(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	keys := testKeys(t)
+
+	inc := testIncident(t, db, "Checkout errors")
+	plain := &Evidence{IncidentID: inc.ID, Title: "before encryption", Content: "card 4111 declined"}
+	if err := db.CreateEvidence(ctx, plain); err != nil {
+		t.Fatal(err)
+	}
+
+	db.SetKeyring(testKeyring(t, keys[0]))
+	ev := &Evidence{IncidentID: inc.ID, Title: "gateway log", Content: "customer alice@example.com refund"}
+	if err := db.CreateEvidence(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+	raw := func(t *testing.T, id int64) string {
+		t.Helper()
+		var v string
+		if err := db.QueryRow(`SELECT content FROM evidence WHERE id = ?`, id).Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	hits := func(t *testing.T, q string) int {
+		t.Helper()
+		got, err := db.Search(ctx, SearchFilter{Query: q})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(got)
+	}
+
+	t.Run("Encryption: stored sealed, read plain", func(t *testing.T) {
+		if v := raw(t, ev.ID); !keyring.IsSealedString(v) || strings.Contains(v, "alice") {
+			t.Errorf("stored content: %q", v)
+		}
+		got, err := db.GetEvidence(ctx, ev.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Content != ev.Content {
+			t.Errorf("content: got %q want %q", got.Content, ev.Content)
+		}
+		if got, _ := db.GetEvidence(ctx, plain.ID); got.Content != plain.Content {
+			t.Errorf("older plain content: %q", got.Content)
+		}
+	})
+
+	t.Run("Encryption: sealed content is not indexed", func(t *testing.T) {
+		if n := hits(t, "alice"); n != 0 {
+			t.Errorf("hits for sealed content: %d", n)
+		}
+		if n := hits(t, "gateway"); n != 1 {
+			t.Errorf("hits for the title: got %d want 1", n)
+		}
+	})
+
+	t.Run("Encryption: no key", func(t *testing.T) {
+		db.SetKeyring(nil)
+		defer db.SetKeyring(testKeyring(t, keys[0]))
+		if _, err := db.GetEvidence(ctx, ev.ID); err == nil {
+			t.Error("expected an error reading sealed content without a key")
+		}
+		if _, err := db.Reencrypt(ctx); err == nil {
+			t.Error("expected reencrypt to need a key")
+		}
+	})
+
+	t.Run("Reencrypt: seals older plain values", func(t *testing.T) {
+		n, err := db.Reencrypt(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("reencrypted: got %d want 1", n)
+		}
+		if v := raw(t, plain.ID); !keyring.IsSealedString(v) {
+			t.Errorf("stored content: %q", v)
+		}
+		if n := hits(t, "4111"); n != 0 {
+			t.Errorf("hits after reencrypt: %d", n)
+		}
+	})
+
+	t.Run("Reencrypt: key rotation", func(t *testing.T) {
+		db.SetKeyring(testKeyring(t, keys[1], keys[0]))
+		n, err := db.Reencrypt(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("rewrapped: got %d want 2", n)
+		}
+
+		db.SetKeyring(testKeyring(t, keys[1]))
+		for _, want := range []*Evidence{plain, ev} {
+			got, err := db.GetEvidence(ctx, want.ID)
+			if err != nil {
+				t.Fatalf("new key alone: %v", err)
+			}
+			if got.Content != want.Content {
+				t.Errorf("content: got %q want %q", got.Content, want.Content)
+			}
+		}
+		if n, _ := db.Reencrypt(ctx); n != 0 {
+			t.Errorf("nothing should be left to reencrypt: %d", n)
+		}
+	})
+
+	t.Run("Reencrypt: unknown key", func(t *testing.T) {
+		db.SetKeyring(testKeyring(t, keys[0]))
+		defer db.SetKeyring(testKeyring(t, keys[1]))
+		if _, err := db.Reencrypt(ctx); !errors.Is(err, keyring.ErrUnknownKey) {
+			t.Errorf("expected ErrUnknownKey, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Evidence is a piece of material collected during an incident: a log
+// excerpt, a metric snapshot, a deploy record, a query plan and so on.
+type Evidence struct {
+	ID         int64
+	IncidentID int64
+	ServiceID  *int64
+	Kind       string
+	Title      string
+	Content    string
+	// BlobSHA256 and BlobSize reference a file in the blob store holding
+	// content too large for the row; BlobSHA256 is empty for none.
+	BlobSHA256  string
+	BlobSize    int64
+	Source      string
+	CollectedAt time.Time
+	// ArchivedAt is set once a retention policy has cut Content down to
+	// the lines cited by RCA drafts.
+	ArchivedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// EvidenceFilter narrows ListEvidence. Zero values do not filter.
+type EvidenceFilter struct {
+	IncidentID int64
+	ServiceID  int64
+	Kind       string
+	// After continues the list past a cursor from Evidence.Cursor.
+	After *Cursor
+	Limit int
+}
+
+// Cursor is the position of ev in ListEvidence.
+func (ev *Evidence) Cursor() Cursor {
+	return Cursor{Key: ev.CollectedAt, ID: ev.ID}
+}
+
+const evidenceColumns = `id, incident_id, service_id, kind, title, content, blob_sha256, blob_size, source, collected_at, archived_at, created_at`
+
+func (d *SQliteDB) CreateEvidence(ctx context.Context, ev *Evidence) error {
+	if err := authorize(ctx, PermWriteEvidence); err != nil {
+		return err
+	}
+
+	if ev.Kind == "" {
+		ev.Kind = "log"
+	}
+	ev.CollectedAt = utc(ev.CollectedAt)
+
+	content, err := d.seal(ev.Content)
+	if err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, ev.IncidentID); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO evidence (incident_id, service_id, kind, title, content, blob_sha256, blob_size, source, collected_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id, created_at`,
+			ev.IncidentID, nullInt64(ev.ServiceID), ev.Kind, ev.Title, content, nullString(ev.BlobSHA256),
+			ev.BlobSize, ev.Source, ev.CollectedAt)
+		if err := row.Scan(&ev.ID, &ev.CreatedAt); err != nil {
+			return fmt.Errorf("create evidence error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditEvidence, ev.ID, ev.IncidentID, nil, ev)
+	})
+}
+
+func (d *SQliteDB) GetEvidence(ctx context.Context, id int64) (*Evidence, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+evidenceColumns+` FROM evidence`+w.String(), w.args...)
+	ev, err := d.scanEvidence(row)
+	if err != nil {
+		return nil, notFound(err, "get evidence")
+	}
+
+	return ev, nil
+}
+
+// ListEvidence returns the evidence matching f in the order it was
+// collected.
+func (d *SQliteDB) ListEvidence(ctx context.Context, f EvidenceFilter) ([]Evidence, error) {
+	var w where
+	if f.IncidentID != 0 {
+		w.add("incident_id = ?", f.IncidentID)
+	}
+	if f.ServiceID != 0 {
+		w.add("service_id = ?", f.ServiceID)
+	}
+	if f.Kind != "" {
+		w.add("kind = ?", f.Kind)
+	}
+	w.after(f.After, "collected_at", false)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	rows, err := d.r.QueryContext(ctx,
+		`SELECT `+evidenceColumns+` FROM evidence`+w.String()+` ORDER BY collected_at, id`+limit(f.Limit), w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list evidence error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []Evidence
+	for rows.Next() {
+		ev, err := d.scanEvidence(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list evidence error: %v", err)
+		}
+		out = append(out, *ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list evidence error: %v", err)
+	}
+
+	return out, nil
+}
+
+func (d *SQliteDB) UpdateEvidence(ctx context.Context, ev *Evidence) error {
+	if err := authorize(ctx, PermWriteEvidence); err != nil {
+		return err
+	}
+
+	ev.CollectedAt = utc(ev.CollectedAt)
+	content, err := d.seal(ev.Content)
+	if err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetEvidence(ctx, ev.ID)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			UPDATE evidence SET service_id = ?, kind = ?, title = ?, content = ?, blob_sha256 = ?, blob_size = ?,
+				source = ?, collected_at = ?
+			WHERE id = ?`,
+			nullInt64(ev.ServiceID), ev.Kind, ev.Title, content, nullString(ev.BlobSHA256), ev.BlobSize,
+			ev.Source, ev.CollectedAt, ev.ID)
+		if err != nil {
+			return fmt.Errorf("update evidence error: %v", err)
+		}
+
+		cur, err := tx.GetEvidence(ctx, ev.ID)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditEvidence, ev.ID, old.IncidentID, old, cur)
+	})
+}
+
+func (d *SQliteDB) DeleteEvidence(ctx context.Context, id int64) error {
+	if err := authorize(ctx, PermWriteEvidence); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetEvidence(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM evidence WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("delete evidence error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditDelete, AuditEvidence, id, old.IncidentID, old, nil)
+	})
+}
+
+func (d *SQliteDB) scanEvidence(s scanner) (*Evidence, error) {
+	var (
+		ev         Evidence
+		serviceID  sql.NullInt64
+		archivedAt sql.NullTime
+		blob       sql.NullString
+	)
+
+	err := s.Scan(&ev.ID, &ev.IncidentID, &serviceID, &ev.Kind, &ev.Title, &ev.Content,
+		&blob, &ev.BlobSize, &ev.Source, &ev.CollectedAt, &archivedAt, &ev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if serviceID.Valid {
+		ev.ServiceID = &serviceID.Int64
+	}
+	ev.BlobSHA256 = blob.String
+	ev.ArchivedAt = timePtr(archivedAt)
+	if ev.Content, err = d.unseal(ev.Content); err != nil {
+		return nil, fmt.Errorf("evidence %d: %w", ev.ID, err)
+	}
+
+	return &ev, nil
+}
+
+// evidenceAudit is what the audit log records of evidence. Raw content is
+// replaced by its size and digest so that the log does not keep what a
+// retention policy removes.
+type evidenceAudit struct {
+	IncidentID    int64
+	ServiceID     *int64
+	Kind          string
+	Title         string
+	ContentSize   int
+	ContentSHA256 string
+	BlobSHA256    string `json:",omitempty"`
+	BlobSize      int64  `json:",omitempty"`
+	Source        string
+	CollectedAt   time.Time
+	ArchivedAt    *time.Time `json:",omitempty"`
+}
+
+func (ev *Evidence) auditView() any {
+	if ev == nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(ev.Content))
+
+	return &evidenceAudit{
+		IncidentID: ev.IncidentID, ServiceID: ev.ServiceID, Kind: ev.Kind, Title: ev.Title,
+		ContentSize: len(ev.Content), ContentSHA256: hex.EncodeToString(sum[:]),
+		BlobSHA256: ev.BlobSHA256, BlobSize: ev.BlobSize,
+		Source: ev.Source, CollectedAt: ev.CollectedAt, ArchivedAt: ev.ArchivedAt,
+	}
+}
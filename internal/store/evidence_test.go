@@ -0,0 +1,110 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_Evidence(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "redis timeouts")
+	redis := &Service{Name: "redis"}
+	if err := db.CreateService(ctx, redis); err != nil {
+		t.Fatal(err)
+	}
+
+	base := time.Date(2025, 4, 1, 14, 0, 0, 0, time.UTC)
+	logLine := &Evidence{IncidentID: inc.ID, ServiceID: &redis.ID, Title: "pool", Content: "pool exhausted", CollectedAt: base}
+	metric := &Evidence{IncidentID: inc.ID, Kind: "metric", Content: "p99=4s", CollectedAt: base.Add(time.Minute)}
+
+	t.Run("CreateEvidence: success", func(t *testing.T) {
+		for _, ev := range []*Evidence{logLine, metric} {
+			if err := db.CreateEvidence(ctx, ev); err != nil {
+				t.Fatalf("failed to create evidence: %v", err)
+			}
+		}
+		if logLine.Kind != "log" {
+			t.Errorf("default kind: got %q want %q", logLine.Kind, "log")
+		}
+	})
+
+	t.Run("GetEvidence: service reference", func(t *testing.T) {
+		got, err := db.GetEvidence(ctx, logLine.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ServiceID == nil || *got.ServiceID != redis.ID || got.Content != "pool exhausted" {
+			t.Errorf("evidence: got %+v", got)
+		}
+
+		got, err = db.GetEvidence(ctx, metric.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ServiceID != nil {
+			t.Errorf("expected nil service id, got %d", *got.ServiceID)
+		}
+	})
+
+	t.Run("ListEvidence: filters", func(t *testing.T) {
+		all, err := db.ListEvidence(ctx, EvidenceFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 2 || all[0].ID != logLine.ID {
+			t.Errorf("evidence order: got %+v", all)
+		}
+
+		got, err := db.ListEvidence(ctx, EvidenceFilter{IncidentID: inc.ID, Kind: "metric"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != metric.ID {
+			t.Errorf("kind filter: got %+v", got)
+		}
+
+		got, err = db.ListEvidence(ctx, EvidenceFilter{ServiceID: redis.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != logLine.ID {
+			t.Errorf("service filter: got %+v", got)
+		}
+	})
+
+	t.Run("UpdateEvidence: success", func(t *testing.T) {
+		metric.Title = "checkout p99"
+		if err := db.UpdateEvidence(ctx, metric); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetEvidence(ctx, metric.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != metric.Title {
+			t.Errorf("title: got %q want %q", got.Title, metric.Title)
+		}
+	})
+
+	t.Run("DeleteEvidence: success", func(t *testing.T) {
+		if err := db.DeleteEvidence(ctx, metric.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetEvidence(ctx, metric.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetEvidence after delete: got %v want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if err := db.UpdateEvidence(ctx, &Evidence{ID: 9999}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateEvidence: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.DeleteEvidence(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteEvidence: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
@@ -0,0 +1,322 @@
+package store
+
+import (
+	"container/heap"
+	"math"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+// HNSWConfig tunes the approximate vector index. M is the number of links
+// per node (twice that on the bottom layer); the ef values are the size of
+// the candidate lists kept while building and searching. Larger values
+// trade speed for recall.
+type HNSWConfig struct {
+	M              int
+	EfConstruction int
+	EfSearch       int
+}
+
+func DefaultHNSWConfig() HNSWConfig {
+	return HNSWConfig{M: 16, EfConstruction: 200, EfSearch: 64}
+}
+
+// EmbeddingKey identifies the row an embedding was computed from.
+type EmbeddingKey struct {
+	Kind  SearchKind
+	RefID int64
+}
+
+type hnswNode struct {
+	key     EmbeddingKey
+	vec     []float32
+	links   [][]int32
+	deleted bool
+}
+
+// hnsw is an in-memory Hierarchical Navigable Small World graph over
+// normalised vectors. Replaced and removed vectors are only marked deleted;
+// the graph is rebuilt from the database on startup.
+type hnsw struct {
+	mu        sync.RWMutex
+	cfg       HNSWConfig
+	model     string
+	dims      int
+	nodes     []*hnswNode
+	byKey     map[EmbeddingKey]int32
+	entry     int32
+	maxLevel  int
+	levelMult float64
+	rng       *rand.Rand
+}
+
+type hnswHit struct {
+	key   EmbeddingKey
+	score float64
+}
+
+func newHNSW(model string, cfg HNSWConfig) *hnsw {
+	def := DefaultHNSWConfig()
+	if cfg.M < 2 {
+		cfg.M = def.M
+	}
+	if cfg.EfConstruction < cfg.M {
+		cfg.EfConstruction = max(def.EfConstruction, cfg.M)
+	}
+	if cfg.EfSearch < 1 {
+		cfg.EfSearch = def.EfSearch
+	}
+
+	return &hnsw{
+		cfg:       cfg,
+		model:     model,
+		byKey:     map[EmbeddingKey]int32{},
+		entry:     -1,
+		levelMult: 1 / math.Log(float64(cfg.M)),
+		rng:       rand.New(rand.NewPCG(1, 2)),
+	}
+}
+
+func (h *hnsw) len() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.byKey)
+}
+
+// dimensions is 0 until the first vector is inserted.
+func (h *hnsw) dimensions() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.dims
+}
+
+func (h *hnsw) maxLinks(level int) int {
+	if level == 0 {
+		return 2 * h.cfg.M
+	}
+
+	return h.cfg.M
+}
+
+func (h *hnsw) dist(q []float32, id int32) float32 {
+	return 1 - dot(q, h.nodes[id].vec)
+}
+
+// insert adds or replaces the vector stored under key. It reports false,
+// and drops any previous vector, when vec does not have the dimension of
+// the index.
+func (h *hnsw) insert(key EmbeddingKey, vec []float32) bool {
+	q := normalize(vec)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if old, ok := h.byKey[key]; ok {
+		h.nodes[old].deleted = true
+		delete(h.byKey, key)
+	}
+	if h.dims == 0 {
+		h.dims = len(vec)
+	}
+	if len(vec) != h.dims {
+		return false
+	}
+
+	level := int(-math.Log(1-h.rng.Float64()) * h.levelMult)
+	id := int32(len(h.nodes))
+	node := &hnswNode{key: key, vec: q, links: make([][]int32, level+1)}
+	h.nodes = append(h.nodes, node)
+	h.byKey[key] = id
+
+	if h.entry < 0 {
+		h.entry, h.maxLevel = id, level
+		return true
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > level; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+	for l := min(level, h.maxLevel); l >= 0; l-- {
+		cands := h.searchLayer(q, ep, h.cfg.EfConstruction, l)
+		node.links[l] = closest(cands, h.cfg.M)
+		for _, n := range node.links[l] {
+			h.link(n, id, l)
+		}
+		ep = cands[0].id
+	}
+
+	if level > h.maxLevel {
+		h.entry, h.maxLevel = id, level
+	}
+
+	return true
+}
+
+// link adds a link from n to id on level l and, when n has too many, keeps
+// only the closest ones.
+func (h *hnsw) link(n, id int32, l int) {
+	links := append(h.nodes[n].links[l], id)
+	if len(links) > h.maxLinks(l) {
+		cands := make([]distItem, len(links))
+		for i, m := range links {
+			cands[i] = distItem{id: m, dist: h.dist(h.nodes[n].vec, m)}
+		}
+		sortItems(cands)
+		links = closest(cands, h.maxLinks(l))
+	}
+	h.nodes[n].links[l] = links
+}
+
+func (h *hnsw) remove(key EmbeddingKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if id, ok := h.byKey[key]; ok {
+		h.nodes[id].deleted = true
+		delete(h.byKey, key)
+	}
+}
+
+// search returns up to k live nodes closest to vec, best first.
+func (h *hnsw) search(vec []float32, k int) []hnswHit {
+	q := normalize(vec)
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.entry < 0 || k <= 0 {
+		return nil
+	}
+
+	ep := h.entry
+	for l := h.maxLevel; l > 0; l-- {
+		ep = h.greedy(q, ep, l)
+	}
+
+	var out []hnswHit
+	for _, c := range h.searchLayer(q, ep, max(h.cfg.EfSearch, k), 0) {
+		if n := h.nodes[c.id]; !n.deleted {
+			out = append(out, hnswHit{key: n.key, score: float64(1 - c.dist)})
+			if len(out) == k {
+				break
+			}
+		}
+	}
+
+	return out
+}
+
+// greedy walks level l from ep towards q and returns the closest node it
+// reaches.
+func (h *hnsw) greedy(q []float32, ep int32, l int) int32 {
+	best := h.dist(q, ep)
+	for changed := true; changed; {
+		changed = false
+		for _, n := range h.nodes[ep].links[l] {
+			if d := h.dist(q, n); d < best {
+				ep, best, changed = n, d, true
+			}
+		}
+	}
+
+	return ep
+}
+
+// searchLayer is a best-first search of level l that keeps the ef closest
+// nodes seen. The result is sorted closest first.
+func (h *hnsw) searchLayer(q []float32, ep int32, ef int, l int) []distItem {
+	visited := map[int32]bool{ep: true}
+	first := distItem{id: ep, dist: h.dist(q, ep)}
+	cands := &distHeap{items: []distItem{first}}
+	found := &distHeap{items: []distItem{first}, max: true}
+
+	for cands.Len() > 0 {
+		c := heap.Pop(cands).(distItem)
+		if c.dist > found.items[0].dist && found.Len() >= ef {
+			break
+		}
+		for _, n := range h.nodes[c.id].links[l] {
+			if visited[n] {
+				continue
+			}
+			visited[n] = true
+
+			d := h.dist(q, n)
+			if found.Len() < ef || d < found.items[0].dist {
+				heap.Push(cands, distItem{id: n, dist: d})
+				heap.Push(found, distItem{id: n, dist: d})
+				if found.Len() > ef {
+					heap.Pop(found)
+				}
+			}
+		}
+	}
+
+	sortItems(found.items)
+	return found.items
+}
+
+type distItem struct {
+	id   int32
+	dist float32
+}
+
+// distHeap is a min-heap on dist, or a max-heap when max is set.
+type distHeap struct {
+	items []distItem
+	max   bool
+}
+
+func (h *distHeap) Len() int { return len(h.items) }
+func (h *distHeap) Less(i, j int) bool {
+	if h.max {
+		return h.items[i].dist > h.items[j].dist
+	}
+	return h.items[i].dist < h.items[j].dist
+}
+func (h *distHeap) Swap(i, j int) { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *distHeap) Push(x any)    { h.items = append(h.items, x.(distItem)) }
+func (h *distHeap) Pop() any {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return it
+}
+
+func sortItems(items []distItem) {
+	sort.Slice(items, func(i, j int) bool { return items[i].dist < items[j].dist })
+}
+
+func closest(sorted []distItem, n int) []int32 {
+	out := make([]int32, 0, min(n, len(sorted)))
+	for _, it := range sorted[:min(n, len(sorted))] {
+		out = append(out, it.id)
+	}
+
+	return out
+}
+
+func dot(a, b []float32) float32 {
+	var s float32
+	for i := range a {
+		s += a[i] * b[i]
+	}
+
+	return s
+}
+
+func normalize(v []float32) []float32 {
+	n := float32(math.Sqrt(float64(dot(v, v))))
+	out := make([]float32, len(v))
+	if n == 0 {
+		return out
+	}
+	for i := range v {
+		out[i] = v[i] / n
+	}
+
+	return out
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"math/rand/v2"
+	"sort"
+	"testing"
+)
+
+func TestHNSW(t *testing.T) {
+	const (
+		n    = 1000
+		dims = 24
+		k    = 10
+	)
+
+	rng := rand.New(rand.NewPCG(7, 7))
+	randVec := func() []float32 {
+		v := make([]float32, dims)
+		for i := range v {
+			v[i] = float32(rng.NormFloat64())
+		}
+		return v
+	}
+
+	idx := newHNSW("m", DefaultHNSWConfig())
+	vecs := make([][]float32, n)
+	for i := range vecs {
+		vecs[i] = randVec()
+		idx.insert(EmbeddingKey{SearchEvidence, int64(i)}, vecs[i])
+	}
+
+	exact := func(q []float32) []int64 {
+		type scored struct {
+			id    int64
+			score float32
+		}
+		all := make([]scored, 0, n)
+		nq := normalize(q)
+		for i, v := range vecs {
+			if _, ok := idx.byKey[EmbeddingKey{SearchEvidence, int64(i)}]; ok {
+				all = append(all, scored{int64(i), dot(nq, normalize(v))})
+			}
+		}
+		sort.Slice(all, func(i, j int) bool { return all[i].score > all[j].score })
+		var ids []int64
+		for _, s := range all[:k] {
+			ids = append(ids, s.id)
+		}
+		return ids
+	}
+
+	recall := func(t *testing.T) float64 {
+		t.Helper()
+		var hit, total int
+		for range 50 {
+			q := randVec()
+			want := map[int64]bool{}
+			for _, id := range exact(q) {
+				want[id] = true
+			}
+			for _, h := range idx.search(q, k) {
+				if want[h.key.RefID] {
+					hit++
+				}
+			}
+			total += k
+		}
+		return float64(hit) / float64(total)
+	}
+
+	t.Run("search: recall", func(t *testing.T) {
+		if r := recall(t); r < 0.9 {
+			t.Errorf("recall: got %.2f want >= 0.9", r)
+		}
+	})
+
+	t.Run("search: exact match first", func(t *testing.T) {
+		got := idx.search(vecs[123], 1)
+		if len(got) != 1 || got[0].key.RefID != 123 || got[0].score < 0.999 {
+			t.Errorf("got %+v", got)
+		}
+	})
+
+	t.Run("remove and replace", func(t *testing.T) {
+		idx.remove(EmbeddingKey{SearchEvidence, 123})
+		for _, h := range idx.search(vecs[123], k) {
+			if h.key.RefID == 123 {
+				t.Fatal("removed vector returned")
+			}
+		}
+
+		vecs[5] = vecs[124]
+		idx.insert(EmbeddingKey{SearchEvidence, 5}, vecs[5])
+		got := idx.search(vecs[124], 2)
+		if len(got) != 2 || got[0].score < 0.999 || got[1].score < 0.999 {
+			t.Errorf("replaced vector not found: %+v", got)
+		}
+		if idx.len() != n-1 {
+			t.Errorf("len: got %d want %d", idx.len(), n-1)
+		}
+		if r := recall(t); r < 0.9 {
+			t.Errorf("recall after updates: got %.2f want >= 0.9", r)
+		}
+	})
+
+	t.Run("insert: wrong dimension", func(t *testing.T) {
+		if idx.insert(EmbeddingKey{SearchEvidence, 7}, []float32{1, 2}) {
+			t.Error("expected insert to be refused")
+		}
+		if _, ok := idx.byKey[EmbeddingKey{SearchEvidence, 7}]; ok {
+			t.Error("previous vector kept after refused insert")
+		}
+	})
+
+	t.Run("search: empty", func(t *testing.T) {
+		if got := newHNSW("m", HNSWConfig{}).search(randVec(), k); got != nil {
+			t.Errorf("got %+v", got)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type HypothesisStatus string
+
+const (
+	HypothesisProposed      HypothesisStatus = "proposed"
+	HypothesisInvestigating HypothesisStatus = "investigating"
+	HypothesisConfirmed     HypothesisStatus = "confirmed"
+	HypothesisRejected      HypothesisStatus = "rejected"
+)
+
+// Hypothesis is a candidate root cause. Confidence is between 0 and 1.
+type Hypothesis struct {
+	ID         int64
+	IncidentID int64
+	Statement  string
+	Rationale  string
+	Status     HypothesisStatus
+	Confidence float64
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// HypothesisFilter narrows ListHypotheses. Zero values do not filter.
+type HypothesisFilter struct {
+	IncidentID int64
+	Status     HypothesisStatus
+	// After continues the list past a cursor from Hypothesis.Cursor.
+	After *Cursor
+	Limit int
+}
+
+// Cursor is the position of h in ListHypotheses.
+func (h *Hypothesis) Cursor() Cursor {
+	return Cursor{Key: h.Confidence, ID: h.ID}
+}
+
+// Valid reports whether s is a known status.
+func (s HypothesisStatus) Valid() bool {
+	switch s {
+	case HypothesisProposed, HypothesisInvestigating, HypothesisConfirmed, HypothesisRejected:
+		return true
+	}
+	return false
+}
+
+const hypothesisColumns = `id, incident_id, statement, rationale, status, confidence, created_at, updated_at`
+
+func (d *SQliteDB) CreateHypothesis(ctx context.Context, h *Hypothesis) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	if h.Status == "" {
+		h.Status = HypothesisProposed
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, h.IncidentID); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO hypotheses (incident_id, statement, rationale, status, confidence)
+			VALUES (?, ?, ?, ?, ?)
+			RETURNING id, created_at, updated_at`,
+			h.IncidentID, h.Statement, h.Rationale, h.Status, h.Confidence)
+		if err := row.Scan(&h.ID, &h.CreatedAt, &h.UpdatedAt); err != nil {
+			return fmt.Errorf("create hypothesis error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditHypothesis, h.ID, h.IncidentID, nil, h)
+	})
+}
+
+func (d *SQliteDB) GetHypothesis(ctx context.Context, id int64) (*Hypothesis, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+hypothesisColumns+` FROM hypotheses`+w.String(), w.args...)
+	h, err := scanHypothesis(row)
+	if err != nil {
+		return nil, notFound(err, "get hypothesis")
+	}
+
+	return h, nil
+}
+
+// ListHypotheses returns the hypotheses matching f ranked by confidence.
+func (d *SQliteDB) ListHypotheses(ctx context.Context, f HypothesisFilter) ([]Hypothesis, error) {
+	var w where
+	if f.IncidentID != 0 {
+		w.add("incident_id = ?", f.IncidentID)
+	}
+	if f.Status != "" {
+		w.add("status = ?", f.Status)
+	}
+	if f.After != nil {
+		w.add("(confidence < ? OR (confidence = ? AND id > ?))", f.After.Key, f.After.Key, f.After.ID)
+	}
+	scopeIncidents(ctx, &w, "incident_id")
+
+	rows, err := d.r.QueryContext(ctx,
+		`SELECT `+hypothesisColumns+` FROM hypotheses`+w.String()+` ORDER BY confidence DESC, id`+limit(f.Limit),
+		w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list hypotheses error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []Hypothesis
+	for rows.Next() {
+		h, err := scanHypothesis(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list hypotheses error: %v", err)
+		}
+		out = append(out, *h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list hypotheses error: %v", err)
+	}
+
+	return out, nil
+}
+
+func (d *SQliteDB) UpdateHypothesis(ctx context.Context, h *Hypothesis) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetHypothesis(ctx, h.ID)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			UPDATE hypotheses
+			SET statement = ?, rationale = ?, status = ?, confidence = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+			RETURNING updated_at`,
+			h.Statement, h.Rationale, h.Status, h.Confidence, h.ID)
+		if err := row.Scan(&h.UpdatedAt); err != nil {
+			return notFound(err, "update hypothesis")
+		}
+
+		cur, err := tx.GetHypothesis(ctx, h.ID)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditHypothesis, h.ID, old.IncidentID, old, cur)
+	})
+}
+
+func scanHypothesis(s scanner) (*Hypothesis, error) {
+	var h Hypothesis
+	err := s.Scan(&h.ID, &h.IncidentID, &h.Statement, &h.Rationale, &h.Status, &h.Confidence,
+		&h.CreatedAt, &h.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &h, nil
+}
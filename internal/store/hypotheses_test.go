@@ -0,0 +1,79 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSQLiteDB_Hypotheses(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "redis timeouts")
+	pool := &Hypothesis{IncidentID: inc.ID, Statement: "pool too small", Confidence: 0.4}
+	deploy := &Hypothesis{IncidentID: inc.ID, Statement: "bad deploy", Confidence: 0.7}
+
+	t.Run("CreateHypothesis: success", func(t *testing.T) {
+		for _, h := range []*Hypothesis{pool, deploy} {
+			if err := db.CreateHypothesis(ctx, h); err != nil {
+				t.Fatalf("failed to create hypothesis: %v", err)
+			}
+		}
+		if pool.Status != HypothesisProposed {
+			t.Errorf("default status: got %q want %q", pool.Status, HypothesisProposed)
+		}
+	})
+
+	t.Run("CreateHypothesis: confidence out of range", func(t *testing.T) {
+		if err := db.CreateHypothesis(ctx, &Hypothesis{IncidentID: inc.ID, Statement: "x", Confidence: 1.5}); err == nil {
+			t.Fatal("expected check constraint error")
+		}
+	})
+
+	t.Run("ListHypotheses: ranked by confidence", func(t *testing.T) {
+		got, err := db.ListHypotheses(ctx, HypothesisFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].ID != deploy.ID {
+			t.Errorf("hypotheses: got %+v", got)
+		}
+	})
+
+	t.Run("ListHypotheses: after a cursor", func(t *testing.T) {
+		c := deploy.Cursor()
+		got, err := db.ListHypotheses(ctx, HypothesisFilter{IncidentID: inc.ID, After: &c, Limit: 5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != pool.ID {
+			t.Errorf("hypotheses: got %+v", got)
+		}
+	})
+
+	t.Run("UpdateHypothesis: status change", func(t *testing.T) {
+		pool.Status = HypothesisConfirmed
+		pool.Confidence = 0.95
+		if err := db.UpdateHypothesis(ctx, pool); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := db.ListHypotheses(ctx, HypothesisFilter{IncidentID: inc.ID, Status: HypothesisConfirmed})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != pool.ID || got[0].Confidence != 0.95 {
+			t.Errorf("confirmed hypotheses: got %+v", got)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := db.GetHypothesis(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetHypothesis: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.UpdateHypothesis(ctx, &Hypothesis{ID: 9999, Status: HypothesisProposed}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateHypothesis: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
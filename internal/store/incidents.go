@@ -0,0 +1,358 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+type IncidentStatus string
+
+const (
+	IncidentOpen          IncidentStatus = "open"
+	IncidentInvestigating IncidentStatus = "investigating"
+	IncidentMitigated     IncidentStatus = "mitigated"
+	IncidentResolved      IncidentStatus = "resolved"
+	IncidentClosed        IncidentStatus = "closed"
+)
+
+type Severity string
+
+const (
+	Sev1 Severity = "sev1"
+	Sev2 Severity = "sev2"
+	Sev3 Severity = "sev3"
+	Sev4 Severity = "sev4"
+)
+
+type Incident struct {
+	ID int64
+	// UID identifies the incident across installations. CreateIncident
+	// generates one when it is empty.
+	UID string
+	// WorkspaceID is the workspace the incident belongs to. CreateIncident
+	// uses the workspace of its context, else this, else the default
+	// workspace.
+	WorkspaceID int64
+	Title       string
+	Summary     string
+	Status      IncidentStatus
+	Severity    Severity
+	RCA         string
+	StartedAt   time.Time
+	DetectedAt  *time.Time
+	ResolvedAt  *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// IncidentFilter narrows ListIncidents. Zero values do not filter.
+type IncidentFilter struct {
+	Status    IncidentStatus
+	Severity  Severity
+	ServiceID int64
+	// Since and Until bound StartedAt, inclusive.
+	Since time.Time
+	Until time.Time
+	// After continues the list past a cursor from Incident.Cursor.
+	After *Cursor
+	Limit int
+}
+
+// Cursor is the position of inc in ListIncidents.
+func (inc *Incident) Cursor() Cursor {
+	return Cursor{Key: inc.StartedAt, ID: inc.ID}
+}
+
+// Valid reports whether s is a known status.
+func (s IncidentStatus) Valid() bool {
+	switch s {
+	case IncidentOpen, IncidentInvestigating, IncidentMitigated, IncidentResolved, IncidentClosed:
+		return true
+	}
+	return false
+}
+
+// Valid reports whether s is a known severity.
+func (s Severity) Valid() bool {
+	switch s {
+	case Sev1, Sev2, Sev3, Sev4:
+		return true
+	}
+	return false
+}
+
+const incidentColumns = `id, uid, workspace_id, title, summary, status, severity, rca, started_at, detected_at,
+	resolved_at, created_at, updated_at`
+
+// CreateIncident opens inc. An incident opened with an RCA publishes it
+// and needs PermPublish.
+func (d *SQliteDB) CreateIncident(ctx context.Context, inc *Incident) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+	if inc.RCA != "" {
+		if err := authorize(ctx, PermPublish); err != nil {
+			return err
+		}
+	}
+	if inc.Status == "" {
+		inc.Status = IncidentOpen
+	}
+	if inc.Severity == "" {
+		inc.Severity = Sev3
+	}
+	if inc.UID == "" {
+		inc.UID = newUID()
+	}
+	inc.StartedAt = utc(inc.StartedAt)
+	inc.WorkspaceID = targetWorkspace(ctx, inc.WorkspaceID)
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO incidents (uid, workspace_id, title, summary, status, severity, rca, started_at, detected_at,
+				resolved_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			RETURNING id, created_at, updated_at`,
+			inc.UID, inc.WorkspaceID, inc.Title, inc.Summary, inc.Status, inc.Severity, inc.RCA, inc.StartedAt,
+			nullTime(inc.DetectedAt), nullTime(inc.ResolvedAt))
+		if err := row.Scan(&inc.ID, &inc.CreatedAt, &inc.UpdatedAt); err != nil {
+			return fmt.Errorf("create incident error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditIncident, inc.ID, inc.ID, nil, inc)
+	})
+}
+
+func (d *SQliteDB) GetIncident(ctx context.Context, id int64) (*Incident, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+incidentColumns+` FROM incidents`+w.String(), w.args...)
+	inc, err := scanIncident(row)
+	if err != nil {
+		return nil, notFound(err, "get incident")
+	}
+
+	return inc, nil
+}
+
+func (d *SQliteDB) GetIncidentByUID(ctx context.Context, uid string) (*Incident, error) {
+	var w where
+	w.add("uid = ?", uid)
+	scopeIncidents(ctx, &w, "id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+incidentColumns+` FROM incidents`+w.String(), w.args...)
+	inc, err := scanIncident(row)
+	if err != nil {
+		return nil, notFound(err, "get incident by uid")
+	}
+
+	return inc, nil
+}
+
+// ListIncidents returns the incidents matching f, most recently started
+// first.
+func (d *SQliteDB) ListIncidents(ctx context.Context, f IncidentFilter) ([]Incident, error) {
+	var w where
+	if f.Status != "" {
+		w.add("status = ?", f.Status)
+	}
+	if f.Severity != "" {
+		w.add("severity = ?", f.Severity)
+	}
+	if f.ServiceID != 0 {
+		w.add("id IN (SELECT incident_id FROM incident_services WHERE service_id = ?)", f.ServiceID)
+	}
+	if !f.Since.IsZero() {
+		w.add("started_at >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		w.add("started_at <= ?", f.Until.UTC())
+	}
+	w.after(f.After, "started_at", true)
+	scopeIncidents(ctx, &w, "id")
+
+	query := `SELECT ` + incidentColumns + ` FROM incidents` + w.String() + ` ORDER BY started_at DESC, id DESC` +
+		limit(f.Limit)
+
+	rows, err := d.r.QueryContext(ctx, query, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list incidents error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []Incident
+	for rows.Next() {
+		inc, err := scanIncident(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list incidents error: %v", err)
+		}
+		out = append(out, *inc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list incidents error: %v", err)
+	}
+
+	return out, nil
+}
+
+// UpdateIncident replaces the fields of an incident. Changing its RCA
+// publishes a postmortem and needs PermPublish.
+func (d *SQliteDB) UpdateIncident(ctx context.Context, inc *Incident) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+	inc.StartedAt = utc(inc.StartedAt)
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetIncident(ctx, inc.ID)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+		if inc.RCA != old.RCA {
+			if err := authorize(ctx, PermPublish); err != nil {
+				return err
+			}
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			UPDATE incidents
+			SET title = ?, summary = ?, status = ?, severity = ?, rca = ?, started_at = ?,
+				detected_at = ?, resolved_at = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+			RETURNING updated_at`,
+			inc.Title, inc.Summary, inc.Status, inc.Severity, inc.RCA, inc.StartedAt,
+			nullTime(inc.DetectedAt), nullTime(inc.ResolvedAt), inc.ID)
+		if err := row.Scan(&inc.UpdatedAt); err != nil {
+			return notFound(err, "update incident")
+		}
+
+		cur, err := tx.GetIncident(ctx, inc.ID)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditIncident, inc.ID, inc.ID, old, cur)
+	})
+}
+
+// DeleteIncident removes an incident and, through the foreign keys,
+// everything recorded for it.
+func (d *SQliteDB) DeleteIncident(ctx context.Context, id int64) error {
+	if err := authorize(ctx, PermDeleteIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetIncident(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+
+		// Audited first, while the incident still tells its workspace.
+		if err := tx.audit(ctx, AuditDelete, AuditIncident, id, id, old, nil); err != nil {
+			return err
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM incidents WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("delete incident error: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// incidentService is what the audit log records for AttachService and
+// DetachService.
+type incidentService struct {
+	IncidentID int64
+	ServiceID  int64
+}
+
+// AttachService records that an incident affected a service. Attaching the
+// same service twice is a no-op.
+func (d *SQliteDB) AttachService(ctx context.Context, incidentID, serviceID int64) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, incidentID); err != nil {
+			return err
+		}
+
+		res, err := tx.q.ExecContext(ctx, `
+			INSERT INTO incident_services (incident_id, service_id) VALUES (?, ?)
+			ON CONFLICT DO NOTHING`, incidentID, serviceID)
+		if err != nil {
+			return fmt.Errorf("attach service error: %v", err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			return err
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditIncidentService, serviceID, incidentID,
+			nil, incidentService{incidentID, serviceID})
+	})
+}
+
+func (d *SQliteDB) DetachService(ctx context.Context, incidentID, serviceID int64) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, incidentID); err != nil {
+			return err
+		}
+
+		res, err := tx.q.ExecContext(ctx, `
+			DELETE FROM incident_services WHERE incident_id = ? AND service_id = ?`,
+			incidentID, serviceID)
+		if err != nil {
+			return fmt.Errorf("detach service error: %v", err)
+		}
+		if err := affectedOne(res, "detach service"); err != nil {
+			return err
+		}
+
+		return tx.audit(ctx, AuditDelete, AuditIncidentService, serviceID, incidentID,
+			incidentService{incidentID, serviceID}, nil)
+	})
+}
+
+func scanIncident(s scanner) (*Incident, error) {
+	var (
+		inc                    Incident
+		detectedAt, resolvedAt sql.NullTime
+	)
+
+	err := s.Scan(&inc.ID, &inc.UID, &inc.WorkspaceID, &inc.Title, &inc.Summary, &inc.Status, &inc.Severity, &inc.RCA,
+		&inc.StartedAt, &detectedAt, &resolvedAt, &inc.CreatedAt, &inc.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	inc.DetectedAt = timePtr(detectedAt)
+	inc.ResolvedAt = timePtr(resolvedAt)
+
+	return &inc, nil
+}
+
+// newUID returns 32 random hex characters, the same shape as the uids the
+// migration backfills.
+func newUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
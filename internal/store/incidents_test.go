@@ -0,0 +1,180 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_Incidents(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	base := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+	payments := &Service{Name: "payments"}
+	if err := db.CreateService(ctx, payments); err != nil {
+		t.Fatal(err)
+	}
+
+	redis := &Incident{Title: "Redis timeouts", Severity: Sev2, StartedAt: base}
+	dns := &Incident{Title: "DNS outage", Severity: Sev1, Status: IncidentResolved, StartedAt: base.Add(48 * time.Hour)}
+
+	t.Run("CreateIncident: defaults", func(t *testing.T) {
+		for _, inc := range []*Incident{redis, dns} {
+			if err := db.CreateIncident(ctx, inc); err != nil {
+				t.Fatalf("failed to create incident: %v", err)
+			}
+		}
+		if redis.ID == 0 || redis.Status != IncidentOpen || redis.CreatedAt.IsZero() {
+			t.Errorf("unexpected incident after create: %+v", redis)
+		}
+		if len(redis.UID) != 32 || redis.UID == dns.UID {
+			t.Errorf("unexpected uids: %q %q", redis.UID, dns.UID)
+		}
+		if err := db.AttachService(ctx, redis.ID, payments.ID); err != nil {
+			t.Fatal(err)
+		}
+		// Attaching twice is a no-op.
+		if err := db.AttachService(ctx, redis.ID, payments.ID); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("CreateIncident: invalid severity", func(t *testing.T) {
+		if err := db.CreateIncident(ctx, &Incident{Title: "x", Severity: "sev9"}); err == nil {
+			t.Fatal("expected check constraint error")
+		}
+	})
+
+	t.Run("GetIncident: success", func(t *testing.T) {
+		got, err := db.GetIncident(ctx, redis.ID)
+		if err != nil {
+			t.Fatalf("failed to get incident: %v", err)
+		}
+		if got.Title != redis.Title || got.Severity != Sev2 || !got.StartedAt.Equal(base) {
+			t.Errorf("incident: got %+v want %+v", got, redis)
+		}
+		if got.DetectedAt != nil || got.ResolvedAt != nil {
+			t.Errorf("expected nil optional times: %+v", got)
+		}
+	})
+
+	t.Run("GetIncidentByUID: success", func(t *testing.T) {
+		got, err := db.GetIncidentByUID(ctx, dns.UID)
+		if err != nil {
+			t.Fatalf("failed to get incident: %v", err)
+		}
+		if got.ID != dns.ID {
+			t.Errorf("id: got %d want %d", got.ID, dns.ID)
+		}
+		if _, err := db.GetIncidentByUID(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("ListIncidents: filters", func(t *testing.T) {
+		tests := []struct {
+			name string
+			f    IncidentFilter
+			want []int64
+		}{
+			{name: "all newest first", f: IncidentFilter{}, want: []int64{dns.ID, redis.ID}},
+			{name: "status", f: IncidentFilter{Status: IncidentResolved}, want: []int64{dns.ID}},
+			{name: "severity", f: IncidentFilter{Severity: Sev2}, want: []int64{redis.ID}},
+			{name: "service", f: IncidentFilter{ServiceID: payments.ID}, want: []int64{redis.ID}},
+			{name: "since", f: IncidentFilter{Since: base.Add(time.Hour)}, want: []int64{dns.ID}},
+			{name: "until", f: IncidentFilter{Until: base}, want: []int64{redis.ID}},
+			{name: "limit", f: IncidentFilter{Limit: 1}, want: []int64{dns.ID}},
+			{name: "after", f: IncidentFilter{After: &Cursor{Key: dns.StartedAt, ID: dns.ID}}, want: []int64{redis.ID}},
+		}
+
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := db.ListIncidents(ctx, tt.f)
+				if err != nil {
+					t.Fatalf("failed to list incidents: %v", err)
+				}
+				var ids []int64
+				for _, inc := range got {
+					ids = append(ids, inc.ID)
+				}
+				if len(ids) != len(tt.want) {
+					t.Fatalf("ids: got %v want %v", ids, tt.want)
+				}
+				for i := range ids {
+					if ids[i] != tt.want[i] {
+						t.Fatalf("ids: got %v want %v", ids, tt.want)
+					}
+				}
+			})
+		}
+	})
+
+	t.Run("UpdateIncident: success", func(t *testing.T) {
+		resolved := base.Add(2 * time.Hour)
+		redis.Status = IncidentResolved
+		redis.RCA = "Connection pool too small."
+		redis.ResolvedAt = &resolved
+		if err := db.UpdateIncident(ctx, redis); err != nil {
+			t.Fatalf("failed to update incident: %v", err)
+		}
+
+		got, err := db.GetIncident(ctx, redis.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != IncidentResolved || got.RCA != redis.RCA || got.ResolvedAt == nil || !got.ResolvedAt.Equal(resolved) {
+			t.Errorf("incident not updated: %+v", got)
+		}
+	})
+
+	t.Run("UpdateIncident: precondition", func(t *testing.T) {
+		stale := errors.New("stale")
+		pctx := WithPrecondition(ctx, func(current any) error {
+			if current.(*Incident).Status != IncidentOpen {
+				return stale
+			}
+			return nil
+		})
+		redis.Title = "Redis timeouts (checkout)"
+		if err := db.UpdateIncident(pctx, redis); !errors.Is(err, stale) {
+			t.Fatalf("UpdateIncident: got %v want %v", err, stale)
+		}
+		if err := db.DeleteIncident(pctx, redis.ID); !errors.Is(err, stale) {
+			t.Fatalf("DeleteIncident: got %v want %v", err, stale)
+		}
+
+		got, err := db.GetIncident(ctx, redis.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title == redis.Title {
+			t.Error("update applied despite the failed precondition")
+		}
+	})
+
+	t.Run("DeleteIncident: success", func(t *testing.T) {
+		if err := db.DeleteIncident(ctx, dns.ID); err != nil {
+			t.Fatalf("failed to delete incident: %v", err)
+		}
+		if _, err := db.GetIncident(ctx, dns.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetIncident after delete: got %v want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := db.GetIncident(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetIncident: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.UpdateIncident(ctx, &Incident{ID: 9999, Title: "x", Status: IncidentOpen, Severity: Sev3}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateIncident: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.DeleteIncident(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteIncident: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.DetachService(ctx, 9999, payments.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DetachService: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
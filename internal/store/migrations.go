@@ -0,0 +1,129 @@
+package store
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+)
+
+// migrationsFS holds the schema migrations compiled into the binary, so the
+// server does not depend on the working directory to find them.
+//
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+func (d *SQliteDB) migrator() (*migrate.Migrate, error) {
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("migration source error: %v", err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("iofs", src, "sqlite3://"+d.path)
+	if err != nil {
+		return nil, fmt.Errorf("migration error: %v", err)
+	}
+
+	return m, nil
+}
+
+// withMigrator runs fn against a fresh migrator. A missing change is not an
+// error: migrating an up to date database is the common case at startup.
+func (d *SQliteDB) withMigrator(op string, fn func(m *migrate.Migrate) error) error {
+	m, err := d.migrator()
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	if err := fn(m); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("migration %s error: %w", op, err)
+	}
+
+	return nil
+}
+
+// withSchemaChange is withMigrator for operations that change the schema.
+// The search index is not part of the migrations since its module depends
+// on how the driver was built, so it is brought in line afterwards.
+func (d *SQliteDB) withSchemaChange(op string, fn func(m *migrate.Migrate) error) error {
+	if err := d.withMigrator(op, fn); err != nil {
+		return err
+	}
+
+	return d.syncSearchIndex(context.Background())
+}
+
+// Migrate applies all pending migrations.
+func (d *SQliteDB) Migrate() error {
+	return d.withSchemaChange("up", func(m *migrate.Migrate) error {
+		return m.Up()
+	})
+}
+
+// Down reverts every applied migration.
+func (d *SQliteDB) Down() error {
+	return d.withSchemaChange("down", func(m *migrate.Migrate) error {
+		return m.Down()
+	})
+}
+
+// Steps applies n migrations when n is positive and reverts -n migrations
+// when it is negative.
+func (d *SQliteDB) Steps(n int) error {
+	return d.withSchemaChange("steps", func(m *migrate.Migrate) error {
+		return m.Steps(n)
+	})
+}
+
+// Force sets the schema version without running any migration and clears
+// the dirty flag. It is the way out after a migration failed halfway.
+func (d *SQliteDB) Force(version int) error {
+	return d.withSchemaChange("force", func(m *migrate.Migrate) error {
+		return m.Force(version)
+	})
+}
+
+// Version returns the applied schema version, 0 when nothing has been
+// applied yet, and whether the last migration failed halfway.
+func (d *SQliteDB) Version() (version uint, dirty bool, err error) {
+	err = d.withMigrator("version", func(m *migrate.Migrate) error {
+		version, dirty, err = m.Version()
+		if errors.Is(err, migrate.ErrNilVersion) {
+			return nil
+		}
+		return err
+	})
+
+	return version, dirty, err
+}
+
+// LatestVersion returns the highest migration version embedded in the
+// binary.
+func LatestVersion() (uint, error) {
+	src, err := iofs.New(migrationsFS, "migrations")
+	if err != nil {
+		return 0, fmt.Errorf("migration source error: %v", err)
+	}
+	defer src.Close()
+
+	v, err := src.First()
+	if err != nil {
+		return 0, fmt.Errorf("migration source error: %v", err)
+	}
+	for {
+		next, err := src.Next(v)
+		if errors.Is(err, fs.ErrNotExist) {
+			return v, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("migration source error: %v", err)
+		}
+		v = next
+	}
+}
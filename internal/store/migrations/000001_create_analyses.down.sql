@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_analyses_incident_id;
+DROP TABLE IF EXISTS analyses;
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS analyses (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL,
+    model       TEXT    NOT NULL DEFAULT '',
+    draft       TEXT    NOT NULL,
+    evidence    TEXT    NOT NULL DEFAULT '[]',
+    critique    TEXT,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_analyses_incident_id ON analyses (incident_id);
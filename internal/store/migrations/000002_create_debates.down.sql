@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_debate_turns_debate_id;
+DROP TABLE IF EXISTS debate_turns;
+DROP INDEX IF EXISTS idx_debates_incident_id;
+DROP TABLE IF EXISTS debates;
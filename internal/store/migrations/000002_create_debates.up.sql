@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS debates (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL,
+    problem     TEXT    NOT NULL,
+    agents      TEXT    NOT NULL DEFAULT '[]',
+    rounds      INTEGER NOT NULL,
+    status      TEXT    NOT NULL DEFAULT 'running',
+    verdict     TEXT    NOT NULL DEFAULT '',
+    error       TEXT    NOT NULL DEFAULT '',
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at DATETIME
+);
+
+CREATE INDEX IF NOT EXISTS idx_debates_incident_id ON debates (incident_id);
+
+CREATE TABLE IF NOT EXISTS debate_turns (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    debate_id  INTEGER NOT NULL REFERENCES debates (id) ON DELETE CASCADE,
+    round      INTEGER NOT NULL,
+    agent      TEXT    NOT NULL,
+    content    TEXT    NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_debate_turns_debate_id ON debate_turns (debate_id, round, id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS persona_assignments;
+DROP TABLE IF EXISTS personas;
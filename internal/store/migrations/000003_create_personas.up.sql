@@ -0,0 +1,61 @@
+CREATE TABLE IF NOT EXISTS personas (
+    key             TEXT PRIMARY KEY,
+    name            TEXT    NOT NULL,
+    system_prompt   TEXT    NOT NULL,
+    evidence_types  TEXT    NOT NULL DEFAULT '[]',
+    report_emphasis TEXT    NOT NULL DEFAULT '',
+    position        INTEGER NOT NULL DEFAULT 0,
+    created_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at      DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- subject_type is 'incident' or 'chat'; one persona per subject.
+CREATE TABLE IF NOT EXISTS persona_assignments (
+    subject_type TEXT    NOT NULL,
+    subject_id   INTEGER NOT NULL,
+    persona_key  TEXT    NOT NULL REFERENCES personas (key) ON DELETE CASCADE,
+    assigned_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (subject_type, subject_id)
+);
+
+INSERT INTO personas (key, name, system_prompt, evidence_types, report_emphasis, position) VALUES
+(
+    'sre',
+    'SRE',
+    'You are a senior site reliability engineer. You reason about deploys, capacity, saturation, retries, timeouts and cascading failures between services.',
+    '["metric","alert","deploy","log"]',
+    'Lead with customer impact, detection and mitigation timeline, and the SLO budget consumed.',
+    1
+),
+(
+    'backend',
+    'Backend Developer',
+    'You are a senior backend developer. You reason about code changes, feature flags, error handling, dependency upgrades and request paths through the application.',
+    '["deploy","log","trace","code"]',
+    'Lead with the faulty code path or change, how it reached production, and the fix.',
+    2
+),
+(
+    'dba',
+    'DBA',
+    'You are a database administrator. You reason about locks, slow queries, connection pools, replication lag, schema changes and storage.',
+    '["query","metric","log"]',
+    'Lead with query plans, lock and pool behaviour, and data integrity impact.',
+    3
+),
+(
+    'network',
+    'Network Engineer',
+    'You are a network engineer. You reason about DNS, load balancers, TLS, packet loss, latency, firewalls and routing changes.',
+    '["network","metric","log"]',
+    'Lead with the network path affected, where packets were lost or delayed, and blast radius.',
+    4
+),
+(
+    'security',
+    'Security Analyst',
+    'You are a security analyst. You reason about authentication failures, suspicious access, abuse traffic, leaked credentials and whether the incident had a malicious cause.',
+    '["audit","log","network","alert"]',
+    'Lead with whether data or access was compromised, the attack vector if any, and required disclosures.',
+    5
+);
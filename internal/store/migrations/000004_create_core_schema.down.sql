@@ -0,0 +1,27 @@
+CREATE TABLE analyses_old (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL,
+    model       TEXT    NOT NULL DEFAULT '',
+    draft       TEXT    NOT NULL,
+    evidence    TEXT    NOT NULL DEFAULT '[]',
+    critique    TEXT,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at DATETIME
+);
+
+INSERT INTO analyses_old (id, incident_id, model, draft, evidence, critique, created_at, reviewed_at)
+SELECT id, incident_id, model, draft, evidence, critique, created_at, reviewed_at FROM analyses;
+
+DROP INDEX IF EXISTS idx_analyses_incident_id;
+DROP TABLE analyses;
+ALTER TABLE analyses_old RENAME TO analyses;
+CREATE INDEX IF NOT EXISTS idx_analyses_incident_id ON analyses (incident_id);
+
+DROP TABLE IF EXISTS chat_messages;
+DROP TABLE IF EXISTS action_items;
+DROP TABLE IF EXISTS hypotheses;
+DROP TABLE IF EXISTS evidence;
+DROP TABLE IF EXISTS timeline_events;
+DROP TABLE IF EXISTS incident_services;
+DROP TABLE IF EXISTS incidents;
+DROP TABLE IF EXISTS services;
@@ -0,0 +1,123 @@
+CREATE TABLE IF NOT EXISTS services (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        TEXT    NOT NULL UNIQUE,
+    description TEXT    NOT NULL DEFAULT '',
+    owner       TEXT    NOT NULL DEFAULT '',
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS incidents (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    title       TEXT    NOT NULL,
+    summary     TEXT    NOT NULL DEFAULT '',
+    status      TEXT    NOT NULL DEFAULT 'open'
+                CHECK (status IN ('open', 'investigating', 'mitigated', 'resolved', 'closed')),
+    severity    TEXT    NOT NULL DEFAULT 'sev3'
+                CHECK (severity IN ('sev1', 'sev2', 'sev3', 'sev4')),
+    rca         TEXT    NOT NULL DEFAULT '',
+    started_at  DATETIME NOT NULL,
+    detected_at DATETIME,
+    resolved_at DATETIME,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_incidents_status ON incidents (status);
+CREATE INDEX IF NOT EXISTS idx_incidents_severity ON incidents (severity);
+CREATE INDEX IF NOT EXISTS idx_incidents_started_at ON incidents (started_at);
+
+CREATE TABLE IF NOT EXISTS incident_services (
+    incident_id INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    service_id  INTEGER NOT NULL REFERENCES services (id) ON DELETE CASCADE,
+    PRIMARY KEY (incident_id, service_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_incident_services_service_id ON incident_services (service_id);
+
+CREATE TABLE IF NOT EXISTS timeline_events (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    occurred_at DATETIME NOT NULL,
+    kind        TEXT    NOT NULL DEFAULT 'note',
+    description TEXT    NOT NULL,
+    source      TEXT    NOT NULL DEFAULT '',
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_timeline_events_incident_id ON timeline_events (incident_id, occurred_at);
+
+CREATE TABLE IF NOT EXISTS evidence (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id  INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    service_id   INTEGER REFERENCES services (id) ON DELETE SET NULL,
+    kind         TEXT    NOT NULL DEFAULT 'log',
+    title        TEXT    NOT NULL DEFAULT '',
+    content      TEXT    NOT NULL DEFAULT '',
+    source       TEXT    NOT NULL DEFAULT '',
+    collected_at DATETIME NOT NULL,
+    created_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_evidence_incident_id ON evidence (incident_id, collected_at);
+CREATE INDEX IF NOT EXISTS idx_evidence_service_id ON evidence (service_id);
+
+CREATE TABLE IF NOT EXISTS hypotheses (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    statement   TEXT    NOT NULL,
+    rationale   TEXT    NOT NULL DEFAULT '',
+    status      TEXT    NOT NULL DEFAULT 'proposed'
+                CHECK (status IN ('proposed', 'investigating', 'confirmed', 'rejected')),
+    confidence  REAL    NOT NULL DEFAULT 0 CHECK (confidence BETWEEN 0 AND 1),
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_hypotheses_incident_id ON hypotheses (incident_id, confidence DESC);
+
+CREATE TABLE IF NOT EXISTS action_items (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    title       TEXT    NOT NULL,
+    description TEXT    NOT NULL DEFAULT '',
+    owner       TEXT    NOT NULL DEFAULT '',
+    status      TEXT    NOT NULL DEFAULT 'open'
+                CHECK (status IN ('open', 'in_progress', 'done', 'wont_do')),
+    due_at      DATETIME,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_action_items_incident_id ON action_items (incident_id);
+CREATE INDEX IF NOT EXISTS idx_action_items_owner ON action_items (owner, status);
+
+CREATE TABLE IF NOT EXISTS chat_messages (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    role        TEXT    NOT NULL CHECK (role IN ('system', 'user', 'assistant')),
+    content     TEXT    NOT NULL,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_chat_messages_incident_id ON chat_messages (incident_id, id);
+
+-- analyses predates incidents; rebuild it so incident_id is a real foreign key.
+CREATE TABLE analyses_new (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    incident_id INTEGER NOT NULL REFERENCES incidents (id) ON DELETE CASCADE,
+    model       TEXT    NOT NULL DEFAULT '',
+    draft       TEXT    NOT NULL,
+    evidence    TEXT    NOT NULL DEFAULT '[]',
+    critique    TEXT,
+    created_at  DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    reviewed_at DATETIME
+);
+
+INSERT INTO analyses_new (id, incident_id, model, draft, evidence, critique, created_at, reviewed_at)
+SELECT id, incident_id, model, draft, evidence, critique, created_at, reviewed_at FROM analyses;
+
+DROP INDEX IF EXISTS idx_analyses_incident_id;
+DROP TABLE analyses;
+ALTER TABLE analyses_new RENAME TO analyses;
+CREATE INDEX IF NOT EXISTS idx_analyses_incident_id ON analyses (incident_id);
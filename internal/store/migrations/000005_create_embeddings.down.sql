@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS embeddings_timeline_events_ad;
+DROP TRIGGER IF EXISTS embeddings_evidence_ad;
+DROP TRIGGER IF EXISTS embeddings_analyses_ad;
+DROP TRIGGER IF EXISTS embeddings_incidents_ad;
+DROP TABLE IF EXISTS embeddings;
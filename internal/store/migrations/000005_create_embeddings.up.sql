@@ -0,0 +1,31 @@
+CREATE TABLE IF NOT EXISTS embeddings (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind         TEXT    NOT NULL CHECK (kind IN ('incident', 'analysis', 'evidence', 'timeline')),
+    ref_id       INTEGER NOT NULL,
+    model        TEXT    NOT NULL,
+    dims         INTEGER NOT NULL CHECK (dims > 0),
+    vector       BLOB    NOT NULL CHECK (length(vector) = dims * 4),
+    content_hash TEXT    NOT NULL DEFAULT '',
+    updated_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (kind, ref_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_embeddings_model ON embeddings (model, dims);
+
+-- Embeddings point at rows of several tables, so they are cleaned up by
+-- triggers instead of foreign keys.
+CREATE TRIGGER IF NOT EXISTS embeddings_incidents_ad AFTER DELETE ON incidents BEGIN
+    DELETE FROM embeddings WHERE kind = 'incident' AND ref_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS embeddings_analyses_ad AFTER DELETE ON analyses BEGIN
+    DELETE FROM embeddings WHERE kind = 'analysis' AND ref_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS embeddings_evidence_ad AFTER DELETE ON evidence BEGIN
+    DELETE FROM embeddings WHERE kind = 'evidence' AND ref_id = old.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS embeddings_timeline_events_ad AFTER DELETE ON timeline_events BEGIN
+    DELETE FROM embeddings WHERE kind = 'timeline' AND ref_id = old.id;
+END;
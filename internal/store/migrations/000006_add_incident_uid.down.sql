@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_incidents_uid;
+ALTER TABLE incidents DROP COLUMN uid;
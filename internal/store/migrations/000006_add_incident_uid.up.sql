@@ -0,0 +1,6 @@
+-- uid identifies an incident across installations; bundles are matched on it.
+ALTER TABLE incidents ADD COLUMN uid TEXT NOT NULL DEFAULT '';
+
+UPDATE incidents SET uid = lower(hex(randomblob(16))) WHERE uid = '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_incidents_uid ON incidents (uid);
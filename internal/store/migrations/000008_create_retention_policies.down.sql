@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_evidence_retention;
+ALTER TABLE evidence DROP COLUMN archived_at;
+DROP TABLE IF EXISTS retention_policies;
@@ -0,0 +1,14 @@
+-- A retention policy archives evidence of a kind once it is older than
+-- max_age_days: its content is cut down to the lines cited by RCA drafts.
+-- Kinds without a policy are kept forever.
+CREATE TABLE IF NOT EXISTS retention_policies (
+    kind         TEXT    PRIMARY KEY,
+    max_age_days INTEGER NOT NULL CHECK (max_age_days > 0),
+    updated_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO retention_policies (kind, max_age_days) VALUES ('log', 90);
+
+ALTER TABLE evidence ADD COLUMN archived_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_evidence_retention ON evidence (kind, collected_at) WHERE archived_at IS NULL;
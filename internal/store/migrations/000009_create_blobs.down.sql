@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS evidence_blobs_ad;
+DROP TRIGGER IF EXISTS evidence_blobs_au;
+DROP TRIGGER IF EXISTS evidence_blobs_ai;
+ALTER TABLE evidence DROP COLUMN blob_size;
+ALTER TABLE evidence DROP COLUMN blob_sha256;
+DROP TABLE IF EXISTS blobs;
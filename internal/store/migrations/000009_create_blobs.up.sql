@@ -0,0 +1,31 @@
+-- blobs counts the evidence rows referencing each file of the blob store.
+-- The triggers keep refs up to date; files of blobs with no references are
+-- removed by the garbage collector.
+CREATE TABLE IF NOT EXISTS blobs (
+    sha256     TEXT    PRIMARY KEY CHECK (length(sha256) = 64),
+    size       INTEGER NOT NULL CHECK (size >= 0),
+    refs       INTEGER NOT NULL DEFAULT 0 CHECK (refs >= 0),
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE evidence ADD COLUMN blob_sha256 TEXT;
+ALTER TABLE evidence ADD COLUMN blob_size INTEGER NOT NULL DEFAULT 0;
+
+CREATE TRIGGER IF NOT EXISTS evidence_blobs_ai AFTER INSERT ON evidence
+WHEN new.blob_sha256 IS NOT NULL BEGIN
+    INSERT INTO blobs (sha256, size, refs) VALUES (new.blob_sha256, new.blob_size, 1)
+    ON CONFLICT (sha256) DO UPDATE SET refs = refs + 1;
+END;
+
+CREATE TRIGGER IF NOT EXISTS evidence_blobs_au AFTER UPDATE OF blob_sha256 ON evidence
+WHEN old.blob_sha256 IS NOT new.blob_sha256 BEGIN
+    UPDATE blobs SET refs = refs - 1 WHERE sha256 = old.blob_sha256;
+    INSERT INTO blobs (sha256, size, refs) SELECT new.blob_sha256, new.blob_size, 1
+    WHERE new.blob_sha256 IS NOT NULL
+    ON CONFLICT (sha256) DO UPDATE SET refs = refs + 1;
+END;
+
+CREATE TRIGGER IF NOT EXISTS evidence_blobs_ad AFTER DELETE ON evidence
+WHEN old.blob_sha256 IS NOT NULL BEGIN
+    UPDATE blobs SET refs = refs - 1 WHERE sha256 = old.blob_sha256;
+END;
@@ -0,0 +1,25 @@
+DROP INDEX IF EXISTS idx_audit_log_workspace_id;
+ALTER TABLE audit_log DROP COLUMN workspace_id;
+
+DROP TRIGGER IF EXISTS workspaces_retention_ad;
+
+CREATE TABLE retention_policies_old (
+    kind         TEXT    PRIMARY KEY,
+    max_age_days INTEGER NOT NULL CHECK (max_age_days > 0),
+    updated_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO retention_policies_old (kind, max_age_days, updated_at)
+SELECT kind, max_age_days, updated_at FROM retention_policies WHERE workspace_id = 0;
+
+DROP TABLE retention_policies;
+ALTER TABLE retention_policies_old RENAME TO retention_policies;
+
+DROP TRIGGER IF EXISTS workspaces_incidents_bd;
+DROP TRIGGER IF EXISTS incidents_workspace_bu;
+DROP TRIGGER IF EXISTS incidents_workspace_bi;
+DROP INDEX IF EXISTS idx_incidents_workspace_id;
+ALTER TABLE incidents DROP COLUMN workspace_id;
+
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
@@ -0,0 +1,82 @@
+-- A workspace holds the incidents of one team; everything recorded for an
+-- incident belongs to the incident's workspace. chat_model overrides the
+-- instance model when not empty and personas, a JSON list of persona keys,
+-- limits the personas offered when not empty.
+CREATE TABLE IF NOT EXISTS workspaces (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    slug       TEXT    NOT NULL UNIQUE CHECK (slug <> ''),
+    name       TEXT    NOT NULL,
+    chat_model TEXT    NOT NULL DEFAULT '',
+    personas   TEXT    NOT NULL DEFAULT '[]',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO workspaces (id, slug, name) VALUES (1, 'default', 'Default');
+
+CREATE TABLE IF NOT EXISTS workspace_members (
+    workspace_id INTEGER NOT NULL REFERENCES workspaces (id) ON DELETE CASCADE,
+    member       TEXT    NOT NULL CHECK (member <> ''),
+    added_at     DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (workspace_id, member)
+);
+
+CREATE INDEX IF NOT EXISTS idx_workspace_members_member ON workspace_members (member);
+
+-- Existing incidents go to the default workspace. A column added by ALTER
+-- TABLE cannot be a foreign key with a default, so triggers keep it valid.
+ALTER TABLE incidents ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_incidents_workspace_id ON incidents (workspace_id, started_at);
+
+CREATE TRIGGER IF NOT EXISTS incidents_workspace_bi BEFORE INSERT ON incidents
+WHEN NOT EXISTS (SELECT 1 FROM workspaces WHERE id = NEW.workspace_id)
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed');
+END;
+
+CREATE TRIGGER IF NOT EXISTS incidents_workspace_bu BEFORE UPDATE OF workspace_id ON incidents
+WHEN NOT EXISTS (SELECT 1 FROM workspaces WHERE id = NEW.workspace_id)
+BEGIN
+    SELECT RAISE(ABORT, 'FOREIGN KEY constraint failed');
+END;
+
+CREATE TRIGGER IF NOT EXISTS workspaces_incidents_bd BEFORE DELETE ON workspaces
+WHEN EXISTS (SELECT 1 FROM incidents WHERE workspace_id = OLD.id)
+BEGIN
+    SELECT RAISE(ABORT, 'workspace has incidents');
+END;
+
+-- Retention policies with workspace_id 0 apply to every workspace that has
+-- no policy of its own for the kind.
+CREATE TABLE retention_policies_new (
+    workspace_id INTEGER NOT NULL DEFAULT 0,
+    kind         TEXT    NOT NULL,
+    max_age_days INTEGER NOT NULL CHECK (max_age_days > 0),
+    updated_at   DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (workspace_id, kind)
+);
+
+INSERT INTO retention_policies_new (workspace_id, kind, max_age_days, updated_at)
+SELECT 0, kind, max_age_days, updated_at FROM retention_policies;
+
+DROP TABLE retention_policies;
+ALTER TABLE retention_policies_new RENAME TO retention_policies;
+
+CREATE TRIGGER IF NOT EXISTS workspaces_retention_ad AFTER DELETE ON workspaces
+BEGIN
+    DELETE FROM retention_policies WHERE workspace_id = OLD.id;
+END;
+
+-- Audit entries remember their workspace so that a workspace's history
+-- survives the deletion of its incidents. NULL is instance-wide.
+ALTER TABLE audit_log ADD COLUMN workspace_id INTEGER;
+
+DROP TRIGGER IF EXISTS audit_log_no_update;
+UPDATE audit_log SET workspace_id = 1 WHERE incident_id IS NOT NULL;
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+BEGIN
+    SELECT RAISE(ABORT, 'audit_log is append-only');
+END;
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_workspace_id ON audit_log (workspace_id, id);
@@ -0,0 +1,7 @@
+-- settings holds the instance settings changed from their defaults, one
+-- row per key. Values are text; the store parses them into typed fields.
+CREATE TABLE IF NOT EXISTS settings (
+    key        TEXT     PRIMARY KEY CHECK (key <> ''),
+    value      TEXT     NOT NULL,
+    updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
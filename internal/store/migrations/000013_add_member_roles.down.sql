@@ -0,0 +1 @@
+ALTER TABLE workspace_members DROP COLUMN role;
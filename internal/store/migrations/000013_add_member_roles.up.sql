@@ -0,0 +1,4 @@
+-- Members hold a role in their workspace: viewer, responder, commander or
+-- admin. Everyone added before roles existed works incidents.
+ALTER TABLE workspace_members ADD COLUMN role TEXT NOT NULL DEFAULT 'responder'
+    CHECK (role IN ('viewer', 'responder', 'commander', 'admin'));
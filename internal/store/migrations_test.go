@@ -0,0 +1,98 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestSQLiteDB_Migrate(t *testing.T) {
+	f := testDBFileSetup(t)
+	defer testDBFileCleanup(t, f)
+
+	db, err := NewSQLiteDB(f)
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
+	}
+	defer db.Close()
+
+	latest, err := LatestVersion()
+	if err != nil {
+		t.Fatalf("failed to read latest version: %v", err)
+	}
+	if latest == 0 {
+		t.Fatal("no embedded migrations found")
+	}
+
+	assertVersion := func(t *testing.T, want uint) {
+		t.Helper()
+		v, dirty, err := db.Version()
+		if err != nil {
+			t.Fatalf("failed to read version: %v", err)
+		}
+		if v != want || dirty {
+			t.Fatalf("version: got %d (dirty %v) want %d", v, dirty, want)
+		}
+	}
+
+	t.Run("Version: empty database", func(t *testing.T) {
+		assertVersion(t, 0)
+	})
+
+	t.Run("Migrate: applies embedded migrations", func(t *testing.T) {
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("failed to migrate: %v", err)
+		}
+		assertVersion(t, latest)
+	})
+
+	t.Run("Migrate: no change is success", func(t *testing.T) {
+		if err := db.Migrate(); err != nil {
+			t.Fatalf("second migrate returned error: %v", err)
+		}
+		assertVersion(t, latest)
+	})
+
+	t.Run("Steps: one down and back up", func(t *testing.T) {
+		if err := db.Steps(-1); err != nil {
+			t.Fatalf("failed to step down: %v", err)
+		}
+		assertVersion(t, latest-1)
+
+		if err := db.Steps(1); err != nil {
+			t.Fatalf("failed to step up: %v", err)
+		}
+		assertVersion(t, latest)
+	})
+
+	t.Run("Force: clears dirty state", func(t *testing.T) {
+		if _, err := db.Exec("UPDATE schema_migrations SET dirty = 1"); err != nil {
+			t.Fatal(err)
+		}
+		if _, dirty, err := db.Version(); err != nil || !dirty {
+			t.Fatalf("expected dirty version, got dirty=%v err=%v", dirty, err)
+		}
+		if err := db.Migrate(); err == nil {
+			t.Fatal("expected migrate to refuse a dirty database")
+		}
+
+		if err := db.Force(int(latest)); err != nil {
+			t.Fatalf("failed to force version: %v", err)
+		}
+		assertVersion(t, latest)
+	})
+
+	t.Run("Down: reverts everything", func(t *testing.T) {
+		if err := db.Down(); err != nil {
+			t.Fatalf("failed to migrate down: %v", err)
+		}
+		assertVersion(t, 0)
+
+		var n int
+		row := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE name = 'analyses'")
+		if err := row.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Error("analyses table still exists after down")
+		}
+	})
+}
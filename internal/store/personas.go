@@ -0,0 +1,209 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Persona is an analysis role with its own system prompt, the evidence
+// types it looks at first and what its reports emphasise.
+type Persona struct {
+	Key            string
+	Name           string
+	SystemPrompt   string
+	EvidenceTypes  []string
+	ReportEmphasis string
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+}
+
+type PersonaSubject string
+
+const (
+	PersonaSubjectIncident PersonaSubject = "incident"
+	PersonaSubjectChat     PersonaSubject = "chat"
+)
+
+const personaColumns = `key, name, system_prompt, evidence_types, report_emphasis, created_at, updated_at`
+
+// ListPersonas returns the personas in display order. In a workspace
+// context only those the workspace offers are listed.
+func (d *SQliteDB) ListPersonas(ctx context.Context) ([]Persona, error) {
+	var w where
+	scopePersonas(ctx, &w, "key")
+
+	rows, err := d.r.QueryContext(ctx, `SELECT `+personaColumns+` FROM personas`+w.String()+` ORDER BY position, key`, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list personas error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []Persona
+	for rows.Next() {
+		p, err := scanPersona(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list personas error: %v", err)
+	}
+
+	return out, nil
+}
+
+func (d *SQliteDB) GetPersona(ctx context.Context, key string) (*Persona, error) {
+	var w where
+	w.add("key = ?", key)
+	scopePersonas(ctx, &w, "key")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+personaColumns+` FROM personas`+w.String(), w.args...)
+	p, err := scanPersona(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return p, err
+}
+
+// UpdatePersona replaces the prompt, evidence preferences and emphasis of an
+// existing persona.
+func (d *SQliteDB) UpdatePersona(ctx context.Context, p *Persona) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	types, err := json.Marshal(nonNil(p.EvidenceTypes))
+	if err != nil {
+		return fmt.Errorf("persona evidence types marshal error: %v", err)
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetPersona(ctx, p.Key)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			UPDATE personas
+			SET name = ?, system_prompt = ?, evidence_types = ?, report_emphasis = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE key = ?`,
+			p.Name, p.SystemPrompt, string(types), p.ReportEmphasis, p.Key)
+		if err != nil {
+			return fmt.Errorf("update persona error: %v", err)
+		}
+
+		cur, err := tx.GetPersona(ctx, p.Key)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditPersona, p.Key, 0, old, cur)
+	})
+}
+
+// personaAssignment is what the audit log records for AssignPersona.
+type personaAssignment struct {
+	Subject    PersonaSubject
+	SubjectID  int64
+	PersonaKey string
+}
+
+// AssignPersona selects the persona used for an incident or chat,
+// replacing any previous selection. A chat is the conversation of an
+// incident and shares its id.
+func (d *SQliteDB) AssignPersona(ctx context.Context, subject PersonaSubject, id int64, key string) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, id); err != nil {
+			return err
+		}
+		if _, err := tx.GetPersona(ctx, key); err != nil {
+			return err
+		}
+
+		var old *personaAssignment
+		prev, err := tx.AssignedPersona(ctx, subject, id)
+		switch {
+		case err == nil:
+			old = &personaAssignment{subject, id, prev.Key}
+		case !errors.Is(err, ErrNotFound):
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			INSERT INTO persona_assignments (subject_type, subject_id, persona_key)
+			VALUES (?, ?, ?)
+			ON CONFLICT (subject_type, subject_id)
+			DO UPDATE SET persona_key = excluded.persona_key, assigned_at = CURRENT_TIMESTAMP`,
+			subject, id, key)
+		if err != nil {
+			return fmt.Errorf("assign persona error: %v", err)
+		}
+
+		action, incidentID := AuditUpdate, int64(0)
+		if old == nil {
+			action = AuditCreate
+		}
+		if subject == PersonaSubjectIncident {
+			incidentID = id
+		}
+		return tx.audit(ctx, action, AuditPersonaAssignment, fmt.Sprintf("%s/%d", subject, id), incidentID,
+			old, &personaAssignment{subject, id, key})
+	})
+}
+
+// AssignedPersona returns the persona selected for an incident or chat, or
+// ErrNotFound when none was selected.
+func (d *SQliteDB) AssignedPersona(ctx context.Context, subject PersonaSubject, id int64) (*Persona, error) {
+	if err := d.incidentInScope(ctx, id); err != nil {
+		return nil, err
+	}
+
+	row := d.r.QueryRowContext(ctx, `
+		SELECT p.key, p.name, p.system_prompt, p.evidence_types, p.report_emphasis, p.created_at, p.updated_at
+		FROM persona_assignments a JOIN personas p ON p.key = a.persona_key
+		WHERE a.subject_type = ? AND a.subject_id = ?`, subject, id)
+	p, err := scanPersona(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+
+	return p, err
+}
+
+// scopePersonas limits w to the personas, keyed by col, that the
+// workspace of ctx offers. A workspace with an empty list offers them all.
+func scopePersonas(ctx context.Context, w *where, col string) {
+	if ws, ok := WorkspaceFrom(ctx); ok {
+		w.add(`((SELECT personas FROM workspaces WHERE id = ?) = '[]' OR `+col+` IN (
+			SELECT value FROM json_each((SELECT personas FROM workspaces WHERE id = ?))))`, ws, ws)
+	}
+}
+
+func scanPersona(s scanner) (*Persona, error) {
+	var (
+		p     Persona
+		types string
+	)
+	err := s.Scan(&p.Key, &p.Name, &p.SystemPrompt, &types, &p.ReportEmphasis, &p.CreatedAt, &p.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("scan persona error: %v", err)
+	}
+
+	if err := json.Unmarshal([]byte(types), &p.EvidenceTypes); err != nil {
+		return nil, fmt.Errorf("persona evidence types decode error: %v", err)
+	}
+
+	return &p, nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestSQLiteDB_Personas(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	t.Run("ListPersonas: seeded", func(t *testing.T) {
+		got, err := db.ListPersonas(ctx)
+		if err != nil {
+			t.Fatalf("failed to list personas: %v", err)
+		}
+
+		var keys []string
+		for _, p := range got {
+			keys = append(keys, p.Key)
+			if p.SystemPrompt == "" || len(p.EvidenceTypes) == 0 || p.ReportEmphasis == "" {
+				t.Errorf("persona %q incomplete: %+v", p.Key, p)
+			}
+		}
+		want := []string{"sre", "backend", "dba", "network", "security"}
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("keys: got %v want %v", keys, want)
+		}
+	})
+
+	t.Run("UpdatePersona: success", func(t *testing.T) {
+		p, err := db.GetPersona(ctx, "dba")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.EvidenceTypes = []string{"query"}
+		p.ReportEmphasis = "Lead with the query plan."
+		if err := db.UpdatePersona(ctx, p); err != nil {
+			t.Fatalf("failed to update persona: %v", err)
+		}
+
+		got, err := db.GetPersona(ctx, "dba")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got.EvidenceTypes, p.EvidenceTypes) || got.ReportEmphasis != p.ReportEmphasis {
+			t.Errorf("persona not updated: %+v", got)
+		}
+	})
+
+	t.Run("AssignPersona: per incident and chat", func(t *testing.T) {
+		if _, err := db.AssignedPersona(ctx, PersonaSubjectIncident, 1); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected no assignment, got %v", err)
+		}
+
+		if err := db.AssignPersona(ctx, PersonaSubjectIncident, 1, "sre"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AssignPersona(ctx, PersonaSubjectIncident, 1, "network"); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.AssignPersona(ctx, PersonaSubjectChat, 1, "security"); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := db.AssignedPersona(ctx, PersonaSubjectIncident, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Key != "network" {
+			t.Errorf("incident persona: got %q want %q", got.Key, "network")
+		}
+
+		got, err = db.AssignedPersona(ctx, PersonaSubjectChat, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Key != "security" {
+			t.Errorf("chat persona: got %q want %q", got.Key, "security")
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := db.GetPersona(ctx, "astrologer"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetPersona: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.AssignPersona(ctx, PersonaSubjectIncident, 1, "astrologer"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("AssignPersona: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.UpdatePersona(ctx, &Persona{Key: "astrologer"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdatePersona: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
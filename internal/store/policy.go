@@ -0,0 +1,108 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrForbidden is returned when the role of the context does not allow a
+// change.
+var ErrForbidden = errors.New("permission denied")
+
+// Role is what a member may do in a workspace. Every role may do what the
+// roles before it in Roles may do.
+type Role string
+
+const (
+	// RoleViewer reads incidents and everything recorded for them.
+	RoleViewer Role = "viewer"
+	// RoleResponder works incidents: adds evidence, hypotheses and action
+	// items and runs analyses.
+	RoleResponder Role = "responder"
+	// RoleCommander also publishes postmortems and deletes incidents.
+	RoleCommander Role = "commander"
+	// RoleAdmin also changes the settings and members of the workspace.
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists the roles from least to most privileged.
+var Roles = []Role{RoleViewer, RoleResponder, RoleCommander, RoleAdmin}
+
+// DefaultRole is the role of members added without one and of every user
+// in a workspace without members.
+const DefaultRole = RoleResponder
+
+// ValidRole reports whether r names a role.
+func ValidRole(r string) bool {
+	return slices.Contains(Roles, Role(r))
+}
+
+// Permission is something a role may allow.
+type Permission string
+
+const (
+	PermRead            Permission = "read"
+	PermEditIncidents   Permission = "edit incidents"
+	PermWriteEvidence   Permission = "write evidence"
+	PermRunAnalyses     Permission = "run analyses"
+	PermPublish         Permission = "publish postmortems"
+	PermDeleteIncidents Permission = "delete incidents"
+	PermManageWorkspace Permission = "manage the workspace"
+)
+
+// permissionRoles is the least privileged role holding each permission.
+var permissionRoles = map[Permission]Role{
+	PermRead:            RoleViewer,
+	PermEditIncidents:   RoleResponder,
+	PermWriteEvidence:   RoleResponder,
+	PermRunAnalyses:     RoleResponder,
+	PermPublish:         RoleCommander,
+	PermDeleteIncidents: RoleCommander,
+	PermManageWorkspace: RoleAdmin,
+}
+
+// Allows reports whether r holds permission p.
+func (r Role) Allows(p Permission) bool {
+	need, ok := permissionRoles[p]
+	if !ok {
+		return false
+	}
+
+	return slices.Index(Roles, r) >= slices.Index(Roles, need)
+}
+
+type roleKey struct{}
+
+// WithRole returns a context whose changes are limited to what role
+// allows. A context without a role, as used by the command line and
+// background jobs, may change anything.
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFrom returns the role set with WithRole and whether there is one.
+func RoleFrom(ctx context.Context) (Role, bool) {
+	role, ok := ctx.Value(roleKey{}).(Role)
+	return role, ok
+}
+
+// Can reports whether the role of ctx holds permission p.
+func Can(ctx context.Context, p Permission) bool {
+	role, ok := RoleFrom(ctx)
+	return !ok || role.Allows(p)
+}
+
+// authorize returns ErrForbidden unless the role of ctx holds every
+// permission in ps.
+func authorize(ctx context.Context, ps ...Permission) error {
+	for _, p := range ps {
+		if !Can(ctx, p) {
+			role, _ := RoleFrom(ctx)
+			return fmt.Errorf("%w: a %s cannot %s", ErrForbidden, role, p)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRole_Allows(t *testing.T) {
+	tests := []struct {
+		name string
+		role Role
+		perm Permission
+		want bool
+	}{
+		{"Allows: viewer reads", RoleViewer, PermRead, true},
+		{"Allows: viewer adds no evidence", RoleViewer, PermWriteEvidence, false},
+		{"Allows: responder adds evidence", RoleResponder, PermWriteEvidence, true},
+		{"Allows: responder runs analyses", RoleResponder, PermRunAnalyses, true},
+		{"Allows: responder does not publish", RoleResponder, PermPublish, false},
+		{"Allows: commander publishes", RoleCommander, PermPublish, true},
+		{"Allows: commander keeps out of settings", RoleCommander, PermManageWorkspace, false},
+		{"Allows: admin manages the workspace", RoleAdmin, PermManageWorkspace, true},
+		{"Allows: unknown role", Role("owner"), PermRead, false},
+		{"Allows: unknown permission", RoleAdmin, Permission("fly"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.role.Allows(tt.perm); got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteDB_Authorize(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	inc := testIncident(t, db, "Disk full")
+
+	t.Run("Authorize: no role may do anything", func(t *testing.T) {
+		if !Can(ctx, PermManageWorkspace) {
+			t.Error("Can: got false without a role")
+		}
+	})
+
+	t.Run("Authorize: viewer", func(t *testing.T) {
+		viewer := WithRole(ctx, RoleViewer)
+		err := db.CreateEvidence(viewer, &Evidence{IncidentID: inc.ID, Kind: "log", Content: "df -h"})
+		if !errors.Is(err, ErrForbidden) {
+			t.Errorf("create evidence: got %v want ErrForbidden", err)
+		}
+		if _, err := db.GetIncident(viewer, inc.ID); err != nil {
+			t.Errorf("get incident: got %v", err)
+		}
+	})
+
+	t.Run("Authorize: responder edits but does not publish", func(t *testing.T) {
+		responder := WithRole(ctx, RoleResponder)
+		cur, _ := db.GetIncident(ctx, inc.ID)
+		cur.Summary = "The log volume filled up"
+		if err := db.UpdateIncident(responder, cur); err != nil {
+			t.Fatalf("update summary: got %v", err)
+		}
+		cur.RCA = "Log rotation was off."
+		if err := db.UpdateIncident(responder, cur); !errors.Is(err, ErrForbidden) {
+			t.Errorf("publish: got %v want ErrForbidden", err)
+		}
+		if err := db.DeleteIncident(responder, inc.ID); !errors.Is(err, ErrForbidden) {
+			t.Errorf("delete: got %v want ErrForbidden", err)
+		}
+		if err := db.CreateIncident(responder, &Incident{Title: "x", RCA: "y"}); !errors.Is(err, ErrForbidden) {
+			t.Errorf("create with RCA: got %v want ErrForbidden", err)
+		}
+	})
+
+	t.Run("Authorize: commander publishes", func(t *testing.T) {
+		commander := WithRole(ctx, RoleCommander)
+		cur, _ := db.GetIncident(ctx, inc.ID)
+		cur.RCA = "Log rotation was off."
+		if err := db.UpdateIncident(commander, cur); err != nil {
+			t.Fatalf("publish: got %v", err)
+		}
+		if err := db.SaveSettings(commander, DefaultSettings()); !errors.Is(err, ErrForbidden) {
+			t.Errorf("settings: got %v want ErrForbidden", err)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var ErrNotFound = errors.New("not found")
+
+// Cursor marks the last row of a page in the order of its list: Key is the
+// value the list is sorted by and ID breaks ties. A list given a cursor
+// continues after that row, so pages stay consistent while rows are added.
+// Each type returns its cursor from a Cursor method.
+type Cursor struct {
+	Key any
+	ID  int64
+}
+
+// after limits w to the rows past c in a list sorted by col then id, both
+// ascending or both descending.
+func (w *where) after(c *Cursor, col string, desc bool) {
+	if c == nil {
+		return
+	}
+	op := ">"
+	if desc {
+		op = "<"
+	}
+	key := c.Key
+	if t, ok := key.(time.Time); ok {
+		key = t.UTC()
+	}
+
+	w.add("("+col+" "+op+" ? OR ("+col+" = ? AND id "+op+" ?))", key, key, c.ID)
+}
+
+// limit returns the LIMIT clause for n, none for 0.
+func limit(n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" LIMIT %d", n)
+}
+
+type preconditionKey struct{}
+
+// WithPrecondition returns a context whose updates and deletes first hand
+// the current row to check, inside their transaction, and give up with its
+// error when it returns one. The API checks If-Match headers with it, so
+// that no write can slip in between the check and the update.
+func WithPrecondition(ctx context.Context, check func(current any) error) context.Context {
+	return context.WithValue(ctx, preconditionKey{}, check)
+}
+
+// precondition runs the check of ctx, if any, on the current row.
+func precondition(ctx context.Context, current any) error {
+	check, ok := ctx.Value(preconditionKey{}).(func(any) error)
+	if !ok {
+		return nil
+	}
+
+	return check(current)
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+// where accumulates the optional filters of a List query.
+type where struct {
+	conds []string
+	args  []any
+}
+
+func (w *where) add(cond string, args ...any) {
+	w.conds = append(w.conds, cond)
+	w.args = append(w.args, args...)
+}
+
+func (w *where) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// affectedOne turns an UPDATE or DELETE that matched no row into
+// ErrNotFound.
+func affectedOne(res sql.Result, op string) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s error: %v", op, err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// notFound maps a missing row to ErrNotFound and wraps any other error.
+func notFound(err error, op string) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	return fmt.Errorf("%s error: %v", op, err)
+}
+
+// utc normalises times before they are written so that the text stored by
+// the driver sorts and compares chronologically.
+func utc(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Now().UTC()
+	}
+
+	return t.UTC()
+}
+
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil || t.IsZero() {
+		return sql.NullTime{}
+	}
+
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func nullInt64(v *int64) sql.NullInt64 {
+	if v == nil {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: *v, Valid: true}
+}
+
+// nullString stores the empty string as NULL.
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+
+	return &t.Time
+}
+
+func nonNil[T any](s []T) []T {
+	if s == nil {
+		return []T{}
+	}
+
+	return s
+}
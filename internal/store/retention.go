@@ -0,0 +1,419 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy archives evidence of Kind once it is older than MaxAge
+// days. Evidence of kinds without a policy, such as pattern summaries, is
+// kept forever. A policy with WorkspaceID 0 applies to every workspace that
+// has no policy of its own for the kind.
+type RetentionPolicy struct {
+	WorkspaceID int64
+	Kind        string
+	MaxAge      int
+	UpdatedAt   time.Time
+}
+
+// RetentionResult reports what ApplyRetention did.
+type RetentionResult struct {
+	Archived int
+	// Freed is how much the database file shrank, in bytes.
+	Freed int64
+}
+
+// StorageCategory is the space taken by one kind of data.
+type StorageCategory struct {
+	Name  string
+	Rows  int64
+	Bytes int64
+}
+
+// StorageUsage breaks the database file down by category. Category sizes
+// count the stored values only; File also holds indexes and page overhead.
+// Attachments live in the blob store, outside File.
+type StorageUsage struct {
+	File       int64
+	Free       int64
+	WAL        int64
+	Categories []StorageCategory
+}
+
+// ListRetentionPolicies returns every policy by workspace and kind. In a
+// workspace context it returns the policies in force there instead: the
+// workspace's own and the instance-wide ones for the other kinds.
+func (d *SQliteDB) ListRetentionPolicies(ctx context.Context) ([]RetentionPolicy, error) {
+	var w where
+	if ws, ok := WorkspaceFrom(ctx); ok {
+		w.add(`(workspace_id = ? OR workspace_id = 0 AND kind NOT IN (
+			SELECT kind FROM retention_policies WHERE workspace_id = ?))`, ws, ws)
+	}
+
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT workspace_id, kind, max_age_days, updated_at FROM retention_policies`+w.String()+`
+		ORDER BY workspace_id, kind`, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list retention policies error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []RetentionPolicy
+	for rows.Next() {
+		var p RetentionPolicy
+		if err := rows.Scan(&p.WorkspaceID, &p.Kind, &p.MaxAge, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("list retention policies error: %v", err)
+		}
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list retention policies error: %v", err)
+	}
+
+	return out, nil
+}
+
+// retentionWorkspace is the workspace whose policies ctx changes: its own,
+// or 0 for the instance-wide ones.
+func retentionWorkspace(ctx context.Context) int64 {
+	ws, _ := WorkspaceFrom(ctx)
+	return ws
+}
+
+func (d *SQliteDB) getRetentionPolicy(ctx context.Context, kind string) (*RetentionPolicy, error) {
+	var p RetentionPolicy
+	err := d.r.QueryRowContext(ctx, `
+		SELECT workspace_id, kind, max_age_days, updated_at FROM retention_policies
+		WHERE workspace_id = ? AND kind = ?`, retentionWorkspace(ctx), kind).
+		Scan(&p.WorkspaceID, &p.Kind, &p.MaxAge, &p.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err, "get retention policy")
+	}
+
+	return &p, nil
+}
+
+// SetRetentionPolicy creates or replaces the policy for p.Kind of the
+// workspace of ctx, or the instance-wide one without a workspace.
+func (d *SQliteDB) SetRetentionPolicy(ctx context.Context, p *RetentionPolicy) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	if p.Kind == "" || p.MaxAge <= 0 {
+		return fmt.Errorf("set retention policy error: kind and a positive max age are required")
+	}
+	p.WorkspaceID = retentionWorkspace(ctx)
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.getRetentionPolicy(ctx, p.Kind)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		err = tx.q.QueryRowContext(ctx, `
+			INSERT INTO retention_policies (workspace_id, kind, max_age_days) VALUES (?, ?, ?)
+			ON CONFLICT (workspace_id, kind) DO UPDATE
+			SET max_age_days = excluded.max_age_days, updated_at = CURRENT_TIMESTAMP
+			RETURNING updated_at`, p.WorkspaceID, p.Kind, p.MaxAge).Scan(&p.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("set retention policy error: %v", err)
+		}
+
+		action := AuditUpdate
+		if old == nil {
+			action = AuditCreate
+		}
+		return tx.audit(ctx, action, AuditRetentionPolicy, p.Kind, 0, old, p)
+	})
+}
+
+// DeleteRetentionPolicy removes the policy for kind of the workspace of
+// ctx, so that the instance-wide policy applies there again, or without a
+// workspace the instance-wide one, so that evidence of that kind is kept
+// forever where no workspace policy says otherwise.
+func (d *SQliteDB) DeleteRetentionPolicy(ctx context.Context, kind string) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.getRetentionPolicy(ctx, kind)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `
+			DELETE FROM retention_policies WHERE workspace_id = ? AND kind = ?`, old.WorkspaceID, kind); err != nil {
+			return fmt.Errorf("delete retention policy error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditDelete, AuditRetentionPolicy, kind, 0, old, nil)
+	})
+}
+
+// ApplyRetention archives the evidence that the policies say has expired
+// at now: its content is cut down to the lines cited by the RCA drafts of
+// its incident, its blob is released for CollectBlobs, and title, source
+// and timestamps stay. Each piece of
+// evidence is archived in a transaction of its own so writers are never
+// held up for long. If anything was archived the file is vacuumed to give
+// the space back. Each workspace follows the policies in force there; in a
+// workspace context only that workspace is handled.
+func (d *SQliteDB) ApplyRetention(ctx context.Context, now time.Time) (*RetentionResult, error) {
+	workspaces, err := d.ListWorkspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for _, ws := range workspaces {
+		policies, err := d.ListRetentionPolicies(WithWorkspace(ctx, ws.ID))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range policies {
+			cutoff := utc(now.AddDate(0, 0, -p.MaxAge))
+			expired, err := d.expiredEvidence(ctx, ws.ID, p.Kind, cutoff)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, expired...)
+		}
+	}
+
+	res := &RetentionResult{}
+	if len(ids) == 0 {
+		return res, nil
+	}
+
+	before, err := d.fileSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cited := map[int64][]string{}
+	archivedAt := utc(now)
+	for _, id := range ids {
+		err := d.inTx(ctx, func(tx *SQliteDB) error {
+			old, err := tx.GetEvidence(ctx, id)
+			if err != nil {
+				return err
+			}
+			lines, ok := cited[old.IncidentID]
+			if !ok {
+				if lines, err = tx.citedLines(ctx, old.IncidentID); err != nil {
+					return err
+				}
+				cited[old.IncidentID] = lines
+			}
+
+			cur := *old
+			cur.Content = keepCited(old.Content, lines)
+			cur.BlobSHA256, cur.BlobSize = "", 0
+			cur.ArchivedAt = &archivedAt
+			content, err := tx.seal(cur.Content)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.q.ExecContext(ctx, `
+				UPDATE evidence SET content = ?, blob_sha256 = NULL, blob_size = 0, archived_at = ? WHERE id = ?`,
+				content, archivedAt, id); err != nil {
+				return fmt.Errorf("archive evidence error: %v", err)
+			}
+
+			return tx.audit(ctx, AuditUpdate, AuditEvidence, id, old.IncidentID, old, &cur)
+		})
+		if err != nil {
+			return res, err
+		}
+		res.Archived++
+	}
+
+	if _, err := d.q.ExecContext(ctx, `VACUUM`); err != nil {
+		return res, fmt.Errorf("vacuum error: %v", err)
+	}
+	after, err := d.fileSize(ctx)
+	if err != nil {
+		return res, err
+	}
+	res.Freed = before - after
+
+	return res, nil
+}
+
+// RunRetention applies the retention policies now and then every interval
+// until ctx is done. Failures are logged and retried at the next tick.
+func (d *SQliteDB) RunRetention(ctx context.Context, every time.Duration) {
+	t := time.NewTicker(every)
+	defer t.Stop()
+
+	for {
+		res, err := d.ApplyRetention(ctx, time.Now())
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err != nil:
+			log.Printf("retention error: %v", err)
+		case res.Archived > 0:
+			log.Printf("retention archived %d evidence, freed %d bytes", res.Archived, res.Freed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (d *SQliteDB) expiredEvidence(ctx context.Context, workspaceID int64, kind string, cutoff time.Time) ([]int64, error) {
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT id FROM evidence
+		WHERE kind = ? AND collected_at < ? AND archived_at IS NULL
+			AND incident_id IN (SELECT id FROM incidents WHERE workspace_id = ?)
+		ORDER BY id`, kind, cutoff, workspaceID)
+	if err != nil {
+		return nil, fmt.Errorf("expired evidence error: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("expired evidence error: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("expired evidence error: %v", err)
+	}
+
+	return ids, nil
+}
+
+// citedLines returns the non-blank lines of the evidence every RCA draft
+// of the incident cites.
+func (d *SQliteDB) citedLines(ctx context.Context, incidentID int64) ([]string, error) {
+	analyses, err := d.ListAnalyses(ctx, incidentID)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, a := range analyses {
+		for _, cite := range a.Evidence {
+			for _, line := range strings.Split(cite, "\n") {
+				if line = strings.TrimSpace(line); line != "" {
+					out = append(out, line)
+				}
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// keepCited returns the lines of content that contain one of the cited
+// lines, in their original order.
+func keepCited(content string, cited []string) string {
+	var kept []string
+	for _, line := range strings.Split(content, "\n") {
+		for _, c := range cited {
+			if strings.Contains(line, c) {
+				kept = append(kept, line)
+				break
+			}
+		}
+	}
+
+	return strings.Join(kept, "\n")
+}
+
+// StorageUsage reports the size of the database file and of each kind of
+// data in it. Evidence is broken down by kind. The file is shared, so the
+// report covers the whole instance whatever the workspace of ctx.
+func (d *SQliteDB) StorageUsage(ctx context.Context) (*StorageUsage, error) {
+	u := &StorageUsage{}
+
+	var pageSize, pages, free int64
+	for _, p := range []struct {
+		pragma string
+		dest   *int64
+	}{{"page_size", &pageSize}, {"page_count", &pages}, {"freelist_count", &free}} {
+		if err := d.r.QueryRowContext(ctx, `PRAGMA `+p.pragma).Scan(p.dest); err != nil {
+			return nil, fmt.Errorf("storage usage error: %v", err)
+		}
+	}
+	u.File = pages * pageSize
+	u.Free = free * pageSize
+	if d.path != "" && d.path != ":memory:" {
+		if fi, err := os.Stat(d.path + "-wal"); err == nil {
+			u.WAL = fi.Size()
+		}
+	}
+
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT 'evidence: ' || kind, count(*),
+			coalesce(sum(length(CAST(content AS BLOB)) + length(CAST(title AS BLOB)) + length(CAST(source AS BLOB))), 0)
+		FROM evidence GROUP BY kind ORDER BY kind`)
+	if err != nil {
+		return nil, fmt.Errorf("storage usage error: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var c StorageCategory
+		if err := rows.Scan(&c.Name, &c.Rows, &c.Bytes); err != nil {
+			return nil, fmt.Errorf("storage usage error: %v", err)
+		}
+		u.Categories = append(u.Categories, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("storage usage error: %v", err)
+	}
+
+	index := `search_index_segments`
+	if d.fts == "fts5" {
+		index = `search_index_data`
+	}
+	for _, c := range []struct{ name, table, size string }{
+		{"incidents", "incidents", "length(CAST(title AS BLOB)) + length(CAST(summary AS BLOB)) + length(CAST(rca AS BLOB))"},
+		{"timeline", "timeline_events", "length(CAST(description AS BLOB)) + length(CAST(source AS BLOB))"},
+		{"rca drafts", "analyses", "length(CAST(draft AS BLOB)) + length(CAST(evidence AS BLOB)) + length(CAST(coalesce(critique, '') AS BLOB))"},
+		{"chat", "chat_messages", "length(CAST(content AS BLOB))"},
+		{"debates", "debate_turns", "length(CAST(content AS BLOB))"},
+		{"embeddings", "embeddings", "length(vector)"},
+		{"search index", index, "length(block)"},
+		{"audit log", "audit_log", "length(CAST(diff AS BLOB))"},
+		{"attachments (blob store)", "blobs", "size"},
+	} {
+		cat := StorageCategory{Name: c.name}
+		err := d.r.QueryRowContext(ctx, fmt.Sprintf(
+			`SELECT count(*), coalesce(sum(%s), 0) FROM %s`, c.size, c.table)).Scan(&cat.Rows, &cat.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("storage usage error: %v", err)
+		}
+		u.Categories = append(u.Categories, cat)
+	}
+
+	return u, nil
+}
+
+func (d *SQliteDB) fileSize(ctx context.Context) (int64, error) {
+	var pageSize, pages int64
+	if err := d.q.QueryRowContext(ctx, `PRAGMA page_size`).Scan(&pageSize); err != nil {
+		return 0, fmt.Errorf("file size error: %v", err)
+	}
+	if err := d.q.QueryRowContext(ctx, `PRAGMA page_count`).Scan(&pages); err != nil {
+		return 0, fmt.Errorf("file size error: %v", err)
+	}
+
+	return pageSize * pages, nil
+}
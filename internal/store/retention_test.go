@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_RetentionPolicies(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	t.Run("RetentionPolicies: default", func(t *testing.T) {
+		got, err := db.ListRetentionPolicies(ctx)
+		if err != nil {
+			t.Fatalf("failed to list policies: %v", err)
+		}
+		if len(got) != 1 || got[0].Kind != "log" || got[0].MaxAge != 90 {
+			t.Errorf("unexpected policies: %+v", got)
+		}
+	})
+
+	t.Run("RetentionPolicies: set and unset", func(t *testing.T) {
+		if err := db.SetRetentionPolicy(ctx, &RetentionPolicy{Kind: "log", MaxAge: 30}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetRetentionPolicy(ctx, &RetentionPolicy{Kind: "metric", MaxAge: 7}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteRetentionPolicy(ctx, "metric"); err != nil {
+			t.Fatal(err)
+		}
+
+		got, _ := db.ListRetentionPolicies(ctx)
+		if len(got) != 1 || got[0].MaxAge != 30 {
+			t.Errorf("unexpected policies: %+v", got)
+		}
+		entries, _ := db.ListAudit(ctx, AuditFilter{Entity: AuditRetentionPolicy})
+		if len(entries) != 3 {
+			t.Errorf("audit entries: got %d want 3", len(entries))
+		}
+	})
+
+	t.Run("RetentionPolicies: errors", func(t *testing.T) {
+		if err := db.SetRetentionPolicy(ctx, &RetentionPolicy{Kind: "log"}); err == nil {
+			t.Error("expected error for a zero max age")
+		}
+		if err := db.DeleteRetentionPolicy(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestSQLiteDB_ApplyRetention(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	now := time.Date(2025, 9, 1, 0, 0, 0, 0, time.UTC)
+
+	inc := testIncident(t, db, "Redis timeouts")
+	raw := strings.Repeat("INFO GET /health 200\n", 2000) + "ERROR redis: i/o timeout\n" + strings.Repeat("INFO GET /health 200\n", 2000)
+	old := &Evidence{IncidentID: inc.ID, Kind: "log", Title: "app log", Content: raw, CollectedAt: now.AddDate(0, 0, -91),
+		BlobSHA256: strings.Repeat("a", 64), BlobSize: 1 << 20}
+	fresh := &Evidence{IncidentID: inc.ID, Kind: "log", Title: "recent log", Content: raw, CollectedAt: now.AddDate(0, 0, -10)}
+	summary := &Evidence{IncidentID: inc.ID, Kind: "pattern", Title: "summary", Content: "timeouts every 5m", CollectedAt: now.AddDate(-1, 0, 0)}
+	for _, ev := range []*Evidence{old, fresh, summary} {
+		if err := db.CreateEvidence(ctx, ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.CreateAnalysis(ctx, &Analysis{IncidentID: inc.ID, Model: "m", Draft: "d", Evidence: []string{"redis: i/o timeout"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := db.ApplyRetention(ctx, now)
+	if err != nil {
+		t.Fatalf("failed to apply retention: %v", err)
+	}
+
+	t.Run("ApplyRetention: expired evidence keeps cited lines", func(t *testing.T) {
+		if res.Archived != 1 || res.Freed <= 0 {
+			t.Errorf("unexpected result: %+v", res)
+		}
+		got, err := db.GetEvidence(ctx, old.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Content != "ERROR redis: i/o timeout" || got.ArchivedAt == nil || !got.ArchivedAt.Equal(now) {
+			t.Errorf("archived evidence: content %q archived %v", got.Content, got.ArchivedAt)
+		}
+		if got.Title != old.Title {
+			t.Errorf("title: got %q want %q", got.Title, old.Title)
+		}
+		if got.BlobSHA256 != "" {
+			t.Errorf("blob should be released: %q", got.BlobSHA256)
+		}
+	})
+
+	t.Run("ApplyRetention: other evidence is kept", func(t *testing.T) {
+		for _, ev := range []*Evidence{fresh, summary} {
+			got, err := db.GetEvidence(ctx, ev.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Content != ev.Content || got.ArchivedAt != nil {
+				t.Errorf("evidence %q should be kept", ev.Title)
+			}
+		}
+	})
+
+	t.Run("ApplyRetention: audit log does not keep the content", func(t *testing.T) {
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM audit_log WHERE diff LIKE '%GET /health%'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("audit entries with raw content: %d", n)
+		}
+	})
+
+	t.Run("ApplyRetention: nothing left to do", func(t *testing.T) {
+		res, err := db.ApplyRetention(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Archived != 0 {
+			t.Errorf("archived again: %+v", res)
+		}
+	})
+}
+
+func TestSQLiteDB_StorageUsage(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "Redis timeouts")
+	if err := db.CreateEvidence(ctx, &Evidence{IncidentID: inc.ID, Kind: "metric", Title: "p99", Content: "1234"}); err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := db.StorageUsage(ctx)
+	if err != nil {
+		t.Fatalf("failed to get storage usage: %v", err)
+	}
+	if u.File <= 0 {
+		t.Errorf("file size: %d", u.File)
+	}
+
+	got := map[string]StorageCategory{}
+	for _, c := range u.Categories {
+		got[c.Name] = c
+	}
+	if c := got["evidence: metric"]; c.Rows != 1 || c.Bytes != int64(len("p99")+len("1234")) {
+		t.Errorf("evidence: %+v", c)
+	}
+	for _, name := range []string{"incidents", "timeline", "rca drafts", "chat", "embeddings", "search index", "audit log"} {
+		if _, ok := got[name]; !ok {
+			t.Errorf("missing category %q", name)
+		}
+	}
+	if got["incidents"].Rows != 1 || got["audit log"].Bytes == 0 {
+		t.Errorf("unexpected usage: %+v", u.Categories)
+	}
+}
+
+func TestKeepCited(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		cited   []string
+		want    string
+	}{
+		{"keepCited: none cited", "a\nb", nil, ""},
+		{"keepCited: fragment", "INFO ok\nERROR boom at 10:00\nINFO ok", []string{"ERROR boom"}, "ERROR boom at 10:00"},
+		{"keepCited: order kept", "one\ntwo\nthree", []string{"three", "one"}, "one\nthree"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := keepCited(tt.content, tt.cited); got != tt.want {
+				t.Errorf("got %q want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,405 @@
+package store
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SearchKind is the kind of row a search hit points at.
+type SearchKind string
+
+const (
+	SearchIncident SearchKind = "incident"
+	SearchAnalysis SearchKind = "analysis"
+	SearchEvidence SearchKind = "evidence"
+	SearchTimeline SearchKind = "timeline"
+)
+
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// searchSource describes how the rows of one table are indexed. A row is
+// stored under rowid id*searchKinds+code so triggers can find it again
+// without scanning the index. In the SQL expressions {r} stands for the
+// row being indexed.
+type searchSource struct {
+	table    string
+	kind     SearchKind
+	code     int
+	incident string
+	title    string
+	body     string
+}
+
+const searchKinds = 8
+
+var searchSources = []searchSource{
+	{"incidents", SearchIncident, 1, "{r}.id", "{r}.title", "{r}.summary || char(10) || {r}.rca"},
+	{"analyses", SearchAnalysis, 2, "{r}.incident_id", "''", "{r}.draft"},
+	{"evidence", SearchEvidence, 3, "{r}.incident_id", "{r}.title", unlessSealed("{r}.content")},
+	{"timeline_events", SearchTimeline, 4, "{r}.incident_id", "''", "{r}.description"},
+}
+
+// unlessSealed is the SQL for column, or ” when the value is encrypted, so
+// that neither the search index nor the embeddings ever see plaintext of
+// encrypted fields. Only sealed values start with a control character.
+func unlessSealed(column string) string {
+	return fmt.Sprintf("CASE WHEN substr(%s, 1, 1) = char(1) THEN '' ELSE %s END", column, column)
+}
+
+// Highlight markers handed to snippet(). Control characters never show up
+// in indexed text, so they can be split on safely.
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+// SearchFilter narrows Search. Query is free text; every word has to match.
+// Zero values of the other fields do not filter.
+type SearchFilter struct {
+	Query      string
+	Kinds      []SearchKind
+	IncidentID int64
+	// Since and Until bound the incident's StartedAt, inclusive.
+	Since time.Time
+	Until time.Time
+	Limit int
+}
+
+// SnippetPart is a run of snippet text; Match marks the matched terms.
+type SnippetPart struct {
+	Text  string
+	Match bool
+}
+
+// SearchResult is one hit, best first. RefID is the id of the row in the
+// table Kind names.
+type SearchResult struct {
+	Kind          SearchKind
+	RefID         int64
+	IncidentID    int64
+	IncidentTitle string
+	StartedAt     time.Time
+	Snippet       []SnippetPart
+	Score         float64
+}
+
+// searchModule reports the full-text module to build the index with.
+// go-sqlite3 only compiles FTS5 in with the sqlite_fts5 build tag, so FTS4
+// is the fallback.
+func (d *SQliteDB) searchModule(ctx context.Context) (string, error) {
+	var fts5 bool
+	err := d.q.QueryRowContext(ctx, `SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&fts5)
+	if err != nil {
+		return "", fmt.Errorf("search module error: %v", err)
+	}
+	if fts5 {
+		return "fts5", nil
+	}
+
+	return "fts4", nil
+}
+
+// syncSearchIndex creates the search index and its triggers when the
+// tables it covers exist and drops it when they do not. The index is
+// derived data: if any piece of it is missing or differs from what this
+// build would create, such as an index made with the other full-text
+// module, it is rebuilt from scratch.
+func (d *SQliteDB) syncSearchIndex(ctx context.Context) error {
+	var tables int
+	err := d.q.QueryRowContext(ctx, `
+		SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name IN ('incidents', 'timeline_events')`).
+		Scan(&tables)
+	if err != nil {
+		return fmt.Errorf("sync search index error: %v", err)
+	}
+
+	have, err := d.searchIndexObjects(ctx)
+	if err != nil {
+		return err
+	}
+	objects, _ := d.searchIndexSchema()
+	current := tables == 2 && len(have) == len(objects)
+	for _, o := range objects {
+		current = current && have[o.name] == o.sql
+	}
+	if current {
+		return nil
+	}
+
+	return d.WithTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.dropSearchIndex(ctx); err != nil {
+			return err
+		}
+		if tables != 2 {
+			return nil
+		}
+		return tx.createSearchIndex(ctx)
+	})
+}
+
+// searchIndexObjects returns the definitions of the search index and its
+// triggers in the database by name.
+func (d *SQliteDB) searchIndexObjects(ctx context.Context) (map[string]string, error) {
+	rows, err := d.q.QueryContext(ctx, `
+		SELECT name, sql FROM sqlite_master
+		WHERE name = 'search_index' OR name LIKE 'search\_%\_a_' ESCAPE '\'`)
+	if err != nil {
+		return nil, fmt.Errorf("sync search index error: %v", err)
+	}
+	defer rows.Close()
+
+	out := map[string]string{}
+	for rows.Next() {
+		var name, sql string
+		if err := rows.Scan(&name, &sql); err != nil {
+			return nil, fmt.Errorf("sync search index error: %v", err)
+		}
+		out[name] = sql
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sync search index error: %v", err)
+	}
+
+	return out, nil
+}
+
+func (d *SQliteDB) dropSearchIndex(ctx context.Context) error {
+	stmts := []string{`DROP TABLE IF EXISTS search_index`}
+	for _, s := range searchSources {
+		for _, ev := range []string{"ai", "au", "ad"} {
+			stmts = append(stmts, fmt.Sprintf(`DROP TRIGGER IF EXISTS search_%s_%s`, s.table, ev))
+		}
+	}
+
+	for _, stmt := range stmts {
+		if _, err := d.q.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("drop search index error: %v", err)
+		}
+	}
+
+	return nil
+}
+
+type schemaObject struct {
+	name string
+	sql  string
+}
+
+// searchIndexSchema returns the search index and its triggers, and the
+// statements that fill the index from the existing rows.
+func (d *SQliteDB) searchIndexSchema() ([]schemaObject, []string) {
+	index := `CREATE VIRTUAL TABLE search_index USING fts4(
+		incident_id, title, body, notindexed=incident_id, tokenize=porter)`
+	if d.fts == "fts5" {
+		index = `CREATE VIRTUAL TABLE search_index USING fts5(
+			incident_id UNINDEXED, title, body, tokenize='porter unicode61')`
+	}
+	objects := []schemaObject{{"search_index", index}}
+
+	var fill []string
+	for _, s := range searchSources {
+		insert := func(r string) string {
+			return strings.ReplaceAll(fmt.Sprintf(
+				`INSERT INTO search_index (rowid, incident_id, title, body) SELECT {r}.id * %d + %d, %s, %s, %s`,
+				searchKinds, s.code, s.incident, s.title, s.body), "{r}", r)
+		}
+		del := fmt.Sprintf(`DELETE FROM search_index WHERE rowid = old.id * %d + %d`, searchKinds, s.code)
+
+		objects = append(objects,
+			schemaObject{"search_" + s.table + "_ai", fmt.Sprintf(
+				`CREATE TRIGGER search_%s_ai AFTER INSERT ON %s BEGIN %s; END`, s.table, s.table, insert("new"))},
+			schemaObject{"search_" + s.table + "_au", fmt.Sprintf(
+				`CREATE TRIGGER search_%s_au AFTER UPDATE ON %s BEGIN %s; %s; END`, s.table, s.table, del, insert("new"))},
+			schemaObject{"search_" + s.table + "_ad", fmt.Sprintf(
+				`CREATE TRIGGER search_%s_ad AFTER DELETE ON %s BEGIN %s; END`, s.table, s.table, del)},
+		)
+		fill = append(fill, insert(s.table)+` FROM `+s.table)
+	}
+
+	return objects, fill
+}
+
+func (d *SQliteDB) createSearchIndex(ctx context.Context) error {
+	objects, fill := d.searchIndexSchema()
+
+	var stmts []string
+	for _, o := range objects {
+		stmts = append(stmts, o.sql)
+	}
+	for _, stmt := range append(stmts, fill...) {
+		if _, err := d.q.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("create search index error: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Search runs f.Query against incidents, RCA drafts, evidence and timeline
+// events and returns the best hits with highlighted snippets.
+func (d *SQliteDB) Search(ctx context.Context, f SearchFilter) ([]SearchResult, error) {
+	match := searchQuery(f.Query)
+	if match == "" {
+		return nil, nil
+	}
+	if f.Limit <= 0 {
+		f.Limit = DefaultSearchLimit
+	}
+	f.Limit = min(f.Limit, MaxSearchLimit)
+
+	var w where
+	w.add("search_index MATCH ?", match)
+	if len(f.Kinds) > 0 {
+		codes := make([]string, 0, len(f.Kinds))
+		for _, k := range f.Kinds {
+			for _, s := range searchSources {
+				if s.kind == k {
+					codes = append(codes, fmt.Sprint(s.code))
+				}
+			}
+		}
+		w.add(fmt.Sprintf("search_index.rowid %% %d IN (%s)", searchKinds, strings.Join(codes, ", ")))
+	}
+	if f.IncidentID != 0 {
+		w.add("search_index.incident_id = ?", f.IncidentID)
+	}
+	if !f.Since.IsZero() {
+		w.add("i.started_at >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		w.add("i.started_at <= ?", f.Until.UTC())
+	}
+	if ws, ok := WorkspaceFrom(ctx); ok {
+		w.add("i.workspace_id = ?", ws)
+	}
+
+	// Title hits weigh ten times as much as body hits.
+	snippet, rank, tail := `snippet(search_index, char(2), char(3), '…', -1, 16)`,
+		`matchinfo(search_index, 'pcx')`, ``
+	if d.fts == "fts5" {
+		snippet = `snippet(search_index, -1, char(2), char(3), '…', 16)`
+		rank = `bm25(search_index, 0.0, 10.0, 1.0)`
+		tail = fmt.Sprintf(` ORDER BY 6 LIMIT %d`, f.Limit)
+	}
+
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT search_index.rowid, i.id, i.title, i.started_at, `+snippet+`, `+rank+`
+		FROM search_index JOIN incidents i ON i.id = search_index.incident_id`+w.String()+tail, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("search error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []SearchResult
+	for rows.Next() {
+		var (
+			res     SearchResult
+			rowid   int64
+			snippet string
+			rank    any
+		)
+		if err := rows.Scan(&rowid, &res.IncidentID, &res.IncidentTitle, &res.StartedAt, &snippet, &rank); err != nil {
+			return nil, fmt.Errorf("search error: %v", err)
+		}
+		res.RefID = rowid / searchKinds
+		for _, s := range searchSources {
+			if int64(s.code) == rowid%searchKinds {
+				res.Kind = s.kind
+			}
+		}
+		res.Snippet = splitSnippet(snippet)
+		switch v := rank.(type) {
+		case float64:
+			res.Score = -v
+		case []byte:
+			res.Score = matchScore(v, []float64{0, 10, 1})
+		}
+		out = append(out, res)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("search error: %v", err)
+	}
+
+	// FTS4 has no ranking function, so its hits are ordered here.
+	if d.fts != "fts5" {
+		sort.SliceStable(out, func(i, j int) bool { return out[i].Score > out[j].Score })
+		if len(out) > f.Limit {
+			out = out[:f.Limit]
+		}
+	}
+
+	return out, nil
+}
+
+// searchQuery turns free text into a MATCH expression that requires every
+// word. Words are quoted so that user input can never be read as query
+// syntax.
+func searchQuery(q string) string {
+	words := strings.FieldsFunc(q, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, word := range words {
+		words[i] = `"` + word + `"`
+	}
+
+	return strings.Join(words, " ")
+}
+
+func splitSnippet(s string) []SnippetPart {
+	var parts []SnippetPart
+	for s != "" {
+		start := strings.Index(s, markStart)
+		if start < 0 {
+			parts = append(parts, SnippetPart{Text: s})
+			break
+		}
+		if start > 0 {
+			parts = append(parts, SnippetPart{Text: s[:start]})
+		}
+		s = s[start+len(markStart):]
+
+		end := strings.Index(s, markEnd)
+		if end < 0 {
+			end = len(s)
+		}
+		parts = append(parts, SnippetPart{Text: s[:end], Match: true})
+		s = strings.TrimPrefix(s[end:], markEnd)
+	}
+
+	return parts
+}
+
+// matchScore ranks an FTS4 hit from its matchinfo('pcx') blob: for every
+// phrase and column, the share of all hits of the phrase in that column
+// that fall in this row, weighted per column.
+func matchScore(info []byte, weights []float64) float64 {
+	ints := make([]uint32, len(info)/4)
+	for i := range ints {
+		ints[i] = binary.NativeEndian.Uint32(info[i*4:])
+	}
+	if len(ints) < 2 {
+		return 0
+	}
+
+	phrases, cols := int(ints[0]), int(ints[1])
+	var score float64
+	for p := range phrases {
+		for c := range min(cols, len(weights)) {
+			i := 2 + 3*(p*cols+c)
+			if i+1 >= len(ints) || ints[i+1] == 0 {
+				continue
+			}
+			score += weights[c] * float64(ints[i]) / float64(ints[i+1])
+		}
+	}
+
+	return score
+}
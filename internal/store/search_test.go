@@ -0,0 +1,242 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_Search(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	spring := time.Date(2025, 4, 10, 9, 0, 0, 0, time.UTC)
+	redis := &Incident{Title: "Redis timeouts on checkout", Summary: "cache cluster failover", StartedAt: spring}
+	if err := db.CreateIncident(ctx, redis); err != nil {
+		t.Fatal(err)
+	}
+	disk := &Incident{Title: "Disk full on db-2", RCA: "log rotation stopped, redis was unrelated", StartedAt: spring.AddDate(0, 5, 0)}
+	if err := db.CreateIncident(ctx, disk); err != nil {
+		t.Fatal(err)
+	}
+	ev := &Evidence{IncidentID: redis.ID, Kind: "log", Title: "app log",
+		Content: "ERR dial tcp 10.0.0.7:6379: i/o timeout", CollectedAt: spring}
+	if err := db.CreateEvidence(ctx, ev); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Search: ranked hits", func(t *testing.T) {
+		got, err := db.Search(ctx, SearchFilter{Query: "redis"})
+		if err != nil {
+			t.Fatalf("failed to search: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("hits: got %d want 2: %+v", len(got), got)
+		}
+		if got[0].IncidentID != redis.ID || got[0].Kind != SearchIncident || got[0].RefID != redis.ID {
+			t.Errorf("title hit should rank first: %+v", got[0])
+		}
+		if got[0].Score < got[1].Score {
+			t.Errorf("scores not descending: %v < %v", got[0].Score, got[1].Score)
+		}
+		if got[0].IncidentTitle != redis.Title || !got[0].StartedAt.Equal(spring) {
+			t.Errorf("incident details: %+v", got[0])
+		}
+	})
+
+	t.Run("Search: stemmed evidence hit with highlight", func(t *testing.T) {
+		got, err := db.Search(ctx, SearchFilter{Query: "timeout", Kinds: []SearchKind{SearchEvidence}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].RefID != ev.ID || got[0].IncidentID != redis.ID {
+			t.Fatalf("unexpected hits: %+v", got)
+		}
+
+		var marked []string
+		for _, p := range got[0].Snippet {
+			if p.Match {
+				marked = append(marked, p.Text)
+			}
+		}
+		if !reflect.DeepEqual(marked, []string{"timeout"}) {
+			t.Errorf("highlights: got %v in %+v", marked, got[0].Snippet)
+		}
+	})
+
+	t.Run("Search: every word must match", func(t *testing.T) {
+		got, err := db.Search(ctx, SearchFilter{Query: "redis rotation"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].IncidentID != disk.ID {
+			t.Errorf("unexpected hits: %+v", got)
+		}
+	})
+
+	t.Run("Search: time window", func(t *testing.T) {
+		got, err := db.Search(ctx, SearchFilter{Query: "redis", Since: spring.AddDate(0, -1, 0), Until: spring.AddDate(0, 2, 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].IncidentID != redis.ID {
+			t.Errorf("unexpected hits: %+v", got)
+		}
+	})
+
+	t.Run("Search: triggers follow updates and deletes", func(t *testing.T) {
+		redis.Title = "Memcached timeouts on checkout"
+		if err := db.UpdateIncident(ctx, redis); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.Search(ctx, SearchFilter{Query: "memcached"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Errorf("hits after update: got %d want 1", len(got))
+		}
+
+		if err := db.DeleteEvidence(ctx, ev.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, err = db.Search(ctx, SearchFilter{Query: "6379"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 0 {
+			t.Errorf("hits after delete: %+v", got)
+		}
+	})
+
+	t.Run("Search: query syntax is not interpreted", func(t *testing.T) {
+		for _, q := range []string{`redis" OR "disk`, `title:*`, `NEAR(`, `-`, ``} {
+			if _, err := db.Search(ctx, SearchFilter{Query: q}); err != nil {
+				t.Errorf("query %q: %v", q, err)
+			}
+		}
+	})
+
+	t.Run("syncSearchIndex: rebuilds a damaged index", func(t *testing.T) {
+		if _, err := db.Exec(`DROP TRIGGER search_incidents_ai`); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		testIncident(t, db, "kafka lag")
+
+		got, err := db.Search(ctx, SearchFilter{Query: "kafka"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Errorf("hits after rebuild: got %d want 1", len(got))
+		}
+	})
+
+	t.Run("syncSearchIndex: keeps a current index", func(t *testing.T) {
+		if _, err := db.Exec(`INSERT INTO search_index (rowid, incident_id, title, body) VALUES (7, 0, 'marker', '')`); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM search_index WHERE rowid = 7`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Error("a current index should not be rebuilt")
+		}
+	})
+
+	t.Run("syncSearchIndex: rebuilds outdated definitions", func(t *testing.T) {
+		_, err := db.Exec(`DROP TRIGGER search_evidence_ai;
+			CREATE TRIGGER search_evidence_ai AFTER INSERT ON evidence BEGIN SELECT 1; END`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		var sql string
+		if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'search_evidence_ai'`).Scan(&sql); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(sql, "INSERT INTO search_index") {
+			t.Errorf("trigger not rebuilt: %s", sql)
+		}
+	})
+
+	t.Run("syncSearchIndex: rebuilds an index of another module", func(t *testing.T) {
+		// An older module stands in for the one of a build with other tags.
+		other := "fts3"
+		if db.fts == "fts5" {
+			other = "fts4"
+		}
+		_, err := db.Exec(`DROP TABLE search_index;
+			CREATE VIRTUAL TABLE search_index USING ` + other + `(incident_id, title, body)`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Migrate(); err != nil {
+			t.Fatal(err)
+		}
+		var sql string
+		if err := db.QueryRow(`SELECT sql FROM sqlite_master WHERE name = 'search_index'`).Scan(&sql); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(sql, "USING "+db.fts+"(") {
+			t.Errorf("index not rebuilt: %s", sql)
+		}
+		if _, err := db.Search(ctx, SearchFilter{Query: "redis"}); err != nil {
+			t.Errorf("search after rebuild: %v", err)
+		}
+	})
+
+	t.Run("syncSearchIndex: dropped with the schema", func(t *testing.T) {
+		if err := db.Down(); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name LIKE 'search%'`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("search objects left: %d", n)
+		}
+	})
+}
+
+func TestSearchQuery(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"redis timeout", `"redis" "timeout"`},
+		{`  "redis" OR title:disk* `, `"redis" "OR" "title" "disk"`},
+		{"10.0.0.7:6379", `"10" "0" "0" "7" "6379"`},
+		{"-- ()", ""},
+	}
+	for _, tt := range tests {
+		if got := searchQuery(tt.in); got != tt.want {
+			t.Errorf("searchQuery(%q): got %s want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSplitSnippet(t *testing.T) {
+	got := splitSnippet("…dial " + markStart + "redis" + markEnd + " at " + markStart + "6379" + markEnd)
+	want := []SnippetPart{
+		{Text: "…dial "},
+		{Text: "redis", Match: true},
+		{Text: " at "},
+		{Text: "6379", Match: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v want %+v", got, want)
+	}
+}
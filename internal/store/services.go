@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type Service struct {
+	ID          int64
+	Name        string
+	Description string
+	Owner       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+const serviceColumns = `id, name, description, owner, created_at, updated_at`
+
+func (d *SQliteDB) CreateService(ctx context.Context, svc *Service) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO services (name, description, owner) VALUES (?, ?, ?)
+			RETURNING id, created_at, updated_at`,
+			svc.Name, svc.Description, svc.Owner)
+		if err := row.Scan(&svc.ID, &svc.CreatedAt, &svc.UpdatedAt); err != nil {
+			return fmt.Errorf("create service error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditService, svc.ID, 0, nil, svc)
+	})
+}
+
+func (d *SQliteDB) GetService(ctx context.Context, id int64) (*Service, error) {
+	row := d.r.QueryRowContext(ctx, `SELECT `+serviceColumns+` FROM services WHERE id = ?`, id)
+	svc, err := scanService(row)
+	if err != nil {
+		return nil, notFound(err, "get service")
+	}
+
+	return svc, nil
+}
+
+func (d *SQliteDB) GetServiceByName(ctx context.Context, name string) (*Service, error) {
+	row := d.r.QueryRowContext(ctx, `SELECT `+serviceColumns+` FROM services WHERE name = ?`, name)
+	svc, err := scanService(row)
+	if err != nil {
+		return nil, notFound(err, "get service")
+	}
+
+	return svc, nil
+}
+
+// ListServices returns all services, or only those affected by an incident
+// when incidentID is not zero. Services are shared by every workspace.
+func (d *SQliteDB) ListServices(ctx context.Context, incidentID int64) ([]Service, error) {
+	var w where
+	if incidentID != 0 {
+		w.add("id IN (SELECT service_id FROM incident_services WHERE incident_id = ?)", incidentID)
+		scopeIncidents(ctx, &w, formatID(incidentID))
+	}
+
+	rows, err := d.r.QueryContext(ctx, `SELECT `+serviceColumns+` FROM services`+w.String()+` ORDER BY name`, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list services error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []Service
+	for rows.Next() {
+		svc, err := scanService(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list services error: %v", err)
+		}
+		out = append(out, *svc)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list services error: %v", err)
+	}
+
+	return out, nil
+}
+
+func (d *SQliteDB) UpdateService(ctx context.Context, svc *Service) error {
+	if err := authorize(ctx, PermEditIncidents); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetService(ctx, svc.ID)
+		if err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			UPDATE services SET name = ?, description = ?, owner = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+			RETURNING updated_at`,
+			svc.Name, svc.Description, svc.Owner, svc.ID)
+		if err := row.Scan(&svc.UpdatedAt); err != nil {
+			return notFound(err, "update service")
+		}
+
+		cur, err := tx.GetService(ctx, svc.ID)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditService, svc.ID, 0, old, cur)
+	})
+}
+
+func scanService(s scanner) (*Service, error) {
+	var svc Service
+	if err := s.Scan(&svc.ID, &svc.Name, &svc.Description, &svc.Owner, &svc.CreatedAt, &svc.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return &svc, nil
+}
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestSQLiteDB_Services(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	api := &Service{Name: "api", Owner: "team-edge"}
+	payments := &Service{Name: "payments", Owner: "team-money"}
+
+	t.Run("CreateService: success", func(t *testing.T) {
+		for _, svc := range []*Service{payments, api} {
+			if err := db.CreateService(ctx, svc); err != nil {
+				t.Fatalf("failed to create service: %v", err)
+			}
+		}
+		if api.ID == 0 {
+			t.Fatal("service id not set")
+		}
+	})
+
+	t.Run("CreateService: duplicate name", func(t *testing.T) {
+		if err := db.CreateService(ctx, &Service{Name: "api"}); err == nil {
+			t.Fatal("expected unique constraint error")
+		}
+	})
+
+	t.Run("GetServiceByName: success", func(t *testing.T) {
+		got, err := db.GetServiceByName(ctx, "payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != payments.ID || got.Owner != "team-money" {
+			t.Errorf("service: got %+v want %+v", got, payments)
+		}
+	})
+
+	t.Run("ListServices: all and by incident", func(t *testing.T) {
+		all, err := db.ListServices(ctx, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 2 || all[0].Name != "api" {
+			t.Errorf("services: got %+v", all)
+		}
+
+		inc := testIncident(t, db, "checkout down")
+		if err := db.AttachService(ctx, inc.ID, payments.ID); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.ListServices(ctx, inc.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != payments.ID {
+			t.Errorf("incident services: got %+v", got)
+		}
+
+		if err := db.DetachService(ctx, inc.ID, payments.ID); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := db.ListServices(ctx, inc.ID); len(got) != 0 {
+			t.Errorf("service still attached: %+v", got)
+		}
+	})
+
+	t.Run("UpdateService: success", func(t *testing.T) {
+		api.Description = "public API gateway"
+		if err := db.UpdateService(ctx, api); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetService(ctx, api.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Description != api.Description {
+			t.Errorf("description: got %q want %q", got.Description, api.Description)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if _, err := db.GetService(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetService: got %v want %v", err, ErrNotFound)
+		}
+		if _, err := db.GetServiceByName(ctx, "nope"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetServiceByName: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.UpdateService(ctx, &Service{ID: 9999, Name: "x"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateService: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
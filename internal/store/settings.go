@@ -0,0 +1,177 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Settings are the instance settings that can change while the server
+// runs. Only values that differ from DefaultSettings are stored.
+type Settings struct {
+	// ProviderURL is the base URL of the Ollama server.
+	ProviderURL string
+	// ChatModel answers chats, drafts and debates unless the workspace
+	// names its own.
+	ChatModel string
+	// EmbeddingModel embeds evidence and RCAs for semantic search.
+	EmbeddingModel string
+	// Temperature applies to model calls that set none of their own; 0
+	// leaves it to the model.
+	Temperature float64
+}
+
+// Setting keys, as stored and as used on the command line.
+const (
+	SettingProviderURL    = "provider_url"
+	SettingChatModel      = "chat_model"
+	SettingEmbeddingModel = "embedding_model"
+	SettingTemperature    = "temperature"
+)
+
+// SettingKeys lists every setting in display order.
+var SettingKeys = []string{SettingProviderURL, SettingChatModel, SettingEmbeddingModel, SettingTemperature}
+
+// MaxTemperature is the highest temperature accepted.
+const MaxTemperature = 2
+
+// DefaultSettings returns the settings of a new instance, which match the
+// defaults of the model provider.
+func DefaultSettings() *Settings {
+	return &Settings{
+		ProviderURL:    "http://localhost:11434",
+		ChatModel:      "deepseek-r1:8b",
+		EmbeddingModel: "nomic-embed-text",
+	}
+}
+
+// Get returns the value of key as text.
+func (s *Settings) Get(key string) (string, error) {
+	switch key {
+	case SettingProviderURL:
+		return s.ProviderURL, nil
+	case SettingChatModel:
+		return s.ChatModel, nil
+	case SettingEmbeddingModel:
+		return s.EmbeddingModel, nil
+	case SettingTemperature:
+		return strconv.FormatFloat(s.Temperature, 'f', -1, 64), nil
+	default:
+		return "", fmt.Errorf("unknown setting %q", key)
+	}
+}
+
+// Set parses value into the field of key. It does not validate the result;
+// see Validate.
+func (s *Settings) Set(key, value string) error {
+	value = strings.TrimSpace(value)
+	switch key {
+	case SettingProviderURL:
+		s.ProviderURL = strings.TrimRight(value, "/")
+	case SettingChatModel:
+		s.ChatModel = value
+	case SettingEmbeddingModel:
+		s.EmbeddingModel = value
+	case SettingTemperature:
+		t, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("temperature must be a number, got %q", value)
+		}
+		s.Temperature = t
+	default:
+		return fmt.Errorf("unknown setting %q", key)
+	}
+
+	return nil
+}
+
+// Validate reports the first setting that is out of range.
+func (s *Settings) Validate() error {
+	u, err := url.ParseRequestURI(s.ProviderURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("provider URL must be an http or https URL, got %q", s.ProviderURL)
+	}
+	for _, m := range []struct{ name, model string }{
+		{"chat model", s.ChatModel},
+		{"embedding model", s.EmbeddingModel},
+	} {
+		if m.model == "" || strings.ContainsAny(m.model, " \t\n") {
+			return fmt.Errorf("%s must be a model name without spaces, got %q", m.name, m.model)
+		}
+	}
+	if s.Temperature < 0 || s.Temperature > MaxTemperature {
+		return fmt.Errorf("temperature must be between 0 and %d, got %g", MaxTemperature, s.Temperature)
+	}
+
+	return nil
+}
+
+// GetSettings returns the stored settings over the defaults. Stored keys
+// this version does not know are ignored.
+func (d *SQliteDB) GetSettings(ctx context.Context) (*Settings, error) {
+	rows, err := d.r.QueryContext(ctx, `SELECT key, value FROM settings`)
+	if err != nil {
+		return nil, fmt.Errorf("get settings error: %v", err)
+	}
+	defer rows.Close()
+
+	s := DefaultSettings()
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("get settings scan error: %v", err)
+		}
+		if _, err := s.Get(key); err != nil {
+			continue
+		}
+		if err := s.Set(key, value); err != nil {
+			return nil, fmt.Errorf("get settings error: %v", err)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get settings error: %v", err)
+	}
+
+	return s, nil
+}
+
+// SaveSettings validates and stores s. Values equal to their default are
+// removed, so that a later change of the default applies to them.
+func (d *SQliteDB) SaveSettings(ctx context.Context, s *Settings) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	if err := s.Validate(); err != nil {
+		return fmt.Errorf("save settings error: %v", err)
+	}
+	def := DefaultSettings()
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetSettings(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, key := range SettingKeys {
+			value, _ := s.Get(key)
+			if dv, _ := def.Get(key); value == dv {
+				_, err = tx.q.ExecContext(ctx, `DELETE FROM settings WHERE key = ?`, key)
+			} else {
+				_, err = tx.q.ExecContext(ctx, `
+					INSERT INTO settings (key, value) VALUES (?, ?)
+					ON CONFLICT (key) DO UPDATE
+					SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+					WHERE value <> excluded.value`, key, value)
+			}
+			if err != nil {
+				return fmt.Errorf("save settings error: %v", err)
+			}
+		}
+
+		// Settings belong to the instance, not to a workspace.
+		return tx.audit(WithWorkspace(ctx, 0), AuditUpdate, AuditSettings, "instance", 0, old, s)
+	})
+}
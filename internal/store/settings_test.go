@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSQLiteDB_Settings(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	t.Run("Settings: defaults", func(t *testing.T) {
+		got, err := db.GetSettings(ctx)
+		if err != nil {
+			t.Fatalf("failed to get settings: %v", err)
+		}
+		if *got != *DefaultSettings() {
+			t.Errorf("settings: got %+v want %+v", got, DefaultSettings())
+		}
+	})
+
+	t.Run("Settings: save and reload", func(t *testing.T) {
+		s := DefaultSettings()
+		if err := s.Set(SettingProviderURL, "http://gpu-box:11434/"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Set(SettingTemperature, "0.2"); err != nil {
+			t.Fatal(err)
+		}
+		s.ChatModel = "llama3:70b"
+		if err := db.SaveSettings(ctx, s); err != nil {
+			t.Fatalf("failed to save settings: %v", err)
+		}
+
+		got, err := db.GetSettings(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := Settings{
+			ProviderURL:    "http://gpu-box:11434",
+			ChatModel:      "llama3:70b",
+			EmbeddingModel: DefaultSettings().EmbeddingModel,
+			Temperature:    0.2,
+		}
+		if *got != want {
+			t.Errorf("settings: got %+v want %+v", *got, want)
+		}
+
+		var n int
+		if err := db.r.QueryRowContext(ctx, `SELECT count(*) FROM settings`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 3 {
+			t.Errorf("stored rows: got %d want 3", n)
+		}
+		entries, _ := db.ListAudit(ctx, AuditFilter{Entity: AuditSettings})
+		if len(entries) != 1 || entries[0].WorkspaceID != 0 {
+			t.Errorf("audit entries: %+v", entries)
+		}
+	})
+
+	t.Run("Settings: back to a default removes the row", func(t *testing.T) {
+		if err := db.SaveSettings(ctx, DefaultSettings()); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		if err := db.r.QueryRowContext(ctx, `SELECT count(*) FROM settings`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("stored rows: got %d want 0", n)
+		}
+	})
+
+	t.Run("Settings: validation", func(t *testing.T) {
+		for name, set := range map[string]func(*Settings){
+			"url":         func(s *Settings) { s.ProviderURL = "localhost:11434" },
+			"scheme":      func(s *Settings) { s.ProviderURL = "ftp://gpu-box" },
+			"chat model":  func(s *Settings) { s.ChatModel = "" },
+			"embed model": func(s *Settings) { s.EmbeddingModel = "nomic embed" },
+			"temperature": func(s *Settings) { s.Temperature = 2.5 },
+		} {
+			s := DefaultSettings()
+			set(s)
+			if err := db.SaveSettings(ctx, s); err == nil {
+				t.Errorf("%s: expected error", name)
+			}
+		}
+		if err := DefaultSettings().Set(SettingTemperature, "warm"); err == nil {
+			t.Error("expected error for a non-numeric temperature")
+		}
+		if err := DefaultSettings().Set("nope", "x"); err == nil {
+			t.Error("expected error for an unknown key")
+		}
+	})
+}
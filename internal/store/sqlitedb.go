@@ -2,64 +2,264 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 
-	"github.com/golang-migrate/migrate/v4"
+	"github.com/dtoebe/RootTensor/internal/keyring"
 	_ "github.com/mattn/go-sqlite3"
 )
 
+// ErrNestedTx is returned by WithTx when it is called on the handle of a
+// transaction that is already running. SQLite has no nested transactions.
+var ErrNestedTx = errors.New("nested transaction")
+
+// querier is what the repositories need from either the pool or a running
+// transaction.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Options are the pragmas and pool sizes every connection is opened with.
+type Options struct {
+	// JournalMode is one of DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF.
+	JournalMode string
+	// Synchronous is one of OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+	// BusyTimeout is how long a connection waits on a lock held by another
+	// one before failing with "database is locked".
+	BusyTimeout time.Duration
+	ForeignKeys bool
+	// ReadConns caps the read pool. Writes always go through a single
+	// connection since SQLite only ever allows one writer.
+	ReadConns int
+}
+
+// DefaultOptions returns the options NewSQLiteDB uses.
+func DefaultOptions() Options {
+	return Options{
+		JournalMode: "WAL",
+		Synchronous: "NORMAL",
+		BusyTimeout: 5 * time.Second,
+		ForeignKeys: true,
+		ReadConns:   4,
+	}
+}
+
+// SQliteDB holds two pools on the same file: a single connection that
+// takes every write and a read-only pool for queries. Inside WithTx both
+// point at the transaction.
 type SQliteDB struct {
 	db   *sql.DB
+	read *sql.DB
+	tx   *sql.Tx
+	q    querier
+	r    querier
 	path string
+	opts Options
+	// fts is the full-text module the search index is built with.
+	fts string
+	// vectors is the approximate nearest-neighbour index, nil until
+	// BuildVectorIndex is called. Transactions share it with the pool.
+	vectors *atomic.Pointer[hnsw]
+	// keys encrypts the sealed columns; nil leaves them in plaintext.
+	keys *keyring.Keyring
 }
 
 func NewSQLiteDB(path string) (*SQliteDB, error) {
-	db, err := sql.Open("sqlite3", path)
+	return NewSQLiteDBWithOptions(path, DefaultOptions())
+}
+
+// NewSQLiteDBWithOptions opens path with opts and pings both pools so a bad
+// path or option fails here rather than on the first query.
+func NewSQLiteDBWithOptions(path string, opts Options) (*SQliteDB, error) {
+	if opts.ReadConns < 1 {
+		opts.ReadConns = 1
+	}
+
+	db, err := sql.Open("sqlite3", dsn(path, opts, false))
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(1)
+
+	read, err := sql.Open("sqlite3", dsn(path, opts, true))
 	if err != nil {
+		db.Close()
 		return nil, err
 	}
+	read.SetMaxOpenConns(opts.ReadConns)
+	read.SetMaxIdleConns(opts.ReadConns)
 
-	return &SQliteDB{
+	d := &SQliteDB{
 		db:   db,
+		read: read,
+		q:    db,
+		r:    read,
 		path: path,
-	}, nil
+		opts: opts,
+
+		vectors: &atomic.Pointer[hnsw]{},
+	}
+
+	// Open the writer first: switching to WAL needs a write lock.
+	for _, p := range []*sql.DB{db, read} {
+		if err := p.Ping(); err != nil {
+			d.Close()
+			return nil, fmt.Errorf("open db error: %v", err)
+		}
+	}
+
+	if d.fts, err = d.searchModule(context.Background()); err != nil {
+		d.Close()
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// dsn builds the go-sqlite3 connection string. Writer connections take the
+// write lock when a transaction begins so two of them never deadlock trying
+// to upgrade a read lock.
+func dsn(path string, opts Options, readOnly bool) string {
+	v := url.Values{}
+	if opts.JournalMode != "" {
+		v.Set("_journal_mode", opts.JournalMode)
+	}
+	if opts.Synchronous != "" {
+		v.Set("_synchronous", opts.Synchronous)
+	}
+	v.Set("_busy_timeout", strconv.FormatInt(opts.BusyTimeout.Milliseconds(), 10))
+	v.Set("_foreign_keys", strconv.FormatBool(opts.ForeignKeys))
+	if readOnly {
+		v.Set("_query_only", "true")
+	} else {
+		v.Set("_txlock", "immediate")
+	}
+
+	return path + "?" + v.Encode()
 }
 
 func (d *SQliteDB) Close() error {
 	if d == nil || d.db == nil {
 		return errors.New("db nil")
 	}
+	if d.tx != nil {
+		return errors.New("close error: the transaction handle does not own the database")
+	}
 
 	err := d.db.Close()
-	d.db = nil
+	if rerr := d.read.Close(); err == nil {
+		err = rerr
+	}
+	d.db, d.read = nil, nil
 	return err
 }
 
+// Ping checks that the database file is still in place and that both
+// pools answer a query. Open connections keep working on a file that was
+// deleted or replaced, so a plain ping would not notice.
+func (d *SQliteDB) Ping(ctx context.Context) error {
+	if d == nil || d.db == nil {
+		return errors.New("db nil")
+	}
+	if d.path != ":memory:" {
+		if _, err := os.Stat(d.path); err != nil {
+			return fmt.Errorf("database file error: %v", err)
+		}
+	}
+	// In a transaction both are the transaction: the writer has a single
+	// connection, which it holds.
+	for _, q := range []querier{d.q, d.r} {
+		var one int
+		if err := q.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+			return fmt.Errorf("ping error: %v", err)
+		}
+	}
+
+	return nil
+}
+
 func (d *SQliteDB) Exec(query string, args ...any) (sql.Result, error) {
-	return d.db.Exec(query, args...)
+	return d.ExecContext(context.Background(), query, args...)
 }
 
 func (d *SQliteDB) QueryRow(query string, args ...any) *sql.Row {
-	return d.db.QueryRow(query, args...)
+	return d.QueryRowContext(context.Background(), query, args...)
 }
 
 func (d *SQliteDB) Query(query string, args ...any) (*sql.Rows, error) {
-	return d.db.Query(query, args...)
+	return d.QueryContext(context.Background(), query, args...)
+}
+
+func (d *SQliteDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return d.q.ExecContext(ctx, query, args...)
+}
+
+func (d *SQliteDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return d.q.QueryRowContext(ctx, query, args...)
 }
 
-func (d *SQliteDB) Migrate(dir string) error {
-	dsn := fmt.Sprintf("sqlite3://%s", d.path)
-	m, err := migrate.New("file://"+dir, dsn)
+func (d *SQliteDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return d.q.QueryContext(ctx, query, args...)
+}
+
+// WithTx runs fn inside a transaction. fn receives a handle bound to the
+// transaction, so every repository method called on it takes part in it.
+// fn must only use that handle: the writer has a single connection, so
+// writing through d while the transaction is open blocks until ctx ends.
+// The transaction is committed when fn returns nil and rolled back when it
+// returns an error or panics; a panic is re-raised after the rollback.
+func (d *SQliteDB) WithTx(ctx context.Context, fn func(tx *SQliteDB) error) error {
+	if d.tx != nil {
+		return ErrNestedTx
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("migration error: %v", err)
+		return fmt.Errorf("begin tx error: %v", err)
 	}
-	defer m.Close()
 
-	if err := m.Up(); err != nil {
-		return fmt.Errorf("migration apply error: %v", err)
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	// The handle is d with its queries bound to tx, so that it keeps
+	// everything else d was opened with.
+	h := *d
+	h.tx, h.q, h.r = tx, tx, tx
+	if err := fn(&h); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			return fmt.Errorf("rollback tx error: %v (after %w)", rbErr, err)
+		}
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit tx error: %v", err)
 	}
 
 	return nil
 }
+
+// inTx runs fn in the transaction d is bound to, or in a new one. The
+// repositories use it so that a change and its audit entry are written
+// together whether or not the caller opened a transaction.
+func (d *SQliteDB) inTx(ctx context.Context, fn func(tx *SQliteDB) error) error {
+	if d.tx != nil {
+		return fn(d)
+	}
+
+	return d.WithTx(ctx, fn)
+}
@@ -1,10 +1,15 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestNewSQLiteDB(t *testing.T) {
@@ -28,6 +33,85 @@ func TestNewSQLiteDB(t *testing.T) {
 			t.Errorf("SQLiteDB.path: got: %s; want: %s", db.path, f)
 		}
 	})
+
+	t.Run("SQLiteDB: pragmas", func(t *testing.T) {
+		tests := []struct {
+			pragma string
+			want   string
+		}{
+			{"journal_mode", "wal"},
+			{"foreign_keys", "1"},
+			{"busy_timeout", "5000"},
+			{"synchronous", "1"},
+		}
+		for _, tt := range tests {
+			for name, p := range map[string]*sql.DB{"writer": db.db, "reader": db.read} {
+				var got string
+				if err := p.QueryRow("PRAGMA " + tt.pragma).Scan(&got); err != nil {
+					t.Fatal(err)
+				}
+				if got != tt.want {
+					t.Errorf("%s %s: got %s want %s", name, tt.pragma, got, tt.want)
+				}
+			}
+		}
+	})
+
+	t.Run("SQLiteDB: read pool is read only", func(t *testing.T) {
+		if _, err := db.read.Exec("CREATE TABLE nope (id INTEGER)"); err == nil {
+			t.Error("expected write on read pool to fail")
+		}
+	})
+
+	t.Run("SQLiteDB: writer pool size", func(t *testing.T) {
+		if got := db.db.Stats().MaxOpenConnections; got != 1 {
+			t.Errorf("writer conns: got %d want 1", got)
+		}
+		if got := db.read.Stats().MaxOpenConnections; got != DefaultOptions().ReadConns {
+			t.Errorf("reader conns: got %d want %d", got, DefaultOptions().ReadConns)
+		}
+	})
+}
+
+func TestNewSQLiteDBWithOptions(t *testing.T) {
+	t.Run("options: applied", func(t *testing.T) {
+		f := testDBFileSetup(t)
+		defer testDBFileCleanup(t, f)
+
+		opts := Options{JournalMode: "DELETE", BusyTimeout: time.Second, ReadConns: 2}
+		db, err := NewSQLiteDBWithOptions(f, opts)
+		if err != nil {
+			t.Fatalf("failed to create test db: %v", err)
+		}
+		defer db.Close()
+
+		var mode string
+		var fk, timeout int
+		row := db.QueryRow("SELECT * FROM pragma_journal_mode, pragma_foreign_keys, pragma_busy_timeout")
+		if err := row.Scan(&mode, &fk, &timeout); err != nil {
+			t.Fatal(err)
+		}
+		if mode != "delete" || fk != 0 || timeout != 1000 {
+			t.Errorf("pragmas: got %s %d %d", mode, fk, timeout)
+		}
+	})
+
+	t.Run("options: invalid journal mode", func(t *testing.T) {
+		f := testDBFileSetup(t)
+		defer testDBFileCleanup(t, f)
+
+		opts := DefaultOptions()
+		opts.JournalMode = "SIDEWAYS"
+		if _, err := NewSQLiteDBWithOptions(f, opts); err == nil {
+			t.Fatal("expected error for invalid journal mode")
+		}
+	})
+
+	t.Run("options: unreachable path", func(t *testing.T) {
+		if _, err := NewSQLiteDB("/nonexistent/dir/rt.db"); err == nil {
+			t.Fatal("expected ping to fail")
+		}
+	})
 }
 
 func TestSQLiteDB_Close(t *testing.T) {
@@ -64,6 +148,40 @@ func TestSQLiteDB_Close(t *testing.T) {
 
 }
 
+func TestSQLiteDB_Ping(t *testing.T) {
+	f := testDBFileSetup(t)
+	// The test removes the file itself.
+	t.Cleanup(func() { os.Remove(f) })
+
+	db, err := NewSQLiteDB(f)
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
+	}
+	ctx := context.Background()
+
+	t.Run("Ping: success", func(t *testing.T) {
+		if err := db.Ping(ctx); err != nil {
+			t.Fatalf("got %v", err)
+		}
+	})
+
+	t.Run("Ping: file removed", func(t *testing.T) {
+		if err := os.Remove(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Ping(ctx); err == nil {
+			t.Fatal("failed to notice the missing file")
+		}
+	})
+
+	t.Run("Ping: closed", func(t *testing.T) {
+		db.Close()
+		if err := db.Ping(ctx); err == nil {
+			t.Fatal("failed to catch closed db")
+		}
+	})
+}
+
 func TestSQLiteDB_Exec(t *testing.T) {
 	f := testDBFileSetup(t)
 	defer testDBFileCleanup(t, f)
@@ -191,6 +309,92 @@ func TestSQLiteDB_Query(t *testing.T) {
 	})
 }
 
+func TestSQLiteDB_ForeignKeys(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	t.Run("ForeignKeys: missing parent", func(t *testing.T) {
+		err := db.CreateEvidence(ctx, &Evidence{IncidentID: 9999, Title: "orphan", CollectedAt: time.Now()})
+		if err == nil {
+			t.Fatal("expected foreign key error")
+		}
+	})
+
+	t.Run("ForeignKeys: cascade", func(t *testing.T) {
+		inc := testIncident(t, db, "cascade")
+		if err := db.CreateEvidence(ctx, &Evidence{IncidentID: inc.ID, Title: "child", CollectedAt: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteIncident(ctx, inc.ID); err != nil {
+			t.Fatal(err)
+		}
+		evs, err := db.ListEvidence(ctx, EvidenceFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evs) != 0 {
+			t.Errorf("evidence after delete: got %d want 0", len(evs))
+		}
+	})
+}
+
+// TestSQLiteDB_Concurrency hammers one database from many goroutines the
+// way concurrent HTTP requests would. None of them may see "database is
+// locked".
+func TestSQLiteDB_Concurrency(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	const (
+		workers = 16
+		rounds  = 25
+	)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds)
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range rounds {
+				inc := &Incident{Title: fmt.Sprintf("worker %d round %d", w, i), StartedAt: time.Now()}
+				if err := db.CreateIncident(ctx, inc); err != nil {
+					errs <- err
+					continue
+				}
+
+				err := db.WithTx(ctx, func(tx *SQliteDB) error {
+					return tx.CreateEvidence(ctx, &Evidence{IncidentID: inc.ID, Title: "log", CollectedAt: time.Now()})
+				})
+				if err != nil {
+					errs <- err
+				}
+
+				if _, err := db.ListIncidents(ctx, IncidentFilter{Limit: 10}); err != nil {
+					errs <- err
+				}
+				if _, err := db.GetIncident(ctx, inc.ID); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM evidence").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	if n != workers*rounds {
+		t.Errorf("evidence rows: got %d want %d", n, workers*rounds)
+	}
+}
+
 func testDBInsertDummyData(t *testing.T, db *SQliteDB) {
 	t.Helper()
 
@@ -206,6 +410,38 @@ func testDBInsertDummyData(t *testing.T, db *SQliteDB) {
 	}
 }
 
+// testDBMigrated returns a database with all migrations applied that is
+// closed and removed when the test finishes.
+func testDBMigrated(t *testing.T) *SQliteDB {
+	t.Helper()
+	f := testDBFileSetup(t)
+	t.Cleanup(func() { testDBFileCleanup(t, f) })
+
+	db, err := NewSQLiteDB(f)
+	if err != nil {
+		t.Fatalf("failed to create test db: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if err := db.Migrate(); err != nil {
+		t.Fatalf("failed to migrate test db: %v", err)
+	}
+
+	return db
+}
+
+// testIncident creates an incident for tests that need a parent row.
+func testIncident(t *testing.T, db *SQliteDB, title string) *Incident {
+	t.Helper()
+
+	inc := &Incident{Title: title, StartedAt: time.Now()}
+	if err := db.CreateIncident(context.Background(), inc); err != nil {
+		t.Fatalf("failed to create test incident: %v", err)
+	}
+
+	return inc
+}
+
 func testDBFileSetup(t *testing.T) string {
 	t.Helper()
 	f, err := os.CreateTemp("", "rt-sqlite-*.db")
@@ -223,3 +459,158 @@ func testDBFileCleanup(t *testing.T, fileName string) {
 		t.Fatalf("unable to delete test db file: %v", err)
 	}
 }
+
+func TestSQLiteDB_Context(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	t.Run("ExecContext: cancelled", func(t *testing.T) {
+		if _, err := db.ExecContext(ctx, "DELETE FROM incidents"); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("QueryContext: cancelled", func(t *testing.T) {
+		if _, err := db.QueryContext(ctx, "SELECT id FROM incidents"); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("QueryRowContext: cancelled", func(t *testing.T) {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT count(*) FROM incidents").Scan(&n); !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v want %v", err, context.Canceled)
+		}
+	})
+
+	t.Run("repository: cancelled", func(t *testing.T) {
+		if _, err := db.ListIncidents(ctx, IncidentFilter{}); err == nil {
+			t.Error("expected error on cancelled context")
+		}
+	})
+}
+
+func TestSQLiteDB_WithTx(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	countIncidents := func(t *testing.T) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM incidents").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	t.Run("WithTx: commit", func(t *testing.T) {
+		var inc *Incident
+		err := db.WithTx(ctx, func(tx *SQliteDB) error {
+			inc = &Incident{Title: "disk full", StartedAt: time.Now()}
+			if err := tx.CreateIncident(ctx, inc); err != nil {
+				return err
+			}
+			return tx.CreateEvidence(ctx, &Evidence{IncidentID: inc.ID, Title: "df -h", CollectedAt: time.Now()})
+		})
+		if err != nil {
+			t.Fatalf("failed to run tx: %v", err)
+		}
+
+		evs, err := db.ListEvidence(ctx, EvidenceFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evs) != 1 {
+			t.Errorf("evidence: got %d want 1", len(evs))
+		}
+	})
+
+	t.Run("WithTx: rollback on error", func(t *testing.T) {
+		before := countIncidents(t)
+		err := db.WithTx(ctx, func(tx *SQliteDB) error {
+			inc := &Incident{Title: "half written", StartedAt: time.Now()}
+			if err := tx.CreateIncident(ctx, inc); err != nil {
+				return err
+			}
+			return tx.CreateHypothesis(ctx, &Hypothesis{IncidentID: inc.ID, Statement: "x", Confidence: 2})
+		})
+		if err == nil {
+			t.Fatal("expected constraint error")
+		}
+		if got := countIncidents(t); got != before {
+			t.Errorf("incidents after rollback: got %d want %d", got, before)
+		}
+	})
+
+	t.Run("WithTx: handle keeps the database", func(t *testing.T) {
+		err := db.WithTx(ctx, func(tx *SQliteDB) error {
+			if tx.opts != db.opts || tx.path != db.path {
+				t.Errorf("options: got %+v want %+v", tx.opts, db.opts)
+			}
+			if err := tx.Ping(ctx); err != nil {
+				t.Errorf("ping: %v", err)
+			}
+			if err := tx.Close(); err == nil {
+				t.Error("close: expected error")
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Ping(ctx); err != nil {
+			t.Errorf("ping after tx: %v", err)
+		}
+	})
+
+	t.Run("WithTx: rollback keeps error", func(t *testing.T) {
+		want := errors.New("stop")
+		err := db.WithTx(ctx, func(tx *SQliteDB) error { return want })
+		if !errors.Is(err, want) {
+			t.Errorf("got %v want %v", err, want)
+		}
+	})
+
+	t.Run("WithTx: rollback on panic", func(t *testing.T) {
+		before := countIncidents(t)
+		func() {
+			defer func() {
+				if p := recover(); p != "boom" {
+					t.Errorf("panic: got %v want boom", p)
+				}
+			}()
+			db.WithTx(ctx, func(tx *SQliteDB) error {
+				if err := tx.CreateIncident(ctx, &Incident{Title: "panicked", StartedAt: time.Now()}); err != nil {
+					t.Fatal(err)
+				}
+				panic("boom")
+			})
+		}()
+		if got := countIncidents(t); got != before {
+			t.Errorf("incidents after panic: got %d want %d", got, before)
+		}
+	})
+
+	t.Run("WithTx: nested", func(t *testing.T) {
+		err := db.WithTx(ctx, func(tx *SQliteDB) error {
+			return tx.WithTx(ctx, func(*SQliteDB) error { return nil })
+		})
+		if !errors.Is(err, ErrNestedTx) {
+			t.Errorf("got %v want %v", err, ErrNestedTx)
+		}
+	})
+
+	t.Run("WithTx: cancelled context", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		called := false
+		err := db.WithTx(cctx, func(*SQliteDB) error {
+			called = true
+			return nil
+		})
+		if err == nil || called {
+			t.Errorf("expected begin to fail; err %v called %v", err, called)
+		}
+	})
+}
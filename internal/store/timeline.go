@@ -0,0 +1,185 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TimelineEvent is one entry of an incident's chronology: an alert firing,
+// a deploy, a mitigation step or a note.
+type TimelineEvent struct {
+	ID          int64
+	IncidentID  int64
+	OccurredAt  time.Time
+	Kind        string
+	Description string
+	Source      string
+	CreatedAt   time.Time
+}
+
+// TimelineFilter narrows ListTimelineEvents. Zero values do not filter.
+type TimelineFilter struct {
+	IncidentID int64
+	Kind       string
+	// Since and Until bound OccurredAt, inclusive.
+	Since time.Time
+	Until time.Time
+	// After continues the list past a cursor from TimelineEvent.Cursor.
+	After *Cursor
+	Limit int
+}
+
+// Cursor is the position of ev in ListTimelineEvents.
+func (ev *TimelineEvent) Cursor() Cursor {
+	return Cursor{Key: ev.OccurredAt, ID: ev.ID}
+}
+
+const timelineColumns = `id, incident_id, occurred_at, kind, description, source, created_at`
+
+func (d *SQliteDB) CreateTimelineEvent(ctx context.Context, ev *TimelineEvent) error {
+	if err := authorize(ctx, PermWriteEvidence); err != nil {
+		return err
+	}
+
+	if ev.Kind == "" {
+		ev.Kind = "note"
+	}
+	ev.OccurredAt = utc(ev.OccurredAt)
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if err := tx.incidentInScope(ctx, ev.IncidentID); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO timeline_events (incident_id, occurred_at, kind, description, source)
+			VALUES (?, ?, ?, ?, ?)
+			RETURNING id, created_at`,
+			ev.IncidentID, ev.OccurredAt, ev.Kind, ev.Description, ev.Source)
+		if err := row.Scan(&ev.ID, &ev.CreatedAt); err != nil {
+			return fmt.Errorf("create timeline event error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditCreate, AuditTimelineEvent, ev.ID, ev.IncidentID, nil, ev)
+	})
+}
+
+func (d *SQliteDB) GetTimelineEvent(ctx context.Context, id int64) (*TimelineEvent, error) {
+	var w where
+	w.add("id = ?", id)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+timelineColumns+` FROM timeline_events`+w.String(), w.args...)
+	ev, err := scanTimelineEvent(row)
+	if err != nil {
+		return nil, notFound(err, "get timeline event")
+	}
+
+	return ev, nil
+}
+
+// ListTimelineEvents returns the events matching f in chronological order.
+func (d *SQliteDB) ListTimelineEvents(ctx context.Context, f TimelineFilter) ([]TimelineEvent, error) {
+	var w where
+	if f.IncidentID != 0 {
+		w.add("incident_id = ?", f.IncidentID)
+	}
+	if f.Kind != "" {
+		w.add("kind = ?", f.Kind)
+	}
+	if !f.Since.IsZero() {
+		w.add("occurred_at >= ?", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		w.add("occurred_at <= ?", f.Until.UTC())
+	}
+	w.after(f.After, "occurred_at", false)
+	scopeIncidents(ctx, &w, "incident_id")
+
+	rows, err := d.r.QueryContext(ctx,
+		`SELECT `+timelineColumns+` FROM timeline_events`+w.String()+` ORDER BY occurred_at, id`+limit(f.Limit),
+		w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list timeline events error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []TimelineEvent
+	for rows.Next() {
+		ev, err := scanTimelineEvent(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list timeline events error: %v", err)
+		}
+		out = append(out, *ev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list timeline events error: %v", err)
+	}
+
+	return out, nil
+}
+
+func (d *SQliteDB) UpdateTimelineEvent(ctx context.Context, ev *TimelineEvent) error {
+	if err := authorize(ctx, PermWriteEvidence); err != nil {
+		return err
+	}
+
+	ev.OccurredAt = utc(ev.OccurredAt)
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetTimelineEvent(ctx, ev.ID)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			UPDATE timeline_events SET occurred_at = ?, kind = ?, description = ?, source = ?
+			WHERE id = ?`,
+			ev.OccurredAt, ev.Kind, ev.Description, ev.Source, ev.ID)
+		if err != nil {
+			return fmt.Errorf("update timeline event error: %v", err)
+		}
+
+		cur, err := tx.GetTimelineEvent(ctx, ev.ID)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, AuditUpdate, AuditTimelineEvent, ev.ID, old.IncidentID, old, cur)
+	})
+}
+
+func (d *SQliteDB) DeleteTimelineEvent(ctx context.Context, id int64) error {
+	if err := authorize(ctx, PermWriteEvidence); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetTimelineEvent(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := precondition(ctx, old); err != nil {
+			return err
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM timeline_events WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("delete timeline event error: %v", err)
+		}
+
+		return tx.audit(ctx, AuditDelete, AuditTimelineEvent, id, old.IncidentID, old, nil)
+	})
+}
+
+func scanTimelineEvent(s scanner) (*TimelineEvent, error) {
+	var ev TimelineEvent
+	err := s.Scan(&ev.ID, &ev.IncidentID, &ev.OccurredAt, &ev.Kind, &ev.Description, &ev.Source, &ev.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ev, nil
+}
@@ -0,0 +1,91 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDB_TimelineEvents(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	inc := testIncident(t, db, "redis timeouts")
+	base := time.Date(2025, 4, 1, 14, 0, 0, 0, time.UTC)
+	deploy := &TimelineEvent{IncidentID: inc.ID, OccurredAt: base.Add(5 * time.Minute), Kind: "deploy", Description: "payments v2.3.1"}
+	alert := &TimelineEvent{IncidentID: inc.ID, OccurredAt: base, Kind: "alert", Description: "p99 latency"}
+
+	t.Run("CreateTimelineEvent: success", func(t *testing.T) {
+		for _, ev := range []*TimelineEvent{deploy, alert} {
+			if err := db.CreateTimelineEvent(ctx, ev); err != nil {
+				t.Fatalf("failed to create timeline event: %v", err)
+			}
+		}
+		note := &TimelineEvent{IncidentID: inc.ID, OccurredAt: base.Add(time.Hour), Description: "escalated"}
+		if err := db.CreateTimelineEvent(ctx, note); err != nil {
+			t.Fatal(err)
+		}
+		if note.Kind != "note" {
+			t.Errorf("default kind: got %q want %q", note.Kind, "note")
+		}
+	})
+
+	t.Run("ListTimelineEvents: chronological and filtered", func(t *testing.T) {
+		all, err := db.ListTimelineEvents(ctx, TimelineFilter{IncidentID: inc.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(all) != 3 || all[0].ID != alert.ID || all[1].ID != deploy.ID {
+			t.Errorf("timeline order: got %+v", all)
+		}
+
+		got, err := db.ListTimelineEvents(ctx, TimelineFilter{IncidentID: inc.ID, Kind: "deploy"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != deploy.ID {
+			t.Errorf("kind filter: got %+v", got)
+		}
+
+		got, err = db.ListTimelineEvents(ctx, TimelineFilter{IncidentID: inc.ID, Since: base.Add(time.Minute), Until: base.Add(10 * time.Minute)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != deploy.ID {
+			t.Errorf("time filter: got %+v", got)
+		}
+	})
+
+	t.Run("UpdateTimelineEvent: success", func(t *testing.T) {
+		deploy.Description = "payments v2.3.1 (canary)"
+		if err := db.UpdateTimelineEvent(ctx, deploy); err != nil {
+			t.Fatal(err)
+		}
+		got, err := db.GetTimelineEvent(ctx, deploy.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Description != deploy.Description || !got.OccurredAt.Equal(deploy.OccurredAt) {
+			t.Errorf("event not updated: %+v", got)
+		}
+	})
+
+	t.Run("DeleteTimelineEvent: success", func(t *testing.T) {
+		if err := db.DeleteTimelineEvent(ctx, alert.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.GetTimelineEvent(ctx, alert.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("GetTimelineEvent after delete: got %v want %v", err, ErrNotFound)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		if err := db.UpdateTimelineEvent(ctx, &TimelineEvent{ID: 9999}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("UpdateTimelineEvent: got %v want %v", err, ErrNotFound)
+		}
+		if err := db.DeleteTimelineEvent(ctx, 9999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("DeleteTimelineEvent: got %v want %v", err, ErrNotFound)
+		}
+	})
+}
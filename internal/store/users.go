@@ -0,0 +1,458 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrUserExists is returned when creating a user whose name is taken.
+var ErrUserExists = errors.New("user already exists")
+
+// User is someone who can sign in. Username is what workspace membership
+// refers to. The store never sees a password, only its hash.
+type User struct {
+	ID           int64
+	Username     string
+	PasswordHash string `json:"-"`
+	// Admin users manage users and workspaces, change the instance
+	// settings and hold RoleAdmin in every workspace.
+	Admin     bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// APIToken is a personal access token. The token itself is shown once
+// when it is created; only its hash is stored.
+type APIToken struct {
+	ID         int64
+	UserID     int64
+	Name       string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// HasScope reports whether the token grants scope.
+func (t *APIToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+const userColumns = `id, username, password_hash, admin, created_at, updated_at`
+
+const apiTokenColumns = `id, user_id, name, scopes, created_at, expires_at, last_used_at`
+
+// CreateUser adds u with the password hash it carries. It fails with
+// ErrUserExists when the name is taken, whatever its case.
+func (d *SQliteDB) CreateUser(ctx context.Context, u *User) error {
+	if u.Username == "" || u.PasswordHash == "" {
+		return errors.New("create user error: username and password are required")
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if _, err := tx.GetUserByName(ctx, u.Username); err == nil {
+			return fmt.Errorf("create user error: %w: %s", ErrUserExists, u.Username)
+		} else if !errors.Is(err, ErrNotFound) {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO users (username, password_hash, admin) VALUES (?, ?, ?)
+			RETURNING id, created_at, updated_at`, u.Username, u.PasswordHash, u.Admin)
+		if err := row.Scan(&u.ID, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return fmt.Errorf("create user error: %v", err)
+		}
+
+		return tx.audit(WithWorkspace(ctx, 0), AuditCreate, AuditUser, u.ID, 0, nil, u)
+	})
+}
+
+func (d *SQliteDB) GetUser(ctx context.Context, id int64) (*User, error) {
+	row := d.r.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = ?`, id)
+	u, err := scanUser(row)
+	if err != nil {
+		return nil, notFound(err, "get user")
+	}
+
+	return u, nil
+}
+
+// GetUserByName returns the user called name, ignoring case.
+func (d *SQliteDB) GetUserByName(ctx context.Context, name string) (*User, error) {
+	row := d.r.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE username = ?`, name)
+	u, err := scanUser(row)
+	if err != nil {
+		return nil, notFound(err, "get user by name")
+	}
+
+	return u, nil
+}
+
+// ListUsers returns every user by name.
+func (d *SQliteDB) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := d.r.QueryContext(ctx, `SELECT `+userColumns+` FROM users ORDER BY username`)
+	if err != nil {
+		return nil, fmt.Errorf("list users error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list users error: %v", err)
+		}
+		out = append(out, *u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list users error: %v", err)
+	}
+
+	return out, nil
+}
+
+// CountUsers returns how many users there are. Without any, the web UI is
+// open to everyone.
+func (d *SQliteDB) CountUsers(ctx context.Context) (int64, error) {
+	var n int64
+	if err := d.r.QueryRowContext(ctx, `SELECT count(*) FROM users`).Scan(&n); err != nil {
+		return 0, fmt.Errorf("count users error: %v", err)
+	}
+
+	return n, nil
+}
+
+// SetUserPassword replaces the password hash of user id and ends all of
+// its sessions.
+func (d *SQliteDB) SetUserPassword(ctx context.Context, id int64, hash string) error {
+	if hash == "" {
+		return errors.New("set user password error: password is required")
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		res, err := tx.q.ExecContext(ctx, `
+			UPDATE users SET password_hash = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, hash, id)
+		if err != nil {
+			return fmt.Errorf("set user password error: %v", err)
+		}
+		if err := affectedOne(res, "set user password"); err != nil {
+			return err
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM sessions WHERE user_id = ?`, id); err != nil {
+			return fmt.Errorf("set user password error: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// SetUserAdmin grants or revokes the admin rights of user id.
+func (d *SQliteDB) SetUserAdmin(ctx context.Context, id int64, admin bool) error {
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			UPDATE users SET admin = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`, admin, id)
+		if err != nil {
+			return fmt.Errorf("set user admin error: %v", err)
+		}
+
+		cur := *old
+		cur.Admin = admin
+		return tx.audit(WithWorkspace(ctx, 0), AuditUpdate, AuditUser, id, 0, old, &cur)
+	})
+}
+
+// DeleteUser removes user id with its sessions, tokens and workspace
+// memberships.
+func (d *SQliteDB) DeleteUser(ctx context.Context, id int64) error {
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetUser(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		workspaces, err := tx.memberWorkspaces(ctx, old.Username)
+		if err != nil {
+			return err
+		}
+		for _, ws := range workspaces {
+			if err := tx.RemoveWorkspaceMember(WithWorkspace(ctx, 0), ws, old.Username); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM users WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("delete user error: %v", err)
+		}
+
+		return tx.audit(WithWorkspace(ctx, 0), AuditDelete, AuditUser, id, 0, old, nil)
+	})
+}
+
+// memberWorkspaces returns the ids of the workspaces member was added to.
+func (d *SQliteDB) memberWorkspaces(ctx context.Context, member string) ([]int64, error) {
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT workspace_id FROM workspace_members WHERE member = ? COLLATE NOCASE`, member)
+	if err != nil {
+		return nil, fmt.Errorf("member workspaces error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("member workspaces error: %v", err)
+		}
+		out = append(out, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("member workspaces error: %v", err)
+	}
+
+	return out, nil
+}
+
+// CreateSession starts a session of user id, identified by the hash of its
+// token, that ends at expires. Expired sessions of every user are dropped
+// on the way.
+func (d *SQliteDB) CreateSession(ctx context.Context, tokenHash string, userID int64, expires time.Time) error {
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		now := time.Now().UTC()
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM sessions WHERE expires_at <= ?`, now); err != nil {
+			return fmt.Errorf("create session error: %v", err)
+		}
+
+		_, err := tx.q.ExecContext(ctx, `
+			INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES (?, ?, ?, ?)`,
+			tokenHash, userID, now, utc(expires))
+		if err != nil {
+			return fmt.Errorf("create session error: %v", err)
+		}
+
+		return nil
+	})
+}
+
+// SessionUser returns the user of the unexpired session with tokenHash.
+func (d *SQliteDB) SessionUser(ctx context.Context, tokenHash string) (*User, error) {
+	row := d.r.QueryRowContext(ctx, `
+		SELECT `+prefixColumns("u", userColumns)+` FROM sessions s
+		JOIN users u ON u.id = s.user_id
+		WHERE s.token_hash = ? AND s.expires_at > ?`, tokenHash, time.Now().UTC())
+	u, err := scanUser(row)
+	if err != nil {
+		return nil, notFound(err, "session user")
+	}
+
+	return u, nil
+}
+
+// DeleteSession ends the session with tokenHash. Ending a session that is
+// gone is not an error.
+func (d *SQliteDB) DeleteSession(ctx context.Context, tokenHash string) error {
+	if _, err := d.q.ExecContext(ctx, `DELETE FROM sessions WHERE token_hash = ?`, tokenHash); err != nil {
+		return fmt.Errorf("delete session error: %v", err)
+	}
+
+	return nil
+}
+
+// CreateAPIToken stores t, identified by the hash of its token.
+func (d *SQliteDB) CreateAPIToken(ctx context.Context, t *APIToken, tokenHash string) error {
+	if t.Name == "" {
+		return errors.New("create api token error: name is required")
+	}
+	if t.ExpiresAt != nil {
+		exp := utc(*t.ExpiresAt)
+		t.ExpiresAt = &exp
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO api_tokens (user_id, name, token_hash, scopes, expires_at) VALUES (?, ?, ?, ?, ?)
+			RETURNING id, created_at`,
+			t.UserID, t.Name, tokenHash, strings.Join(t.Scopes, " "), nullTime(t.ExpiresAt))
+		if err := row.Scan(&t.ID, &t.CreatedAt); err != nil {
+			return fmt.Errorf("create api token error: %v", err)
+		}
+
+		return tx.audit(WithWorkspace(ctx, 0), AuditCreate, AuditAPIToken, t.ID, 0, nil, t)
+	})
+}
+
+// ListAPITokens returns the tokens of user id, newest first.
+func (d *SQliteDB) ListAPITokens(ctx context.Context, userID int64) ([]APIToken, error) {
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("list api tokens error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []APIToken
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list api tokens error: %v", err)
+		}
+		out = append(out, *t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list api tokens error: %v", err)
+	}
+
+	return out, nil
+}
+
+// DeleteAPIToken revokes token id of user userID.
+func (d *SQliteDB) DeleteAPIToken(ctx context.Context, userID, id int64) error {
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		row := tx.r.QueryRowContext(ctx, `
+			SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ? AND user_id = ?`, id, userID)
+		old, err := scanAPIToken(row)
+		if err != nil {
+			return notFound(err, "delete api token")
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM api_tokens WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("delete api token error: %v", err)
+		}
+
+		return tx.audit(WithWorkspace(ctx, 0), AuditDelete, AuditAPIToken, id, 0, old, nil)
+	})
+}
+
+// TokenUser returns the unexpired token with tokenHash and its user, and
+// records that the token was used.
+func (d *SQliteDB) TokenUser(ctx context.Context, tokenHash string) (*User, *APIToken, error) {
+	now := time.Now().UTC()
+	row := d.r.QueryRowContext(ctx, `
+		SELECT `+prefixColumns("t", apiTokenColumns)+` FROM api_tokens t
+		WHERE t.token_hash = ? AND (t.expires_at IS NULL OR t.expires_at > ?)`, tokenHash, now)
+	t, err := scanAPIToken(row)
+	if err != nil {
+		return nil, nil, notFound(err, "token user")
+	}
+	u, err := d.GetUser(ctx, t.UserID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := d.q.ExecContext(ctx, `UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, now, t.ID); err != nil {
+		return nil, nil, fmt.Errorf("token user error: %v", err)
+	}
+	t.LastUsedAt = &now
+
+	return u, t, nil
+}
+
+// WorkspaceRole returns the role of user name in workspace id: the role it
+// was given as a member, DefaultRole in a workspace without members, and
+// "" when the workspace has members and the user is not one of them.
+func (d *SQliteDB) WorkspaceRole(ctx context.Context, id int64, name string) (Role, error) {
+	var role Role
+	err := d.r.QueryRowContext(ctx, `
+		SELECT role FROM workspace_members WHERE workspace_id = ? AND member = ? COLLATE NOCASE`,
+		id, name).Scan(&role)
+	if err == nil {
+		return role, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return "", fmt.Errorf("workspace role error: %v", err)
+	}
+
+	var open bool
+	err = d.r.QueryRowContext(ctx, `
+		SELECT NOT EXISTS (SELECT 1 FROM workspace_members WHERE workspace_id = ?)`, id).Scan(&open)
+	if err != nil {
+		return "", fmt.Errorf("workspace role error: %v", err)
+	}
+	if open {
+		return DefaultRole, nil
+	}
+
+	return "", nil
+}
+
+// CanEnterWorkspace reports whether user name may work in workspace id: a
+// workspace without members is open to every user, one with members only
+// to them.
+func (d *SQliteDB) CanEnterWorkspace(ctx context.Context, id int64, name string) (bool, error) {
+	role, err := d.WorkspaceRole(ctx, id, name)
+	return role != "", err
+}
+
+// ListWorkspacesOf returns the workspaces user name may work in by name.
+func (d *SQliteDB) ListWorkspacesOf(ctx context.Context, name string) ([]Workspace, error) {
+	all, err := d.ListWorkspaces(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	out := all[:0]
+	for _, ws := range all {
+		ok, err := d.CanEnterWorkspace(ctx, ws.ID, name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			out = append(out, ws)
+		}
+	}
+
+	return out, nil
+}
+
+// prefixColumns qualifies every column of a column list with table alias
+// t.
+func prefixColumns(t, columns string) string {
+	cols := strings.Split(columns, ", ")
+	for i, c := range cols {
+		cols[i] = t + "." + c
+	}
+
+	return strings.Join(cols, ", ")
+}
+
+func scanUser(s scanner) (*User, error) {
+	var u User
+	err := s.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Admin, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func scanAPIToken(s scanner) (*APIToken, error) {
+	var (
+		t                 APIToken
+		scopes            string
+		expires, lastUsed sql.NullTime
+	)
+	err := s.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.CreatedAt, &expires, &lastUsed)
+	if err != nil {
+		return nil, err
+	}
+	t.Scopes = strings.Fields(scopes)
+	t.ExpiresAt = timePtr(expires)
+	t.LastUsedAt = timePtr(lastUsed)
+
+	return &t, nil
+}
@@ -0,0 +1,258 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testUser(t *testing.T, db *SQliteDB, name string) *User {
+	t.Helper()
+
+	u := &User{Username: name, PasswordHash: "$argon2id$test"}
+	if err := db.CreateUser(context.Background(), u); err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+
+	return u
+}
+
+func TestSQLiteDB_Users(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	t.Run("Users: create and get", func(t *testing.T) {
+		u := testUser(t, db, "Alice")
+
+		got, err := db.GetUserByName(ctx, "alice")
+		if err != nil {
+			t.Fatalf("failed to get user by name: %v", err)
+		}
+		if got.ID != u.ID || got.Username != "Alice" || got.PasswordHash != "$argon2id$test" || got.Admin {
+			t.Errorf("unexpected user: %+v", got)
+		}
+		if n, _ := db.CountUsers(ctx); n != 1 {
+			t.Errorf("count: got %d want 1", n)
+		}
+
+		entries, _ := db.ListAudit(ctx, AuditFilter{Entity: AuditUser})
+		if len(entries) != 1 || entries[0].Diff["Username"].New == nil {
+			t.Fatalf("unexpected audit entries: %+v", entries)
+		}
+		if _, ok := entries[0].Diff["PasswordHash"]; ok {
+			t.Errorf("unexpected audit entries: %+v", entries)
+		}
+	})
+
+	t.Run("Users: duplicate name", func(t *testing.T) {
+		err := db.CreateUser(ctx, &User{Username: "ALICE", PasswordHash: "x"})
+		if !errors.Is(err, ErrUserExists) {
+			t.Errorf("error: got %v want ErrUserExists", err)
+		}
+	})
+
+	t.Run("Users: admin and password", func(t *testing.T) {
+		u, _ := db.GetUserByName(ctx, "alice")
+		if err := db.CreateSession(ctx, "s1", u.ID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetUserAdmin(ctx, u.ID, true); err != nil {
+			t.Fatalf("failed to set admin: %v", err)
+		}
+		if err := db.SetUserPassword(ctx, u.ID, "$argon2id$new"); err != nil {
+			t.Fatalf("failed to set password: %v", err)
+		}
+
+		got, _ := db.GetUser(ctx, u.ID)
+		if !got.Admin || got.PasswordHash != "$argon2id$new" {
+			t.Errorf("unexpected user: %+v", got)
+		}
+		if _, err := db.SessionUser(ctx, "s1"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("session after password change: got %v want ErrNotFound", err)
+		}
+		if err := db.SetUserPassword(ctx, 999, "x"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("missing user: got %v want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Users: delete", func(t *testing.T) {
+		u := testUser(t, db, "bob")
+		ws := testWorkspace(t, db, "ops")
+		if err := db.AddWorkspaceMember(ctx, ws.ID, "bob", DefaultRole); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.CreateSession(ctx, "s2", u.ID, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.DeleteUser(ctx, u.ID); err != nil {
+			t.Fatalf("failed to delete user: %v", err)
+		}
+		if _, err := db.GetUser(ctx, u.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("get deleted user: got %v want ErrNotFound", err)
+		}
+		if members, _ := db.ListWorkspaceMembers(ctx, ws.ID); len(members) != 0 {
+			t.Errorf("members: got %+v want none", members)
+		}
+		if _, err := db.SessionUser(ctx, "s2"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("session of deleted user: got %v want ErrNotFound", err)
+		}
+	})
+}
+
+func TestSQLiteDB_Sessions(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	u := testUser(t, db, "alice")
+
+	if err := db.CreateSession(ctx, "old", u.ID, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if err := db.CreateSession(ctx, "new", u.ID, time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+
+	t.Run("Sessions: valid", func(t *testing.T) {
+		got, err := db.SessionUser(ctx, "new")
+		if err != nil {
+			t.Fatalf("failed to get session user: %v", err)
+		}
+		if got.ID != u.ID {
+			t.Errorf("user: got %d want %d", got.ID, u.ID)
+		}
+	})
+
+	t.Run("Sessions: expired and pruned", func(t *testing.T) {
+		if _, err := db.SessionUser(ctx, "old"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expired session: got %v want ErrNotFound", err)
+		}
+		var n int
+		db.r.QueryRowContext(ctx, `SELECT count(*) FROM sessions`).Scan(&n)
+		if n != 1 {
+			t.Errorf("sessions: got %d want 1", n)
+		}
+	})
+
+	t.Run("Sessions: delete", func(t *testing.T) {
+		if err := db.DeleteSession(ctx, "new"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.SessionUser(ctx, "new"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleted session: got %v want ErrNotFound", err)
+		}
+	})
+}
+
+func TestSQLiteDB_APITokens(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	u := testUser(t, db, "alice")
+
+	tok := &APIToken{UserID: u.ID, Name: "ci", Scopes: []string{"incidents:read", "evidence:write"}}
+	if err := db.CreateAPIToken(ctx, tok, "hash-ci"); err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	expired := &APIToken{UserID: u.ID, Name: "old", ExpiresAt: &past}
+	if err := db.CreateAPIToken(ctx, expired, "hash-old"); err != nil {
+		t.Fatalf("failed to create token: %v", err)
+	}
+
+	t.Run("APITokens: token user", func(t *testing.T) {
+		gotUser, got, err := db.TokenUser(ctx, "hash-ci")
+		if err != nil {
+			t.Fatalf("failed to get token user: %v", err)
+		}
+		if gotUser.ID != u.ID || got.ID != tok.ID || !got.HasScope("evidence:write") || got.HasScope("analyses:run") {
+			t.Errorf("unexpected token: %+v of %+v", got, gotUser)
+		}
+
+		list, _ := db.ListAPITokens(ctx, u.ID)
+		if len(list) != 2 || list[1].LastUsedAt == nil {
+			t.Errorf("unexpected tokens: %+v", list)
+		}
+	})
+
+	t.Run("APITokens: expired and unknown", func(t *testing.T) {
+		for _, hash := range []string{"hash-old", "nope"} {
+			if _, _, err := db.TokenUser(ctx, hash); !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: got %v want ErrNotFound", hash, err)
+			}
+		}
+	})
+
+	t.Run("APITokens: delete", func(t *testing.T) {
+		if err := db.DeleteAPIToken(ctx, u.ID+1, tok.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("token of another user: got %v want ErrNotFound", err)
+		}
+		if err := db.DeleteAPIToken(ctx, u.ID, tok.ID); err != nil {
+			t.Fatalf("failed to delete token: %v", err)
+		}
+		if _, _, err := db.TokenUser(ctx, "hash-ci"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("deleted token: got %v want ErrNotFound", err)
+		}
+	})
+}
+
+func TestSQLiteDB_CanEnterWorkspace(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+	closed := testWorkspace(t, db, "closed")
+	if err := db.AddWorkspaceMember(ctx, closed.ID, "alice", RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ws   int64
+		user string
+		want bool
+	}{
+		{"CanEnterWorkspace: open workspace", DefaultWorkspaceID, "bob", true},
+		{"CanEnterWorkspace: member", closed.ID, "alice", true},
+		{"CanEnterWorkspace: member in other case", closed.ID, "Alice", true},
+		{"CanEnterWorkspace: not a member", closed.ID, "bob", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.CanEnterWorkspace(ctx, tt.ws, tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("WorkspaceRole: by membership", func(t *testing.T) {
+		for _, tt := range []struct {
+			ws   int64
+			user string
+			want Role
+		}{
+			{DefaultWorkspaceID, "bob", DefaultRole},
+			{closed.ID, "alice", RoleViewer},
+			{closed.ID, "bob", ""},
+		} {
+			got, err := db.WorkspaceRole(ctx, tt.ws, tt.user)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("%s in %d: got %q want %q", tt.user, tt.ws, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("ListWorkspacesOf: only enterable", func(t *testing.T) {
+		got, err := db.ListWorkspacesOf(ctx, "bob")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != DefaultWorkspaceID {
+			t.Errorf("workspaces: got %+v", got)
+		}
+	})
+}
@@ -0,0 +1,526 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// DefaultWorkspaceID is the workspace incidents go to when no workspace is
+// given. It holds everything recorded before workspaces existed and cannot
+// be deleted.
+const DefaultWorkspaceID = 1
+
+// ErrWorkspaceNotEmpty is returned when deleting a workspace that still has
+// incidents.
+var ErrWorkspaceNotEmpty = errors.New("workspace has incidents")
+
+// Workspace is one team's share of the instance. ChatModel overrides the
+// instance model when not empty; Personas limits the personas offered when
+// not empty.
+type Workspace struct {
+	ID        int64
+	Slug      string
+	Name      string
+	ChatModel string
+	Personas  []string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// WorkspaceMember is someone allowed into a workspace, with the role they
+// hold there.
+type WorkspaceMember struct {
+	WorkspaceID int64
+	Member      string
+	Role        Role
+	AddedAt     time.Time
+}
+
+// WorkspaceStats is a workspace with counts of what it holds, for the
+// cross-workspace admin view.
+type WorkspaceStats struct {
+	Workspace
+	Incidents     int64
+	OpenIncidents int64
+	Members       int64
+	// EvidenceBytes is the size of the evidence content stored in the
+	// database; attachments are not included.
+	EvidenceBytes int64
+}
+
+var slugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type workspaceKey struct{}
+
+// WithWorkspace returns a context whose queries see only the incidents of
+// workspace id, and everything recorded for them, and whose new incidents
+// go to that workspace. Rows outside the workspace are reported as
+// ErrNotFound. A context without a workspace, or WithWorkspace(ctx, 0),
+// sees every workspace; the command line, background jobs and the admin
+// view use one.
+func WithWorkspace(ctx context.Context, id int64) context.Context {
+	return context.WithValue(ctx, workspaceKey{}, id)
+}
+
+// WorkspaceFrom returns the workspace set with WithWorkspace and whether
+// there is one.
+func WorkspaceFrom(ctx context.Context) (int64, bool) {
+	id, ok := ctx.Value(workspaceKey{}).(int64)
+	return id, ok && id != 0
+}
+
+// targetWorkspace is the workspace a new incident goes to: the one of ctx,
+// else id, else the default workspace.
+func targetWorkspace(ctx context.Context, id int64) int64 {
+	if ws, ok := WorkspaceFrom(ctx); ok {
+		return ws
+	}
+	if id != 0 {
+		return id
+	}
+
+	return DefaultWorkspaceID
+}
+
+// scopeIncidents limits w to the rows whose incident, the SQL expression
+// col, is in the workspace of ctx.
+func scopeIncidents(ctx context.Context, w *where, col string) {
+	if ws, ok := WorkspaceFrom(ctx); ok {
+		w.add(col+" IN (SELECT id FROM incidents WHERE workspace_id = ?)", ws)
+	}
+}
+
+// incidentInScope returns ErrNotFound when incident id is not in the
+// workspace of ctx. Without a workspace the foreign keys are left to catch
+// a missing incident.
+func (d *SQliteDB) incidentInScope(ctx context.Context, id int64) error {
+	ws, ok := WorkspaceFrom(ctx)
+	if !ok {
+		return nil
+	}
+
+	var n int
+	err := d.r.QueryRowContext(ctx, `SELECT count(*) FROM incidents WHERE id = ? AND workspace_id = ?`, id, ws).Scan(&n)
+	if err != nil {
+		return fmt.Errorf("incident scope error: %v", err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+const workspaceColumns = `id, slug, name, chat_model, personas, created_at, updated_at`
+
+// ValidSlug reports whether s can name a workspace: lower case letters,
+// digits and dashes, starting with a letter or digit.
+func ValidSlug(s string) bool {
+	return slugPattern.MatchString(s)
+}
+
+func (d *SQliteDB) CreateWorkspace(ctx context.Context, ws *Workspace) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	if !ValidSlug(ws.Slug) {
+		return fmt.Errorf("create workspace error: invalid slug %q", ws.Slug)
+	}
+	if ws.Name == "" {
+		ws.Name = ws.Slug
+	}
+	personas, err := json.Marshal(nonNil(ws.Personas))
+	if err != nil {
+		return fmt.Errorf("workspace personas marshal error: %v", err)
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		row := tx.q.QueryRowContext(ctx, `
+			INSERT INTO workspaces (slug, name, chat_model, personas) VALUES (?, ?, ?, ?)
+			RETURNING id, created_at, updated_at`,
+			ws.Slug, ws.Name, ws.ChatModel, string(personas))
+		if err := row.Scan(&ws.ID, &ws.CreatedAt, &ws.UpdatedAt); err != nil {
+			return fmt.Errorf("create workspace error: %v", err)
+		}
+
+		return tx.audit(WithWorkspace(ctx, ws.ID), AuditCreate, AuditWorkspace, ws.ID, 0, nil, ws)
+	})
+}
+
+// GetWorkspace returns workspace id. In a workspace context only that
+// workspace is found.
+func (d *SQliteDB) GetWorkspace(ctx context.Context, id int64) (*Workspace, error) {
+	if cur, ok := WorkspaceFrom(ctx); ok && cur != id {
+		return nil, ErrNotFound
+	}
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+workspaceColumns+` FROM workspaces WHERE id = ?`, id)
+	ws, err := scanWorkspace(row)
+	if err != nil {
+		return nil, notFound(err, "get workspace")
+	}
+
+	return ws, nil
+}
+
+func (d *SQliteDB) GetWorkspaceBySlug(ctx context.Context, slug string) (*Workspace, error) {
+	var w where
+	w.add("slug = ?", slug)
+	if cur, ok := WorkspaceFrom(ctx); ok {
+		w.add("id = ?", cur)
+	}
+
+	row := d.r.QueryRowContext(ctx, `SELECT `+workspaceColumns+` FROM workspaces`+w.String(), w.args...)
+	ws, err := scanWorkspace(row)
+	if err != nil {
+		return nil, notFound(err, "get workspace by slug")
+	}
+
+	return ws, nil
+}
+
+// ListWorkspaces returns the workspaces by name; in a workspace context,
+// only that one.
+func (d *SQliteDB) ListWorkspaces(ctx context.Context) ([]Workspace, error) {
+	var w where
+	if cur, ok := WorkspaceFrom(ctx); ok {
+		w.add("id = ?", cur)
+	}
+
+	rows, err := d.r.QueryContext(ctx, `SELECT `+workspaceColumns+` FROM workspaces`+w.String()+` ORDER BY name, id`, w.args...)
+	if err != nil {
+		return nil, fmt.Errorf("list workspaces error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []Workspace
+	for rows.Next() {
+		ws, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list workspaces error: %v", err)
+		}
+		out = append(out, *ws)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list workspaces error: %v", err)
+	}
+
+	return out, nil
+}
+
+// UpdateWorkspace replaces the name and settings of a workspace. The slug
+// does not change. Unknown persona keys are rejected.
+func (d *SQliteDB) UpdateWorkspace(ctx context.Context, ws *Workspace) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	personas, err := json.Marshal(nonNil(ws.Personas))
+	if err != nil {
+		return fmt.Errorf("workspace personas marshal error: %v", err)
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetWorkspace(ctx, ws.ID)
+		if err != nil {
+			return err
+		}
+		for _, key := range ws.Personas {
+			var n int
+			if err := tx.r.QueryRowContext(ctx, `SELECT count(*) FROM personas WHERE key = ?`, key).Scan(&n); err != nil {
+				return fmt.Errorf("update workspace error: %v", err)
+			}
+			if n == 0 {
+				return fmt.Errorf("update workspace error: unknown persona %q", key)
+			}
+		}
+		if ws.Name == "" {
+			ws.Name = old.Slug
+		}
+
+		_, err = tx.q.ExecContext(ctx, `
+			UPDATE workspaces SET name = ?, chat_model = ?, personas = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?`, ws.Name, ws.ChatModel, string(personas), ws.ID)
+		if err != nil {
+			return fmt.Errorf("update workspace error: %v", err)
+		}
+
+		cur, err := tx.GetWorkspace(ctx, ws.ID)
+		if err != nil {
+			return err
+		}
+		*ws = *cur
+		return tx.audit(WithWorkspace(ctx, ws.ID), AuditUpdate, AuditWorkspace, ws.ID, 0, old, cur)
+	})
+}
+
+// DeleteWorkspace removes an empty workspace with its members and
+// retention policies. It fails with ErrWorkspaceNotEmpty while incidents
+// are left; move or delete them first. The default workspace cannot be
+// deleted.
+func (d *SQliteDB) DeleteWorkspace(ctx context.Context, id int64) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	if id == DefaultWorkspaceID {
+		return errors.New("delete workspace error: the default workspace cannot be deleted")
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetWorkspace(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		var n int
+		if err := tx.r.QueryRowContext(ctx, `SELECT count(*) FROM incidents WHERE workspace_id = ?`, id).Scan(&n); err != nil {
+			return fmt.Errorf("delete workspace error: %v", err)
+		}
+		if n > 0 {
+			return fmt.Errorf("delete workspace error: %w: %d left", ErrWorkspaceNotEmpty, n)
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `DELETE FROM workspaces WHERE id = ?`, id); err != nil {
+			return fmt.Errorf("delete workspace error: %v", err)
+		}
+
+		return tx.audit(WithWorkspace(ctx, id), AuditDelete, AuditWorkspace, id, 0, old, nil)
+	})
+}
+
+// AddWorkspaceMember lets member into workspace id with role, or gives an
+// existing member role. Adding a member again with its role is a no-op.
+func (d *SQliteDB) AddWorkspaceMember(ctx context.Context, id int64, member string, role Role) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+	if member == "" {
+		return errors.New("add workspace member error: member is required")
+	}
+	if !ValidRole(string(role)) {
+		return fmt.Errorf("add workspace member error: invalid role %q", role)
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if _, err := tx.GetWorkspace(ctx, id); err != nil {
+			return err
+		}
+
+		var old *WorkspaceMember
+		row := tx.r.QueryRowContext(ctx, `
+			SELECT workspace_id, member, role, added_at FROM workspace_members
+			WHERE workspace_id = ? AND member = ?`, id, member)
+		if m, err := scanWorkspaceMember(row); err == nil {
+			old = m
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("add workspace member error: %v", err)
+		}
+		if old != nil && old.Role == role {
+			return nil
+		}
+
+		_, err := tx.q.ExecContext(ctx, `
+			INSERT INTO workspace_members (workspace_id, member, role) VALUES (?, ?, ?)
+			ON CONFLICT DO UPDATE SET role = excluded.role`, id, member, role)
+		if err != nil {
+			return fmt.Errorf("add workspace member error: %v", err)
+		}
+
+		m := &WorkspaceMember{WorkspaceID: id, Member: member, Role: role}
+		if old != nil {
+			m.AddedAt = old.AddedAt
+			return tx.audit(WithWorkspace(ctx, id), AuditUpdate, AuditWorkspaceMember, member, 0, old, m)
+		}
+		return tx.audit(WithWorkspace(ctx, id), AuditCreate, AuditWorkspaceMember, member, 0, nil, m)
+	})
+}
+
+func (d *SQliteDB) RemoveWorkspaceMember(ctx context.Context, id int64, member string) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		if _, err := tx.GetWorkspace(ctx, id); err != nil {
+			return err
+		}
+
+		row := tx.q.QueryRowContext(ctx, `
+			DELETE FROM workspace_members WHERE workspace_id = ? AND member = ?
+			RETURNING workspace_id, member, role, added_at`, id, member)
+		old, err := scanWorkspaceMember(row)
+		if err != nil {
+			return notFound(err, "remove workspace member")
+		}
+
+		return tx.audit(WithWorkspace(ctx, id), AuditDelete, AuditWorkspaceMember, member, 0, old, nil)
+	})
+}
+
+// ListWorkspaceMembers returns the members of workspace id by name.
+func (d *SQliteDB) ListWorkspaceMembers(ctx context.Context, id int64) ([]WorkspaceMember, error) {
+	if _, err := d.GetWorkspace(ctx, id); err != nil {
+		return nil, err
+	}
+
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT workspace_id, member, role, added_at FROM workspace_members
+		WHERE workspace_id = ? ORDER BY member`, id)
+	if err != nil {
+		return nil, fmt.Errorf("list workspace members error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []WorkspaceMember
+	for rows.Next() {
+		m, err := scanWorkspaceMember(rows)
+		if err != nil {
+			return nil, fmt.Errorf("list workspace members error: %v", err)
+		}
+		out = append(out, *m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list workspace members error: %v", err)
+	}
+
+	return out, nil
+}
+
+// IsWorkspaceMember reports whether member was added to workspace id.
+func (d *SQliteDB) IsWorkspaceMember(ctx context.Context, id int64, member string) (bool, error) {
+	var n int
+	err := d.r.QueryRowContext(ctx, `
+		SELECT count(*) FROM workspace_members WHERE workspace_id = ? AND member = ?`, id, member).Scan(&n)
+	if err != nil {
+		return false, fmt.Errorf("workspace member error: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+// ListWorkspaceStats returns every workspace with what it holds. It is
+// meant for administrators and ignores the workspace of ctx.
+func (d *SQliteDB) ListWorkspaceStats(ctx context.Context) ([]WorkspaceStats, error) {
+	rows, err := d.r.QueryContext(ctx, `
+		SELECT w.id, w.slug, w.name, w.chat_model, w.personas, w.created_at, w.updated_at,
+			(SELECT count(*) FROM incidents i WHERE i.workspace_id = w.id),
+			(SELECT count(*) FROM incidents i WHERE i.workspace_id = w.id AND i.status NOT IN ('resolved', 'closed')),
+			(SELECT count(*) FROM workspace_members m WHERE m.workspace_id = w.id),
+			(SELECT coalesce(sum(length(CAST(e.content AS BLOB))), 0) FROM evidence e
+				JOIN incidents i ON i.id = e.incident_id WHERE i.workspace_id = w.id)
+		FROM workspaces w ORDER BY w.name, w.id`)
+	if err != nil {
+		return nil, fmt.Errorf("list workspace stats error: %v", err)
+	}
+	defer rows.Close()
+
+	var out []WorkspaceStats
+	for rows.Next() {
+		var (
+			s        WorkspaceStats
+			personas string
+		)
+		err := rows.Scan(&s.ID, &s.Slug, &s.Name, &s.ChatModel, &personas, &s.CreatedAt, &s.UpdatedAt,
+			&s.Incidents, &s.OpenIncidents, &s.Members, &s.EvidenceBytes)
+		if err != nil {
+			return nil, fmt.Errorf("list workspace stats error: %v", err)
+		}
+		if err := json.Unmarshal([]byte(personas), &s.Personas); err != nil {
+			return nil, fmt.Errorf("workspace personas decode error: %v", err)
+		}
+		out = append(out, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("list workspace stats error: %v", err)
+	}
+
+	return out, nil
+}
+
+// MoveIncident moves an incident, with everything recorded for it, to
+// another workspace. Both must be visible from ctx, so in a workspace
+// context nothing can be moved in or out.
+func (d *SQliteDB) MoveIncident(ctx context.Context, id, workspaceID int64) error {
+	if err := authorize(ctx, PermManageWorkspace); err != nil {
+		return err
+	}
+
+	return d.inTx(ctx, func(tx *SQliteDB) error {
+		old, err := tx.GetIncident(ctx, id)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.GetWorkspace(ctx, workspaceID); err != nil {
+			return err
+		}
+		if old.WorkspaceID == workspaceID {
+			return nil
+		}
+
+		if _, err := tx.q.ExecContext(ctx, `
+			UPDATE incidents SET workspace_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?`,
+			workspaceID, id); err != nil {
+			return fmt.Errorf("move incident error: %v", err)
+		}
+
+		cur, err := tx.GetIncident(ctx, id)
+		if err != nil {
+			return err
+		}
+		// Recorded in both workspaces so that each history shows the move.
+		if err := tx.audit(WithWorkspace(ctx, old.WorkspaceID), AuditUpdate, AuditIncident, id, id, old, cur); err != nil {
+			return err
+		}
+		return tx.audit(WithWorkspace(ctx, workspaceID), AuditUpdate, AuditIncident, id, id, old, cur)
+	})
+}
+
+func scanWorkspace(s scanner) (*Workspace, error) {
+	var (
+		ws       Workspace
+		personas string
+	)
+	err := s.Scan(&ws.ID, &ws.Slug, &ws.Name, &ws.ChatModel, &personas, &ws.CreatedAt, &ws.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(personas), &ws.Personas); err != nil {
+		return nil, fmt.Errorf("workspace personas decode error: %v", err)
+	}
+
+	return &ws, nil
+}
+
+func scanWorkspaceMember(s scanner) (*WorkspaceMember, error) {
+	var m WorkspaceMember
+	if err := s.Scan(&m.WorkspaceID, &m.Member, &m.Role, &m.AddedAt); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// workspaceOf returns the workspace of incident id, whatever the workspace
+// of ctx.
+func (d *SQliteDB) workspaceOf(ctx context.Context, incidentID int64) (sql.NullInt64, error) {
+	var ws sql.NullInt64
+	err := d.r.QueryRowContext(ctx, `SELECT workspace_id FROM incidents WHERE id = ?`, incidentID).Scan(&ws)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ws, fmt.Errorf("workspace of incident error: %v", err)
+	}
+
+	return ws, nil
+}
+
+// formatID is an id spliced into SQL.
+func formatID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
@@ -0,0 +1,407 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func testWorkspace(t *testing.T, db *SQliteDB, slug string) *Workspace {
+	t.Helper()
+
+	ws := &Workspace{Slug: slug, Name: slug}
+	if err := db.CreateWorkspace(context.Background(), ws); err != nil {
+		t.Fatalf("failed to create workspace: %v", err)
+	}
+
+	return ws
+}
+
+func TestSQLiteDB_Workspaces(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	t.Run("Workspaces: default exists", func(t *testing.T) {
+		ws, err := db.GetWorkspace(ctx, DefaultWorkspaceID)
+		if err != nil {
+			t.Fatalf("failed to get default workspace: %v", err)
+		}
+		if ws.Slug != "default" {
+			t.Errorf("slug: got %q want default", ws.Slug)
+		}
+	})
+
+	t.Run("Workspaces: create, update and get by slug", func(t *testing.T) {
+		ws := testWorkspace(t, db, "payments")
+		ws.Name, ws.ChatModel, ws.Personas = "Payments", "llama3:70b", []string{"sre", "dba"}
+		if err := db.UpdateWorkspace(ctx, ws); err != nil {
+			t.Fatalf("failed to update workspace: %v", err)
+		}
+
+		got, err := db.GetWorkspaceBySlug(ctx, "payments")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "Payments" || got.ChatModel != "llama3:70b" || len(got.Personas) != 2 {
+			t.Errorf("unexpected workspace: %+v", got)
+		}
+
+		entries, _ := db.ListAudit(ctx, AuditFilter{Entity: AuditWorkspace})
+		if len(entries) != 2 || entries[0].WorkspaceID != ws.ID {
+			t.Errorf("unexpected audit entries: %+v", entries)
+		}
+	})
+
+	t.Run("Workspaces: invalid input", func(t *testing.T) {
+		for _, slug := range []string{"", "Has Space", "-dash"} {
+			if err := db.CreateWorkspace(ctx, &Workspace{Slug: slug}); err == nil {
+				t.Errorf("expected error for slug %q", slug)
+			}
+		}
+		if err := db.CreateWorkspace(ctx, &Workspace{Slug: "payments"}); err == nil {
+			t.Error("expected error for a duplicate slug")
+		}
+
+		ws, _ := db.GetWorkspaceBySlug(ctx, "payments")
+		ws.Personas = []string{"nope"}
+		if err := db.UpdateWorkspace(ctx, ws); err == nil {
+			t.Error("expected error for an unknown persona")
+		}
+	})
+
+	t.Run("Workspaces: members", func(t *testing.T) {
+		ws, _ := db.GetWorkspaceBySlug(ctx, "payments")
+		for _, m := range []string{"bob", "alice", "bob"} {
+			if err := db.AddWorkspaceMember(ctx, ws.ID, m, DefaultRole); err != nil {
+				t.Fatalf("failed to add member: %v", err)
+			}
+		}
+
+		got, err := db.ListWorkspaceMembers(ctx, ws.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 || got[0].Member != "alice" || got[1].Member != "bob" || got[0].Role != DefaultRole {
+			t.Errorf("unexpected members: %+v", got)
+		}
+
+		if err := db.AddWorkspaceMember(ctx, ws.ID, "alice", RoleCommander); err != nil {
+			t.Fatalf("failed to change role: %v", err)
+		}
+		if role, _ := db.WorkspaceRole(ctx, ws.ID, "alice"); role != RoleCommander {
+			t.Errorf("role: got %q want %q", role, RoleCommander)
+		}
+		entries, _ := db.ListAudit(ctx, AuditFilter{Entity: AuditWorkspaceMember})
+		if len(entries) != 3 || entries[0].Action != AuditUpdate || string(entries[0].Diff["Role"].New) != `"commander"` {
+			t.Errorf("unexpected audit entries: %+v", entries)
+		}
+		if err := db.AddWorkspaceMember(ctx, ws.ID, "alice", "owner"); err == nil {
+			t.Error("expected error for an unknown role")
+		}
+
+		if err := db.RemoveWorkspaceMember(ctx, ws.ID, "bob"); err != nil {
+			t.Fatal(err)
+		}
+		if ok, _ := db.IsWorkspaceMember(ctx, ws.ID, "bob"); ok {
+			t.Error("bob is still a member")
+		}
+		if err := db.RemoveWorkspaceMember(ctx, ws.ID, "bob"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Workspaces: delete", func(t *testing.T) {
+		ws := testWorkspace(t, db, "short-lived")
+		inc := &Incident{Title: "in the way", WorkspaceID: ws.ID}
+		if err := db.CreateIncident(ctx, inc); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetRetentionPolicy(WithWorkspace(ctx, ws.ID), &RetentionPolicy{Kind: "log", MaxAge: 5}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.DeleteWorkspace(ctx, ws.ID); !errors.Is(err, ErrWorkspaceNotEmpty) {
+			t.Errorf("expected ErrWorkspaceNotEmpty, got %v", err)
+		}
+		if err := db.DeleteIncident(ctx, inc.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteWorkspace(ctx, ws.ID); err != nil {
+			t.Fatalf("failed to delete workspace: %v", err)
+		}
+		if _, err := db.GetWorkspace(ctx, ws.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		policies, _ := db.ListRetentionPolicies(ctx)
+		for _, p := range policies {
+			if p.WorkspaceID == ws.ID {
+				t.Errorf("policy left behind: %+v", p)
+			}
+		}
+
+		if err := db.DeleteWorkspace(ctx, DefaultWorkspaceID); err == nil {
+			t.Error("expected error deleting the default workspace")
+		}
+	})
+
+	t.Run("Workspaces: incidents need an existing workspace", func(t *testing.T) {
+		if err := db.CreateIncident(ctx, &Incident{Title: "orphan", WorkspaceID: 999}); err == nil {
+			t.Error("expected error for an unknown workspace")
+		}
+	})
+}
+
+func TestSQLiteDB_WorkspaceIsolation(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	red, blue := testWorkspace(t, db, "red"), testWorkspace(t, db, "blue")
+	redCtx, blueCtx := WithWorkspace(ctx, red.ID), WithWorkspace(ctx, blue.ID)
+
+	inc := &Incident{Title: "Red checkout outage"}
+	if err := db.CreateIncident(redCtx, inc); err != nil {
+		t.Fatal(err)
+	}
+	if inc.WorkspaceID != red.ID {
+		t.Fatalf("workspace: got %d want %d", inc.WorkspaceID, red.ID)
+	}
+	ev := &Evidence{IncidentID: inc.ID, Kind: "log", Title: "checkout log", Content: "checkout timeout"}
+	if err := db.CreateEvidence(redCtx, ev); err != nil {
+		t.Fatal(err)
+	}
+	tl := &TimelineEvent{IncidentID: inc.ID, Description: "checkout alert fired"}
+	if err := db.CreateTimelineEvent(redCtx, tl); err != nil {
+		t.Fatal(err)
+	}
+	a := &Analysis{IncidentID: inc.ID, Model: "m", Draft: "checkout draft"}
+	if err := db.CreateAnalysis(redCtx, a); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("WorkspaceIsolation: owner sees everything", func(t *testing.T) {
+		if _, err := db.GetIncident(redCtx, inc.ID); err != nil {
+			t.Errorf("get incident: %v", err)
+		}
+		if got, _ := db.ListIncidents(redCtx, IncidentFilter{}); len(got) != 1 {
+			t.Errorf("incidents: got %d want 1", len(got))
+		}
+		if got, _ := db.Search(redCtx, SearchFilter{Query: "checkout"}); len(got) == 0 {
+			t.Error("expected search hits")
+		}
+		if got, _ := db.ListAudit(redCtx, AuditFilter{IncidentID: inc.ID}); len(got) != 4 {
+			t.Errorf("audit entries: got %d want 4", len(got))
+		}
+	})
+
+	t.Run("WorkspaceIsolation: others see nothing", func(t *testing.T) {
+		for name, err := range map[string]error{
+			"incident": func() error { _, err := db.GetIncident(blueCtx, inc.ID); return err }(),
+			"by uid":   func() error { _, err := db.GetIncidentByUID(blueCtx, inc.UID); return err }(),
+			"evidence": func() error { _, err := db.GetEvidence(blueCtx, ev.ID); return err }(),
+			"timeline": func() error { _, err := db.GetTimelineEvent(blueCtx, tl.ID); return err }(),
+			"analysis": func() error { _, err := db.GetAnalysis(blueCtx, a.ID); return err }(),
+			"update":   db.UpdateIncident(blueCtx, &Incident{ID: inc.ID, Title: "hijacked"}),
+			"delete":   db.DeleteEvidence(blueCtx, ev.ID),
+			"create":   db.CreateTimelineEvent(blueCtx, &TimelineEvent{IncidentID: inc.ID, Description: "x"}),
+			"chat":     db.CreateChatMessage(blueCtx, &ChatMessage{IncidentID: inc.ID, Role: "user", Content: "x"}),
+			"persona":  db.AssignPersona(blueCtx, PersonaSubjectIncident, inc.ID, "sre"),
+			"move":     db.MoveIncident(blueCtx, inc.ID, blue.ID),
+		} {
+			if !errors.Is(err, ErrNotFound) {
+				t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+			}
+		}
+
+		if got, _ := db.ListIncidents(blueCtx, IncidentFilter{}); len(got) != 0 {
+			t.Errorf("incidents: got %d want 0", len(got))
+		}
+		if got, _ := db.ListEvidence(blueCtx, EvidenceFilter{IncidentID: inc.ID}); len(got) != 0 {
+			t.Errorf("evidence: got %d want 0", len(got))
+		}
+		if got, _ := db.ListAnalyses(blueCtx, inc.ID); len(got) != 0 {
+			t.Errorf("analyses: got %d want 0", len(got))
+		}
+		if got, _ := db.Search(blueCtx, SearchFilter{Query: "checkout"}); len(got) != 0 {
+			t.Errorf("search hits: got %d want 0", len(got))
+		}
+		if got, _ := db.ListAudit(blueCtx, AuditFilter{}); len(got) != 1 || got[0].Entity != AuditWorkspace {
+			t.Errorf("unexpected audit entries: %+v", got)
+		}
+		if got, _ := db.ListWorkspaces(blueCtx); len(got) != 1 || got[0].ID != blue.ID {
+			t.Errorf("unexpected workspaces: %+v", got)
+		}
+	})
+
+	t.Run("WorkspaceIsolation: embeddings", func(t *testing.T) {
+		for _, e := range []*Embedding{
+			{Kind: SearchIncident, RefID: inc.ID, Model: "m", Vector: []float32{1, 0}},
+			{Kind: SearchEvidence, RefID: ev.ID, Model: "m", Vector: []float32{0.9, 0.1}},
+		} {
+			if err := db.UpsertEmbedding(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if got, _ := db.NearestEmbeddings(redCtx, "m", []float32{1, 0}, 5); len(got) != 2 {
+			t.Errorf("red neighbours: got %d want 2", len(got))
+		}
+		if got, _ := db.NearestEmbeddings(blueCtx, "m", []float32{1, 0}, 5); len(got) != 0 {
+			t.Errorf("blue neighbours: got %d want 0", len(got))
+		}
+	})
+
+	t.Run("WorkspaceIsolation: bundle uid taken elsewhere", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := db.ExportIncident(redCtx, nil, inc.ID, &buf); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.ExportIncident(blueCtx, nil, inc.ID, &bytes.Buffer{}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound exporting from blue, got %v", err)
+		}
+
+		res, err := db.ImportIncident(blueCtx, nil, &buf, ImportOptions{})
+		if err != nil {
+			t.Fatalf("failed to import: %v", err)
+		}
+		if res.UID == inc.UID {
+			t.Error("expected a new uid")
+		}
+		got, err := db.GetIncident(blueCtx, res.IncidentID)
+		if err != nil || got.WorkspaceID != blue.ID {
+			t.Errorf("unexpected imported incident: %+v, %v", got, err)
+		}
+	})
+
+	t.Run("WorkspaceIsolation: move", func(t *testing.T) {
+		if err := db.MoveIncident(ctx, inc.ID, blue.ID); err != nil {
+			t.Fatalf("failed to move incident: %v", err)
+		}
+		if _, err := db.GetEvidence(blueCtx, ev.ID); err != nil {
+			t.Errorf("evidence did not follow the incident: %v", err)
+		}
+		if _, err := db.GetIncident(redCtx, inc.ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound in the old workspace, got %v", err)
+		}
+		for _, c := range []context.Context{redCtx, blueCtx} {
+			got, _ := db.ListAudit(c, AuditFilter{Entity: AuditIncident, EntityID: formatID(inc.ID), Limit: 1})
+			if len(got) != 1 || got[0].Diff["WorkspaceID"].New == nil {
+				t.Errorf("move not in the history: %+v", got)
+			}
+		}
+	})
+}
+
+func TestSQLiteDB_WorkspaceSettings(t *testing.T) {
+	db := testDBMigrated(t)
+	ctx := context.Background()
+
+	ws := testWorkspace(t, db, "infra")
+	wsCtx := WithWorkspace(ctx, ws.ID)
+	ws.Personas = []string{"network", "sre"}
+	if err := db.UpdateWorkspace(ctx, ws); err != nil {
+		t.Fatal(err)
+	}
+	inc := &Incident{Title: "BGP flap"}
+	if err := db.CreateIncident(wsCtx, inc); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("WorkspaceSettings: personas", func(t *testing.T) {
+		got, err := db.ListPersonas(wsCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 2 {
+			t.Errorf("personas: got %d want 2", len(got))
+		}
+		if _, err := db.GetPersona(wsCtx, "dba"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound for a persona not offered, got %v", err)
+		}
+		if err := db.AssignPersona(wsCtx, PersonaSubjectIncident, inc.ID, "dba"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound assigning a persona not offered, got %v", err)
+		}
+		if all, _ := db.ListPersonas(WithWorkspace(ctx, DefaultWorkspaceID)); len(all) <= 2 {
+			t.Errorf("default workspace personas: got %d", len(all))
+		}
+	})
+
+	t.Run("WorkspaceSettings: retention", func(t *testing.T) {
+		if err := db.SetRetentionPolicy(wsCtx, &RetentionPolicy{Kind: "log", MaxAge: 7}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetRetentionPolicy(ctx, &RetentionPolicy{Kind: "metric", MaxAge: 30}); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := db.ListRetentionPolicies(wsCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]RetentionPolicy{
+			"log":    {WorkspaceID: ws.ID, MaxAge: 7},
+			"metric": {WorkspaceID: 0, MaxAge: 30},
+		}
+		if len(got) != len(want) {
+			t.Fatalf("unexpected policies: %+v", got)
+		}
+		for _, p := range got {
+			if w := want[p.Kind]; p.WorkspaceID != w.WorkspaceID || p.MaxAge != w.MaxAge {
+				t.Errorf("%s: got %+v want %+v", p.Kind, p, w)
+			}
+		}
+
+		now := time.Now()
+		other := testIncident(t, db, "elsewhere")
+		for _, e := range []*Evidence{
+			{IncidentID: inc.ID, Kind: "log", Title: "router log", Content: "flap", CollectedAt: now.AddDate(0, 0, -10)},
+			{IncidentID: other.ID, Kind: "log", Title: "app log", Content: "ok", CollectedAt: now.AddDate(0, 0, -10)},
+		} {
+			if err := db.CreateEvidence(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		res, err := db.ApplyRetention(ctx, now)
+		if err != nil {
+			t.Fatalf("failed to apply retention: %v", err)
+		}
+		if res.Archived != 1 {
+			t.Errorf("archived: got %d want 1", res.Archived)
+		}
+		left, _ := db.ListEvidence(ctx, EvidenceFilter{IncidentID: other.ID})
+		if len(left) != 1 || left[0].ArchivedAt != nil {
+			t.Errorf("default workspace evidence was archived: %+v", left)
+		}
+
+		if err := db.DeleteRetentionPolicy(wsCtx, "log"); err != nil {
+			t.Fatal(err)
+		}
+		got, _ = db.ListRetentionPolicies(wsCtx)
+		for _, p := range got {
+			if p.Kind == "log" && (p.WorkspaceID != 0 || p.MaxAge != 90) {
+				t.Errorf("expected the instance-wide log policy back, got %+v", p)
+			}
+		}
+	})
+
+	t.Run("WorkspaceSettings: stats", func(t *testing.T) {
+		if err := db.CreateEvidence(wsCtx, &Evidence{IncidentID: inc.ID, Kind: "metric", Title: "bgp", Content: "flaps"}); err != nil {
+			t.Fatal(err)
+		}
+
+		stats, err := db.ListWorkspaceStats(wsCtx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stats) != 2 {
+			t.Fatalf("stats: got %d want 2", len(stats))
+		}
+		for _, s := range stats {
+			if s.ID == ws.ID && (s.Incidents != 1 || s.OpenIncidents != 1 || s.EvidenceBytes == 0) {
+				t.Errorf("unexpected stats: %+v", s)
+			}
+		}
+	})
+}